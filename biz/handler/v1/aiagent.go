@@ -0,0 +1,172 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/colin-404/logx"
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/protocols/aiagent"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+type RollbackAgentRequest struct {
+	Version int64  `json:"version"`
+	Comment string `json:"comment"`
+}
+
+// CreateAgent 创建代理及其第一个版本，name 唯一，创建人为鉴权的调用方
+func CreateAgent(c *gin.Context) {
+	var req aiagent.CreateRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	req.CreatedBy = principal(c).Name
+	a, err := aiagent.Create(c.Request.Context(), xdb.Default(), req)
+	if err != nil {
+		agentError(c, "CreateAgent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"agent": a})
+}
+
+func GetAgent(c *gin.Context) {
+	a, err := aiagent.Get(c.Request.Context(), xdb.Default(), c.Param("name"))
+	if err != nil {
+		agentError(c, "GetAgent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"agent": a})
+}
+
+// ListAgent 按 namePrefix、tag 过滤，tag 可以用逗号分隔多个，需全部匹配
+func ListAgent(c *gin.Context) {
+	filter := aiagent.ListFilter{
+		NamePrefix: c.Query("namePrefix"),
+		Cursor:     c.Query("cursor"),
+	}
+	if tag := c.Query("tag"); tag != "" {
+		filter.Tags = strings.Split(tag, ",")
+	}
+	pageSize, ok := queryPageSize(c)
+	if !ok {
+		return
+	}
+	filter.PageSize = pageSize
+
+	agents, next, err := aiagent.List(c.Request.Context(), xdb.Default(), filter)
+	if err != nil {
+		agentError(c, "ListAgent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":      agents,
+		"nextCursor": next,
+	})
+}
+
+// UpdateAgent 只修改请求中出现的字段，每次修改生成新版本
+// baseVersion 不为 0 时必须等于当前版本，否则返回 409
+func UpdateAgent(c *gin.Context) {
+	var req aiagent.UpdateRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	req.UpdatedBy = principal(c).Name
+	a, err := aiagent.Update(c.Request.Context(), xdb.Default(), c.Param("name"), req)
+	if err != nil {
+		agentError(c, "UpdateAgent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"agent": a})
+}
+
+// DeleteAgent 删除代理，历史版本保留
+func DeleteAgent(c *gin.Context) {
+	if err := aiagent.Delete(c.Request.Context(), xdb.Default(), c.Param("name")); err != nil {
+		agentError(c, "DeleteAgent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": c.Param("name")})
+}
+
+// ListAgentVersions 按版本号倒序
+func ListAgentVersions(c *gin.Context) {
+	pageSize, ok := queryPageSize(c)
+	if !ok {
+		return
+	}
+	versions, next, err := aiagent.ListVersions(c.Request.Context(), xdb.Default(), c.Param("name"), pageSize, c.Query("cursor"))
+	if err != nil {
+		agentError(c, "ListAgentVersions", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":      versions,
+		"nextCursor": next,
+	})
+}
+
+func GetAgentVersion(c *gin.Context) {
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	v, err := aiagent.GetVersion(c.Request.Context(), xdb.Default(), c.Param("name"), version)
+	if err != nil {
+		agentError(c, "GetAgentVersion", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": v})
+}
+
+// RollbackAgent 把指定版本复制为新版本并设为当前版本
+func RollbackAgent(c *gin.Context) {
+	var req RollbackAgentRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if req.Version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version is required"})
+		return
+	}
+	a, err := aiagent.Rollback(c.Request.Context(), xdb.Default(), c.Param("name"), req.Version, principal(c).Name, req.Comment)
+	if err != nil {
+		agentError(c, "RollbackAgent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"agent": a})
+}
+
+func queryPageSize(c *gin.Context) (int, bool) {
+	pageSize := c.Query("pageSize")
+	if pageSize == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(pageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pageSize"})
+		return 0, false
+	}
+	return n, true
+}
+
+func agentError(c *gin.Context, name string, err error) {
+	switch {
+	case errors.Is(err, aiagent.ErrAgentNotFound), errors.Is(err, aiagent.ErrVersionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, aiagent.ErrInvalidAgent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, aiagent.ErrAgentAlreadyExists), errors.Is(err, aiagent.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logx.Errorf("%s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
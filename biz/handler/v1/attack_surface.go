@@ -1,26 +1,122 @@
 package v1
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/colin-404/logx"
 	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/protocols/attack_surface"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// GetAttackSurface 查询已采集的攻击面，支持过滤和游标分页
+// tag 参数格式为 key:value，可重复
 func GetAttackSurface(c *gin.Context) {
+	filter := attack_surface.ListFilter{
+		Provider:  c.Query("provider"),
+		AccountID: c.Query("account"),
+		Region:    c.Query("region"),
+		Protocol:  c.Query("protocol"),
+		Cidr:      c.Query("cidr"),
+		Sort:      c.Query("sort"),
+		Cursor:    c.Query("cursor"),
+		Tags:      map[string]string{},
+	}
+	if filter.Sort != "" && filter.Sort != "risk" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be risk"})
+		return
+	}
+	for _, tag := range c.QueryArray("tag") {
+		k, v, ok := strings.Cut(tag, ":")
+		if !ok || k == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tag must be key:value"})
+			return
+		}
+		filter.Tags[k] = v
+	}
+	if port := c.Query("port"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 0 || p > 65535 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid port"})
+			return
+		}
+		filter.Port = p
+	}
+	if pageSize := c.Query("pageSize"); pageSize != "" {
+		n, err := strconv.Atoi(pageSize)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pageSize"})
+			return
+		}
+		filter.PageSize = n
+	}
 
-	// repository := repositories.NewXidInfoRepository()
-	// xidInfo, err := repository.FindOneByXidAndPath(c, xid, xidType)
-	// if err != nil {
-	// 	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-	// 	return
-	// }
-
-	// query := c.Query("query")
-	// if query == "aws" {
-	// 	awsInstance := attack_surface.NewAWSAttackSurface(id)
-	// 	c.JSON(http.StatusOK, awsInstance)
-	// 	return
-	// }
-
-	c.JSON(http.StatusOK, nil)
+	entries, next, err := attack_surface.ListAttackSurfaces(c.Request.Context(), xdb.Default(), filter)
+	if errors.Is(err, attack_surface.ErrInvalidFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("GetAttackSurface: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":      entries,
+		"nextCursor": next,
+	})
+}
+
+// GetAttackSurfaceDetail 返回单个实例的攻击面、规则和原始卡片
+func GetAttackSurfaceDetail(c *gin.Context) {
+	detail, err := attack_surface.GetAttackSurfaceDetail(c.Request.Context(), xdb.Default(), c.Param("xid"))
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attack surface not found"})
+		return
+	}
+	if err != nil {
+		logx.Errorf("GetAttackSurfaceDetail: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+// ScanAttackSurface 采集所有已启用区域的EC2实例、网卡、公网IP和安全组
+func ScanAttackSurface(c *gin.Context) {
+	result, err := attack_surface.ScanAWS(c.Request.Context(), xdb.Default())
+	if err != nil {
+		logx.Errorf("ScanAttackSurface: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"regions":        result.Regions,
+		"instances":      len(result.Instances),
+		"securityGroups": len(result.SecurityGroups),
+		"attackSurfaces": len(result.AttackSurfaces),
+		"exposures":      result.Exposures,
+		"errors":         result.Errors,
+		"drift":          result.Drift,
+	})
+}
+
+// GetAttackSurfaceDrift 返回指定扫描(scanId)或最近一次扫描的变化
+func GetAttackSurfaceDrift(c *gin.Context) {
+	drift, err := attack_surface.GetDrift(c.Request.Context(), xdb.Default(), c.Param("scanId"))
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "drift not found"})
+		return
+	}
+	if err != nil {
+		logx.Errorf("GetAttackSurfaceDrift: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, drift)
 }
@@ -0,0 +1,47 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/internal/mcp"
+	"github.com/xid-protocol/xidp/internal/mcptools"
+)
+
+const principalKey = "principal"
+
+// Authenticate 按 MCP.clients 的 token 鉴权，调用方放入上下文，未配置或不匹配时返回 401
+func Authenticate(c *gin.Context) {
+	p, ok := mcptools.Authenticate(c.Request)
+	if !ok {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Set(principalKey, p)
+}
+
+// RequireRead 要求已鉴权的调用方可读 path
+func RequireRead(path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := principal(c).CheckRead(path); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		}
+	}
+}
+
+// RequireWrite 要求已鉴权的调用方可写 path
+func RequireWrite(path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := principal(c).CheckWrite(path); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		}
+	}
+}
+
+// principal 返回 Authenticate 放入的调用方，未经鉴权时为 nil
+func principal(c *gin.Context) *mcp.Principal {
+	v, _ := c.Get(principalKey)
+	p, _ := v.(*mcp.Principal)
+	return p
+}
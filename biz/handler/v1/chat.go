@@ -0,0 +1,198 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/protocols/mcpchat"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+// Chat 创建或继续一个 thread，并以 SSE 推送 agent 输出直到 end 或 cancelled
+func Chat(c *gin.Context) {
+	var req mcpchat.ChatRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if req.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content is required"})
+		return
+	}
+
+	tm := mcpchat.ThreadMan()
+	ctx, events, threadID, err := tm.StartThread(c.Request.Context(), req)
+	switch {
+	case errors.Is(err, mcpchat.ErrThreadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, mcpchat.ErrThreadRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		logx.Errorf("Chat error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	req.ThreadID = threadID
+	// 客户端断开不影响 agent 继续执行，取消走 DELETE /chat/threads/:id
+	go tm.Run(ctx, threadID, req)
+
+	c.Header("X-Thread-ID", threadID)
+	writeChatEvents(c, events)
+}
+
+// writeChatEvents 推送事件直到 end、cancelled 或客户端断开
+func writeChatEvents(c *gin.Context, events <-chan mcpchat.ChatEvent) {
+	sseStart(c)
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeSSE(c, e.ID, e.Type, e); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			if e.Final() {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// CancelChatThread 取消 thread 的本轮执行，thread 可以在任一副本运行
+func CancelChatThread(c *gin.Context) {
+	ok, err := mcpchat.ThreadMan().CancelThread(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no running thread " + c.Param("id")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "cancelled"})
+}
+
+type ToolDecisionRequest struct {
+	Comment string `json:"comment"`
+}
+
+// ApproveChatTool 放行等待审批的工具调用，鉴权的调用方为审批人；thread 可以在任一副本运行
+func ApproveChatTool(c *gin.Context) {
+	decideChatTool(c, true)
+}
+
+// RejectChatTool 拒绝等待审批的工具调用，agent 收到拒绝结果后继续
+func RejectChatTool(c *gin.Context) {
+	decideChatTool(c, false)
+}
+
+func decideChatTool(c *gin.Context, approved bool) {
+	var req ToolDecisionRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	err := mcpchat.ThreadMan().DecideTool(c.Request.Context(), c.Param("id"), c.Param("stepId"), mcpchat.ToolDecision{
+		Approved: approved,
+		Actor:    principal(c).Name,
+		Comment:  req.Comment,
+	})
+	if errors.Is(err, mcpchat.ErrNoPendingApproval) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("decideChatTool error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"approved": approved})
+}
+
+// ListChatThreads 按 taskID 列出 thread，最近活跃的在前
+func ListChatThreads(c *gin.Context) {
+	pageSize := 0
+	if v := c.Query("pageSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pageSize"})
+			return
+		}
+		pageSize = n
+	}
+	threads, next, err := mcpchat.ListThreads(c.Request.Context(), xdb.Default(), c.Query("taskID"), pageSize, c.Query("cursor"))
+	if err != nil {
+		logx.Errorf("ListChatThreads error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":      threads,
+		"nextCursor": next,
+	})
+}
+
+// GetChatThread 返回 thread 及完整的消息历史
+func GetChatThread(c *gin.Context) {
+	ctx := c.Request.Context()
+	thread, err := mcpchat.GetThread(ctx, xdb.Default(), c.Param("id"))
+	if errors.Is(err, mcpchat.ErrThreadNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("GetChatThread error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	messages, err := mcpchat.ListMessages(ctx, xdb.Default(), thread.ThreadID)
+	if err != nil {
+		logx.Errorf("GetChatThread error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"thread": thread, "messages": messages})
+}
+
+// FollowChatThread 重连或旁听 thread，按 Last-Event-ID 补发错过的事件，overflow 指定慢读时的处理策略
+func FollowChatThread(c *gin.Context) {
+	after, err := lastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last event id"})
+		return
+	}
+	policy, err := mcpchat.ParseOverflowPolicy(c.Query("overflow"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := mcpchat.ThreadMan().Follow(c.Request.Context(), c.Param("id"), after, policy)
+	if errors.Is(err, mcpchat.ErrThreadNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("FollowChatThread error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	writeChatEvents(c, events)
+}
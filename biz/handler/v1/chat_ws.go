@@ -0,0 +1,297 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/xidp/internal/mcp"
+	"github.com/xid-protocol/xidp/internal/mcptools"
+	"github.com/xid-protocol/xidp/protocols/mcpchat"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	wsPingInterval = 15 * time.Second
+	// 两次心跳都没有收到客户端任何消息时断开
+	wsReadTimeout  = 2*wsPingInterval + 5*time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+// client -> server
+const (
+	wsUserInput   = "user_input"
+	wsCancel      = "cancel"
+	wsApproveTool = "approve_tool"
+	wsRejectTool  = "reject_tool"
+	wsPing        = "ping"
+	wsPong        = "pong"
+)
+
+// server -> client，除 ChatEvent 的类型外还有 ping、pong 和 error
+const wsError = "error"
+
+// ChatClientMessage is a message a WebSocket client sends.
+type ChatClientMessage struct {
+	Type        string `json:"type"`
+	Content     string `json:"content,omitempty"`
+	MessageID   string `json:"messageID,omitempty"`
+	TaskID      string `json:"taskID,omitempty"`
+	ProjectName string `json:"projectName,omitempty"`
+	StepID      string `json:"stepID,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// ChatWebSocket 双向对话：客户端发送输入、取消和工具审批，服务端推送 ChatEvent。
+// threadID 参数用于连接已有 thread，Last-Event-ID 或 lastEventId 补发错过的事件。
+// 连接已有 thread 需要可读 /protocols/mcpchat，输入、取消和工具审批需要可写，
+// 工具审批以连接的客户端为操作人。
+func ChatWebSocket(c *gin.Context) {
+	p, ok := wsAuthenticate(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	after, err := lastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last event id"})
+		return
+	}
+	threadID := c.Query("threadID")
+	srv := websocket.Server{
+		Handshake: wsCheckOrigin,
+		Handler: func(conn *websocket.Conn) {
+			s := &wsSession{conn: conn, tm: mcpchat.ThreadMan(), principal: p, threadID: threadID}
+			s.serve(after)
+		},
+	}
+	srv.ServeHTTP(c.Writer, c.Request)
+}
+
+// wsAuthenticate 按 MCP.clients 的 token 鉴权，未配置客户端时拒绝所有连接。
+// 浏览器无法在升级请求上设置 header，因此也接受 token 参数。
+func wsAuthenticate(c *gin.Context) (*mcp.Principal, bool) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		token = c.Query("token")
+	}
+	return mcptools.AuthenticateToken(token)
+}
+
+// wsCheckOrigin 只允许 Chat.ws_origins 中的来源，未配置时来源须与 Host 相同。
+// 不带 Origin 的非浏览器客户端不受限制。
+func wsCheckOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	origins := viper.GetStringSlice("Chat.ws_origins")
+	if len(origins) == 0 {
+		if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+			return nil
+		}
+		return fmt.Errorf("origin %q does not match host %q", origin, r.Host)
+	}
+	for _, o := range origins {
+		if origin == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q not allowed", origin)
+}
+
+type wsSession struct {
+	conn      *websocket.Conn
+	tm        *mcpchat.ThreadManager
+	principal *mcp.Principal
+	threadID  string
+
+	writeMu    sync.Mutex
+	stopFollow context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+func (s *wsSession) serve(after int64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		// 断开连接只停止推送，不取消 thread
+		cancel()
+		s.wg.Wait()
+		s.conn.Close()
+	}()
+
+	if s.threadID != "" {
+		if err := s.principal.CheckRead(mcpchat.PathThread); err != nil {
+			s.sendError(err)
+			return
+		}
+		followCtx, stop := context.WithCancel(ctx)
+		events, err := s.tm.Follow(followCtx, s.threadID, after, mcpchat.OverflowSpill)
+		if err != nil {
+			stop()
+			s.sendError(err)
+			return
+		}
+		s.followWith(stop, events)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.heartbeat(ctx)
+	}()
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		var msg ChatClientMessage
+		if err := websocket.JSON.Receive(s.conn, &msg); err != nil {
+			if ctx.Err() == nil && !isClosed(err) {
+				logx.Errorf("chat websocket receive: %v", err)
+			}
+			return
+		}
+		s.handle(ctx, msg)
+	}
+}
+
+func isClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
+
+func (s *wsSession) handle(ctx context.Context, msg ChatClientMessage) {
+	switch msg.Type {
+	case wsUserInput:
+		s.userInput(ctx, msg)
+	case wsCancel:
+		if s.threadID == "" {
+			s.sendError(errors.New("no running thread to cancel"))
+			return
+		}
+		if err := s.principal.CheckWrite(mcpchat.PathThread); err != nil {
+			s.sendError(err)
+			return
+		}
+		ok, err := s.tm.CancelThread(ctx, s.threadID)
+		if err == nil && !ok {
+			err = errors.New("no running thread to cancel")
+		}
+		if err != nil {
+			s.sendError(err)
+		}
+	case wsApproveTool, wsRejectTool:
+		if msg.StepID == "" {
+			s.sendError(errors.New("stepID is required"))
+			return
+		}
+		if err := s.principal.CheckWrite(mcpchat.PathThread); err != nil {
+			s.sendError(err)
+			return
+		}
+		err := s.tm.DecideTool(ctx, s.threadID, msg.StepID, mcpchat.ToolDecision{
+			Approved: msg.Type == wsApproveTool,
+			Actor:    s.principal.Name,
+			Comment:  msg.Comment,
+		})
+		if err != nil {
+			s.sendError(err)
+		}
+	case wsPing:
+		s.send(mcpchat.ChatEvent{ThreadID: s.threadID, Type: wsPong})
+	case wsPong:
+	default:
+		s.sendError(fmt.Errorf("unknown message type %q", msg.Type))
+	}
+}
+
+// userInput 开始新一轮对话；thread 正在运行时作为追加输入交给 agent
+func (s *wsSession) userInput(ctx context.Context, msg ChatClientMessage) {
+	if msg.Content == "" {
+		s.sendError(errors.New("content is required"))
+		return
+	}
+	if err := s.principal.CheckWrite(mcpchat.PathThread); err != nil {
+		s.sendError(err)
+		return
+	}
+	req := mcpchat.ChatRequest{
+		ProjectName: msg.ProjectName,
+		TaskID:      msg.TaskID,
+		MessageID:   msg.MessageID,
+		ThreadID:    s.threadID,
+		Content:     msg.Content,
+	}
+	if s.threadID != "" && s.tm.GetThreadStatus(s.threadID) == mcptask.StatusRunning {
+		if err := s.tm.PushInput(ctx, req); err != nil {
+			s.sendError(err)
+		}
+		return
+	}
+
+	followCtx, cancel := context.WithCancel(ctx)
+	runCtx, events, threadID, err := s.tm.StartThread(followCtx, req)
+	if err != nil {
+		cancel()
+		s.sendError(err)
+		return
+	}
+	s.threadID = threadID
+	req.ThreadID = threadID
+	s.followWith(cancel, events)
+	go s.tm.Run(runCtx, threadID, req)
+}
+
+// followWith 转发事件到客户端，替换之前的转发
+func (s *wsSession) followWith(cancel context.CancelFunc, events <-chan mcpchat.ChatEvent) {
+	if s.stopFollow != nil {
+		s.stopFollow()
+	}
+	s.stopFollow = cancel
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for ev := range events {
+			if err := s.send(ev); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (s *wsSession) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.send(mcpchat.ChatEvent{Type: wsPing}); err != nil {
+				// 写失败时关闭连接，让读循环退出
+				s.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *wsSession) send(ev mcpchat.ChatEvent) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return websocket.JSON.Send(s.conn, ev)
+}
+
+func (s *wsSession) sendError(err error) {
+	s.send(mcpchat.ChatEvent{ThreadID: s.threadID, Agent: "system", Content: err.Error(), Type: wsError})
+}
@@ -0,0 +1,138 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/internal/eventlog"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+const sseHeartbeat = 15 * time.Second
+
+// 事件类型来自 worker，去掉换行避免破坏 SSE 帧
+var sseField = strings.NewReplacer("\r", "", "\n", "")
+
+// PublishTaskEvent 供 worker 上报任务步骤事件，worker 需以可写 /protocols/task 的 token 鉴权
+func PublishTaskEvent(c *gin.Context) {
+	var ev task.StepEvent
+	if err := c.BindJSON(&ev); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	e, err := task.PublishStepEvent(c.Request.Context(), xdb.Default(), c.Param("id"), ev)
+	if err != nil {
+		taskError(c, "PublishTaskEvent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"event": e})
+}
+
+// StreamTaskEvents 以 SSE 推送任务的步骤事件，Last-Event-ID 或 lastEventId 参数用于断点重放
+func StreamTaskEvents(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := task.Get(c.Request.Context(), xdb.Default(), id); err != nil {
+		taskError(c, "StreamTaskEvents", err)
+		return
+	}
+	streamEvents(c, task.EventStream(id))
+}
+
+// PublishThreadEvent 供 worker 上报 mcptask 线程的步骤事件，鉴权同 PublishTaskEvent
+func PublishThreadEvent(c *gin.Context) {
+	var ev mcptask.StepEvent
+	if err := c.BindJSON(&ev); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	e, err := mcptask.PublishStepEvent(c.Request.Context(), xdb.Default(), c.Param("id"), ev)
+	if errors.Is(err, mcptask.ErrInvalidEvent) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("PublishThreadEvent error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"event": e})
+}
+
+func StreamThreadEvents(c *gin.Context) {
+	streamEvents(c, mcptask.ThreadEventStream(c.Param("id")))
+}
+
+func lastEventID(c *gin.Context) (int64, error) {
+	v := c.GetHeader("Last-Event-ID")
+	if v == "" {
+		v = c.Query("lastEventId")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// streamEvents replays the stream after the client's last event ID and
+// keeps pushing new events until the client disconnects.
+func streamEvents(c *gin.Context, stream string) {
+	after, err := lastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last event id"})
+		return
+	}
+	ctx := c.Request.Context()
+	events := eventlog.Subscribe(ctx, xdb.Default(), stream, after)
+
+	sseStart(c)
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeSSE(c, e.ID, e.Type, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+func sseStart(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+}
+
+// writeSSE writes one event frame with v encoded as JSON data.
+func writeSSE(c *gin.Context, id int64, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logx.Errorf("marshal sse event %d: %v", id, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", id, sseField.Replace(event), data)
+	return err
+}
@@ -0,0 +1,19 @@
+package v1
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/internal/mcptools"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+var mcpHandler = sync.OnceValue(func() http.Handler {
+	return mcptools.NewServer(xdb.Default()).HTTPHandler(mcptools.Authenticate)
+})
+
+// MCP 以 streamable HTTP 提供 MCP 工具，客户端按 MCP.clients 的 token 鉴权
+func MCP(c *gin.Context) {
+	mcpHandler().ServeHTTP(c.Writer, c.Request)
+}
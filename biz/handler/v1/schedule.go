@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+// CreateSchedule 创建定时任务，cronExpression 与 startTime（一次性）二选一，创建人为鉴权的调用方
+func CreateSchedule(c *gin.Context) {
+	var req task.Schedule
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	req.CreatedBy = principal(c).Name
+	s, err := task.CreateSchedule(c.Request.Context(), xdb.Default(), req)
+	if err != nil {
+		scheduleError(c, "CreateSchedule", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedule": s})
+}
+
+func GetSchedule(c *gin.Context) {
+	s, err := task.GetSchedule(c.Request.Context(), xdb.Default(), c.Param("id"))
+	if err != nil {
+		scheduleError(c, "GetSchedule", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedule": s})
+}
+
+func ListSchedule(c *gin.Context) {
+	pageSize := 0
+	if v := c.Query("pageSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pageSize"})
+			return
+		}
+		pageSize = n
+	}
+	items, next, err := task.ListSchedules(c.Request.Context(), xdb.Default(), pageSize, c.Query("cursor"))
+	if err != nil {
+		scheduleError(c, "ListSchedule", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":      items,
+		"nextCursor": next,
+	})
+}
+
+// DeleteSchedule 停止定时任务，已创建的任务不受影响
+func DeleteSchedule(c *gin.Context) {
+	if err := task.DeleteSchedule(c.Request.Context(), xdb.Default(), c.Param("id")); err != nil {
+		scheduleError(c, "DeleteSchedule", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func scheduleError(c *gin.Context, name string, err error) {
+	if errors.Is(err, task.ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	taskError(c, name, err)
+}
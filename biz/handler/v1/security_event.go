@@ -0,0 +1,135 @@
+package v1
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/colin-404/logx"
+	"github.com/gin-gonic/gin"
+	securityevent "github.com/xid-protocol/xidp/protocols/security_event"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+type AttackStatusRequest struct {
+	Status securityevent.AttackStatus `json:"status"`
+	Detail string                     `json:"detail"`
+}
+
+// AddAttackEvent 记录攻击链中的一步，parent_id 指向上一步
+func AddAttackEvent(c *gin.Context) {
+	var req securityevent.AddRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	e, err := securityevent.AddEvent(c.Request.Context(), xdb.Default(), req)
+	if err != nil {
+		attackError(c, "AddAttackEvent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"event": e})
+}
+
+func GetAttackEvent(c *gin.Context) {
+	e, err := securityevent.GetEvent(c.Request.Context(), xdb.Default(), c.Param("id"))
+	if err != nil {
+		attackError(c, "GetAttackEvent", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"event": e})
+}
+
+// UpdateAttackStatus 更新攻击进展，结束后的攻击不能再修改
+func UpdateAttackStatus(c *gin.Context) {
+	var req AttackStatusRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	e, err := securityevent.UpdateStatus(c.Request.Context(), xdb.Default(), c.Param("id"), req.Status, req.Detail)
+	if err != nil {
+		attackError(c, "UpdateAttackStatus", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"event": e})
+}
+
+// ListAssetAttackEvents 按资产 xid 列出攻击事件，按创建时间倒序
+func ListAssetAttackEvents(c *gin.Context) {
+	pageSize, ok := queryPageSize(c)
+	if !ok {
+		return
+	}
+	events, next, err := securityevent.ListAssetEvents(c.Request.Context(), xdb.Default(), c.Param("xid"), pageSize, c.Query("cursor"))
+	if err != nil {
+		attackError(c, "ListAssetAttackEvents", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":      events,
+		"nextCursor": next,
+	})
+}
+
+// GetAttackGraph 返回 taskID 或 threadID 下的完整攻击图
+func GetAttackGraph(c *gin.Context) {
+	g, err := attackGraph(c)
+	if err != nil {
+		attackError(c, "GetAttackGraph", err)
+		return
+	}
+	c.JSON(http.StatusOK, g)
+}
+
+// GetAttackPaths 计算攻击图中从 entry（事件ID或资产）到 target 资产的攻击路径
+func GetAttackPaths(c *gin.Context) {
+	entry, target := c.Query("entry"), c.Query("target")
+	if entry == "" || target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entry and target are required"})
+		return
+	}
+	g, err := attackGraph(c)
+	if err != nil {
+		attackError(c, "GetAttackPaths", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"paths": g.Paths(entry, target)})
+}
+
+// ExportAttackGraph 按 format（dot、mermaid、stix）导出 taskID 或 threadID 下的攻击图
+func ExportAttackGraph(c *gin.Context) {
+	format := securityevent.Format(c.DefaultQuery("format", string(securityevent.FormatDOT)))
+	g, err := attackGraph(c)
+	if err != nil {
+		attackError(c, "ExportAttackGraph", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := securityevent.Export(&buf, g, format); err != nil {
+		attackError(c, "ExportAttackGraph", err)
+		return
+	}
+	c.Data(http.StatusOK, format.ContentType(), buf.Bytes())
+}
+
+func attackGraph(c *gin.Context) (*securityevent.Graph, error) {
+	return securityevent.GetGraph(c.Request.Context(), xdb.Default(), securityevent.GraphFilter{
+		TaskID:   c.Query("taskID"),
+		ThreadID: c.Query("threadID"),
+	})
+}
+
+func attackError(c *gin.Context, op string, err error) {
+	switch {
+	case errors.Is(err, securityevent.ErrAttackEventNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, securityevent.ErrInvalidAttackEvent), errors.Is(err, securityevent.ErrUnknownFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, securityevent.ErrAttackEventExists), errors.Is(err, securityevent.ErrAttackFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logx.Errorf("%s error: %v", op, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/colin-404/logx"
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+type TaskActionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CreateTask 创建任务，draft 为 false 时直接进入 pending，创建人为鉴权的调用方
+func CreateTask(c *gin.Context) {
+	var req task.CreateRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	req.CreatedBy = principal(c).Name
+	t, err := task.Create(c.Request.Context(), xdb.Default(), req)
+	if err != nil {
+		taskError(c, "CreateTask", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task": t})
+}
+
+func GetTask(c *gin.Context) {
+	t, err := task.Get(c.Request.Context(), xdb.Default(), c.Param("id"))
+	if err != nil {
+		taskError(c, "GetTask", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task": t})
+}
+
+// ListTask 按 status、taskType、createdBy 过滤，按创建时间倒序
+func ListTask(c *gin.Context) {
+	filter := task.ListFilter{
+		Status:    task.TaskStatus(c.Query("status")),
+		TaskType:  c.Query("taskType"),
+		CreatedBy: c.Query("createdBy"),
+		Cursor:    c.Query("cursor"),
+	}
+	if pageSize := c.Query("pageSize"); pageSize != "" {
+		n, err := strconv.Atoi(pageSize)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pageSize"})
+			return
+		}
+		filter.PageSize = n
+	}
+	tasks, next, err := task.List(c.Request.Context(), xdb.Default(), filter)
+	if err != nil {
+		taskError(c, "ListTask", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":      tasks,
+		"nextCursor": next,
+	})
+}
+
+// GetTaskDAG 返回任务、步骤及上下游任务的依赖图和当前状态
+func GetTaskDAG(c *gin.Context) {
+	dag, err := task.GetDAG(c.Request.Context(), xdb.Default(), c.Param("id"))
+	if err != nil {
+		taskError(c, "GetTaskDAG", err)
+		return
+	}
+	c.JSON(http.StatusOK, dag)
+}
+
+func SubmitTask(c *gin.Context) {
+	taskAction(c, "SubmitTask", func(actor string, req TaskActionRequest) (*task.Task, error) {
+		return task.Submit(c.Request.Context(), xdb.Default(), c.Param("id"), actor)
+	})
+}
+
+func CancelTask(c *gin.Context) {
+	taskAction(c, "CancelTask", func(actor string, req TaskActionRequest) (*task.Task, error) {
+		return task.Cancel(c.Request.Context(), xdb.Default(), c.Param("id"), actor, req.Reason)
+	})
+}
+
+// RetryTask 失败、取消或超时的任务重新进入 pending
+func RetryTask(c *gin.Context) {
+	taskAction(c, "RetryTask", func(actor string, req TaskActionRequest) (*task.Task, error) {
+		return task.Retry(c.Request.Context(), xdb.Default(), c.Param("id"), actor, req.Reason)
+	})
+}
+
+// taskAction 以鉴权的调用方为操作人执行 action
+func taskAction(c *gin.Context, name string, action func(actor string, req TaskActionRequest) (*task.Task, error)) {
+	var req TaskActionRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	t, err := action(principal(c).Name, req)
+	if err != nil {
+		taskError(c, name, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task": t})
+}
+
+func taskError(c *gin.Context, name string, err error) {
+	switch {
+	case errors.Is(err, task.ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrInvalidTask):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrInvalidTransition), errors.Is(err, task.ErrTaskConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logx.Errorf("%s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
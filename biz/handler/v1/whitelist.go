@@ -1,43 +1,196 @@
 package v1
 
-// type CreateWhitelistRequest struct {
-// 	Type  string      `json:"type"`
-// 	Value interface{} `json:"value"`
-// }
-
-// func CreateWhitelist(c *gin.Context) {
-// 	var req CreateWhitelistRequest
-// 	err := c.BindJSON(&req)
-// 	if err != nil {
-// 		c.JSON(400, gin.H{"error": "Invalid JSON: " + err.Error()})
-// 		return
-// 	}
-
-// 	if req.Type == "awsOpenPort" {
-// 		value, ok := req.Value.(whitelist.AWSOpenPort)
-// 		if !ok {
-// 			c.JSON(400, gin.H{"error": "Invalid value format for awsOpenPort type"})
-// 			return
-// 		}
-
-// 		hash := sha256.Sum256([]byte(fmt.Sprintf("%+v", value)))
-// 		sha256Value := fmt.Sprintf("%x", hash)
-// 		xid := protocols.GenerateXid(value.InstanceID)
-
-// 		wl := whitelist.Whitelist{
-// 			Type:        req.Type,
-// 			Value:       value,
-// 			Sha256Value: sha256Value,
-// 		}
-
-// 		whitelist, err := whitelist.NewWhitelist(xid, req.Type, wl)
-// 		if err != nil {
-// 			c.JSON(400, gin.H{"error": "Invalid JSON: " + err.Error()})
-// 			return
-// 		}
-// 		c.JSON(200, gin.H{"whitelist": whitelist})
-// 		return
-// 	}
-
-// 	c.JSON(400, gin.H{"error": "Unsupported type"})
-// }
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/colin-404/logx"
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/protocols/whitelist"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+type DecideWhitelistRequest struct {
+	Comment string `json:"comment"`
+}
+
+type CheckWhitelistRequest struct {
+	Type    string          `json:"type"`
+	Subject json.RawMessage `json:"subject"`
+}
+
+type RevokeWhitelistRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CreateWhitelist 提交白名单申请，审批通过后才生效
+// 申请人为鉴权的调用方，owner、justification、expiresAt、approver 必填，approver 不能是申请人
+func CreateWhitelist(c *gin.Context) {
+	var req whitelist.CreateRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	req.CreatedBy = principal(c).Name
+
+	wl, err := whitelist.Create(c.Request.Context(), xdb.Default(), req)
+	if errors.Is(err, whitelist.ErrWhitelistExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "whitelist": wl})
+		return
+	}
+	if errors.Is(err, whitelist.ErrInvalidWhitelist) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("CreateWhitelist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"whitelist": wl})
+}
+
+// ListWhitelist 按 type、owner、approver、status、instanceId 过滤
+// status: pending/approved/rejected/expired/revoked
+func ListWhitelist(c *gin.Context) {
+	filter := whitelist.ListFilter{
+		Type:       c.Query("type"),
+		Owner:      c.Query("owner"),
+		Approver:   c.Query("approver"),
+		Status:     whitelist.Status(c.Query("status")),
+		InstanceID: c.Query("instanceId"),
+		Cursor:     c.Query("cursor"),
+	}
+	switch filter.Status {
+	case "", whitelist.StatusPending, whitelist.StatusApproved, whitelist.StatusRejected,
+		whitelist.StatusExpired, whitelist.StatusRevoked:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	if pageSize := c.Query("pageSize"); pageSize != "" {
+		n, err := strconv.Atoi(pageSize)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pageSize"})
+			return
+		}
+		filter.PageSize = n
+	}
+
+	items, next, err := whitelist.List(c.Request.Context(), xdb.Default(), filter)
+	if err != nil {
+		logx.Errorf("ListWhitelist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":      items,
+		"nextCursor": next,
+	})
+}
+
+func GetWhitelist(c *gin.Context) {
+	wl, err := whitelist.Get(c.Request.Context(), xdb.Default(), c.Param("id"))
+	if errors.Is(err, whitelist.ErrWhitelistMissing) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("GetWhitelist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"whitelist": wl})
+}
+
+// RevokeWhitelist 撤销白名单，只有申请人或审批人可以操作，卡片保留用于审计
+func RevokeWhitelist(c *gin.Context) {
+	var req RevokeWhitelistRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+
+	wl, err := whitelist.Revoke(c.Request.Context(), xdb.Default(), c.Param("id"), principal(c).Name, req.Reason)
+	if errors.Is(err, whitelist.ErrWhitelistMissing) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, whitelist.ErrNotRevoker) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, whitelist.ErrNotPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("RevokeWhitelist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"whitelist": wl})
+}
+
+// ApproveWhitelist 审批通过，只有指定的审批人（鉴权的调用方）可以操作
+func ApproveWhitelist(c *gin.Context) {
+	decideWhitelist(c, whitelist.Approve)
+}
+
+// RejectWhitelist 驳回申请
+func RejectWhitelist(c *gin.Context) {
+	decideWhitelist(c, whitelist.Reject)
+}
+
+func decideWhitelist(c *gin.Context, decide func(context.Context, xdb.XIDRepo, string, string, string) (*whitelist.Whitelist, error)) {
+	var req DecideWhitelistRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+
+	wl, err := decide(c.Request.Context(), xdb.Default(), c.Param("id"), principal(c).Name, req.Comment)
+	switch {
+	case errors.Is(err, whitelist.ErrWhitelistMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, whitelist.ErrNotApprover):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, whitelist.ErrNotPending), errors.Is(err, whitelist.ErrWhitelistExpired):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		logx.Errorf("decideWhitelist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"whitelist": wl})
+	}
+}
+
+// CheckWhitelist 判断 subject 是否被已审批且未过期的白名单放行
+// subject 的结构由 type 决定
+func CheckWhitelist(c *gin.Context) {
+	var req CheckWhitelistRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+
+	match, err := whitelist.Check(c.Request.Context(), xdb.Default(), req.Type, req.Subject)
+	if errors.Is(err, whitelist.ErrInvalidWhitelist) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logx.Errorf("CheckWhitelist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, match)
+}
+
+// GetWhitelistTypes 返回已注册的白名单类型
+func GetWhitelistTypes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"types": whitelist.Types()})
+}
@@ -0,0 +1,141 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+type LeaseStepRequest struct {
+	WorkerID string `json:"workerId"`
+	// 租约时长（秒），为 0 使用 Task.visibility_timeout
+	VisibilityTimeout int64 `json:"visibilityTimeout"`
+}
+
+type ExtendLeaseRequest struct {
+	TaskID            string `json:"taskId"`
+	StepID            string `json:"stepId"`
+	LeaseID           string `json:"leaseId"`
+	WorkerID          string `json:"workerId"`
+	VisibilityTimeout int64  `json:"visibilityTimeout"`
+}
+
+// RegisterWorker 注册 worker 及其能力，重复注册会更新能力。
+// worker 归属于鉴权的调用方，之后的心跳、领取和完成只接受该调用方
+func RegisterWorker(c *gin.Context) {
+	var req task.Worker
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	req.Principal = principal(c).Name
+	w, err := task.RegisterWorker(c.Request.Context(), xdb.Default(), req)
+	if err != nil {
+		workerError(c, "RegisterWorker", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"worker": w})
+}
+
+func WorkerHeartbeat(c *gin.Context) {
+	if !ownWorker(c, "WorkerHeartbeat", c.Param("id")) {
+		return
+	}
+	if err := task.Heartbeat(c.Request.Context(), xdb.Default(), c.Param("id")); err != nil {
+		workerError(c, "WorkerHeartbeat", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ListWorkers online=true 只返回在线的 worker
+func ListWorkers(c *gin.Context) {
+	workers, err := task.ListWorkers(c.Request.Context(), xdb.Default(), c.Query("online") == "true")
+	if err != nil {
+		workerError(c, "ListWorkers", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": workers})
+}
+
+// LeaseStep 为 worker 分配一个步骤，没有可执行的步骤时返回 204
+func LeaseStep(c *gin.Context) {
+	var req LeaseStepRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if !ownWorker(c, "LeaseStep", req.WorkerID) {
+		return
+	}
+	lease, err := task.LeaseStep(c.Request.Context(), xdb.Default(), req.WorkerID, req.VisibilityTimeout)
+	if errors.Is(err, task.ErrNoStep) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		workerError(c, "LeaseStep", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"lease": lease})
+}
+
+func ExtendLease(c *gin.Context) {
+	var req ExtendLeaseRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if !ownWorker(c, "ExtendLease", req.WorkerID) {
+		return
+	}
+	expiresAt, err := task.ExtendLease(c.Request.Context(), xdb.Default(), req.TaskID, req.StepID, req.LeaseID, req.WorkerID, req.VisibilityTimeout)
+	if err != nil {
+		workerError(c, "ExtendLease", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"expiresAt": expiresAt})
+}
+
+// CompleteStep 写入步骤结果，error 非空表示失败
+func CompleteStep(c *gin.Context) {
+	var req task.CompleteRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: " + err.Error()})
+		return
+	}
+	if !ownWorker(c, "CompleteStep", req.WorkerID) {
+		return
+	}
+	t, err := task.CompleteStep(c.Request.Context(), xdb.Default(), req)
+	if err != nil {
+		workerError(c, "CompleteStep", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task": t})
+}
+
+// ownWorker 检查 worker 由鉴权的调用方注册，否则写入错误响应
+func ownWorker(c *gin.Context, name, workerID string) bool {
+	if _, err := task.CheckWorker(c.Request.Context(), xdb.Default(), workerID, principal(c).Name); err != nil {
+		workerError(c, name, err)
+		return false
+	}
+	return true
+}
+
+func workerError(c *gin.Context, name string, err error) {
+	switch {
+	case errors.Is(err, task.ErrWorkerForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrWorkerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, task.ErrWorkerOffline), errors.Is(err, task.ErrLeaseExpired):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		taskError(c, name, err)
+	}
+}
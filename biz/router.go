@@ -1,8 +1,14 @@
 package biz
 
 import (
+	"expvar"
+
 	"github.com/gin-gonic/gin"
 	v1 "github.com/xid-protocol/xidp/biz/handler/v1"
+	"github.com/xid-protocol/xidp/protocols/aiagent"
+	"github.com/xid-protocol/xidp/protocols/mcpchat"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/protocols/whitelist"
 )
 
 func RegisterRouter(r *gin.Engine) {
@@ -24,21 +30,107 @@ func RegisterRouter(r *gin.Engine) {
 		//notify
 		notifyGroup := apiv1Group.Group("/notify")
 		{
-			notifyGroup.POST("/lark", func(c *gin.Context) {
-				message := c.Query("message")
-				v1.SendToLark(message)
-			})
+			notifyGroup.POST("/lark", v1.NotifyLark)
+		}
+
+		// 运行指标仅对可读 /debug/vars 的客户端开放
+		apiv1Group.GET("/debug/vars", v1.Authenticate, v1.RequireRead("/debug/vars"), gin.WrapH(expvar.Handler()))
+		apiv1Group.Any("/mcp", v1.MCP)
+		apiv1Group.POST("/chat", v1.Authenticate, v1.RequireWrite(mcpchat.PathThread), v1.Chat)
+		chatGroup := apiv1Group.Group("/chat")
+		{
+			// websocket 在连接时自行鉴权
+			chatGroup.GET("/ws", v1.ChatWebSocket)
+			readChat := chatGroup.Group("", v1.Authenticate, v1.RequireRead(mcpchat.PathThread))
+			readChat.GET("/threads", v1.ListChatThreads)
+			readChat.GET("/threads/:id", v1.GetChatThread)
+			readChat.GET("/threads/:id/events", v1.FollowChatThread)
+			// 审批人为鉴权的调用方
+			writeChat := chatGroup.Group("", v1.Authenticate, v1.RequireWrite(mcpchat.PathThread))
+			writeChat.DELETE("/threads/:id", v1.CancelChatThread)
+			writeChat.POST("/threads/:id/approve/:stepId", v1.ApproveChatTool)
+			writeChat.POST("/threads/:id/reject/:stepId", v1.RejectChatTool)
 		}
 
 		protocolGroup := apiv1Group.Group("/protocols")
 		{
 			attackSurface := protocolGroup.Group("/attack-surface")
 			attackSurface.GET("/list", v1.GetAttackSurface)
+			attackSurface.GET("/detail/:xid", v1.GetAttackSurfaceDetail)
+			attackSurface.POST("/scan", v1.ScanAttackSurface)
+			attackSurface.GET("/drift", v1.GetAttackSurfaceDrift)
+			attackSurface.GET("/drift/:scanId", v1.GetAttackSurfaceDrift)
+
+		}
+		whitelistGroup := protocolGroup.Group("/whitelist")
+		{
+			// 申请、审批、撤销以鉴权的调用方为操作人
+			writeWhitelist := whitelistGroup.Group("", v1.Authenticate, v1.RequireWrite(whitelist.Path))
+			writeWhitelist.POST("/create", v1.CreateWhitelist)
+			whitelistGroup.GET("/list", v1.ListWhitelist)
+			whitelistGroup.POST("/check", v1.CheckWhitelist)
+			whitelistGroup.GET("/types", v1.GetWhitelistTypes)
+			whitelistGroup.GET("/detail/:id", v1.GetWhitelist)
+			writeWhitelist.POST("/approve/:id", v1.ApproveWhitelist)
+			writeWhitelist.POST("/reject/:id", v1.RejectWhitelist)
+			writeWhitelist.POST("/revoke/:id", v1.RevokeWhitelist)
+		}
+		taskGroup := protocolGroup.Group("/task")
+		{
+			// 创建和状态变更以鉴权的调用方为操作人
+			writeTask := taskGroup.Group("", v1.Authenticate, v1.RequireWrite(task.Path))
+			writeTask.POST("/create", v1.CreateTask)
+			taskGroup.GET("/list", v1.ListTask)
+			taskGroup.GET("/detail/:id", v1.GetTask)
+			taskGroup.GET("/dag/:id", v1.GetTaskDAG)
+			writeTask.POST("/submit/:id", v1.SubmitTask)
+			writeTask.POST("/cancel/:id", v1.CancelTask)
+			writeTask.POST("/retry/:id", v1.RetryTask)
+			taskGroup.GET("/events/:id", v1.StreamTaskEvents)
+			writeTask.POST("/events/:id", v1.PublishTaskEvent)
+
+			workerGroup := taskGroup.Group("/worker")
+			workerGroup.GET("/list", v1.ListWorkers)
+			// worker 以鉴权的调用方注册，领取和完成步骤时校验归属
+			writeTask.POST("/worker/register", v1.RegisterWorker)
+			writeTask.POST("/worker/heartbeat/:id", v1.WorkerHeartbeat)
+			writeTask.POST("/step/lease", v1.LeaseStep)
+			writeTask.POST("/step/extend", v1.ExtendLease)
+			writeTask.POST("/step/complete", v1.CompleteStep)
 
+			scheduleGroup := taskGroup.Group("/schedule")
+			scheduleGroup.GET("/list", v1.ListSchedule)
+			scheduleGroup.GET("/detail/:id", v1.GetSchedule)
+			writeTask.POST("/schedule/create", v1.CreateSchedule)
+			writeTask.POST("/schedule/delete/:id", v1.DeleteSchedule)
+		}
+		securityEventGroup := protocolGroup.Group("/security-event")
+		{
+			securityEventGroup.POST("/attack/create", v1.AddAttackEvent)
+			securityEventGroup.GET("/attack/detail/:id", v1.GetAttackEvent)
+			securityEventGroup.POST("/attack/status/:id", v1.UpdateAttackStatus)
+			securityEventGroup.GET("/attack/asset/:xid", v1.ListAssetAttackEvents)
+			securityEventGroup.GET("/attack/graph", v1.GetAttackGraph)
+			securityEventGroup.GET("/attack/paths", v1.GetAttackPaths)
+			securityEventGroup.GET("/attack/export", v1.ExportAttackGraph)
+		}
+		mcptaskGroup := protocolGroup.Group("/mcptask")
+		{
+			mcptaskGroup.GET("/thread/events/:id", v1.StreamThreadEvents)
+			mcptaskGroup.POST("/thread/events/:id", v1.Authenticate, v1.RequireWrite(task.Path), v1.PublishThreadEvent)
+		}
+		aiagentGroup := protocolGroup.Group("/aiagent")
+		{
+			// 新版本以鉴权的调用方为作者
+			writeAgent := aiagentGroup.Group("", v1.Authenticate, v1.RequireWrite(aiagent.Path))
+			writeAgent.POST("/create", v1.CreateAgent)
+			aiagentGroup.GET("/list", v1.ListAgent)
+			aiagentGroup.GET("/detail/:name", v1.GetAgent)
+			writeAgent.POST("/update/:name", v1.UpdateAgent)
+			writeAgent.POST("/delete/:name", v1.DeleteAgent)
+			aiagentGroup.GET("/versions/:name", v1.ListAgentVersions)
+			aiagentGroup.GET("/version/:name/:version", v1.GetAgentVersion)
+			writeAgent.POST("/rollback/:name", v1.RollbackAgent)
 		}
-		// whitelistGroup := protocolGroup.Group("/whitelist")
-		// {
-		// 	whitelistGroup.POST("/create", v1.CreateWhitelist)
-		// }
 	}
 }
@@ -11,6 +11,7 @@ require (
 	github.com/spf13/viper v1.20.1
 	github.com/xid-protocol/common v0.2.2
 	go.mongodb.org/mongo-driver v1.17.4
+	golang.org/x/net v0.33.0
 )
 
 require (
@@ -67,7 +68,6 @@ require (
 	go.uber.org/zap v1.27.0 // indirect
 	golang.org/x/arch v0.8.0 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
-	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
@@ -0,0 +1,78 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"*/15 9-17 * * mon-fri", true},
+		{"0 0 1,15 jan,jul ?", true},
+		{"5/10 * * * *", true},
+		{"0 0 * * 7", true},
+		{"@daily", true},
+		{"@Weekly", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"@reboot", false},
+		{"a * * * *", false},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if (err == nil) != tt.valid {
+			t.Errorf("Parse(%q) error = %v, want valid %v", tt.expr, err, tt.valid)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2026-03-02 是周一
+	from := time.Date(2026, 3, 2, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2026, 3, 2, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2026, 3, 2, 10, 15, 0, 0, time.UTC)},
+		{"5/10 * * * *", from, time.Date(2026, 3, 2, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", from, time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC), time.Date(2026, 3, 3, 10, 30, 0, 0, time.UTC)},
+		{"@hourly", from, time.Date(2026, 3, 2, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", from, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", from, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", from, time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", from, time.Time{}},
+		// 两个日期字段都受限时任一匹配即可
+		{"0 0 13 * fri", from, time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 3 * sun", from, time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)},
+		// 以 * 开头的字段视为未限制，需要同时匹配
+		{"0 0 */2 * 1", from, time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * */3", from, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("Next(%q, %s) = %s, want %s", tt.expr, tt.from.Format(time.RFC3339), got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package eventlog persists ordered event streams as XID cards so that
+// subscribers can replay a stream from any event ID and then follow it live.
+package eventlog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	Path    = "/protocols/event"
+	PathSeq = "/protocols/event/seq"
+)
+
+// 其他副本写入的事件靠轮询发现，本进程写入的事件会立即唤醒订阅者
+var PollInterval = time.Second
+
+// path /protocols/event
+type Event struct {
+	ID        int64  `json:"id" bson:"id"` // sequence within the stream, starts at 1
+	Stream    string `json:"stream" bson:"stream"`
+	Type      string `json:"type" bson:"type"`
+	Data      any    `json:"data" bson:"data"`
+	CreatedAt int64  `json:"createdAt" bson:"createdAt"`
+}
+
+var (
+	mu      sync.Mutex
+	waiters = map[string]map[chan struct{}]struct{}{}
+)
+
+// Append stores an event at the end of stream and wakes local subscribers.
+func Append(ctx context.Context, repo xdb.XIDRepo, stream, typ string, data any) (*Event, error) {
+	seq, err := repo.Incr(ctx, protocols.GenerateXid(stream), PathSeq, "payload.seq", 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate event id: %v", err)
+	}
+	e := &Event{ID: seq, Stream: stream, Type: typ, Data: data, CreatedAt: common.GetTimestamp()}
+
+	info := protocols.NewInfo(stream+"#"+strconv.FormatInt(seq, 10), "event_id")
+	meta := protocols.NewMetadata(protocols.OperationCreate, Path, "application/json")
+	if err := repo.Insert(ctx, protocols.NewXID[any](&info, &meta, e)); err != nil {
+		return nil, fmt.Errorf("failed to save event: %v", err)
+	}
+	wake(stream)
+	return e, nil
+}
+
+// Since returns up to limit events of stream with an ID greater than after.
+func Since(ctx context.Context, repo xdb.XIDRepo, stream string, after int64, limit int) ([]*Event, error) {
+	docs, _, err := repo.List(ctx, xdb.Query{
+		Path:     Path,
+		Where:    map[string]any{"payload.stream": stream, "payload.id": map[string]any{"$gt": after}},
+		SortBy:   "payload.id",
+		SortAsc:  true,
+		PageSize: limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Event, 0, len(docs))
+	for _, doc := range docs {
+		e, err := decodeEvent(doc)
+		if err != nil {
+			logx.Errorf("decode event %s: %v", doc.Xid, err)
+			continue
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// decodeEvent keeps document data as bson.M, which any would decode as
+// bson.D and render as Key/Value pairs in JSON.
+func decodeEvent(doc *protocols.XID[any]) (*Event, error) {
+	var stored struct {
+		Event `bson:",inline"`
+		Data  bson.RawValue `bson:"data"`
+	}
+	if err := xdb.DecodePayload(doc, &stored); err != nil {
+		return nil, err
+	}
+	e := stored.Event
+	var err error
+	if stored.Data.Type == bson.TypeEmbeddedDocument {
+		var m bson.M
+		err = stored.Data.Unmarshal(&m)
+		e.Data = m
+	} else if stored.Data.Type != 0 {
+		err = stored.Data.Unmarshal(&e.Data)
+	}
+	return &e, err
+}
+
+// Decode converts the event data into out, typically the struct it was
+// appended with.
+func (e *Event) Decode(out any) error {
+	raw, err := bson.Marshal(bson.M{"data": e.Data})
+	if err != nil {
+		return err
+	}
+	return bson.Raw(raw).Lookup("data").Unmarshal(out)
+}
+
+// Subscribe replays the events of stream after the given ID and then keeps
+// delivering new ones until ctx is done, when the channel is closed.
+func Subscribe(ctx context.Context, repo xdb.XIDRepo, stream string, after int64) <-chan *Event {
+	ch := make(chan *Event)
+	wakeup := watch(stream)
+	go func() {
+		defer close(ch)
+		defer unwatch(stream, wakeup)
+		ticker := time.NewTicker(PollInterval)
+		defer ticker.Stop()
+		for {
+			events, err := Since(ctx, repo, stream, after, 500)
+			if err != nil && ctx.Err() == nil {
+				logx.Errorf("read events of %s: %v", stream, err)
+			}
+			for _, e := range events {
+				select {
+				case ch <- e:
+					after = e.ID
+				case <-ctx.Done():
+					return
+				}
+			}
+			// 一页没读完时直接读下一页
+			if len(events) == 500 {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-wakeup:
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch
+}
+
+func watch(stream string) chan struct{} {
+	c := make(chan struct{}, 1)
+	mu.Lock()
+	defer mu.Unlock()
+	if waiters[stream] == nil {
+		waiters[stream] = map[chan struct{}]struct{}{}
+	}
+	waiters[stream][c] = struct{}{}
+	return c
+}
+
+func unwatch(stream string, c chan struct{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(waiters[stream], c)
+	if len(waiters[stream]) == 0 {
+		delete(waiters, stream)
+	}
+}
+
+func wake(stream string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for c := range waiters[stream] {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
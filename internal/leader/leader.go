@@ -0,0 +1,76 @@
+// Package leader elects one replica among those sharing the XID store by
+// holding a lease card that expires unless renewed.
+package leader
+
+import (
+	"context"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+const Path = "/protocols/leader"
+
+// path /protocols/leader
+type Lease struct {
+	Name      string `json:"name" bson:"name"`
+	Holder    string `json:"holder" bson:"holder"`
+	ExpiresAt int64  `json:"expiresAt" bson:"expiresAt"`
+}
+
+type Elector struct {
+	repo xdb.XIDRepo
+	name string
+	id   string
+	ttl  time.Duration
+}
+
+// NewElector competes for the lease called name. Each process gets its own
+// holder ID.
+func NewElector(repo xdb.XIDRepo, name string, ttl time.Duration) *Elector {
+	return &Elector{repo: repo, name: name, id: common.GenerateID(), ttl: ttl}
+}
+
+func (e *Elector) ID() string {
+	return e.id
+}
+
+// TryAcquire takes or renews the lease and reports whether this process
+// holds it until the next call. A lease held by another process is only
+// taken once it has expired.
+func (e *Elector) TryAcquire(ctx context.Context) (bool, error) {
+	xid := protocols.GenerateXid(e.name)
+	info := protocols.NewInfo(e.name, "leader_lease")
+	meta := protocols.NewMetadata(protocols.OperationInit, Path, "application/json")
+	card := protocols.NewXID[any](&info, &meta, &Lease{Name: e.name})
+	// 只在第一次时创建租约卡片
+	if err := e.repo.InsertIdempotent(ctx, card, Path+"/"+e.name); err != nil {
+		return false, err
+	}
+
+	now := common.GetTimestamp()
+	return e.repo.UpdateFieldsIf(ctx, xid, Path, map[string]any{
+		"$or": []map[string]any{
+			{"payload.holder": e.id},
+			{"payload.expiresAt": map[string]any{"$lt": now}},
+		},
+	}, map[string]any{
+		"payload.holder":    e.id,
+		"payload.expiresAt": now + e.ttl.Milliseconds(),
+	})
+}
+
+// Release gives the lease up so another replica can take over right away.
+func (e *Elector) Release(ctx context.Context) {
+	_, err := e.repo.UpdateFieldsIf(ctx, protocols.GenerateXid(e.name), Path, map[string]any{
+		"payload.holder": e.id,
+	}, map[string]any{
+		"payload.expiresAt": int64(0),
+	})
+	if err != nil {
+		logx.Errorf("failed to release leader lease %s: %v", e.name, err)
+	}
+}
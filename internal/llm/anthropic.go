@@ -0,0 +1,214 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+	// Messages API 要求 max_tokens
+	defaultAnthropicMaxTokens = 4096
+)
+
+// anthropic speaks the Messages API.
+type anthropic struct {
+	cfg ProviderConfig
+}
+
+func newAnthropic(cfg ProviderConfig) *anthropic {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://api.anthropic.com"
+	}
+	return &anthropic{cfg: cfg}
+}
+
+func (p *anthropic) Name() string { return p.cfg.Name }
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []map[string]any `json:"content"`
+}
+
+type anthropicEvent struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Text string `json:"text"`
+	} `json:"content_block"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Message struct {
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// messages converts the conversation, putting tool results into user turns
+// and merging consecutive turns of the same role.
+func (p *anthropic) messages(req *Request) []anthropicMessage {
+	var out []anthropicMessage
+	add := func(role string, block map[string]any) {
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Content = append(out[n-1].Content, block)
+			return
+		}
+		out = append(out, anthropicMessage{Role: role, Content: []map[string]any{block}})
+	}
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleTool:
+			add(RoleUser, map[string]any{
+				"type":        "tool_result",
+				"tool_use_id": m.ToolCallID,
+				"content":     m.Content,
+				"is_error":    m.IsError,
+			})
+		case RoleAssistant:
+			if m.Content != "" {
+				add(RoleAssistant, map[string]any{"type": "text", "text": m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				input := arguments(tc.Arguments)
+				if !strings.HasPrefix(strings.TrimSpace(string(input)), "{") {
+					input = json.RawMessage("{}")
+				}
+				add(RoleAssistant, map[string]any{"type": "tool_use", "id": tc.ID, "name": tc.Name, "input": input})
+			}
+		default:
+			add(RoleUser, map[string]any{"type": "text", "text": m.Content})
+		}
+	}
+	return out
+}
+
+func (p *anthropic) body(req *Request) map[string]any {
+	model := req.Model
+	if model == "" {
+		model = p.cfg.Model
+	}
+	maxTokens := req.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultAnthropicMaxTokens
+	}
+	body := map[string]any{
+		"model":      model,
+		"messages":   p.messages(req),
+		"max_tokens": maxTokens,
+		"stream":     true,
+	}
+	if req.System != "" {
+		body["system"] = req.System
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]any, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			tools = append(tools, map[string]any{
+				"name":         t.Name,
+				"description":  t.Description,
+				"input_schema": inputSchema(t),
+			})
+		}
+		body["tools"] = tools
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		body["top_p"] = *req.TopP
+	}
+	return body
+}
+
+func (p *anthropic) Stream(ctx context.Context, req *Request, onChunk func(Chunk) error) (*Response, error) {
+	headers := map[string]string{"anthropic-version": anthropicVersion}
+	if p.cfg.APIKey != "" {
+		headers["x-api-key"] = p.cfg.APIKey
+	}
+	resp, err := postStream(ctx, p.cfg.Name, strings.TrimSuffix(p.cfg.BaseURL, "/")+"/v1/messages", headers, p.body(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out := &Response{Message: Message{Role: RoleAssistant}}
+	var text strings.Builder
+	// 进行中的 tool_use 块，按 index
+	calls := map[int]*ToolCall{}
+	inputs := map[int]*strings.Builder{}
+	err = readSSE(resp.Body, func(_, data string) error {
+		var ev anthropicEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("invalid event from %s: %v", p.cfg.Name, err)
+		}
+		switch ev.Type {
+		case "error":
+			return fmt.Errorf("%s: %s", p.cfg.Name, ev.Error.Message)
+		case "message_start":
+			out.Usage.InputTokens = ev.Message.Usage.InputTokens
+		case "content_block_start":
+			if ev.ContentBlock.Type == "tool_use" {
+				calls[ev.Index] = &ToolCall{ID: ev.ContentBlock.ID, Name: ev.ContentBlock.Name}
+				inputs[ev.Index] = &strings.Builder{}
+			}
+		case "content_block_delta":
+			switch ev.Delta.Type {
+			case "text_delta":
+				text.WriteString(ev.Delta.Text)
+				return onChunk(Chunk{Type: ChunkText, Text: ev.Delta.Text})
+			case "thinking_delta":
+				return onChunk(Chunk{Type: ChunkReasoning, Text: ev.Delta.Thinking})
+			case "input_json_delta":
+				if b, ok := inputs[ev.Index]; ok {
+					b.WriteString(ev.Delta.PartialJSON)
+				}
+			}
+		case "content_block_stop":
+			tc, ok := calls[ev.Index]
+			if !ok {
+				return nil
+			}
+			delete(calls, ev.Index)
+			tc.Arguments = arguments(json.RawMessage(inputs[ev.Index].String()))
+			out.Message.ToolCalls = append(out.Message.ToolCalls, *tc)
+			return onChunk(Chunk{Type: ChunkToolCall, ToolCall: tc})
+		case "message_delta":
+			out.Usage.OutputTokens = ev.Usage.OutputTokens
+			switch ev.Delta.StopReason {
+			case "":
+			case "tool_use":
+				out.StopReason = StopToolUse
+			case "max_tokens":
+				out.StopReason = StopMaxTokens
+			default:
+				out.StopReason = StopEndTurn
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Message.Content = text.String()
+	if out.StopReason == "" {
+		out.StopReason = StopEndTurn
+	}
+	return out, nil
+}
@@ -0,0 +1,239 @@
+// Package llm calls language models of different vendors through one
+// streaming interface with tool calling.
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/xidp/internal/mcp"
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Why a model stopped generating.
+const (
+	StopEndTurn   = "end_turn"
+	StopToolUse   = "tool_use"
+	StopMaxTokens = "max_tokens"
+)
+
+const (
+	ChunkReasoning = "reasoning"
+	ChunkText      = "text"
+	ChunkToolCall  = "tool_call"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown llm provider")
+	ErrNoProvider      = errors.New("no llm provider is configured")
+)
+
+// Message is one turn of the conversation. An assistant message may carry
+// tool calls; each call is answered by a tool message with its ToolCallID.
+type Message struct {
+	Role       string     `json:"role" bson:"role"`
+	Content    string     `json:"content,omitempty" bson:"content,omitempty"`
+	ToolCalls  []ToolCall `json:"toolCalls,omitempty" bson:"toolCalls,omitempty"`
+	ToolCallID string     `json:"toolCallID,omitempty" bson:"toolCallID,omitempty"`
+	IsError    bool       `json:"isError,omitempty" bson:"isError,omitempty"`
+}
+
+type ToolCall struct {
+	ID        string          `json:"id" bson:"id"`
+	Name      string          `json:"name" bson:"name"`
+	Arguments json.RawMessage `json:"arguments" bson:"arguments"`
+}
+
+type Request struct {
+	Model       string
+	System      string
+	Messages    []Message
+	Tools       []mcp.Tool
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int
+}
+
+// Chunk is a piece of streamed output. Tool calls are delivered whole.
+type Chunk struct {
+	Type     string
+	Text     string
+	ToolCall *ToolCall
+}
+
+type Usage struct {
+	InputTokens  int `json:"inputTokens" bson:"inputTokens"`
+	OutputTokens int `json:"outputTokens" bson:"outputTokens"`
+}
+
+// Response is the complete assistant message of one call.
+type Response struct {
+	Message    Message
+	StopReason string
+	Usage      Usage
+}
+
+type Provider interface {
+	Name() string
+	// Stream calls the model and passes output to onChunk as it arrives. An
+	// error returned by onChunk aborts the call.
+	Stream(ctx context.Context, req *Request, onChunk func(Chunk) error) (*Response, error)
+}
+
+// ProviderConfig is one entry of LLM.providers.
+type ProviderConfig struct {
+	Name    string `mapstructure:"name"`
+	Type    string `mapstructure:"type"` // openai, anthropic or mock
+	BaseURL string `mapstructure:"base_url"`
+	APIKey  string `mapstructure:"api_key"`
+	// 从环境变量读取 key，优先于 api_key
+	APIKeyEnv string `mapstructure:"api_key_env"`
+	Model     string `mapstructure:"model"` // default model
+}
+
+func providerConfigs() []ProviderConfig {
+	var out []ProviderConfig
+	viper.UnmarshalKey("LLM.providers", &out)
+	return out
+}
+
+// Has reports whether a provider name can be opened.
+func Has(name string) bool {
+	_, ok := lookup(name)
+	return ok
+}
+
+func lookup(name string) (ProviderConfig, bool) {
+	configs := providerConfigs()
+	if name == "" {
+		name = viper.GetString("LLM.default")
+	}
+	for _, c := range configs {
+		if c.Name == name || (name == "" && c.Name != "") {
+			return c, true
+		}
+	}
+	// 未配置时内置一个 mock，便于测试
+	if name == "mock" {
+		return ProviderConfig{Name: "mock", Type: "mock"}, true
+	}
+	return ProviderConfig{}, false
+}
+
+// Open returns the provider configured under name, or the default provider
+// (LLM.default, else the first one) when name is empty.
+func Open(name string) (Provider, error) {
+	c, ok := lookup(name)
+	if !ok {
+		if name == "" {
+			return nil, ErrNoProvider
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	if c.APIKeyEnv != "" {
+		c.APIKey = os.Getenv(c.APIKeyEnv)
+	}
+	switch c.Type {
+	case "openai":
+		return newOpenAI(c), nil
+	case "anthropic":
+		return newAnthropic(c), nil
+	case "mock":
+		return NewMock(), nil
+	}
+	return nil, fmt.Errorf("%w: %s has unsupported type %q", ErrUnknownProvider, c.Name, c.Type)
+}
+
+// postStream POSTs body as JSON and returns the response when it succeeded.
+func postStream(ctx context.Context, provider, url string, headers map[string]string, body any) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(b)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %v", provider, err)
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s: %s", provider, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// readSSE calls fn with the event name and data of every server-sent event.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 4<<20)
+	var event string
+	var data strings.Builder
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+			if data.Len() > 0 {
+				if err := fn(event, data.String()); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if data.Len() > 0 {
+		return fn(event, data.String())
+	}
+	return nil
+}
+
+func inputSchema(t mcp.Tool) any {
+	if t.InputSchema == nil {
+		return map[string]any{"type": "object"}
+	}
+	return t.InputSchema
+}
+
+// arguments 模型可能输出不完整的 JSON，此时作为字符串保留，由参数校验报错
+func arguments(raw json.RawMessage) json.RawMessage {
+	if len(strings.TrimSpace(string(raw))) == 0 {
+		return json.RawMessage("{}")
+	}
+	if !json.Valid(raw) {
+		b, _ := json.Marshal(string(raw))
+		return b
+	}
+	return raw
+}
@@ -0,0 +1,177 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xid-protocol/xidp/internal/mcp"
+)
+
+// sseServer answers every request with the given server-sent events.
+func sseServer(t *testing.T, events ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, e := range events {
+			fmt.Fprintf(w, "data: %s\n\n", e)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// collect returns the streamed chunks, merging consecutive text of the same
+// type.
+func collect(chunks *[]Chunk) func(Chunk) error {
+	return func(c Chunk) error {
+		if n := len(*chunks); n > 0 && c.ToolCall == nil && (*chunks)[n-1].Type == c.Type && (*chunks)[n-1].ToolCall == nil {
+			(*chunks)[n-1].Text += c.Text
+			return nil
+		}
+		*chunks = append(*chunks, c)
+		return nil
+	}
+}
+
+func TestOpenAIToolCallFragments(t *testing.T) {
+	// 两个工具调用的参数分片交错到达，第二个调用的 index 先出现
+	srv := sseServer(t,
+		`{"choices":[{"delta":{"reasoning_content":"need the "}}]}`,
+		`{"choices":[{"delta":{"reasoning_content":"ports"}}]}`,
+		`{"choices":[{"delta":{"content":"Checking."}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"scan","arguments":"{\"port\""}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"resolve_xid","arguments":""}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"id\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"function":{"arguments":":22}"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"i-1\"}"}}]}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":7}}`,
+		`[DONE]`,
+	)
+	p := newOpenAI(ProviderConfig{Name: "test", BaseURL: srv.URL})
+	var chunks []Chunk
+	resp, err := p.Stream(context.Background(), &Request{Messages: []Message{{Role: RoleUser, Content: "scan i-1"}}}, collect(&chunks))
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+
+	calls := []ToolCall{
+		{ID: "call_a", Name: "resolve_xid", Arguments: []byte(`{"id":"i-1"}`)},
+		{ID: "call_b", Name: "scan", Arguments: []byte(`{"port":22}`)},
+	}
+	if !reflect.DeepEqual(resp.Message.ToolCalls, calls) {
+		t.Errorf("tool calls = %s, want %s", toolCalls(resp.Message.ToolCalls), toolCalls(calls))
+	}
+	if resp.Message.Content != "Checking." || resp.StopReason != StopToolUse {
+		t.Errorf("content %q, stop reason %q", resp.Message.Content, resp.StopReason)
+	}
+	if resp.Usage != (Usage{InputTokens: 12, OutputTokens: 7}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+	want := []string{"reasoning:need the ports", "text:Checking.", "tool_call:call_a", "tool_call:call_b"}
+	if got := chunkList(chunks); !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %v, want %v", got, want)
+	}
+}
+
+func TestAnthropicInputJSONDelta(t *testing.T) {
+	srv := sseServer(t,
+		`{"type":"message_start","message":{"usage":{"input_tokens":20}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"look it up"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Looking "}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"up i-1."}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"read_card"}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":""}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"xid\": \"x1\","}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":" \"path\": \"/info\"}"}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":9}}`,
+		`{"type":"message_stop"}`,
+	)
+	p := newAnthropic(ProviderConfig{Name: "test", BaseURL: srv.URL})
+	var chunks []Chunk
+	resp, err := p.Stream(context.Background(), &Request{Messages: []Message{{Role: RoleUser, Content: "read i-1"}}}, collect(&chunks))
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+
+	calls := []ToolCall{{ID: "toolu_1", Name: "read_card", Arguments: []byte(`{"xid": "x1", "path": "/info"}`)}}
+	if !reflect.DeepEqual(resp.Message.ToolCalls, calls) {
+		t.Errorf("tool calls = %s, want %s", toolCalls(resp.Message.ToolCalls), toolCalls(calls))
+	}
+	if resp.Message.Content != "Looking up i-1." || resp.StopReason != StopToolUse {
+		t.Errorf("content %q, stop reason %q", resp.Message.Content, resp.StopReason)
+	}
+	if resp.Usage != (Usage{InputTokens: 20, OutputTokens: 9}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+	want := []string{"reasoning:look it up", "text:Looking up i-1.", "tool_call:toolu_1"}
+	if got := chunkList(chunks); !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %v, want %v", got, want)
+	}
+}
+
+func TestMockStream(t *testing.T) {
+	m := NewMock()
+	m.Reasoning = "the user asks to call a tool"
+	req := &Request{
+		Messages: []Message{{Role: RoleUser, Content: `call scan {"port":22}`}},
+		Tools:    []mcp.Tool{{Name: "scan"}},
+	}
+	var chunks []Chunk
+	resp, err := m.Stream(context.Background(), req, collect(&chunks))
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if resp.StopReason != StopToolUse || len(resp.Message.ToolCalls) != 1 || string(resp.Message.ToolCalls[0].Arguments) != `{"port":22}` {
+		t.Fatalf("response = %+v, want a scan call", resp)
+	}
+	want := []string{"reasoning:the user asks to call a tool", "tool_call:call_1"}
+	if got := chunkList(chunks); !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %v, want %v", got, want)
+	}
+
+	// 工具结果之后给出回答
+	req.Messages = append(req.Messages, resp.Message, Message{Role: RoleTool, ToolCallID: "call_1", Content: "22/tcp open"})
+	chunks = nil
+	resp, err = m.Stream(context.Background(), req, collect(&chunks))
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if resp.StopReason != StopEndTurn || resp.Message.Content != "tool result: 22/tcp open" {
+		t.Fatalf("response = %+v", resp)
+	}
+	want = []string{"reasoning:the user asks to call a tool", "text:tool result: 22/tcp open"}
+	if got := chunkList(chunks); !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %v, want %v", got, want)
+	}
+}
+
+func chunkList(chunks []Chunk) []string {
+	out := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		if c.ToolCall != nil {
+			out = append(out, c.Type+":"+c.ToolCall.ID)
+			continue
+		}
+		out = append(out, c.Type+":"+c.Text)
+	}
+	return out
+}
+
+func toolCalls(calls []ToolCall) string {
+	parts := make([]string, 0, len(calls))
+	for _, c := range calls {
+		parts = append(parts, fmt.Sprintf("%s %s(%s)", c.ID, c.Name, c.Arguments))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Mock is a deterministic provider for tests and local runs. Without a
+// script it echoes the last user message, and a message of the form
+// "call <tool> <json arguments>" makes it call that tool, then answer with
+// the tool's result.
+type Mock struct {
+	// Script[i] is returned for the call made after i assistant turns
+	Script []Response
+	// streamed as reasoning chunks before every response
+	Reasoning string
+}
+
+func NewMock(script ...Response) *Mock {
+	return &Mock{Script: script}
+}
+
+func (m *Mock) Name() string { return "mock" }
+
+func (m *Mock) Stream(ctx context.Context, req *Request, onChunk func(Chunk) error) (*Response, error) {
+	turn := 0
+	for _, msg := range req.Messages {
+		if msg.Role == RoleAssistant {
+			turn++
+		}
+	}
+	var resp Response
+	if turn < len(m.Script) {
+		resp = m.Script[turn]
+	} else {
+		resp = m.reply(req, turn)
+	}
+	resp.Message.Role = RoleAssistant
+	if resp.StopReason == "" {
+		resp.StopReason = StopEndTurn
+		if len(resp.Message.ToolCalls) > 0 {
+			resp.StopReason = StopToolUse
+		}
+	}
+
+	// 按词流式输出
+	for _, part := range []struct{ typ, text string }{
+		{ChunkReasoning, m.Reasoning},
+		{ChunkText, resp.Message.Content},
+	} {
+		for _, word := range strings.SplitAfter(part.text, " ") {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if word == "" {
+				continue
+			}
+			if err := onChunk(Chunk{Type: part.typ, Text: word}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := range resp.Message.ToolCalls {
+		if err := onChunk(Chunk{Type: ChunkToolCall, ToolCall: &resp.Message.ToolCalls[i]}); err != nil {
+			return nil, err
+		}
+	}
+	if resp.Usage == (Usage{}) {
+		in := len(strings.Fields(req.System))
+		for _, msg := range req.Messages {
+			in += len(strings.Fields(msg.Content))
+		}
+		resp.Usage = Usage{InputTokens: in, OutputTokens: len(strings.Fields(resp.Message.Content))}
+	}
+	return &resp, nil
+}
+
+func (m *Mock) reply(req *Request, turn int) Response {
+	if len(req.Messages) == 0 {
+		return Response{Message: Message{Content: "hello"}}
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role == RoleTool {
+		var results []string
+		for i := len(req.Messages) - 1; i >= 0 && req.Messages[i].Role == RoleTool; i-- {
+			results = append([]string{req.Messages[i].Content}, results...)
+		}
+		return Response{Message: Message{Content: "tool result: " + strings.Join(results, "\n")}}
+	}
+
+	if rest, ok := strings.CutPrefix(last.Content, "call "); ok {
+		name, args, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		for _, t := range req.Tools {
+			if t.Name == name {
+				return Response{Message: Message{ToolCalls: []ToolCall{{
+					ID:        fmt.Sprintf("call_%d", turn+1),
+					Name:      name,
+					Arguments: arguments(json.RawMessage(strings.TrimSpace(args))),
+				}}}}
+			}
+		}
+		return Response{Message: Message{Content: "unknown tool " + name}}
+	}
+	return Response{Message: Message{Content: "echo: " + last.Content}}
+}
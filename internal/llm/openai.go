@@ -0,0 +1,216 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// openAI speaks the chat completions API, which many vendors and local
+// servers also implement.
+type openAI struct {
+	cfg ProviderConfig
+}
+
+func newOpenAI(cfg ProviderConfig) *openAI {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://api.openai.com/v1"
+	}
+	return &openAI{cfg: cfg}
+}
+
+func (p *openAI) Name() string { return p.cfg.Name }
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    *string          `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    int    `json:"index,omitempty"` // 只出现在流式响应中
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+			// DeepSeek 等兼容接口的推理输出
+			ReasoningContent string           `json:"reasoning_content"`
+			ToolCalls        []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *openAI) body(req *Request) map[string]any {
+	msgs := []openAIMessage{}
+	if req.System != "" {
+		msgs = append(msgs, openAIMessage{Role: "system", Content: &req.System})
+	}
+	for _, m := range req.Messages {
+		content := m.Content
+		om := openAIMessage{Role: m.Role, Content: &content}
+		switch m.Role {
+		case RoleTool:
+			om.ToolCallID = m.ToolCallID
+		case RoleAssistant:
+			for _, tc := range m.ToolCalls {
+				c := openAIToolCall{ID: tc.ID, Type: "function"}
+				c.Function.Name = tc.Name
+				c.Function.Arguments = string(arguments(tc.Arguments))
+				om.ToolCalls = append(om.ToolCalls, c)
+			}
+			if content == "" && len(om.ToolCalls) > 0 {
+				om.Content = nil
+			}
+		}
+		msgs = append(msgs, om)
+	}
+
+	model := req.Model
+	if model == "" {
+		model = p.cfg.Model
+	}
+	body := map[string]any{
+		"model":          model,
+		"messages":       msgs,
+		"stream":         true,
+		"stream_options": map[string]any{"include_usage": true},
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]any, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			tools = append(tools, map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name":        t.Name,
+					"description": t.Description,
+					"parameters":  inputSchema(t),
+				},
+			})
+		}
+		body["tools"] = tools
+	}
+	if req.Temperature != nil {
+		body["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		body["top_p"] = *req.TopP
+	}
+	if req.MaxTokens > 0 {
+		body["max_tokens"] = req.MaxTokens
+	}
+	return body
+}
+
+func (p *openAI) Stream(ctx context.Context, req *Request, onChunk func(Chunk) error) (*Response, error) {
+	headers := map[string]string{}
+	if p.cfg.APIKey != "" {
+		headers["Authorization"] = "Bearer " + p.cfg.APIKey
+	}
+	resp, err := postStream(ctx, p.cfg.Name, strings.TrimSuffix(p.cfg.BaseURL, "/")+"/chat/completions", headers, p.body(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	out := &Response{Message: Message{Role: RoleAssistant}}
+	var text strings.Builder
+	calls := map[int]*openAIToolCall{}
+	err = readSSE(resp.Body, func(_, data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("invalid chunk from %s: %v", p.cfg.Name, err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("%s: %s", p.cfg.Name, chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			out.Usage = Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+		}
+		for _, choice := range chunk.Choices {
+			d := choice.Delta
+			if d.ReasoningContent != "" {
+				if err := onChunk(Chunk{Type: ChunkReasoning, Text: d.ReasoningContent}); err != nil {
+					return err
+				}
+			}
+			if d.Content != "" {
+				text.WriteString(d.Content)
+				if err := onChunk(Chunk{Type: ChunkText, Text: d.Content}); err != nil {
+					return err
+				}
+			}
+			// 工具调用的参数分片到达，按 index 拼接
+			for _, tc := range d.ToolCalls {
+				c, ok := calls[tc.Index]
+				if !ok {
+					c = &openAIToolCall{Index: tc.Index}
+					calls[tc.Index] = c
+				}
+				if tc.ID != "" {
+					c.ID = tc.ID
+				}
+				if tc.Function.Name != "" {
+					c.Function.Name = tc.Function.Name
+				}
+				c.Function.Arguments += tc.Function.Arguments
+			}
+			switch choice.FinishReason {
+			case "":
+			case "tool_calls", "function_call":
+				out.StopReason = StopToolUse
+			case "length":
+				out.StopReason = StopMaxTokens
+			default:
+				out.StopReason = StopEndTurn
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out.Message.Content = text.String()
+	indexes := make([]int, 0, len(calls))
+	for i := range calls {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		c := calls[i]
+		tc := ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: arguments(json.RawMessage(c.Function.Arguments))}
+		out.Message.ToolCalls = append(out.Message.ToolCalls, tc)
+		if err := onChunk(Chunk{Type: ChunkToolCall, ToolCall: &tc}); err != nil {
+			return nil, err
+		}
+	}
+	if len(out.Message.ToolCalls) > 0 {
+		out.StopReason = StopToolUse
+	}
+	if out.StopReason == "" {
+		out.StopReason = StopEndTurn
+	}
+	return out, nil
+}
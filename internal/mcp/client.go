@@ -0,0 +1,375 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/colin-404/logx"
+)
+
+var ErrClosed = errors.New("mcp connection closed")
+
+// Transport carries messages to one server.
+type Transport interface {
+	// Call sends a request and waits for its response.
+	Call(ctx context.Context, msg *Message) (*Message, error)
+	Notify(ctx context.Context, msg *Message) error
+	Close() error
+}
+
+// Client talks to one MCP server.
+type Client struct {
+	t      Transport
+	nextID atomic.Int64
+	Server InitializeResult
+}
+
+// Connect performs the initialize handshake over t.
+func Connect(ctx context.Context, t Transport, clientInfo Implementation) (*Client, error) {
+	c := &Client{t: t}
+	var res InitializeResult
+	err := c.call(ctx, "initialize", &InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]any{},
+		ClientInfo:      clientInfo,
+	}, &res)
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("failed to initialize mcp server: %v", err)
+	}
+	c.Server = res
+	if err := t.Notify(ctx, &Message{JSONRPC: "2.0", Method: "notifications/initialized"}); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) call(ctx context.Context, method string, params, out any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	id := json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+	resp, err := c.t.Call(ctx, &Message{JSONRPC: "2.0", ID: id, Method: method, Params: raw})
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+// ListTools returns every tool of the server, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var res ListToolsResult
+		if err := c.call(ctx, "tools/list", params, &res); err != nil {
+			return nil, err
+		}
+		tools = append(tools, res.Tools...)
+		if res.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = res.NextCursor
+	}
+}
+
+func (c *Client) CallTool(ctx context.Context, name string, args json.RawMessage) (*CallToolResult, error) {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	var res CallToolResult
+	if err := c.call(ctx, "tools/call", &CallToolParams{Name: name, Arguments: args}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Client) Close() error {
+	return c.t.Close()
+}
+
+// stdioTransport runs the server as a child process speaking
+// newline-delimited JSON on its stdin and stdout.
+type stdioTransport struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	pending map[string]chan *Message
+	done    chan struct{}
+	err     error
+}
+
+// NewStdioTransport starts command with args. env entries (KEY=value) are
+// added to the environment of the current process.
+func NewStdioTransport(command string, args []string, env []string) (Transport, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Env = append(os.Environ(), env...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", command, err)
+	}
+	t := &stdioTransport{cmd: cmd, stdin: stdin, pending: make(map[string]chan *Message), done: make(chan struct{})}
+	go t.readLoop(stdout)
+	go func() {
+		// 子进程的 stderr 只记录日志
+		s := bufio.NewScanner(stderr)
+		for s.Scan() {
+			logx.Infof("mcp server %s: %s", command, s.Text())
+		}
+	}()
+	return t, nil
+}
+
+func (t *stdioTransport) readLoop(r io.Reader) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for s.Scan() {
+		var msg Message
+		if err := json.Unmarshal(s.Bytes(), &msg); err != nil {
+			logx.Errorf("mcp stdio: invalid message: %v", err)
+			continue
+		}
+		// 不支持服务端发起的请求
+		if msg.Method != "" {
+			continue
+		}
+		t.mu.Lock()
+		ch, ok := t.pending[string(msg.ID)]
+		delete(t.pending, string(msg.ID))
+		t.mu.Unlock()
+		if ok {
+			ch <- &msg
+		}
+	}
+	t.mu.Lock()
+	t.err = s.Err()
+	if t.err == nil {
+		t.err = ErrClosed
+	}
+	t.mu.Unlock()
+	close(t.done)
+}
+
+func (t *stdioTransport) write(msg *Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.stdin.Write(append(b, '\n'))
+	return err
+}
+
+func (t *stdioTransport) Call(ctx context.Context, msg *Message) (*Message, error) {
+	ch := make(chan *Message, 1)
+	key := string(msg.ID)
+	t.mu.Lock()
+	t.pending[key] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, key)
+		t.mu.Unlock()
+	}()
+
+	if err := t.write(msg); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-t.done:
+		return nil, t.err
+	case <-ctx.Done():
+		// 通知服务端放弃该请求
+		params, _ := json.Marshal(map[string]any{"requestId": msg.ID, "reason": ctx.Err().Error()})
+		t.write(&Message{JSONRPC: "2.0", Method: "notifications/cancelled", Params: params})
+		return nil, ctx.Err()
+	}
+}
+
+func (t *stdioTransport) Notify(ctx context.Context, msg *Message) error {
+	return t.write(msg)
+}
+
+// Close closes stdin and gives the server a moment to exit before killing it.
+func (t *stdioTransport) Close() error {
+	t.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- t.cmd.Wait() }()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
+
+// httpTransport speaks streamable HTTP: each message is POSTed and the
+// response comes back as JSON or as an SSE stream.
+type httpTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+func NewHTTPTransport(url string, headers map[string]string) Transport {
+	return &httpTransport{url: url, headers: headers, client: &http.Client{}}
+}
+
+func (t *httpTransport) post(ctx context.Context, msg *Message) (*http.Response, error) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set("MCP-Protocol-Version", ProtocolVersion)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	t.mu.Unlock()
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("mcp server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (t *httpTransport) Call(ctx context.Context, msg *Message) (*Message, error) {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		var out Message
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxMessageSize)).Decode(&out); err != nil {
+			return nil, fmt.Errorf("invalid mcp response: %v", err)
+		}
+		return &out, nil
+	}
+
+	// SSE 流中可能夹带通知，取 id 匹配的响应
+	s := bufio.NewScanner(resp.Body)
+	s.Buffer(make([]byte, 64*1024), maxMessageSize)
+	var data strings.Builder
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+		var out Message
+		err := json.Unmarshal([]byte(data.String()), &out)
+		data.Reset()
+		if err == nil && out.Method == "" && string(out.ID) == string(msg.ID) {
+			return &out, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("mcp stream ended without a response to request %s", msg.ID)
+}
+
+func (t *httpTransport) Notify(ctx context.Context, msg *Message) error {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Close ends the session when the server issued one.
+func (t *httpTransport) Close() error {
+	t.mu.Lock()
+	id := t.sessionID
+	t.mu.Unlock()
+	if id == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Mcp-Session-Id", id)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,211 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Schema is the subset of JSON Schema used for tool inputs. Keywords it does
+// not know are ignored when validating schemas of other servers.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"` // bool 或 *Schema
+}
+
+func Object(props map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: props, Required: required, AdditionalProperties: false}
+}
+
+func String(desc string) *Schema {
+	return &Schema{Type: "string", Description: desc}
+}
+
+func Integer(desc string) *Schema {
+	return &Schema{Type: "integer", Description: desc}
+}
+
+func Boolean(desc string) *Schema {
+	return &Schema{Type: "boolean", Description: desc}
+}
+
+func Array(desc string, items *Schema) *Schema {
+	return &Schema{Type: "array", Description: desc, Items: items}
+}
+
+// Map is an object with arbitrary keys whose values match values.
+func Map(desc string, values *Schema) *Schema {
+	return &Schema{Type: "object", Description: desc, AdditionalProperties: values}
+}
+
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	type plain Schema
+	var raw struct {
+		plain
+		AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*s = Schema(raw.plain)
+	s.AdditionalProperties = nil
+	switch ap := strings.TrimSpace(string(raw.AdditionalProperties)); {
+	case ap == "":
+	case ap == "true" || ap == "false":
+		s.AdditionalProperties = ap == "true"
+	default:
+		var sub Schema
+		if err := json.Unmarshal(raw.AdditionalProperties, &sub); err != nil {
+			return err
+		}
+		s.AdditionalProperties = &sub
+	}
+	return nil
+}
+
+// ValidateJSON checks raw arguments against the schema. Empty input is
+// treated as an empty object.
+func (s *Schema) ValidateJSON(raw json.RawMessage) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		raw = json.RawMessage("{}")
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return s.Validate(v)
+}
+
+// Validate checks a value decoded by encoding/json against the schema.
+func (s *Schema) Validate(v any) error {
+	return s.validate("", v)
+}
+
+func (s *Schema) validate(at string, v any) error {
+	if s == nil {
+		return nil
+	}
+	name := at
+	if name == "" {
+		name = "input"
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return fmt.Errorf("%s must be one of %v", name, s.Enum)
+	}
+
+	switch s.Type {
+	case "":
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s must be a string", name)
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok || (s.Type == "integer" && n != math.Trunc(n)) {
+			return fmt.Errorf("%s must be %s", name, article(s.Type))
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("%s must be at least %v", name, *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("%s must be at most %v", name, *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", name)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s must be an array", name)
+		}
+		for i, item := range items {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", name, i), item); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s must be an object", name)
+		}
+		return s.validateObject(at, obj)
+	case "null":
+		if v != nil {
+			return fmt.Errorf("%s must be null", name)
+		}
+	default:
+		return fmt.Errorf("unsupported schema type %q", s.Type)
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(at string, obj map[string]any) error {
+	for _, r := range s.Required {
+		if _, ok := obj[r]; !ok {
+			return fmt.Errorf("%s is required", join(at, r))
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if prop, ok := s.Properties[k]; ok {
+			if err := prop.validate(join(at, k), obj[k]); err != nil {
+				return err
+			}
+			continue
+		}
+		switch ap := s.AdditionalProperties.(type) {
+		case bool:
+			if !ap {
+				return fmt.Errorf("unknown property %s", join(at, k))
+			}
+		case *Schema:
+			if err := ap.validate(join(at, k), obj[k]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func join(at, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
+
+func article(t string) string {
+	if t == "integer" {
+		return "an integer"
+	}
+	return "a number"
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if e == v {
+			return true
+		}
+		// 数字枚举在 Go 代码里可能写成 int
+		if n, ok := v.(float64); ok {
+			if i, ok := e.(int); ok && float64(i) == n {
+				return true
+			}
+		}
+	}
+	return false
+}
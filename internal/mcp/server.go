@@ -0,0 +1,170 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/colin-404/logx"
+)
+
+var ErrForbidden = errors.New("permission denied")
+
+// Principal is the caller of a tool and the card paths it may read and
+// write. A pattern matches the path itself and every path below it; "*"
+// matches all paths.
+type Principal struct {
+	Name  string   `json:"name" mapstructure:"name"`
+	Read  []string `json:"read" mapstructure:"read"`
+	Write []string `json:"write" mapstructure:"write"`
+}
+
+func (p *Principal) CanRead(path string) bool {
+	return p != nil && (matchPaths(p.Read, path) || matchPaths(p.Write, path))
+}
+
+func (p *Principal) CanWrite(path string) bool {
+	return p != nil && matchPaths(p.Write, path)
+}
+
+// CheckRead returns ErrForbidden unless the principal may read path.
+func (p *Principal) CheckRead(path string) error {
+	if !p.CanRead(path) {
+		return fmt.Errorf("%w: read %s", ErrForbidden, path)
+	}
+	return nil
+}
+
+func (p *Principal) CheckWrite(path string) error {
+	if !p.CanWrite(path) {
+		return fmt.Errorf("%w: write %s", ErrForbidden, path)
+	}
+	return nil
+}
+
+func matchPaths(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/*")
+		if pattern == "*" || pattern == path || strings.HasPrefix(path, pattern+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolHandler runs a tool with arguments already validated against its
+// input schema. The returned value is sent as structured content; an error
+// is reported to the model as a failed tool call.
+type ToolHandler func(ctx context.Context, p *Principal, args json.RawMessage) (any, error)
+
+type serverTool struct {
+	tool    Tool
+	handler ToolHandler
+}
+
+type Server struct {
+	info         Implementation
+	instructions string
+	tools        map[string]*serverTool
+	order        []string
+}
+
+func NewServer(name, version, instructions string) *Server {
+	return &Server{
+		info:         Implementation{Name: name, Version: version},
+		instructions: instructions,
+		tools:        make(map[string]*serverTool),
+	}
+}
+
+func (s *Server) AddTool(t Tool, h ToolHandler) {
+	if _, ok := s.tools[t.Name]; !ok {
+		s.order = append(s.order, t.Name)
+	}
+	s.tools[t.Name] = &serverTool{tool: t, handler: h}
+}
+
+func (s *Server) Tools() []Tool {
+	out := make([]Tool, 0, len(s.order))
+	for _, name := range s.order {
+		out = append(out, s.tools[name].tool)
+	}
+	return out
+}
+
+// Handle processes one message from the client and returns the response to
+// send, or nil for notifications and responses.
+func (s *Server) Handle(ctx context.Context, p *Principal, msg *Message) *response {
+	if msg.JSONRPC != "2.0" {
+		return newError(msg.ID, CodeInvalidRequest, "jsonrpc must be 2.0")
+	}
+	if !msg.IsRequest() {
+		return nil
+	}
+
+	switch msg.Method {
+	case "initialize":
+		var params InitializeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return newError(msg.ID, CodeInvalidParams, err.Error())
+		}
+		return newResult(msg.ID, &InitializeResult{
+			ProtocolVersion: negotiate(params.ProtocolVersion),
+			Capabilities:    map[string]any{"tools": map[string]any{"listChanged": false}},
+			ServerInfo:      s.info,
+			Instructions:    s.instructions,
+		})
+	case "ping":
+		return newResult(msg.ID, struct{}{})
+	case "tools/list":
+		return newResult(msg.ID, &ListToolsResult{Tools: s.Tools()})
+	case "tools/call":
+		var params CallToolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return newError(msg.ID, CodeInvalidParams, err.Error())
+		}
+		t, ok := s.tools[params.Name]
+		if !ok {
+			return newError(msg.ID, CodeInvalidParams, "unknown tool "+params.Name)
+		}
+		return newResult(msg.ID, s.call(ctx, p, t, params.Arguments))
+	}
+	return newError(msg.ID, CodeMethodNotFound, "method not found: "+msg.Method)
+}
+
+func (s *Server) call(ctx context.Context, p *Principal, t *serverTool, args json.RawMessage) *CallToolResult {
+	if err := t.tool.InputSchema.ValidateJSON(args); err != nil {
+		return ErrorResult(fmt.Errorf("invalid arguments: %v", err))
+	}
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	out, err := t.handler(ctx, p, args)
+	if err != nil {
+		if !errors.Is(err, ErrForbidden) {
+			logx.Errorf("mcp tool %s: %v", t.tool.Name, err)
+		}
+		return ErrorResult(err)
+	}
+	text, err := json.Marshal(out)
+	if err != nil {
+		return ErrorResult(err)
+	}
+	return &CallToolResult{Content: []Content{{Type: "text", Text: string(text)}}, StructuredContent: out}
+}
+
+func ErrorResult(err error) *CallToolResult {
+	return &CallToolResult{Content: []Content{{Type: "text", Text: err.Error()}}, IsError: true}
+}
+
+// negotiate 客户端请求的版本受支持时沿用，否则返回最新版本
+func negotiate(requested string) string {
+	for _, v := range supportedVersions {
+		if v == requested {
+			return v
+		}
+	}
+	return ProtocolVersion
+}
@@ -0,0 +1,96 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 单条消息的上限
+const maxMessageSize = 4 << 20
+
+// ServeStdio reads newline-delimited messages from r and writes responses
+// to w until r is closed or ctx is done. Requests are served concurrently.
+func (s *Server) ServeStdio(ctx context.Context, p *Principal, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	write := func(resp *response) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(resp)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			write(newError(nil, CodeParseError, err.Error()))
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp := s.Handle(ctx, p, &msg); resp != nil {
+				write(resp)
+			}
+		}()
+	}
+	return scanner.Err()
+}
+
+// Authenticator returns the principal of an HTTP request, or false to
+// reject it with 401.
+type Authenticator func(r *http.Request) (*Principal, bool)
+
+// HTTPHandler serves the streamable HTTP transport. It is stateless: no
+// session ID is issued and every request is authenticated on its own, so
+// any replica can serve any request. Responses are plain JSON; the server
+// never opens a stream of its own.
+func (s *Server) HTTPHandler(auth Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		p, ok := auth(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var msg Message
+		body := http.MaxBytesReader(w, r.Body, maxMessageSize)
+		if err := json.NewDecoder(body).Decode(&msg); err != nil {
+			writeJSON(w, http.StatusBadRequest, newError(nil, CodeParseError, err.Error()))
+			return
+		}
+		resp := s.Handle(r.Context(), p, &msg)
+		if resp == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
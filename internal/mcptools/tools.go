@@ -0,0 +1,343 @@
+// Package mcptools exposes xidp data to agents as MCP tools.
+package mcptools
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/xidp/internal/mcp"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/protocols/attack_surface"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/protocols/whitelist"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxPageSize = 100
+
+// client is one entry of MCP.clients.
+type client struct {
+	mcp.Principal `mapstructure:",squash"`
+	Token         string `mapstructure:"token"`
+}
+
+// Authenticate maps the bearer token of a request to a client configured in
+// MCP.clients. Without configured clients the HTTP transport is closed.
+func Authenticate(r *http.Request) (*mcp.Principal, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, false
+	}
+	return AuthenticateToken(token)
+}
+
+// AuthenticateToken maps a client token to its client in MCP.clients.
+func AuthenticateToken(token string) (*mcp.Principal, bool) {
+	if token == "" {
+		return nil, false
+	}
+	var clients []client
+	if err := viper.UnmarshalKey("MCP.clients", &clients); err != nil {
+		return nil, false
+	}
+	for _, c := range clients {
+		if c.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 {
+			p := c.Principal
+			return &p, true
+		}
+	}
+	return nil, false
+}
+
+// StdioPrincipal is the caller over stdio, configured by MCP.stdio. The
+// local operator may read every path and write none unless configured
+// otherwise.
+func StdioPrincipal() *mcp.Principal {
+	p := mcp.Principal{Name: "stdio", Read: []string{"*"}, Write: []string{}}
+	if viper.IsSet("MCP.stdio") {
+		p = mcp.Principal{Name: "stdio"}
+		viper.UnmarshalKey("MCP.stdio", &p)
+	}
+	return &p
+}
+
+func NewServer(repo xdb.XIDRepo) *mcp.Server {
+	s := mcp.NewServer("xidp", protocols.XIDVersion,
+		"xidp stores identity data as XID cards addressed by xid and path. "+
+			"Resolve an ID to its xid first, then read or search cards.")
+	t := &tools{repo: repo}
+
+	s.AddTool(mcp.Tool{
+		Name:        "resolve_xid",
+		Description: "Compute the xid of an ID such as an instance ID, account or task ID.",
+		InputSchema: mcp.Object(map[string]*mcp.Schema{
+			"id": mcp.String("the plain ID"),
+		}, "id"),
+	}, t.resolveXid)
+
+	s.AddTool(mcp.Tool{
+		Name:        "read_card",
+		Description: "Read the XID card stored for an xid at a path.",
+		InputSchema: mcp.Object(map[string]*mcp.Schema{
+			"xid":  mcp.String("xid of the card"),
+			"path": mcp.String("card path, e.g. /protocols/task"),
+		}, "xid", "path"),
+	}, t.readCard)
+
+	s.AddTool(mcp.Tool{
+		Name:        "search_cards",
+		Description: "List XID cards at a path, filtered by name prefix, tags and payload fields.",
+		InputSchema: mcp.Object(map[string]*mcp.Schema{
+			"path":       mcp.String("card path"),
+			"namePrefix": mcp.String("prefix of info.id"),
+			"tags":       mcp.Array("cards must carry all these tags", mcp.String("")),
+			"where":      mcp.Map("payload fields that must equal the given values", &mcp.Schema{}),
+			"pageSize":   pageSizeSchema(),
+			"cursor":     mcp.String("nextCursor of the previous page"),
+		}, "path"),
+	}, t.searchCards)
+
+	s.AddTool(mcp.Tool{
+		Name:        "query_attack_surface",
+		Description: "List internet-exposed cloud instances with their open ports, whitelist coverage and risk, or get one instance by xid.",
+		InputSchema: mcp.Object(map[string]*mcp.Schema{
+			"xid":       mcp.String("xid of one instance, other filters are ignored"),
+			"provider":  mcp.String("cloud provider, e.g. aws"),
+			"accountId": mcp.String(""),
+			"region":    mcp.String(""),
+			"tags":      mcp.Map("instance tags", mcp.String("")),
+			"port":      mcp.Integer("only instances exposing this port"),
+			"protocol":  mcp.String("tcp, udp or all"),
+			"cidr":      mcp.String("only exposures open to this CIDR"),
+			"sort":      {Type: "string", Enum: []any{"risk"}, Description: "risk sorts by risk score, highest first"},
+			"pageSize":  pageSizeSchema(),
+			"cursor":    mcp.String("nextCursor of the previous page"),
+		}),
+	}, t.queryAttackSurface)
+
+	s.AddTool(mcp.Tool{
+		Name:        "check_whitelist",
+		Description: "Check whether approved, unexpired whitelist entries cover a subject.",
+		InputSchema: mcp.Object(map[string]*mcp.Schema{
+			"type":    {Type: "string", Enum: typeEnum(), Description: "whitelist type"},
+			"subject": {Type: "object", Description: "subject to check, shaped by the type"},
+		}, "type", "subject"),
+	}, t.checkWhitelist)
+
+	s.AddTool(mcp.Tool{
+		Name:        "create_task",
+		Description: "Create a task. It is submitted right away unless draft is true.",
+		InputSchema: mcp.Object(map[string]*mcp.Schema{
+			"name":        mcp.String(""),
+			"taskType":    mcp.String("type of the task, decides which workers run it"),
+			"userInput":   mcp.String(""),
+			"description": mcp.String(""),
+			"targets":     mcp.Array("targets of the task", mcp.String("")),
+			"steps": mcp.Array("steps run by workers", mcp.Object(map[string]*mcp.Schema{
+				"stepId":     mcp.String(""),
+				"stepName":   mcp.String(""),
+				"capability": mcp.String("worker capability, defaults to taskType"),
+				"params":     mcp.Map("step parameters", &mcp.Schema{}),
+				"dependsOn": mcp.Array("steps that must finish first", mcp.Object(map[string]*mcp.Schema{
+					"stepId":    mcp.String(""),
+					"condition": {Type: "string", Enum: []any{"success", "failed", "completed"}},
+					"required":  mcp.Boolean("skip this step when the condition does not hold"),
+					"params":    mcp.Map("parameter name -> field of the upstream result, a.b.c", mcp.String("")),
+				}, "stepId")),
+			}, "stepId")),
+			"timeout":      mcp.Integer("seconds"),
+			"draft":        mcp.Boolean("keep the task in init"),
+			"agent":        mcp.String("agent that runs the task"),
+			"agentVersion": mcp.Integer("agent version to pin, defaults to the current one"),
+		}, "taskType"),
+	}, t.createTask)
+	return s
+}
+
+func pageSizeSchema() *mcp.Schema {
+	min, max := 1.0, float64(maxPageSize)
+	return &mcp.Schema{Type: "integer", Minimum: &min, Maximum: &max}
+}
+
+func typeEnum() []any {
+	var out []any
+	for _, name := range whitelist.Types() {
+		out = append(out, name)
+	}
+	return out
+}
+
+type tools struct {
+	repo xdb.XIDRepo
+}
+
+func (t *tools) resolveXid(ctx context.Context, p *mcp.Principal, raw json.RawMessage) (any, error) {
+	var args struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	return map[string]string{"id": args.ID, "xid": protocols.GenerateXid(args.ID)}, nil
+}
+
+func (t *tools) readCard(ctx context.Context, p *mcp.Principal, raw json.RawMessage) (any, error) {
+	var args struct {
+		Xid  string `json:"xid"`
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if err := p.CheckRead(args.Path); err != nil {
+		return nil, err
+	}
+	doc, err := t.repo.FindByXid(ctx, args.Xid, args.Path)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("no card %s at %s", args.Xid, args.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"card": doc}, nil
+}
+
+func (t *tools) searchCards(ctx context.Context, p *mcp.Principal, raw json.RawMessage) (any, error) {
+	var args struct {
+		Path       string         `json:"path"`
+		NamePrefix string         `json:"namePrefix"`
+		Tags       []string       `json:"tags"`
+		Where      map[string]any `json:"where"`
+		PageSize   int            `json:"pageSize"`
+		Cursor     string         `json:"cursor"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if err := p.CheckRead(args.Path); err != nil {
+		return nil, err
+	}
+	// 只允许等值比较，防止通过操作符绕过路径限制
+	for k, v := range args.Where {
+		if strings.Contains(k, "$") {
+			return nil, fmt.Errorf("invalid field %q", k)
+		}
+		switch v.(type) {
+		case string, float64, bool, nil:
+		default:
+			return nil, fmt.Errorf("where.%s must be a string, number, boolean or null", k)
+		}
+	}
+
+	q := xdb.Query{Path: args.Path, TagsAll: args.Tags, AttributesEq: args.Where, SortBy: "createdAt", PageSize: pageSize(args.PageSize)}
+	if args.NamePrefix != "" {
+		q.NamePrefix = &args.NamePrefix
+	}
+	if args.Cursor != "" {
+		q.AfterCursor = &args.Cursor
+	}
+	docs, next, err := t.repo.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"items": docs, "nextCursor": next}, nil
+}
+
+func (t *tools) queryAttackSurface(ctx context.Context, p *mcp.Principal, raw json.RawMessage) (any, error) {
+	var args struct {
+		Xid       string            `json:"xid"`
+		Provider  string            `json:"provider"`
+		AccountID string            `json:"accountId"`
+		Region    string            `json:"region"`
+		Tags      map[string]string `json:"tags"`
+		Port      int               `json:"port"`
+		Protocol  string            `json:"protocol"`
+		Cidr      string            `json:"cidr"`
+		Sort      string            `json:"sort"`
+		PageSize  int               `json:"pageSize"`
+		Cursor    string            `json:"cursor"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if err := p.CheckRead(attack_surface.PathAWSAttackSurface); err != nil {
+		return nil, err
+	}
+	if args.Xid != "" {
+		detail, err := attack_surface.GetAttackSurfaceDetail(ctx, t.repo, args.Xid)
+		if err != nil {
+			return nil, err
+		}
+		// 详情包含实例和安全组卡片，每个卡片的路径都要可读
+		for _, card := range detail.Cards {
+			if err := p.CheckRead(card.Metadata.Path); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]any{"detail": detail}, nil
+	}
+	entries, next, err := attack_surface.ListAttackSurfaces(ctx, t.repo, attack_surface.ListFilter{
+		Provider:  args.Provider,
+		AccountID: args.AccountID,
+		Region:    args.Region,
+		Tags:      args.Tags,
+		Port:      args.Port,
+		Protocol:  args.Protocol,
+		Cidr:      args.Cidr,
+		Sort:      args.Sort,
+		PageSize:  pageSize(args.PageSize),
+		Cursor:    args.Cursor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"items": entries, "nextCursor": next}, nil
+}
+
+func (t *tools) checkWhitelist(ctx context.Context, p *mcp.Principal, raw json.RawMessage) (any, error) {
+	var args struct {
+		Type    string          `json:"type"`
+		Subject json.RawMessage `json:"subject"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if err := p.CheckRead(whitelist.Path); err != nil {
+		return nil, err
+	}
+	m, err := whitelist.Check(ctx, t.repo, args.Type, args.Subject)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (t *tools) createTask(ctx context.Context, p *mcp.Principal, raw json.RawMessage) (any, error) {
+	var req task.CreateRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	if err := p.CheckWrite(task.Path); err != nil {
+		return nil, err
+	}
+	req.CreatedBy = "mcp:" + p.Name
+	created, err := task.Create(ctx, t.repo, req)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"task": created}, nil
+}
+
+func pageSize(n int) int {
+	if n <= 0 || n > maxPageSize {
+		return maxPageSize
+	}
+	return n
+}
@@ -0,0 +1,75 @@
+package mcptools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xid-protocol/xidp/internal/mcp"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+// TestToolSchemas calls the tools with arguments their input schemas reject,
+// and with arguments they accept.
+func TestToolSchemas(t *testing.T) {
+	s := NewServer(xdbtest.New())
+	p := &mcp.Principal{Name: "test", Read: []string{"*"}, Write: []string{"*"}}
+
+	tests := []struct {
+		tool    string
+		args    string
+		wantErr string // 空表示调用成功
+	}{
+		{"resolve_xid", `{"id":"i-1"}`, ""},
+		{"resolve_xid", ``, "invalid arguments: id is required"},
+		{"resolve_xid", `{"id":5}`, "id must be a string"},
+		{"resolve_xid", `{"id":"i-1","extra":true}`, "unknown property extra"},
+		{"read_card", `{"xid":"x"}`, "path is required"},
+		{"search_cards", `{"path":"/protocols/task","pageSize":10,"tags":["a"],"where":{"status":"pending"}}`, ""},
+		{"search_cards", `{"path":"/protocols/task","pageSize":0}`, "pageSize must be at least 1"},
+		{"search_cards", `{"path":"/protocols/task","pageSize":101}`, "pageSize must be at most 100"},
+		{"search_cards", `{"path":"/protocols/task","pageSize":2.5}`, "pageSize must be an integer"},
+		{"search_cards", `{"path":"/protocols/task","tags":["a",1]}`, "tags[1] must be a string"},
+		{"search_cards", `{"path":"/protocols/task","where":"status"}`, "where must be an object"},
+		// where 的值由 schema 放行，由工具本身限制为标量
+		{"search_cards", `{"path":"/protocols/task","where":{"status":{"$ne":"done"}}}`, "where.status must be a string, number, boolean or null"},
+		{"query_attack_surface", `null`, ""},
+		{"query_attack_surface", `{"sort":"risk","tags":{"env":"prod"},"port":22}`, ""},
+		{"query_attack_surface", `{"sort":"name"}`, "sort must be one of [risk]"},
+		{"query_attack_surface", `{"tags":{"env":1}}`, "tags.env must be a string"},
+		{"query_attack_surface", `{"port":"22"}`, "port must be an integer"},
+		{"check_whitelist", `{"type":"ipCidr","subject":{"cidr":"10.0.0.1"}}`, ""},
+		{"check_whitelist", `{"type":"firewall","subject":{}}`, "type must be one of"},
+		{"check_whitelist", `{"type":"ipCidr","subject":"10.0.0.1"}`, "subject must be an object"},
+		{"create_task", `{"taskType":"scan","steps":[{"stepId":"a"},{"stepId":"b","dependsOn":[{"stepId":"a","condition":"failed"}]}]}`, ""},
+		{"create_task", `{"name":"scan"}`, "taskType is required"},
+		{"create_task", `{"taskType":"scan","steps":[{"stepName":"a"}]}`, "steps[0].stepId is required"},
+		{"create_task", `{"taskType":"scan","steps":[{"stepId":"a","dependsOn":[{"stepId":"b","condition":"sometimes"}]}]}`, "steps[0].dependsOn[0].condition must be one of"},
+		{"create_task", `{"taskType":"scan","steps":[{"stepId":"a","dependsOn":[{"stepId":"b","params":{"host":1}}]}]}`, "steps[0].dependsOn[0].params.host must be a string"},
+		{"create_task", `{"taskType":"scan","draft":"yes"}`, "draft must be a boolean"},
+	}
+	for i, tt := range tests {
+		res := call(t, s, p, i, tt.tool, tt.args)
+		switch {
+		case tt.wantErr == "" && res.IsError:
+			t.Errorf("%s %s: %s", tt.tool, tt.args, res.Text())
+		case tt.wantErr != "" && !res.IsError:
+			t.Errorf("%s %s: succeeded, want %q", tt.tool, tt.args, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(res.Text(), tt.wantErr):
+			t.Errorf("%s %s: got %q, want %q", tt.tool, tt.args, res.Text(), tt.wantErr)
+		}
+	}
+}
+
+func call(t *testing.T, s *mcp.Server, p *mcp.Principal, id int, tool, args string) *mcp.CallToolResult {
+	t.Helper()
+	params, _ := json.Marshal(mcp.CallToolParams{Name: tool, Arguments: json.RawMessage(args)})
+	msg := &mcp.Message{JSONRPC: "2.0", ID: json.RawMessage(fmt.Sprint(id + 1)), Method: "tools/call", Params: params}
+	resp := s.Handle(context.Background(), p, msg)
+	if resp.Error != nil {
+		t.Fatalf("%s: %v", tool, resp.Error)
+	}
+	return resp.Result.(*mcp.CallToolResult)
+}
@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/colin-404/logx"
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
+)
+
+// Notifier delivers a human readable message to an external channel.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, subject, message string) error
+}
+
+var (
+	mu        sync.RWMutex
+	notifiers []Notifier
+)
+
+func Register(n Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	notifiers = append(notifiers, n)
+}
+
+// Init registers the notifiers configured under Notify.
+func Init() {
+	if webhook := viper.GetString("Notify.lark_custom_bot_webhook"); webhook != "" {
+		Register(&LarkNotifier{WebhookURL: webhook})
+	}
+}
+
+// Send delivers the message through every registered notifier. Failures are
+// logged and joined, one broken channel does not stop the others.
+func Send(ctx context.Context, subject, message string) error {
+	mu.RLock()
+	list := append([]Notifier{}, notifiers...)
+	mu.RUnlock()
+
+	if len(list) == 0 {
+		logx.Infof("notify (no notifier configured): %s: %s", subject, message)
+		return nil
+	}
+
+	var errs []error
+	for _, n := range list {
+		if err := n.Notify(ctx, subject, message); err != nil {
+			logx.Errorf("notify %s: %v", n.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %v", n.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var ErrLarkNotConfigured = errors.New("Notify.lark_custom_bot_webhook is not set")
+
+// SendToLark 把消息原样发送到 Notify.lark_custom_bot_webhook 配置的飞书机器人
+func SendToLark(message string) error {
+	webhook := viper.GetString("Notify.lark_custom_bot_webhook")
+	if webhook == "" {
+		return ErrLarkNotConfigured
+	}
+	return (&LarkNotifier{WebhookURL: webhook}).send(message)
+}
+
+// Lark消息结构
+type LarkMessage struct {
+	MsgType string `json:"msg_type"`
+	Content struct {
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+// LarkNotifier 飞书自定义机器人
+type LarkNotifier struct {
+	WebhookURL string
+}
+
+func (l *LarkNotifier) Name() string {
+	return "lark_custom_bot"
+}
+
+func (l *LarkNotifier) Notify(ctx context.Context, subject, message string) error {
+	return l.send(fmt.Sprintf("%s\n%s", subject, message))
+}
+
+func (l *LarkNotifier) send(text string) error {
+	msg := LarkMessage{MsgType: "text"}
+	msg.Content.Text = text
+	resp, err := common.DoHttp("POST", l.WebhookURL, msg, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("lark webhook returned %s", resp.Status())
+	}
+	return nil
+}
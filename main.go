@@ -1,20 +1,42 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/colin-404/logx"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
 	"github.com/xid-protocol/xidp/biz"
+	"github.com/xid-protocol/xidp/internal/mcptools"
+	"github.com/xid-protocol/xidp/internal/notify"
+	"github.com/xid-protocol/xidp/protocols/agentrun"
+	"github.com/xid-protocol/xidp/protocols/attack_surface"
+	"github.com/xid-protocol/xidp/protocols/mcpchat"
+	securityevent "github.com/xid-protocol/xidp/protocols/security_event"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/xdb"
 )
 
 var sig = make(chan os.Signal, 1)
 
+var (
+	mcpStdio = flag.Bool("mcp", false, "serve MCP tools over stdin/stdout instead of HTTP")
+	// stdio 和导出模式下 stdout 只用于输出，日志改写到 stderr
+	mcpOut = os.Stdout
+
+	exportGraph  = flag.String("export-graph", "", "export the attack graph of -task or -thread as dot, mermaid or stix and exit")
+	exportTask   = flag.String("task", "", "task ID of the attack graph to export")
+	exportThread = flag.String("thread", "", "thread ID of the attack graph to export")
+	exportOut    = flag.String("o", "", "file to write the export to, stdout when empty")
+)
+
 func initConfig() string {
 	confPath := flag.String("c", "/opt/xidp/conf/config.yml", "config file path")
 	flag.Parse()
@@ -51,6 +73,32 @@ func initLog() {
 	logx.InitLogger(loger)
 }
 
+func initMongo() {
+	uri := viper.GetString("MongoDB.uri")
+	database := viper.GetString("MongoDB.database")
+	if uri == "" || database == "" {
+		logx.Errorf("MongoDB.uri, MongoDB.database is required")
+		os.Exit(1)
+	}
+	if _, err := common.NewMongo(&common.MongoOptions{URI: uri, DBName: database}); err != nil {
+		logx.Errorf("failed to connect mongodb: %v", err)
+		os.Exit(1)
+	}
+	//所有XID卡片默认存放在同一个collection
+	collection := viper.GetString("MongoDB.collection")
+	if collection == "" {
+		collection = "xid"
+	}
+	c := common.GetCollection(collection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := xdb.EnsureXIDIndexes(ctx, c); err != nil {
+		logx.Errorf("failed to create xid indexes: %v", err)
+		os.Exit(1)
+	}
+	xdb.SetDefault(xdb.NewMongoXIDRepo(c))
+}
+
 func init() {
 	//优雅关闭
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
@@ -61,16 +109,70 @@ func init() {
 	viper.SetConfigFile(confPath)
 	viper.ReadInConfig()
 
+	if *mcpStdio || *exportGraph != "" {
+		os.Stdout = os.Stderr
+	}
 	initLog()
-
+	initMongo()
+	notify.Init()
 }
 
 func main() {
+	if *mcpStdio {
+		MCPStart()
+		return
+	}
+	if *exportGraph != "" {
+		if err := ExportGraph(); err != nil {
+			logx.Errorf("export attack graph: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
 
+	mcpchat.SetHandler(agentrun.ChatHandler(xdb.Default()))
 	go ServerStart()
 	//go sealsuite.SealsuiteAcountInit()
 	//go accounts.AccountMonitor()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := SchedulerStart(ctx)
 	<-sig
+	cancel()
+	<-done
+}
+
+// SchedulerStart registers in-process task types and runs the task
+// scheduler until ctx is done. The returned channel is closed on exit.
+func SchedulerStart(ctx context.Context) <-chan struct{} {
+	if err := attack_surface.RegisterScanTask(ctx, xdb.Default()); err != nil {
+		logx.Errorf("failed to schedule attack surface scan: %v", err)
+	}
+	agentrun.RegisterExecutor(xdb.Default())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		task.NewScheduler(xdb.Default()).Run(ctx)
+	}()
+	return done
+}
+
+// MCPStart serves MCP over stdio until stdin is closed or a signal arrives.
+func MCPStart() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- mcptools.NewServer(xdb.Default()).ServeStdio(ctx, mcptools.StdioPrincipal(), os.Stdin, mcpOut)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			logx.Errorf("mcp stdio: %v", err)
+		}
+	case <-sig:
+	}
 }
 
 func ServerStart() {
@@ -93,3 +195,27 @@ func ServerStart() {
 
 	<-sig
 }
+
+// ExportGraph writes the attack graph selected by -task and -thread in the
+// -export-graph format to -o or stdout.
+func ExportGraph() error {
+	g, err := securityevent.GetGraph(context.Background(), xdb.Default(), securityevent.GraphFilter{
+		TaskID:   *exportTask,
+		ThreadID: *exportThread,
+	})
+	if err != nil {
+		return err
+	}
+	if *exportOut == "" {
+		return securityevent.Export(mcpOut, g, securityevent.Format(*exportGraph))
+	}
+	f, err := os.Create(*exportOut)
+	if err != nil {
+		return err
+	}
+	if err := securityevent.Export(f, g, securityevent.Format(*exportGraph)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,319 @@
+// Package agentrun runs AI agents: the model reasons, calls tools of the
+// agent's MCP servers and observes their results until it answers.
+package agentrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/llm"
+	"github.com/xid-protocol/xidp/protocols/aiagent"
+	"github.com/xid-protocol/xidp/protocols/mcpchat"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+)
+
+// 未配置 Agent.max_iterations、Agent.token_budget、Agent.run_timeout 时的限制
+const (
+	defaultMaxIterations = 10
+	defaultTokenBudget   = 200000
+	defaultRunTimeout    = 10 * time.Minute
+)
+
+// 返回给模型的工具结果最长字符数
+const maxObservation = 16 * 1024
+
+var (
+	ErrMaxIterations = errors.New("agent reached the iteration limit")
+	ErrTokenBudget   = errors.New("agent exhausted its token budget")
+)
+
+// Limits bound one run. TokenBudget counts input and output tokens of all
+// model calls.
+type Limits struct {
+	MaxIterations int
+	TokenBudget   int
+	Timeout       time.Duration
+}
+
+// DefaultLimits reads the limits from the Agent config.
+func DefaultLimits() Limits {
+	return Limits{}.orDefault()
+}
+
+// orDefault fills the unset limits from the config.
+func (l Limits) orDefault() Limits {
+	if l.MaxIterations <= 0 {
+		l.MaxIterations = viper.GetInt("Agent.max_iterations")
+	}
+	if l.MaxIterations <= 0 {
+		l.MaxIterations = defaultMaxIterations
+	}
+	if l.TokenBudget <= 0 {
+		l.TokenBudget = viper.GetInt("Agent.token_budget")
+	}
+	if l.TokenBudget <= 0 {
+		l.TokenBudget = defaultTokenBudget
+	}
+	if l.Timeout <= 0 {
+		l.Timeout = time.Duration(viper.GetInt64("Agent.run_timeout")) * time.Second
+	}
+	if l.Timeout <= 0 {
+		l.Timeout = defaultRunTimeout
+	}
+	return l
+}
+
+// Runner runs one agent version.
+type Runner struct {
+	Agent *aiagent.Version
+	// defaults to the provider of the agent's model settings
+	Provider llm.Provider
+	// stores the steps of the thread, may be nil
+	Record aiagent.StepRecorder
+	// decides on tool calls needing approval, defaults to asking in Chat
+	Approve aiagent.Approver
+	// receives the streamed output and tool progress, may be nil
+	Chat *mcpchat.ThreadManager
+	// zero values are taken from the config
+	Limits Limits
+}
+
+// run is the state of one Run.
+type run struct {
+	*Runner
+	task     *mcptask.Task
+	thread   int // index in task.Threads
+	provider llm.Provider
+	tools    *aiagent.Toolbox
+	limits   Limits
+	used     int
+}
+
+// Run answers input on behalf of task t. Every model call becomes a step of
+// a new thread of t with threadID; tool calls are recorded as steps of their
+// own. history holds the earlier turns of the conversation. The task's
+// status, Result and Error are set when the run ends.
+func (r *Runner) Run(ctx context.Context, t *mcptask.Task, threadID, input string, history []llm.Message) error {
+	if threadID == "" {
+		threadID = common.GenerateID()
+	}
+	t.Threads = append(t.Threads, mcptask.Thread{
+		ThreadID:   threadID,
+		ThreadName: r.Agent.Name,
+		Status:     mcptask.StatusRunning,
+		Steps:      []mcptask.Step{},
+	})
+	t.History = append(t.History, input)
+	t.Status, t.Result, t.Error, t.UpdatedAt = mcptask.StatusRunning, "", "", common.GetTimestamp()
+	run := &run{Runner: r, task: t, thread: len(t.Threads) - 1, limits: r.Limits.orDefault()}
+
+	ctx, cancel := context.WithTimeout(ctx, run.limits.Timeout)
+	defer cancel()
+	return run.finish(ctx, run.loop(ctx, threadID, input, history))
+}
+
+func (r *run) loop(ctx context.Context, threadID, input string, history []llm.Message) error {
+	r.provider = r.Provider
+	if r.provider == nil {
+		p, err := llm.Open(r.Agent.Config.Model.Provider)
+		if err != nil {
+			return err
+		}
+		r.provider = p
+	}
+	if len(r.Agent.Config.Tools) > 0 {
+		tb, err := aiagent.OpenToolbox(ctx, r.Agent.Config, r.recordStep, r.approver())
+		if err != nil {
+			return err
+		}
+		defer tb.Close()
+		r.tools = tb
+	}
+
+	msgs := append(append([]llm.Message{}, history...), llm.Message{Role: llm.RoleUser, Content: input})
+	for i := 1; i <= r.limits.MaxIterations; i++ {
+		if r.used >= r.limits.TokenBudget {
+			return fmt.Errorf("%w: used %d of %d tokens", ErrTokenBudget, r.used, r.limits.TokenBudget)
+		}
+		msg, err := r.iterate(ctx, threadID, i, msgs)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg...)
+		if msg[0].Role == llm.RoleAssistant && len(msg[0].ToolCalls) == 0 {
+			r.task.Result = msg[0].Content
+			return nil
+		}
+	}
+	return fmt.Errorf("%w of %d", ErrMaxIterations, r.limits.MaxIterations)
+}
+
+// iterate calls the model once and runs the tools it asks for. It returns
+// the assistant message followed by one tool message per call.
+func (r *run) iterate(ctx context.Context, threadID string, n int, msgs []llm.Message) ([]llm.Message, error) {
+	step := mcptask.Step{
+		ThreadID:   threadID,
+		StepID:     common.GenerateID(),
+		StepName:   fmt.Sprintf("iteration %d", n),
+		WorkerID:   r.Agent.Name,
+		WorkerName: r.provider.Name(),
+		Params:     map[string]any{"iteration": n, "agentVersion": r.Agent.Version},
+		Status:     mcptask.StatusRunning,
+		Result:     map[string]any{},
+	}
+	r.recordStep(ctx, threadID, step)
+
+	req := r.Agent.Config.Model.Request(r.Agent.SystemPrompt)
+	req.Messages = msgs
+	if r.tools != nil {
+		req.Tools = r.tools.Tools()
+	}
+	onChunk := func(llm.Chunk) error { return nil }
+	var stream *mcpchat.Stream
+	if r.Chat != nil {
+		stream = r.Chat.NewStream(threadID, r.Agent.Name)
+		onChunk = stream.OnChunk
+	}
+	resp, err := r.provider.Stream(ctx, req, onChunk)
+	if stream != nil {
+		stream.Flush()
+	}
+	if err != nil {
+		step.Status, step.Error = status(ctx, err), err.Error()
+		r.recordStep(ctx, threadID, step)
+		return nil, fmt.Errorf("failed to call model: %w", err)
+	}
+	r.used += resp.Usage.InputTokens + resp.Usage.OutputTokens
+
+	out := []llm.Message{resp.Message}
+	step.Result["content"] = resp.Message.Content
+	step.Result["stopReason"] = resp.StopReason
+	step.Result["usage"] = resp.Usage
+	calls := make([]map[string]any, 0, len(resp.Message.ToolCalls))
+	for _, call := range resp.Message.ToolCalls {
+		obs, isError, err := r.callTool(ctx, threadID, call)
+		if err != nil {
+			step.Status, step.Error = status(ctx, err), err.Error()
+			r.recordStep(ctx, threadID, step)
+			return nil, err
+		}
+		calls = append(calls, map[string]any{"id": call.ID, "name": call.Name, "arguments": string(call.Arguments), "isError": isError})
+		out = append(out, llm.Message{Role: llm.RoleTool, ToolCallID: call.ID, Content: obs, IsError: isError})
+	}
+	if len(calls) > 0 {
+		step.Params["toolCalls"] = calls
+	}
+	step.Status = mcptask.StatusCompleted
+	r.recordStep(ctx, threadID, step)
+	return out, nil
+}
+
+// callTool runs one tool call and returns the observation for the model.
+// Failures of the tool are observations too; only cancellation of the run
+// is returned as an error.
+func (r *run) callTool(ctx context.Context, threadID string, call llm.ToolCall) (string, bool, error) {
+	if r.Chat != nil {
+		content, _ := json.Marshal(map[string]any{"id": call.ID, "name": call.Name, "arguments": call.Arguments})
+		r.Chat.SendToolRunning(threadID, r.Agent.Name, string(content))
+	}
+	var obs string
+	isError := true
+	if r.tools == nil {
+		obs = "no tools are available"
+	} else {
+		res, err := r.tools.Call(ctx, threadID, call.Name, call.Arguments)
+		if ctx.Err() != nil {
+			return "", false, ctx.Err()
+		}
+		switch {
+		case err != nil:
+			obs = err.Error()
+		default:
+			obs, isError = res.Text(), res.IsError
+		}
+	}
+	if len(obs) > maxObservation {
+		obs = obs[:maxObservation] + "\n[truncated]"
+	}
+	if r.Chat != nil {
+		r.Chat.SendToolResult(threadID, r.Agent.Name, obs)
+	}
+	return obs, isError, nil
+}
+
+// approver wraps the approver of the run to mark the task waiting while a
+// decision is pending. Without one calls needing approval are rejected.
+func (r *run) approver() aiagent.Approver {
+	ask := r.Approve
+	if ask == nil && r.Chat != nil {
+		ask = func(ctx context.Context, step mcptask.Step) (mcptask.Approval, error) {
+			d, err := r.Chat.RequestToolApproval(ctx, step.ThreadID, r.Agent.Name, mcpchat.ToolApprovalRequest{
+				StepID:    step.StepID,
+				Tool:      step.StepName,
+				Arguments: step.Params,
+			})
+			return mcptask.Approval{Approved: d.Approved, Actor: d.Actor, Comment: d.Comment, DecidedAt: d.DecidedAt}, err
+		}
+	}
+	if ask == nil {
+		return nil
+	}
+	return func(ctx context.Context, step mcptask.Step) (mcptask.Approval, error) {
+		r.setStatus(mcptask.StatusWaiting)
+		defer r.setStatus(mcptask.StatusRunning)
+		return ask(ctx, step)
+	}
+}
+
+func (r *run) setStatus(st mcptask.Status) {
+	r.task.Status, r.task.UpdatedAt = st, common.GetTimestamp()
+	r.task.Threads[r.thread].Status = st
+}
+
+// recordStep keeps the step in the task's thread and hands it to Record.
+func (r *run) recordStep(ctx context.Context, threadID string, step mcptask.Step) error {
+	th := &r.task.Threads[r.thread]
+	replaced := false
+	for i := range th.Steps {
+		if th.Steps[i].StepID == step.StepID {
+			th.Steps[i], replaced = step, true
+		}
+	}
+	if !replaced {
+		th.Steps = append(th.Steps, step)
+	}
+	if r.Record == nil {
+		return nil
+	}
+	// 运行被取消后仍要记录最终状态
+	if err := r.Record(context.WithoutCancel(ctx), threadID, step); err != nil {
+		logx.Errorf("failed to record step %s of thread %s: %v", step.StepID, threadID, err)
+	}
+	return nil
+}
+
+func (r *run) finish(ctx context.Context, err error) error {
+	st := mcptask.StatusCompleted
+	if err != nil {
+		st = status(ctx, err)
+		r.task.Error = err.Error()
+	}
+	r.setStatus(st)
+	return err
+}
+
+func status(ctx context.Context, err error) mcptask.Status {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return mcptask.StatusTimeout
+	case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+		return mcptask.StatusCancelled
+	}
+	return mcptask.StatusFailed
+}
@@ -0,0 +1,121 @@
+package agentrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/colin-404/logx"
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/llm"
+	"github.com/xid-protocol/xidp/protocols/aiagent"
+	"github.com/xid-protocol/xidp/protocols/mcpchat"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+var ErrNoAgent = errors.New("no agent for the chat, pin one to the task or set Chat.agent")
+
+// ChatHandler answers chat input with an agent: the one pinned to the task
+// of the chat, else the current version of Chat.agent. Steps are stored in
+// the chat thread; the round runs the stored task through its state machine
+// and saves the answer as its result.
+func ChatHandler(repo xdb.XIDRepo) mcpchat.Handler {
+	return func(ctx context.Context, tm *mcpchat.ThreadManager, threadID string, req mcpchat.ChatRequest) error {
+		var stored *task.Task
+		if req.TaskID != "" {
+			t, err := task.Get(ctx, repo, req.TaskID)
+			if err != nil && !errors.Is(err, task.ErrTaskNotFound) {
+				return err
+			}
+			stored = t
+		}
+		agent, err := chatAgent(ctx, repo, stored)
+		if err != nil {
+			return err
+		}
+		history, err := chatHistory(ctx, repo, threadID, req.Content)
+		if err != nil {
+			return fmt.Errorf("failed to load chat history: %v", err)
+		}
+
+		var t *mcptask.Task
+		track := false
+		holdCtx, stopHold := context.WithCancel(ctx)
+		defer stopHold()
+		if stored != nil {
+			if stored, track, err = startChatTask(ctx, repo, stored, threadID); err != nil {
+				return err
+			}
+			if track {
+				go holdChatTask(holdCtx, repo, stored.TaskID, threadID)
+			}
+			t = runTask(stored)
+		} else {
+			now := common.GetTimestamp()
+			t = &mcptask.Task{
+				TaskID:    req.TaskID,
+				Name:      agent.Name,
+				TaskType:  "chat",
+				UserInput: req.Content,
+				Status:    mcptask.StatusInit,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+		}
+		r := &Runner{Agent: agent, Chat: tm, Record: recordStep(repo)}
+		tm.SendAgentStart(threadID, agent.Name, fmt.Sprintf("%s v%d", agent.Name, agent.Version))
+		err = r.Run(ctx, t, threadID, req.Content, history)
+		if track {
+			stopHold()
+			// 本轮被取消时仍要保存任务状态
+			if ferr := finishChatTask(context.WithoutCancel(ctx), repo, t); ferr != nil {
+				logx.Errorf("%v", ferr)
+			}
+		}
+		return err
+	}
+}
+
+// chatAgent resolves the agent version that answers a chat of task t, which
+// may be nil.
+func chatAgent(ctx context.Context, repo xdb.XIDRepo, t *task.Task) (*aiagent.Version, error) {
+	if t != nil && t.Agent != "" {
+		return aiagent.Resolve(ctx, repo, t.Agent, t.AgentVersion)
+	}
+	name := viper.GetString("Chat.agent")
+	if name == "" {
+		return nil, ErrNoAgent
+	}
+	v, err := aiagent.Resolve(ctx, repo, name, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load agent %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// chatHistory turns the stored conversation into model messages, leaving
+// out the input of the current round.
+func chatHistory(ctx context.Context, repo xdb.XIDRepo, threadID, input string) ([]llm.Message, error) {
+	msgs, err := mcpchat.ListMessages(ctx, repo, threadID)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(msgs); n > 0 && msgs[n-1].Role == mcpchat.RoleUser && msgs[n-1].Content == input {
+		msgs = msgs[:n-1]
+	}
+	out := make([]llm.Message, 0, len(msgs))
+	for _, m := range msgs {
+		if m.Content == "" {
+			continue
+		}
+		role := llm.RoleUser
+		if m.Role == mcpchat.RoleAssistant {
+			role = llm.RoleAssistant
+		}
+		out = append(out, llm.Message{Role: role, Content: m.Content})
+	}
+	return out, nil
+}
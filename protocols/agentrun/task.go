@@ -0,0 +1,157 @@
+package agentrun
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/xid-protocol/xidp/protocols/aiagent"
+	"github.com/xid-protocol/xidp/protocols/mcpchat"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+// TaskTypeAgent is the type of tasks answered by the agent they pin.
+const TaskTypeAgent = "agent"
+
+// chatTaskLease is how long a chat round holds its task without renewing;
+// the scheduler reclaims the task once the lease has run out.
+var chatTaskLease = 2 * time.Minute
+
+// RegisterExecutor runs pending agent tasks in the scheduler: the pinned
+// agent answers the task's userInput in a new chat thread of the task, the
+// answer becomes the task result. Steps are stored in the thread and tool
+// calls needing approval wait for a decision on the thread like in a chat.
+func RegisterExecutor(repo xdb.XIDRepo) {
+	task.RegisterExecutor(TaskTypeAgent, func(ctx context.Context, t *task.Task) (string, error) {
+		if t.Agent == "" {
+			return "", fmt.Errorf("%w: task %s pins no agent", ErrNoAgent, t.TaskID)
+		}
+		agent, err := aiagent.Resolve(ctx, repo, t.Agent, t.AgentVersion)
+		if err != nil {
+			return "", fmt.Errorf("failed to load agent %s: %w", t.Agent, err)
+		}
+
+		tm := mcpchat.ThreadMan()
+		req := mcpchat.ChatRequest{TaskID: t.TaskID, Content: t.UserInput}
+		// 没有客户端读取事件，订阅随执行结束
+		subCtx, unsubscribe := context.WithCancel(ctx)
+		defer unsubscribe()
+		runCtx, _, threadID, err := tm.StartThread(subCtx, req)
+		if err != nil {
+			return "", fmt.Errorf("failed to start thread of task %s: %v", t.TaskID, err)
+		}
+		req.ThreadID = threadID
+		// 任务被取消或超时时一并取消 thread
+		stop := context.AfterFunc(ctx, func() {
+			if _, err := tm.CancelThread(context.Background(), threadID); err != nil {
+				logx.Errorf("failed to cancel thread %s of task %s: %v", threadID, t.TaskID, err)
+			}
+		})
+		defer stop()
+
+		mt := runTask(t)
+		err = tm.RunWith(runCtx, threadID, req, func(ctx context.Context, tm *mcpchat.ThreadManager, threadID string, req mcpchat.ChatRequest) error {
+			r := &Runner{Agent: agent, Chat: tm, Record: recordStep(repo)}
+			tm.SendAgentStart(threadID, agent.Name, fmt.Sprintf("%s v%d", agent.Name, agent.Version))
+			return r.Run(ctx, mt, threadID, req.Content, nil)
+		})
+		if err != nil {
+			return "", err
+		}
+		return mt.Result, nil
+	})
+}
+
+// recordStep stores the steps of a run in its chat thread.
+func recordStep(repo xdb.XIDRepo) aiagent.StepRecorder {
+	return func(ctx context.Context, threadID string, step mcptask.Step) error {
+		return mcpchat.RecordStep(ctx, repo, threadID, step)
+	}
+}
+
+// runTask is the view of a stored task a Runner works on.
+func runTask(t *task.Task) *mcptask.Task {
+	return &mcptask.Task{
+		TaskID:      t.TaskID,
+		Name:        t.Name,
+		TaskType:    t.TaskType,
+		UserInput:   t.UserInput,
+		Description: t.Description,
+		Targets:     t.Targets,
+		History:     append([]string{}, t.History...),
+		Status:      mcptask.Status(t.Status),
+		Threads:     []mcptask.Thread{},
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+	}
+}
+
+// startChatTask claims the task of a chat round, retrying it when an earlier
+// round ended without success. It returns false for a completed task, which
+// keeps its result while the round goes on in the thread only. The claim has
+// to be held with holdChatTask while the round runs.
+func startChatTask(ctx context.Context, repo xdb.XIDRepo, t *task.Task, threadID string) (*task.Task, bool, error) {
+	id, reason := t.TaskID, "chat thread "+threadID
+	var err error
+	switch t.Status {
+	case task.TaskStatusCompleted:
+		return t, false, nil
+	case task.TaskStatusInit:
+		_, err = task.Transition(ctx, repo, id, task.TaskStatusPending, "chat", reason, nil)
+	case task.TaskStatusFailed, task.TaskStatusCancelled, task.TaskStatusTimeout:
+		_, err = task.Retry(ctx, repo, id, "chat", reason)
+	}
+	if err == nil {
+		t, err = task.Claim(ctx, repo, id, chatOwner(threadID), chatTaskLease, reason)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to start task %s: %w", id, err)
+	}
+	return t, true, nil
+}
+
+// holdChatTask renews the claim of the chat round on task id until ctx is
+// done, so that the scheduler does not reclaim a task waiting on a long tool
+// call or an approval.
+func holdChatTask(ctx context.Context, repo xdb.XIDRepo, id, threadID string) {
+	ticker := time.NewTicker(chatTaskLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, err := task.RenewClaim(ctx, repo, id, chatOwner(threadID), chatTaskLease)
+			if err != nil {
+				logx.Errorf("%v", err)
+				continue
+			}
+			if !ok {
+				logx.Warnf("chat thread %s lost the claim on task %s", threadID, id)
+				return
+			}
+		}
+	}
+}
+
+// chatOwner is the owner of the tasks claimed by a chat thread.
+func chatOwner(threadID string) string {
+	return "chat:" + threadID
+}
+
+// finishChatTask stores the outcome of a chat round on its task.
+func finishChatTask(ctx context.Context, repo xdb.XIDRepo, mt *mcptask.Task) error {
+	_, err := task.Transition(ctx, repo, mt.TaskID, task.TaskStatus(mt.Status), "chat", "", map[string]any{
+		"payload.result":         mt.Result,
+		"payload.error":          mt.Error,
+		"payload.history":        mt.History,
+		"payload.leaseExpiresAt": 0,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to finish task %s: %w", mt.TaskID, err)
+	}
+	return nil
+}
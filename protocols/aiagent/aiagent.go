@@ -1,12 +1,119 @@
 package aiagent
 
 import (
+	"errors"
+
 	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/llm"
 	"github.com/xid-protocol/xidp/protocols"
 )
 
+const (
+	Path        = "/protocols/aiagent"
+	PathVersion = "/protocols/aiagent/version"
+	// 每个代理一张计数卡片，分配递增的版本号
+	PathVersionSeq = "/protocols/aiagent/version/seq"
+)
+
+var (
+	ErrAgentAlreadyExists = errors.New("agent already exists")
+	ErrAgentNotFound      = errors.New("agent not found")
+	ErrVersionNotFound    = errors.New("agent version not found")
+	ErrInvalidAgent       = errors.New("invalid agent")
+	// 并发更新，当前版本已被其他请求改变
+	ErrVersionConflict = errors.New("agent was updated concurrently")
+)
+
 type Config struct {
-	tools any
+	Model ModelSettings `json:"model" bson:"model"`
+	// MCP servers the agent may call tools of
+	Tools []ToolServer `json:"tools" bson:"tools"`
+}
+
+// ModelSettings selects the model an agent runs on. Provider names an entry
+// of LLM.providers, empty is the default provider. Unset values fall back to
+// the defaults of the provider and model.
+type ModelSettings struct {
+	Provider    string   `json:"provider,omitempty" bson:"provider,omitempty"`
+	Model       string   `json:"model,omitempty" bson:"model,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty" bson:"temperature,omitempty"`
+	TopP        *float64 `json:"topP,omitempty" bson:"topP,omitempty"`
+	MaxTokens   int      `json:"maxTokens,omitempty" bson:"maxTokens,omitempty"`
+}
+
+// ToolServer references an MCP server, reached either by starting Command
+// over stdio or at URL over streamable HTTP. Allow and Deny hold tool names
+// or path.Match patterns; an empty Allow list allows every tool. Policies
+// decide whether admitted tools run right away, wait for approval or are
+// refused; the first matching entry wins and unmatched tools run right away.
+type ToolServer struct {
+	Name     string            `json:"name" bson:"name"`
+	Command  string            `json:"command,omitempty" bson:"command,omitempty"`
+	Args     []string          `json:"args,omitempty" bson:"args,omitempty"`
+	Env      []string          `json:"env,omitempty" bson:"env,omitempty"` // KEY=value
+	URL      string            `json:"url,omitempty" bson:"url,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
+	Allow    []string          `json:"allow,omitempty" bson:"allow,omitempty"`
+	Deny     []string          `json:"deny,omitempty" bson:"deny,omitempty"`
+	Timeout  int64             `json:"timeout,omitempty" bson:"timeout,omitempty"` // seconds per call
+	Policies []ToolPolicy      `json:"policies,omitempty" bson:"policies,omitempty"`
+}
+
+type ApprovalPolicy string
+
+const (
+	PolicyAuto            ApprovalPolicy = "auto"
+	PolicyRequireApproval ApprovalPolicy = "require-approval"
+	PolicyDeny            ApprovalPolicy = "deny"
+)
+
+// ToolPolicy applies a policy to the tools matching Tool, a tool name or
+// path.Match pattern.
+type ToolPolicy struct {
+	Tool   string         `json:"tool" bson:"tool"`
+	Policy ApprovalPolicy `json:"policy" bson:"policy"`
+}
+
+// Agent is the current definition of an agent, a copy of its latest version.
+// path /protocols/aiagent
+type Agent struct {
+	Name         string   `json:"name" bson:"name"`
+	Description  string   `json:"description,omitempty" bson:"description,omitempty"`
+	SystemPrompt string   `json:"systemPrompt" bson:"systemPrompt"`
+	Config       Config   `json:"config" bson:"config"`
+	Tags         []string `json:"tags" bson:"tags"`
+	Version      int64    `json:"version" bson:"version"`
+	CreatedBy    string   `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	UpdatedBy    string   `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	CreatedAt    int64    `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    int64    `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Version is an immutable snapshot of an agent, written on every change.
+// path /protocols/aiagent/version
+type Version struct {
+	Name         string   `json:"name" bson:"name"`
+	Version      int64    `json:"version" bson:"version"`
+	Description  string   `json:"description,omitempty" bson:"description,omitempty"`
+	SystemPrompt string   `json:"systemPrompt" bson:"systemPrompt"`
+	Config       Config   `json:"config" bson:"config"`
+	Tags         []string `json:"tags" bson:"tags"`
+	Comment      string   `json:"comment,omitempty" bson:"comment,omitempty"`
+	// 回滚产生的版本记录来源版本
+	RolledBackFrom int64  `json:"rolledBackFrom,omitempty" bson:"rolledBackFrom,omitempty"`
+	CreatedBy      string `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt      int64  `json:"createdAt" bson:"createdAt"`
+}
+
+// Request starts a model request with these settings.
+func (m ModelSettings) Request(system string) *llm.Request {
+	return &llm.Request{
+		Model:       m.Model,
+		System:      system,
+		Temperature: m.Temperature,
+		TopP:        m.TopP,
+		MaxTokens:   m.MaxTokens,
+	}
 }
 
 func NewInfo(AgentName string, systemPrompt string) protocols.Info {
@@ -23,7 +130,7 @@ func NewMetadata(operation protocols.OperationType) protocols.Metadata {
 		CreatedAt:   common.GetTimestamp(),
 		CardId:      common.GenerateID(),
 		Operation:   operation,
-		Path:        "/protocols/aiagent",
+		Path:        Path,
 		ContentType: "application/json",
 	}
 }
@@ -0,0 +1,363 @@
+package aiagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/llm"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var agentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+type CreateRequest struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	SystemPrompt string   `json:"systemPrompt"`
+	Config       Config   `json:"config"`
+	Tags         []string `json:"tags"`
+	CreatedBy    string   `json:"createdBy"`
+	Comment      string   `json:"comment"`
+}
+
+// UpdateRequest changes the fields that are set. BaseVersion, when set, must
+// be the current version, so edits based on an old version are refused.
+type UpdateRequest struct {
+	Description  *string   `json:"description"`
+	SystemPrompt *string   `json:"systemPrompt"`
+	Config       *Config   `json:"config"`
+	Tags         *[]string `json:"tags"`
+	UpdatedBy    string    `json:"updatedBy"`
+	BaseVersion  int64     `json:"baseVersion"`
+	Comment      string    `json:"comment"`
+}
+
+type ListFilter struct {
+	NamePrefix string
+	Tags       []string
+	PageSize   int
+	Cursor     string
+}
+
+// Create stores a new agent together with its first version.
+func Create(ctx context.Context, repo xdb.XIDRepo, req CreateRequest) (*Agent, error) {
+	v := &Version{
+		Name:         strings.TrimSpace(req.Name),
+		Description:  req.Description,
+		SystemPrompt: req.SystemPrompt,
+		Config:       req.Config,
+		Tags:         req.Tags,
+		Comment:      req.Comment,
+		CreatedBy:    req.CreatedBy,
+	}
+	if err := validate(v); err != nil {
+		return nil, err
+	}
+	exists, err := repo.Exists(ctx, protocols.GenerateXid(v.Name), Path)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrAgentAlreadyExists
+	}
+	if err := saveVersion(ctx, repo, v); err != nil {
+		return nil, err
+	}
+
+	a := &Agent{
+		Name:      v.Name,
+		CreatedBy: req.CreatedBy,
+		UpdatedBy: req.CreatedBy,
+		CreatedAt: v.CreatedAt,
+	}
+	a.apply(v)
+	info := NewInfo(a.Name, a.SystemPrompt)
+	info.Tags = append(info.Tags, a.Tags...)
+	meta := NewMetadata(protocols.OperationCreate)
+	card := NewXID[any](&info, &meta, a)
+	if err := repo.Upsert(ctx, card.Xid, Path, card); err != nil {
+		return nil, fmt.Errorf("failed to store agent: %v", err)
+	}
+	return a, nil
+}
+
+// Get returns the current definition of an agent.
+func Get(ctx context.Context, repo xdb.XIDRepo, name string) (*Agent, error) {
+	doc, err := repo.FindByXid(ctx, protocols.GenerateXid(name), Path)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrAgentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a Agent
+	if err := xdb.DecodePayload(doc, &a); err != nil {
+		return nil, fmt.Errorf("failed to decode agent %s: %v", name, err)
+	}
+	return &a, nil
+}
+
+// List returns one page of agents, newest first, and the cursor of the next page.
+func List(ctx context.Context, repo xdb.XIDRepo, filter ListFilter) ([]*Agent, string, error) {
+	q := xdb.Query{Path: Path, SortBy: "createdAt", PageSize: filter.PageSize}
+	if len(filter.Tags) > 0 {
+		q.Where = map[string]any{"payload.tags": map[string]any{"$all": filter.Tags}}
+	}
+	if filter.NamePrefix != "" {
+		q.NamePrefix = &filter.NamePrefix
+	}
+	if filter.Cursor != "" {
+		q.AfterCursor = &filter.Cursor
+	}
+	docs, next, err := repo.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	out := make([]*Agent, 0, len(docs))
+	for _, doc := range docs {
+		var a Agent
+		if err := xdb.DecodePayload(doc, &a); err != nil {
+			return nil, "", fmt.Errorf("failed to decode agent %s: %v", doc.Xid, err)
+		}
+		out = append(out, &a)
+	}
+	return out, next, nil
+}
+
+// Update writes a new version of the agent and makes it current.
+func Update(ctx context.Context, repo xdb.XIDRepo, name string, req UpdateRequest) (*Agent, error) {
+	a, err := Get(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	if req.BaseVersion != 0 && req.BaseVersion != a.Version {
+		return nil, fmt.Errorf("%w: current version is %d", ErrVersionConflict, a.Version)
+	}
+	v := a.snapshot()
+	if req.Description != nil {
+		v.Description = *req.Description
+	}
+	if req.SystemPrompt != nil {
+		v.SystemPrompt = *req.SystemPrompt
+	}
+	if req.Config != nil {
+		v.Config = *req.Config
+	}
+	if req.Tags != nil {
+		v.Tags = *req.Tags
+	}
+	v.Comment, v.CreatedBy = req.Comment, req.UpdatedBy
+	if err := validate(v); err != nil {
+		return nil, err
+	}
+	return commit(ctx, repo, a, v)
+}
+
+// Rollback makes an earlier version current again. The old version is copied
+// into a new one, so the history stays append-only.
+func Rollback(ctx context.Context, repo xdb.XIDRepo, name string, version int64, actor, comment string) (*Agent, error) {
+	a, err := Get(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	old, err := GetVersion(ctx, repo, name, version)
+	if err != nil {
+		return nil, err
+	}
+	v := *old
+	v.RolledBackFrom, v.Comment, v.CreatedBy = old.Version, comment, actor
+	if v.Comment == "" {
+		v.Comment = "rollback to version " + strconv.FormatInt(old.Version, 10)
+	}
+	// 回滚前的版本可能引用了已不允许的命令
+	if err := validate(&v); err != nil {
+		return nil, err
+	}
+	return commit(ctx, repo, a, &v)
+}
+
+// Delete removes the agent. Its versions are kept, tasks pinned to one of
+// them can still load it.
+func Delete(ctx context.Context, repo xdb.XIDRepo, name string) error {
+	xid := protocols.GenerateXid(name)
+	exists, err := repo.Exists(ctx, xid, Path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrAgentNotFound
+	}
+	if err := repo.DeleteSoft(ctx, xid, Path, common.GetTimestamp()); err != nil {
+		return fmt.Errorf("failed to delete agent %s: %v", name, err)
+	}
+	return nil
+}
+
+// GetVersion returns one version of an agent, also after the agent was deleted.
+func GetVersion(ctx context.Context, repo xdb.XIDRepo, name string, version int64) (*Version, error) {
+	doc, err := repo.FindByXid(ctx, protocols.GenerateXid(versionID(name, version)), PathVersion)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v Version
+	if err := xdb.DecodePayload(doc, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode agent %s version %d: %v", name, version, err)
+	}
+	return &v, nil
+}
+
+// ListVersions returns one page of the versions of an agent, newest first.
+func ListVersions(ctx context.Context, repo xdb.XIDRepo, name string, pageSize int, cursor string) ([]*Version, string, error) {
+	q := xdb.Query{
+		Path:     PathVersion,
+		Where:    map[string]any{"payload.name": name},
+		SortBy:   "payload.version",
+		PageSize: pageSize,
+	}
+	if cursor != "" {
+		q.AfterCursor = &cursor
+	}
+	docs, next, err := repo.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	out := make([]*Version, 0, len(docs))
+	for _, doc := range docs {
+		var v Version
+		if err := xdb.DecodePayload(doc, &v); err != nil {
+			return nil, "", fmt.Errorf("failed to decode agent version %s: %v", doc.Xid, err)
+		}
+		out = append(out, &v)
+	}
+	return out, next, nil
+}
+
+// Resolve returns the version a task runs with: the given version, or the
+// current one when version is 0.
+func Resolve(ctx context.Context, repo xdb.XIDRepo, name string, version int64) (*Version, error) {
+	if version > 0 {
+		return GetVersion(ctx, repo, name, version)
+	}
+	a, err := Get(ctx, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	return GetVersion(ctx, repo, name, a.Version)
+}
+
+// commit stores v as the next version and makes it current, provided the
+// agent is still at the version a was read at.
+func commit(ctx context.Context, repo xdb.XIDRepo, a *Agent, v *Version) (*Agent, error) {
+	base := a.Version
+	if err := saveVersion(ctx, repo, v); err != nil {
+		return nil, err
+	}
+	a.apply(v)
+	a.UpdatedBy = v.CreatedBy
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(a.Name), Path, map[string]any{
+		"payload.version": base,
+	}, map[string]any{
+		"info.desc":            a.SystemPrompt,
+		"info.tags":            append([]string{"AI Agent"}, a.Tags...),
+		"payload.description":  a.Description,
+		"payload.systemPrompt": a.SystemPrompt,
+		"payload.config":       a.Config,
+		"payload.tags":         a.Tags,
+		"payload.version":      a.Version,
+		"payload.updatedBy":    a.UpdatedBy,
+		"payload.updatedAt":    a.UpdatedAt,
+	})
+	if err == nil && !ok {
+		err = ErrVersionConflict
+	}
+	if err != nil {
+		// 未生效的版本不保留
+		repo.DeleteHard(ctx, protocols.GenerateXid(versionID(v.Name, v.Version)), PathVersion)
+		if errors.Is(err, ErrVersionConflict) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to update agent %s: %v", a.Name, err)
+	}
+	return a, nil
+}
+
+// saveVersion allocates the next version number of the agent and stores v.
+func saveVersion(ctx context.Context, repo xdb.XIDRepo, v *Version) error {
+	n, err := repo.Incr(ctx, protocols.GenerateXid(v.Name), PathVersionSeq, "payload.seq", 1)
+	if err != nil {
+		return fmt.Errorf("failed to allocate agent version: %v", err)
+	}
+	v.Version, v.CreatedAt = n, common.GetTimestamp()
+	if v.Tags == nil {
+		v.Tags = []string{}
+	}
+	info := protocols.NewInfo(versionID(v.Name, v.Version), "AI_Agent_Version")
+	meta := protocols.NewMetadata(protocols.OperationCreate, PathVersion, "application/json")
+	if err := repo.Insert(ctx, protocols.NewXID[any](&info, &meta, v)); err != nil {
+		return fmt.Errorf("failed to store agent version: %v", err)
+	}
+	return nil
+}
+
+func versionID(name string, version int64) string {
+	return name + "#" + strconv.FormatInt(version, 10)
+}
+
+func (a *Agent) apply(v *Version) {
+	a.Description, a.SystemPrompt, a.Config, a.Tags = v.Description, v.SystemPrompt, v.Config, v.Tags
+	a.Version, a.UpdatedAt = v.Version, v.CreatedAt
+}
+
+func (a *Agent) snapshot() *Version {
+	return &Version{
+		Name:         a.Name,
+		Description:  a.Description,
+		SystemPrompt: a.SystemPrompt,
+		Config:       a.Config,
+		Tags:         a.Tags,
+	}
+}
+
+func validate(v *Version) error {
+	if !agentName.MatchString(v.Name) {
+		return fmt.Errorf("%w: name must be 1-64 letters, digits, _, . or -", ErrInvalidAgent)
+	}
+	if strings.TrimSpace(v.SystemPrompt) == "" {
+		return fmt.Errorf("%w: systemPrompt is required", ErrInvalidAgent)
+	}
+	m := v.Config.Model
+	if m.Provider != "" && !llm.Has(m.Provider) {
+		return fmt.Errorf("%w: provider %s is not in LLM.providers", ErrInvalidAgent, m.Provider)
+	}
+	if m.Temperature != nil && (*m.Temperature < 0 || *m.Temperature > 2) {
+		return fmt.Errorf("%w: temperature must be between 0 and 2", ErrInvalidAgent)
+	}
+	if m.TopP != nil && (*m.TopP < 0 || *m.TopP > 1) {
+		return fmt.Errorf("%w: topP must be between 0 and 1", ErrInvalidAgent)
+	}
+	if m.MaxTokens < 0 {
+		return fmt.Errorf("%w: maxTokens must not be negative", ErrInvalidAgent)
+	}
+	seen := map[string]bool{}
+	for i := range v.Config.Tools {
+		s := &v.Config.Tools[i]
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAgent, err)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("%w: duplicate tool server %s", ErrInvalidAgent, s.Name)
+		}
+		seen[s.Name] = true
+	}
+	return nil
+}
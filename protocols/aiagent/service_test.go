@@ -0,0 +1,152 @@
+package aiagent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+// TestVersions updates an agent and rolls it back: every change writes a new
+// version and old versions stay as they were.
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	a, err := Create(ctx, repo, CreateRequest{Name: "recon", SystemPrompt: "v1", Tags: []string{"scan"}, CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if a.Version != 1 {
+		t.Fatalf("version = %d, want 1", a.Version)
+	}
+	if _, err := Create(ctx, repo, CreateRequest{Name: "recon", SystemPrompt: "again"}); !errors.Is(err, ErrAgentAlreadyExists) {
+		t.Fatalf("second create got %v, want ErrAgentAlreadyExists", err)
+	}
+
+	prompt := "v2"
+	if a, err = Update(ctx, repo, "recon", UpdateRequest{SystemPrompt: &prompt, UpdatedBy: "bob", BaseVersion: 1}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if a.Version != 2 || a.SystemPrompt != "v2" || a.UpdatedBy != "bob" || !reflect.DeepEqual(a.Tags, []string{"scan"}) {
+		t.Fatalf("updated agent = %+v", a)
+	}
+	stale := "stale"
+	if _, err := Update(ctx, repo, "recon", UpdateRequest{SystemPrompt: &stale, BaseVersion: 1}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("update of an old version got %v, want ErrVersionConflict", err)
+	}
+
+	if a, err = Rollback(ctx, repo, "recon", 1, "carol", ""); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if a.Version != 3 || a.SystemPrompt != "v1" || a.UpdatedBy != "carol" {
+		t.Fatalf("rolled back agent = %+v", a)
+	}
+	if _, err := Rollback(ctx, repo, "recon", 9, "carol", ""); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("rollback to a missing version got %v, want ErrVersionNotFound", err)
+	}
+
+	want := []struct {
+		prompt, createdBy string
+		rolledBackFrom    int64
+	}{
+		{"v1", "alice", 0},
+		{"v2", "bob", 0},
+		{"v1", "carol", 1},
+	}
+	for i, w := range want {
+		v, err := GetVersion(ctx, repo, "recon", int64(i+1))
+		if err != nil {
+			t.Fatalf("version %d: %v", i+1, err)
+		}
+		if v.SystemPrompt != w.prompt || v.CreatedBy != w.createdBy || v.RolledBackFrom != w.rolledBackFrom {
+			t.Errorf("version %d = %+v, want %+v", i+1, v, w)
+		}
+	}
+	versions, _, err := ListVersions(ctx, repo, "recon", 10, "")
+	if err != nil {
+		t.Fatalf("list versions: %v", err)
+	}
+	var numbers []int64
+	for _, v := range versions {
+		numbers = append(numbers, v.Version)
+	}
+	if !reflect.DeepEqual(numbers, []int64{3, 2, 1}) {
+		t.Errorf("versions = %v, want [3 2 1], the rejected update must not leave a version", numbers)
+	}
+
+	// 任务可以固定在某个版本上，删除代理后仍能加载
+	for pin, prompt := range map[int64]string{0: "v1", 2: "v2"} {
+		v, err := Resolve(ctx, repo, "recon", pin)
+		if err != nil || v.SystemPrompt != prompt {
+			t.Errorf("resolve %d = %+v, %v, want %s", pin, v, err, prompt)
+		}
+	}
+	if err := Delete(ctx, repo, "recon"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := Get(ctx, repo, "recon"); !errors.Is(err, ErrAgentNotFound) {
+		t.Errorf("get after delete got %v, want ErrAgentNotFound", err)
+	}
+	if v, err := Resolve(ctx, repo, "recon", 2); err != nil || v.SystemPrompt != "v2" {
+		t.Errorf("pinned version after delete = %+v, %v", v, err)
+	}
+}
+
+// TestCommitConflict commits on a copy of the agent read before another
+// update: the version it wrote is dropped.
+func TestCommitConflict(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	if _, err := Create(ctx, repo, CreateRequest{Name: "recon", SystemPrompt: "v1"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	stale, _ := Get(ctx, repo, "recon")
+	prompt := "v2"
+	if _, err := Update(ctx, repo, "recon", UpdateRequest{SystemPrompt: &prompt}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	v := stale.snapshot()
+	v.SystemPrompt = "lost"
+	if _, err := commit(ctx, repo, stale, v); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("commit on a stale agent got %v, want ErrVersionConflict", err)
+	}
+	if _, err := GetVersion(ctx, repo, "recon", v.Version); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("version %d of the failed commit got %v, want ErrVersionNotFound", v.Version, err)
+	}
+	if a, _ := Get(ctx, repo, "recon"); a.Version != 2 || a.SystemPrompt != "v2" {
+		t.Errorf("agent = %+v, want version 2 kept", a)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	temp := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name  string
+		v     Version
+		valid bool
+	}{
+		{"minimal", Version{Name: "recon", SystemPrompt: "scan"}, true},
+		{"bad name", Version{Name: "-recon", SystemPrompt: "scan"}, false},
+		{"name with a slash", Version{Name: "a/b", SystemPrompt: "scan"}, false},
+		{"no prompt", Version{Name: "recon", SystemPrompt: "  "}, false},
+		{"temperature", Version{Name: "recon", SystemPrompt: "scan", Config: Config{Model: ModelSettings{Temperature: temp(2.5)}}}, false},
+		{"topP", Version{Name: "recon", SystemPrompt: "scan", Config: Config{Model: ModelSettings{TopP: temp(1.5)}}}, false},
+		{"unknown provider", Version{Name: "recon", SystemPrompt: "scan", Config: Config{Model: ModelSettings{Provider: "nope"}}}, false},
+		{"tool server", Version{Name: "recon", SystemPrompt: "scan", Config: Config{Tools: []ToolServer{{Name: "lab", URL: "http://lab"}}}}, true},
+		{"duplicate tool server", Version{Name: "recon", SystemPrompt: "scan", Config: Config{Tools: []ToolServer{{Name: "lab", URL: "http://a"}, {Name: "lab", URL: "http://b"}}}}, false},
+		{"command not allowed", Version{Name: "recon", SystemPrompt: "scan", Config: Config{Tools: []ToolServer{{Name: "lab", Command: "/bin/sh"}}}}, false},
+		{"bad policy", Version{Name: "recon", SystemPrompt: "scan", Config: Config{Tools: []ToolServer{{Name: "lab", URL: "http://lab", Policies: []ToolPolicy{{Tool: "*", Policy: "ask"}}}}}}, false},
+	}
+	for _, tt := range tests {
+		err := validate(&tt.v)
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidAgent) {
+			t.Errorf("%s: got %v, want ErrInvalidAgent", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package aiagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/mcp"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+)
+
+const defaultToolTimeout = 60 * time.Second
+
+var (
+	ErrInvalidToolServer = errors.New("invalid tool server")
+	ErrUnknownTool       = errors.New("unknown tool")
+	ErrInvalidArguments  = errors.New("invalid tool arguments")
+	ErrToolDenied        = errors.New("tool call denied by policy")
+	ErrToolRejected      = errors.New("tool call rejected by approver")
+)
+
+// Validate checks a tool server reference before it is stored.
+func (s *ToolServer) Validate() error {
+	switch {
+	case s.Name == "" || strings.Contains(s.Name, "__"):
+		return fmt.Errorf("%w: name is required and must not contain __", ErrInvalidToolServer)
+	case (s.Command == "") == (s.URL == ""):
+		return fmt.Errorf("%w: %s needs exactly one of command and url", ErrInvalidToolServer, s.Name)
+	case s.Command != "" && !commandAllowed(s.Command):
+		return fmt.Errorf("%w: command %s is not in Agent.mcp_commands", ErrInvalidToolServer, s.Command)
+	case s.Timeout < 0:
+		return fmt.Errorf("%w: timeout must not be negative", ErrInvalidToolServer)
+	}
+	patterns := append(slices.Clone(s.Allow), s.Deny...)
+	for _, p := range s.Policies {
+		switch p.Policy {
+		case PolicyAuto, PolicyRequireApproval, PolicyDeny:
+		default:
+			return fmt.Errorf("%w: unknown policy %q for %s", ErrInvalidToolServer, p.Policy, p.Tool)
+		}
+		patterns = append(patterns, p.Tool)
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("%w: bad pattern %q", ErrInvalidToolServer, p)
+		}
+	}
+	return nil
+}
+
+// commandAllowed 代理配置可以通过 API 修改，只允许启动配置文件中列出的命令
+func commandAllowed(command string) bool {
+	return slices.Contains(viper.GetStringSlice("Agent.mcp_commands"), command)
+}
+
+// Permits reports whether the server's allow and deny lists admit a tool.
+func (s *ToolServer) Permits(tool string) bool {
+	for _, p := range s.Deny {
+		if ok, _ := path.Match(p, tool); ok {
+			return false
+		}
+	}
+	if len(s.Allow) == 0 {
+		return true
+	}
+	for _, p := range s.Allow {
+		if ok, _ := path.Match(p, tool); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy returns the approval policy of a tool, auto when none matches.
+func (s *ToolServer) Policy(tool string) ApprovalPolicy {
+	for _, p := range s.Policies {
+		if ok, _ := path.Match(p.Tool, tool); ok {
+			return p.Policy
+		}
+	}
+	return PolicyAuto
+}
+
+func (s *ToolServer) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return time.Duration(s.Timeout) * time.Second
+	}
+	return defaultToolTimeout
+}
+
+func (s *ToolServer) connect(ctx context.Context) (*mcp.Client, error) {
+	var t mcp.Transport
+	if s.Command != "" {
+		if !commandAllowed(s.Command) {
+			return nil, fmt.Errorf("%w: command %s is not in Agent.mcp_commands", ErrInvalidToolServer, s.Command)
+		}
+		var err error
+		if t, err = mcp.NewStdioTransport(s.Command, s.Args, s.Env); err != nil {
+			return nil, err
+		}
+	} else {
+		t = mcp.NewHTTPTransport(s.URL, s.Headers)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	return mcp.Connect(ctx, t, mcp.Implementation{Name: "xidp-agent", Version: protocols.XIDVersion})
+}
+
+// StepRecorder stores a tool call step of a thread.
+type StepRecorder func(ctx context.Context, threadID string, step mcptask.Step) error
+
+// Approver asks a person to decide on a tool call, step is recorded in
+// waiting state. It blocks until the decision or until ctx is done.
+type Approver func(ctx context.Context, step mcptask.Step) (mcptask.Approval, error)
+
+type toolEntry struct {
+	server *ToolServer
+	client *mcp.Client
+	tool   mcp.Tool
+}
+
+// Toolbox holds the connected MCP servers of an agent and the tools they
+// expose to it. Tools are named <server>__<tool>.
+type Toolbox struct {
+	clients []*mcp.Client
+	tools   map[string]*toolEntry
+	order   []string
+	record  StepRecorder
+	approve Approver
+}
+
+// OpenToolbox connects to every tool server of the config and lists the
+// tools its allow and deny lists admit. record may be nil. Without approve
+// calls needing approval are rejected.
+func OpenToolbox(ctx context.Context, cfg Config, record StepRecorder, approve Approver) (*Toolbox, error) {
+	tb := &Toolbox{tools: make(map[string]*toolEntry), record: record, approve: approve}
+	for i := range cfg.Tools {
+		s := &cfg.Tools[i]
+		if err := s.Validate(); err != nil {
+			tb.Close()
+			return nil, err
+		}
+		client, err := s.connect(ctx)
+		if err != nil {
+			tb.Close()
+			return nil, fmt.Errorf("failed to connect to tool server %s: %v", s.Name, err)
+		}
+		tb.clients = append(tb.clients, client)
+
+		listCtx, cancel := context.WithTimeout(ctx, s.timeout())
+		tools, err := client.ListTools(listCtx)
+		cancel()
+		if err != nil {
+			tb.Close()
+			return nil, fmt.Errorf("failed to list tools of %s: %v", s.Name, err)
+		}
+		for _, t := range tools {
+			if !s.Permits(t.Name) {
+				continue
+			}
+			name := s.Name + "__" + t.Name
+			// 禁止的工具不提供给模型，仍保留以便记录被拒绝的调用
+			if _, ok := tb.tools[name]; !ok && s.Policy(t.Name) != PolicyDeny {
+				tb.order = append(tb.order, name)
+			}
+			tb.tools[name] = &toolEntry{server: s, client: client, tool: t}
+		}
+	}
+	return tb, nil
+}
+
+// Tools returns the admitted tools under their qualified names, leaving out
+// those denied by policy.
+func (tb *Toolbox) Tools() []mcp.Tool {
+	out := make([]mcp.Tool, 0, len(tb.order))
+	for _, name := range tb.order {
+		t := tb.tools[name].tool
+		t.Name = name
+		out = append(out, t)
+	}
+	return out
+}
+
+// Call validates the arguments against the tool's input schema, passes the
+// call through the server's approval policy and invokes it within the
+// server's timeout. The call is recorded as a step of the thread, waiting
+// for approval, running and then with its outcome, together with the
+// decision. A tool reporting an error is returned as a result with IsError
+// set, not as an error.
+func (tb *Toolbox) Call(ctx context.Context, threadID, name string, args json.RawMessage) (*mcp.CallToolResult, error) {
+	e, ok := tb.tools[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTool, name)
+	}
+	if err := e.tool.InputSchema.ValidateJSON(args); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+	}
+
+	step := mcptask.Step{
+		ThreadID:   threadID,
+		StepID:     common.GenerateID(),
+		StepName:   name,
+		WorkerID:   e.server.Name,
+		WorkerName: e.tool.Name,
+		Status:     mcptask.StatusRunning,
+	}
+	if len(args) > 0 {
+		json.Unmarshal(args, &step.Params)
+	}
+	if err := tb.gate(ctx, e, &step); err != nil {
+		return nil, err
+	}
+	tb.recordStep(ctx, step)
+
+	callCtx, cancel := context.WithTimeout(ctx, e.server.timeout())
+	defer cancel()
+	res, err := e.client.CallTool(callCtx, e.tool.Name, args)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		step.Status = mcptask.StatusTimeout
+		step.Error = fmt.Sprintf("tool %s timed out after %s", name, e.server.timeout())
+		err = errors.New(step.Error)
+	case errors.Is(err, context.Canceled):
+		step.Status = mcptask.StatusCancelled
+		step.Error = err.Error()
+	case err != nil:
+		step.Status = mcptask.StatusFailed
+		step.Error = err.Error()
+	case res.IsError:
+		step.Status = mcptask.StatusFailed
+		step.Error = res.Text()
+	default:
+		step.Status = mcptask.StatusCompleted
+	}
+	if res != nil {
+		step.Result = map[string]any{"content": res.Text()}
+		if res.StructuredContent != nil {
+			step.Result["structuredContent"] = res.StructuredContent
+		}
+	}
+	// 调用方的 ctx 可能已取消，结果仍要落库
+	tb.recordStep(context.WithoutCancel(ctx), step)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// gate applies the approval policy to a call. A refused call is recorded
+// with its decision and returned as an error.
+func (tb *Toolbox) gate(ctx context.Context, e *toolEntry, step *mcptask.Step) error {
+	policy := e.server.Policy(e.tool.Name)
+	decision := mcptask.Approval{Policy: string(policy), Approved: true, DecidedAt: common.GetTimestamp()}
+	var err error
+	switch policy {
+	case PolicyDeny:
+		decision.Approved = false
+		err = fmt.Errorf("%w: %s", ErrToolDenied, step.StepName)
+	case PolicyRequireApproval:
+		if tb.approve == nil {
+			decision.Approved, decision.Comment = false, "no approver is available"
+			err = fmt.Errorf("%w: %s: %s", ErrToolRejected, step.StepName, decision.Comment)
+			break
+		}
+		step.Status = mcptask.StatusWaiting
+		tb.recordStep(ctx, *step)
+		decision, err = tb.approve(ctx, *step)
+		if err != nil {
+			step.Status, step.Error = mcptask.StatusFailed, err.Error()
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				step.Status = mcptask.StatusTimeout
+			case errors.Is(err, context.Canceled):
+				step.Status = mcptask.StatusCancelled
+			}
+			tb.recordStep(context.WithoutCancel(ctx), *step)
+			return err
+		}
+		decision.Policy = string(policy)
+		if !decision.Approved {
+			err = fmt.Errorf("%w: %s", ErrToolRejected, step.StepName)
+			if decision.Comment != "" {
+				err = fmt.Errorf("%w: %s", err, decision.Comment)
+			}
+		}
+	}
+	step.Approval = &decision
+	if err != nil {
+		step.Status, step.Error = mcptask.StatusFailed, err.Error()
+		tb.recordStep(ctx, *step)
+		return err
+	}
+	step.Status = mcptask.StatusRunning
+	return nil
+}
+
+func (tb *Toolbox) recordStep(ctx context.Context, step mcptask.Step) {
+	if tb.record == nil || step.ThreadID == "" {
+		return
+	}
+	if err := tb.record(ctx, step.ThreadID, step); err != nil {
+		logx.Errorf("failed to record tool step %s: %v", step.StepID, err)
+	}
+}
+
+// Close disconnects from every tool server.
+func (tb *Toolbox) Close() {
+	for _, c := range tb.clients {
+		if err := c.Close(); err != nil {
+			logx.Errorf("failed to close tool server: %v", err)
+		}
+	}
+	tb.clients = nil
+}
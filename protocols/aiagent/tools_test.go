@@ -0,0 +1,105 @@
+package aiagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/xid-protocol/xidp/internal/mcp"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+)
+
+// labServer serves a scan tool with a validated input schema, and an exec
+// and a debug_dump tool that agents are kept away from.
+func labServer(t *testing.T) string {
+	t.Helper()
+	min, max := 1.0, 65535.0
+	s := mcp.NewServer("lab", "1", "")
+	s.AddTool(mcp.Tool{
+		Name: "scan",
+		InputSchema: mcp.Object(map[string]*mcp.Schema{
+			"host": mcp.String(""),
+			"port": {Type: "integer", Minimum: &min, Maximum: &max},
+		}, "host"),
+	}, func(ctx context.Context, p *mcp.Principal, args json.RawMessage) (any, error) {
+		return map[string]any{"open": true}, nil
+	})
+	for _, name := range []string{"exec", "debug_dump"} {
+		s.AddTool(mcp.Tool{Name: name, InputSchema: mcp.Object(nil)}, func(ctx context.Context, p *mcp.Principal, args json.RawMessage) (any, error) {
+			return "ran", nil
+		})
+	}
+	srv := httptest.NewServer(s.HTTPHandler(func(*http.Request) (*mcp.Principal, bool) {
+		return &mcp.Principal{Name: "agent"}, true
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestToolboxCall(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var steps []mcptask.Step
+	record := func(_ context.Context, _ string, step mcptask.Step) error {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+		return nil
+	}
+	tb, err := OpenToolbox(ctx, Config{Tools: []ToolServer{{
+		Name:     "lab",
+		URL:      labServer(t),
+		Deny:     []string{"debug_*"},
+		Policies: []ToolPolicy{{Tool: "exec", Policy: PolicyDeny}},
+	}}}, record, nil)
+	if err != nil {
+		t.Fatalf("open toolbox: %v", err)
+	}
+	defer tb.Close()
+
+	var names []string
+	for _, tool := range tb.Tools() {
+		names = append(names, tool.Name)
+	}
+	if want := []string{"lab__scan"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("tools = %v, want %v", names, want)
+	}
+
+	tests := []struct {
+		tool    string
+		args    string
+		wantErr error
+		status  []mcptask.Status // 依次记录的步骤状态
+	}{
+		{"lab__scan", `{"port":22}`, ErrInvalidArguments, nil},
+		{"lab__scan", `{"host":"10.0.0.1","port":0}`, ErrInvalidArguments, nil},
+		{"lab__scan", `{"host":"10.0.0.1","port":"22"}`, ErrInvalidArguments, nil},
+		{"lab__scan", `{"host":"10.0.0.1","proto":"tcp"}`, ErrInvalidArguments, nil},
+		{"lab__debug_dump", `{}`, ErrUnknownTool, nil},
+		{"lab__exec", `{}`, ErrToolDenied, []mcptask.Status{mcptask.StatusFailed}},
+		{"lab__scan", `{"host":"10.0.0.1","port":22}`, nil, []mcptask.Status{mcptask.StatusRunning, mcptask.StatusCompleted}},
+	}
+	for _, tt := range tests {
+		steps = nil
+		res, err := tb.Call(ctx, "t1", tt.tool, json.RawMessage(tt.args))
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s %s: got %v, want %v", tt.tool, tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (res.IsError || res.Text() != `{"open":true}`) {
+			t.Errorf("%s %s: result %+v", tt.tool, tt.args, res)
+		}
+		var status []mcptask.Status
+		for _, s := range steps {
+			status = append(status, s.Status)
+		}
+		if !reflect.DeepEqual(status, tt.status) {
+			t.Errorf("%s %s: recorded %v, want %v", tt.tool, tt.args, status, tt.status)
+		}
+	}
+}
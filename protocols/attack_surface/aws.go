@@ -7,16 +7,28 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/colin-404/logx"
 	"github.com/spf13/viper"
 )
 
-// 获取所有可用区域
+// 创建指定区域的会话，AWS.endpoint 不为空时指向本地的 AWS API 模拟服务（moto、localstack 等）
+func newSession(region string) (*session.Session, error) {
+	config := &aws.Config{
+		Region:      aws.String(region),
+		Credentials: credentials.NewStaticCredentials(viper.GetString("AWS.AwsApiKey"), viper.GetString("AWS.AwsSecretKey"), ""),
+	}
+	if endpoint := viper.GetString("AWS.endpoint"); endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+		config.DisableSSL = aws.Bool(viper.GetBool("AWS.disable_ssl"))
+	}
+	return session.NewSession(config)
+}
+
+// 获取所有已启用的区域
 func GetAllRegions() ([]string, error) {
 	// 使用默认区域创建一个会话，用于获取区域列表
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String("us-east-1"), // 使用一个默认区域
-		Credentials: credentials.NewStaticCredentials(viper.GetString("AWS.AwsApiKey"), viper.GetString("AWS.AwsSecretKey"), ""),
-	})
+	sess, err := newSession("us-east-1")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %v", err)
 	}
@@ -32,38 +44,41 @@ func GetAllRegions() ([]string, error) {
 		return nil, fmt.Errorf("failed to describe regions: %v", err)
 	}
 
-	// 提取区域名称
+	// 提取区域名称，跳过未启用(not-opted-in)的区域
 	var regions []string
 	for _, region := range result.Regions {
-		if region.RegionName != nil {
-			regions = append(regions, *region.RegionName)
+		if region.RegionName == nil {
+			continue
 		}
+		if aws.StringValue(region.OptInStatus) == "not-opted-in" {
+			continue
+		}
+		regions = append(regions, *region.RegionName)
 	}
 
 	return regions, nil
 }
 
-// 获取所有区域的EC2客户端
-// func GetAllRegionEc2Clients() map[string]*ec2.EC2 {
-// 	// 获取所有可用区域
-// 	regions, err := GetAllRegions()
-// 	if err != nil {
-// 		log.Println(err)
-// 	}
+// 获取所有区域的EC2客户端，创建会话失败的区域及原因在 failed 中返回
+func GetAllRegionEc2Clients() (clients map[string]ec2iface.EC2API, failed map[string]string, err error) {
+	// 获取所有可用区域
+	regions, err := GetAllRegions()
+	if err != nil {
+		return nil, nil, err
+	}
 
-// 	// 创建所有区域的客户端
-// 	clients := make(map[string]*ec2.EC2)
-// 	for _, region := range regions {
-// 		sess, err := session.NewSession(&aws.Config{
-// 			Region:      aws.String(region),
-// 			Credentials: credentials.NewStaticCredentials(viper.GetString("AWS.AwsApiKey"), viper.GetString("AWS.AwsSecretKey"), ""),
-// 		})
-// 		if err != nil {
-// 			log.Printf("Failed to create session for region %s: %v", region, err)
-// 			continue
-// 		}
-// 		clients[region] = ec2.New(sess)
-// 	}
+	// 创建所有区域的客户端
+	clients = make(map[string]ec2iface.EC2API)
+	failed = make(map[string]string)
+	for _, region := range regions {
+		sess, err := newSession(region)
+		if err != nil {
+			logx.Errorf("Failed to create session for region %s: %v", region, err)
+			failed[region] = fmt.Sprintf("failed to create session: %v", err)
+			continue
+		}
+		clients[region] = ec2.New(sess)
+	}
 
-// 	return clients
-// }
+	return clients, failed, nil
+}
@@ -0,0 +1,415 @@
+package attack_surface
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/colin-404/logx"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/notify"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/protocols/whitelist"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AWSCollector walks every region it has a client for and stores the
+// instances, security groups and derived attack surface as XID cards.
+type AWSCollector struct {
+	repo    xdb.XIDRepo
+	clients map[string]ec2iface.EC2API
+	risk    *RiskConfig
+}
+
+type CollectResult struct {
+	Regions        []string            `json:"regions"`
+	Instances      []*AWSInstance      `json:"instances"`
+	SecurityGroups []*AWSSecurityGroup `json:"securityGroups"`
+	AttackSurfaces []*AWSAttackSurface `json:"attackSurfaces"`
+	Exposures      int                 `json:"exposures"`
+	Errors         map[string]string   `json:"errors,omitempty"` // region -> error
+	Drift          *Drift              `json:"drift,omitempty"`
+}
+
+func NewAWSCollector(repo xdb.XIDRepo, clients map[string]ec2iface.EC2API) *AWSCollector {
+	return &AWSCollector{repo: repo, clients: clients, risk: LoadRiskConfig()}
+}
+
+// ScanAWS collects every enabled region with the configured credentials
+// and records the scan as a snapshot diffed against the previous one.
+func ScanAWS(ctx context.Context, repo xdb.XIDRepo) (*CollectResult, error) {
+	clients, failed, err := GetAllRegionEc2Clients()
+	if err != nil {
+		return nil, err
+	}
+	result, err := NewAWSCollector(repo, clients).Collect(ctx)
+	// 没有客户端的区域同样记为失败，漂移计算时沿用上次的实例
+	for region, msg := range failed {
+		result.Errors[region] = msg
+	}
+	if err == nil && len(result.Regions) == 0 && len(result.Errors) > 0 {
+		err = fmt.Errorf("all %d regions failed", len(result.Errors))
+	}
+	if err != nil {
+		return result, err
+	}
+	drift, err := RecordScan(ctx, repo, result)
+	if err != nil {
+		return result, err
+	}
+	result.Drift = drift
+	return result, nil
+}
+
+func (c *AWSCollector) Collect(ctx context.Context) (*CollectResult, error) {
+	result := &CollectResult{Errors: map[string]string{}}
+
+	regions := make([]string, 0, len(c.clients))
+	for region := range c.clients {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	for _, region := range regions {
+		instances, groups, err := c.collectRegion(ctx, region, c.clients[region])
+		if err != nil {
+			// 单个区域失败不影响其它区域
+			logx.Errorf("collect region %s: %v", region, err)
+			result.Errors[region] = err.Error()
+			continue
+		}
+		result.Regions = append(result.Regions, region)
+		result.Instances = append(result.Instances, instances...)
+		result.SecurityGroups = append(result.SecurityGroups, groups...)
+	}
+
+	groupIndex := make(map[string]*AWSSecurityGroup, len(result.SecurityGroups))
+	for _, sg := range result.SecurityGroups {
+		groupIndex[sg.GroupID] = sg
+	}
+	analysis := AnalyzeExposure(result.Instances, result.SecurityGroups)
+	exposures := analysis.ByInstance()
+	references := map[string][]GroupReference{}
+	for _, ref := range analysis.References {
+		references[ref.InstanceID] = append(references[ref.InstanceID], ref)
+	}
+	prefixLists := map[string][]PrefixListReference{}
+	for _, ref := range analysis.PrefixLists {
+		prefixLists[ref.InstanceID] = append(prefixLists[ref.InstanceID], ref)
+	}
+	matcher, err := whitelist.LoadOpenPortMatcher(ctx, c.repo)
+	if err != nil {
+		return result, fmt.Errorf("failed to load whitelist: %v", err)
+	}
+	now := common.GetTimestamp()
+	var alerts []Exposure
+	for _, instance := range result.Instances {
+		eas := NewAWSAttackSurface(instance, groupIndex)
+		if e, ok := exposures[instance.InstanceID]; ok {
+			eas.Exposures = e
+		}
+		if r, ok := references[instance.InstanceID]; ok {
+			eas.References = r
+		}
+		if p, ok := prefixLists[instance.InstanceID]; ok {
+			eas.PrefixLists = p
+		}
+		if err := c.assess(ctx, eas, matcher, now); err != nil {
+			return result, err
+		}
+		alerts = append(alerts, c.alerts(eas, now)...)
+		result.AttackSurfaces = append(result.AttackSurfaces, eas)
+	}
+	result.Exposures = len(analysis.Exposures)
+
+	if err := c.store(ctx, result); err != nil {
+		return result, err
+	}
+	if len(alerts) > 0 {
+		notify.Send(ctx, fmt.Sprintf("[xidp] %d exposures above risk threshold %.0f", len(alerts), c.risk.AlertThreshold), formatExposures(alerts))
+	}
+	if len(result.Regions) == 0 && len(result.Errors) > 0 {
+		return result, fmt.Errorf("all %d regions failed", len(result.Errors))
+	}
+	return result, nil
+}
+
+// assess carries FirstSeen/AlertedAt over from the previously stored card,
+// checks the exposures of eas against matcher and scores them.
+func (c *AWSCollector) assess(ctx context.Context, eas *AWSAttackSurface, matcher *whitelist.Matcher, now int64) error {
+	previous := map[string]Exposure{}
+	card, err := c.repo.FindByXid(ctx, protocols.GenerateXid(eas.InstanceID), PathAWSAttackSurface)
+	if err == nil {
+		var prev AWSAttackSurface
+		if err := xdb.DecodePayload(card, &prev); err == nil {
+			for _, e := range prev.Exposures {
+				previous[e.Key()] = e
+			}
+		}
+	} else if err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to load attack surface %s: %v", eas.InstanceID, err)
+	}
+
+	for i := range eas.Exposures {
+		e := &eas.Exposures[i]
+		e.FirstSeen = now
+		if p, ok := previous[e.Key()]; ok && p.FirstSeen > 0 {
+			e.FirstSeen = p.FirstSeen
+			e.AlertedAt = p.AlertedAt
+		}
+		e.Whitelisted, e.WhitelistIDs = whitelistedBy(matcher, *e)
+		e.Risk = c.risk.Score(*e, eas.Tags, now)
+		if e.Risk.Score > eas.RiskScore {
+			eas.RiskScore = e.Risk.Score
+			eas.RiskLevel = e.Risk.Level
+		}
+	}
+	return nil
+}
+
+// alerts marks and returns the exposures that crossed the alert threshold
+// since the last scan. Falling below the threshold re-arms the alert.
+func (c *AWSCollector) alerts(eas *AWSAttackSurface, now int64) []Exposure {
+	var out []Exposure
+	for i := range eas.Exposures {
+		e := &eas.Exposures[i]
+		if e.Whitelisted || c.risk.AlertThreshold <= 0 || e.Risk.Score < c.risk.AlertThreshold {
+			e.AlertedAt = 0
+			continue
+		}
+		if e.AlertedAt == 0 {
+			e.AlertedAt = now
+			out = append(out, *e)
+		}
+	}
+	return out
+}
+
+func (c *AWSCollector) store(ctx context.Context, result *CollectResult) error {
+	for _, instance := range result.Instances {
+		if err := saveCard(ctx, c.repo, instance.InstanceID, "aws_instance_id", PathAWSInstance, instance); err != nil {
+			return fmt.Errorf("failed to store instance %s: %v", instance.InstanceID, err)
+		}
+	}
+	for _, sg := range result.SecurityGroups {
+		if err := saveCard(ctx, c.repo, sg.GroupID, "aws_secgroup_id", PathAWSSecGroup, sg); err != nil {
+			return fmt.Errorf("failed to store security group %s: %v", sg.GroupID, err)
+		}
+	}
+	for _, eas := range result.AttackSurfaces {
+		if err := saveCard(ctx, c.repo, eas.InstanceID, "aws_instance_id", PathAWSAttackSurface, eas); err != nil {
+			return fmt.Errorf("failed to store attack surface %s: %v", eas.InstanceID, err)
+		}
+	}
+	return nil
+}
+
+// saveCard upserts the card for id under path, one card per (xid, path).
+func saveCard(ctx context.Context, repo xdb.XIDRepo, id, infoType, path string, payload any) error {
+	info := protocols.NewInfo(id, infoType)
+	metadata := protocols.NewMetadata(protocols.OperationUpdate, path, "application/json")
+	XID := protocols.NewXID[any](&info, &metadata, payload)
+	return repo.Upsert(ctx, XID.Xid, path, XID)
+}
+
+func (c *AWSCollector) collectRegion(ctx context.Context, region string, client ec2iface.EC2API) ([]*AWSInstance, []*AWSSecurityGroup, error) {
+	var instances []*AWSInstance
+	byID := map[string]*AWSInstance{}
+
+	err := client.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{}, func(out *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range out.Reservations {
+			for _, inst := range reservation.Instances {
+				instance := newAWSInstance(region, aws.StringValue(reservation.OwnerId), inst)
+				instances = append(instances, instance)
+				byID[instance.InstanceID] = instance
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to describe instances: %v", err)
+	}
+
+	// 网卡上的公网IP、IPv6和安全组
+	err = client.DescribeNetworkInterfacesPagesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{}, func(out *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, eni := range out.NetworkInterfaces {
+			if eni.Attachment == nil {
+				continue
+			}
+			instance, ok := byID[aws.StringValue(eni.Attachment.InstanceId)]
+			if !ok {
+				continue
+			}
+			instance.mergeNetworkInterface(newAWSNetworkInterface(eni))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to describe network interfaces: %v", err)
+	}
+
+	// 弹性IP
+	addresses, err := client.DescribeAddressesWithContext(ctx, &ec2.DescribeAddressesInput{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to describe addresses: %v", err)
+	}
+	for _, addr := range addresses.Addresses {
+		if instance, ok := byID[aws.StringValue(addr.InstanceId)]; ok {
+			instance.PublicIPs = appendUnique(instance.PublicIPs, aws.StringValue(addr.PublicIp))
+		}
+	}
+
+	groups, err := describeSecurityGroups(ctx, region, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return instances, groups, nil
+}
+
+func describeSecurityGroups(ctx context.Context, region string, client ec2iface.EC2API) ([]*AWSSecurityGroup, error) {
+	var groups []*AWSSecurityGroup
+	byID := map[string]*AWSSecurityGroup{}
+
+	err := client.DescribeSecurityGroupsPagesWithContext(ctx, &ec2.DescribeSecurityGroupsInput{}, func(out *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+		for _, g := range out.SecurityGroups {
+			sg := &AWSSecurityGroup{
+				GroupID:     aws.StringValue(g.GroupId),
+				GroupName:   aws.StringValue(g.GroupName),
+				Description: aws.StringValue(g.Description),
+				VpcID:       aws.StringValue(g.VpcId),
+				AccountID:   aws.StringValue(g.OwnerId),
+				Region:      region,
+				Tags:        tagsToMap(g.Tags),
+				Rules:       []SecurityGroupRule{},
+			}
+			groups = append(groups, sg)
+			byID[sg.GroupID] = sg
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe security groups: %v", err)
+	}
+
+	// 规则单独查询，才能拿到 SecurityGroupRuleId
+	err = client.DescribeSecurityGroupRulesPagesWithContext(ctx, &ec2.DescribeSecurityGroupRulesInput{}, func(out *ec2.DescribeSecurityGroupRulesOutput, lastPage bool) bool {
+		for _, r := range out.SecurityGroupRules {
+			sg, ok := byID[aws.StringValue(r.GroupId)]
+			if !ok {
+				continue
+			}
+			rule := SecurityGroupRule{
+				RuleID:       aws.StringValue(r.SecurityGroupRuleId),
+				GroupID:      sg.GroupID,
+				IsEgress:     aws.BoolValue(r.IsEgress),
+				Protocol:     aws.StringValue(r.IpProtocol),
+				FromPort:     int(aws.Int64Value(r.FromPort)),
+				ToPort:       int(aws.Int64Value(r.ToPort)),
+				CidrIPv4:     aws.StringValue(r.CidrIpv4),
+				CidrIPv6:     aws.StringValue(r.CidrIpv6),
+				PrefixListID: aws.StringValue(r.PrefixListId),
+				Description:  aws.StringValue(r.Description),
+			}
+			if r.ReferencedGroupInfo != nil {
+				rule.ReferencedGroupID = aws.StringValue(r.ReferencedGroupInfo.GroupId)
+			}
+			sg.Rules = append(sg.Rules, rule)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe security group rules: %v", err)
+	}
+
+	return groups, nil
+}
+
+func newAWSInstance(region, accountID string, inst *ec2.Instance) *AWSInstance {
+	instance := &AWSInstance{
+		InstanceID:        aws.StringValue(inst.InstanceId),
+		AccountID:         accountID,
+		Region:            region,
+		VpcID:             aws.StringValue(inst.VpcId),
+		Tags:              tagsToMap(inst.Tags),
+		PublicIPs:         []string{},
+		PrivateIPs:        []string{},
+		SecurityGroupIDs:  []string{},
+		NetworkInterfaces: []AWSNetworkInterface{},
+	}
+	instance.InstanceName = instance.Tags["Name"]
+	if inst.State != nil {
+		instance.State = aws.StringValue(inst.State.Name)
+	}
+	instance.PublicIPs = appendUnique(instance.PublicIPs, aws.StringValue(inst.PublicIpAddress))
+	instance.PrivateIPs = appendUnique(instance.PrivateIPs, aws.StringValue(inst.PrivateIpAddress))
+	instance.IPv6Addresses = appendUnique(instance.IPv6Addresses, aws.StringValue(inst.Ipv6Address))
+	for _, g := range inst.SecurityGroups {
+		instance.SecurityGroupIDs = appendUnique(instance.SecurityGroupIDs, aws.StringValue(g.GroupId))
+	}
+	return instance
+}
+
+func newAWSNetworkInterface(eni *ec2.NetworkInterface) AWSNetworkInterface {
+	ni := AWSNetworkInterface{
+		NetworkInterfaceID: aws.StringValue(eni.NetworkInterfaceId),
+		SubnetID:           aws.StringValue(eni.SubnetId),
+		VpcID:              aws.StringValue(eni.VpcId),
+	}
+	if eni.Association != nil {
+		ni.PublicIPs = appendUnique(ni.PublicIPs, aws.StringValue(eni.Association.PublicIp))
+	}
+	for _, addr := range eni.PrivateIpAddresses {
+		ni.PrivateIPs = appendUnique(ni.PrivateIPs, aws.StringValue(addr.PrivateIpAddress))
+		if addr.Association != nil {
+			ni.PublicIPs = appendUnique(ni.PublicIPs, aws.StringValue(addr.Association.PublicIp))
+		}
+	}
+	for _, addr := range eni.Ipv6Addresses {
+		ni.IPv6Addresses = appendUnique(ni.IPv6Addresses, aws.StringValue(addr.Ipv6Address))
+	}
+	for _, g := range eni.Groups {
+		ni.SecurityGroupIDs = appendUnique(ni.SecurityGroupIDs, aws.StringValue(g.GroupId))
+	}
+	return ni
+}
+
+func (instance *AWSInstance) mergeNetworkInterface(ni AWSNetworkInterface) {
+	instance.NetworkInterfaces = append(instance.NetworkInterfaces, ni)
+	for _, ip := range ni.PublicIPs {
+		instance.PublicIPs = appendUnique(instance.PublicIPs, ip)
+	}
+	for _, ip := range ni.PrivateIPs {
+		instance.PrivateIPs = appendUnique(instance.PrivateIPs, ip)
+	}
+	for _, ip := range ni.IPv6Addresses {
+		instance.IPv6Addresses = appendUnique(instance.IPv6Addresses, ip)
+	}
+	for _, id := range ni.SecurityGroupIDs {
+		instance.SecurityGroupIDs = appendUnique(instance.SecurityGroupIDs, id)
+	}
+}
+
+func tagsToMap(tags []*ec2.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, t := range tags {
+		m[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return m
+}
+
+func appendUnique(list []string, v string) []string {
+	if v == "" {
+		return list
+	}
+	for _, s := range list {
+		if s == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
@@ -0,0 +1,164 @@
+package attack_surface
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/protocols/whitelist"
+	"github.com/xid-protocol/xidp/xdb"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+// fakeEC2 serves one region: an instance with a public IP whose security
+// group opens port to the internet. err fails the first call.
+type fakeEC2 struct {
+	ec2iface.EC2API
+	instanceID string
+	publicIP   string
+	port       int64
+	err        error
+}
+
+func (f *fakeEC2) DescribeInstancesPagesWithContext(_ aws.Context, _ *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, _ ...request.Option) error {
+	if f.err != nil {
+		return f.err
+	}
+	fn(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+		OwnerId: aws.String("123456789012"),
+		Instances: []*ec2.Instance{{
+			InstanceId:      aws.String(f.instanceID),
+			PublicIpAddress: aws.String(f.publicIP),
+			State:           &ec2.InstanceState{Name: aws.String("running")},
+			SecurityGroups:  []*ec2.GroupIdentifier{{GroupId: aws.String("sg-" + f.instanceID)}},
+			Tags:            []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(f.instanceID)}},
+		}},
+	}}}, true)
+	return nil
+}
+
+func (f *fakeEC2) DescribeNetworkInterfacesPagesWithContext(_ aws.Context, _ *ec2.DescribeNetworkInterfacesInput, fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool, _ ...request.Option) error {
+	fn(&ec2.DescribeNetworkInterfacesOutput{}, true)
+	return nil
+}
+
+func (f *fakeEC2) DescribeAddressesWithContext(aws.Context, *ec2.DescribeAddressesInput, ...request.Option) (*ec2.DescribeAddressesOutput, error) {
+	return &ec2.DescribeAddressesOutput{}, nil
+}
+
+func (f *fakeEC2) DescribeSecurityGroupsPagesWithContext(_ aws.Context, _ *ec2.DescribeSecurityGroupsInput, fn func(*ec2.DescribeSecurityGroupsOutput, bool) bool, _ ...request.Option) error {
+	fn(&ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{{
+		GroupId:   aws.String("sg-" + f.instanceID),
+		GroupName: aws.String("web"),
+		OwnerId:   aws.String("123456789012"),
+	}}}, true)
+	return nil
+}
+
+func (f *fakeEC2) DescribeSecurityGroupRulesPagesWithContext(_ aws.Context, _ *ec2.DescribeSecurityGroupRulesInput, fn func(*ec2.DescribeSecurityGroupRulesOutput, bool) bool, _ ...request.Option) error {
+	fn(&ec2.DescribeSecurityGroupRulesOutput{SecurityGroupRules: []*ec2.SecurityGroupRule{{
+		SecurityGroupRuleId: aws.String("sgr-" + f.instanceID),
+		GroupId:             aws.String("sg-" + f.instanceID),
+		IsEgress:            aws.Bool(false),
+		IpProtocol:          aws.String("tcp"),
+		FromPort:            aws.Int64(f.port),
+		ToPort:              aws.Int64(f.port),
+		CidrIpv4:            aws.String("0.0.0.0/0"),
+	}}}, true)
+	return nil
+}
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	approveOpenPort(t, repo, whitelist.AWSOpenPort{InstanceID: "i-eu", Cidr: "0.0.0.0/0", Protocol: "tcp", FromPort: 443, ToPort: 443})
+
+	c := NewAWSCollector(repo, map[string]ec2iface.EC2API{
+		"us-east-1":  &fakeEC2{instanceID: "i-us", publicIP: "198.51.100.1", port: 22},
+		"eu-west-1":  &fakeEC2{instanceID: "i-eu", publicIP: "198.51.100.2", port: 443},
+		"ap-south-1": &fakeEC2{err: errors.New("UnauthorizedOperation")},
+	})
+	result, err := c.Collect(ctx)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+
+	if want := []string{"eu-west-1", "us-east-1"}; !reflect.DeepEqual(result.Regions, want) {
+		t.Errorf("regions = %v, want %v", result.Regions, want)
+	}
+	if len(result.Errors) != 1 || result.Errors["ap-south-1"] == "" {
+		t.Errorf("errors = %v, want only ap-south-1", result.Errors)
+	}
+	if len(result.Instances) != 2 || len(result.SecurityGroups) != 2 || result.Exposures != 2 {
+		t.Fatalf("got %d instances, %d security groups and %d exposures, want 2 of each",
+			len(result.Instances), len(result.SecurityGroups), result.Exposures)
+	}
+
+	want := map[string]struct {
+		region      string
+		whitelisted bool
+	}{
+		"i-us": {"us-east-1", false},
+		"i-eu": {"eu-west-1", true},
+	}
+	for id, w := range want {
+		doc, err := repo.FindByXid(ctx, protocols.GenerateXid(id), PathAWSAttackSurface)
+		if err != nil {
+			t.Fatalf("attack surface of %s: %v", id, err)
+		}
+		var eas AWSAttackSurface
+		if err := xdb.DecodePayload(doc, &eas); err != nil {
+			t.Fatalf("decode attack surface of %s: %v", id, err)
+		}
+		if eas.Region != w.region {
+			t.Errorf("%s region = %s, want %s", id, eas.Region, w.region)
+		}
+		if len(eas.Exposures) != 1 || eas.Exposures[0].Whitelisted != w.whitelisted {
+			t.Errorf("%s exposures = %+v, want one with whitelisted %v", id, eas.Exposures, w.whitelisted)
+		}
+	}
+}
+
+func TestCollectAllRegionsFailed(t *testing.T) {
+	c := NewAWSCollector(xdbtest.New(), map[string]ec2iface.EC2API{
+		"us-east-1": &fakeEC2{err: errors.New("RequestExpired")},
+		"eu-west-1": &fakeEC2{err: errors.New("RequestExpired")},
+	})
+	result, err := c.Collect(context.Background())
+	if err == nil {
+		t.Fatal("collect succeeded with every region failing")
+	}
+	if len(result.Errors) != 2 || len(result.Regions) != 0 {
+		t.Errorf("regions = %v, errors = %v, want two errors", result.Regions, result.Errors)
+	}
+}
+
+func approveOpenPort(t *testing.T, repo xdb.XIDRepo, value whitelist.AWSOpenPort) {
+	t.Helper()
+	ctx := context.Background()
+	raw, _ := json.Marshal(value)
+	wl, err := whitelist.Create(ctx, repo, whitelist.CreateRequest{
+		Type:          whitelist.TypeAWSOpenPort,
+		Value:         raw,
+		Owner:         "web-team",
+		Justification: "public website",
+		ExpiresAt:     common.GetTimestamp() + time.Hour.Milliseconds(),
+		CreatedBy:     "alice",
+		Approver:      "bob",
+	})
+	if err != nil {
+		t.Fatalf("create whitelist: %v", err)
+	}
+	if _, err := whitelist.Approve(ctx, repo, wl.ID, "bob", ""); err != nil {
+		t.Fatalf("approve whitelist: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package attack_surface
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/colin-404/logx"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/notify"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/protocols/whitelist"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	PathAttackSurfaceSnapshot = "/protocols/external-attack-surface/snapshot"
+	PathAttackSurfaceDrift    = "/protocols/external-attack-surface/drift"
+)
+
+type SnapshotInstance struct {
+	InstanceID string `json:"instanceId" bson:"instanceId"`
+	Region     string `json:"region" bson:"region"`
+}
+
+type SnapshotIP struct {
+	InstanceID string `json:"instanceId" bson:"instanceId"`
+	IP         string `json:"ip" bson:"ip"`
+}
+
+// Snapshot is the attack surface as seen by one scan.
+// path /protocols/external-attack-surface/snapshot
+type Snapshot struct {
+	ScanID    string             `json:"scanId" bson:"scanId"`
+	Provider  string             `json:"provider" bson:"provider"`
+	Regions   []string           `json:"regions" bson:"regions"`
+	Instances []SnapshotInstance `json:"instances" bson:"instances"`
+	PublicIPs []SnapshotIP       `json:"publicIps" bson:"publicIps"`
+	Exposures []Exposure         `json:"exposures" bson:"exposures"`
+	CreatedAt int64              `json:"createdAt" bson:"createdAt"`
+}
+
+// Drift is the difference between a scan and the one before it.
+// path /protocols/external-attack-surface/drift
+type Drift struct {
+	ScanID               string             `json:"scanId" bson:"scanId"`
+	PreviousScanID       string             `json:"previousScanId" bson:"previousScanId"`
+	NewExposures         []Exposure         `json:"newExposures" bson:"newExposures"`
+	ClosedExposures      []Exposure         `json:"closedExposures" bson:"closedExposures"`
+	NewPublicIPs         []SnapshotIP       `json:"newPublicIps" bson:"newPublicIps"`
+	RemovedPublicIPs     []SnapshotIP       `json:"removedPublicIps" bson:"removedPublicIps"`
+	DisappearedInstances []SnapshotInstance `json:"disappearedInstances" bson:"disappearedInstances"`
+	// 新增且未被白名单覆盖的暴露面，已发送通知
+	UnwhitelistedExposures []Exposure `json:"unwhitelistedExposures" bson:"unwhitelistedExposures"`
+	CreatedAt              int64      `json:"createdAt" bson:"createdAt"`
+}
+
+// Empty reports whether nothing changed between the two scans.
+func (d *Drift) Empty() bool {
+	return len(d.NewExposures) == 0 && len(d.ClosedExposures) == 0 &&
+		len(d.NewPublicIPs) == 0 && len(d.RemovedPublicIPs) == 0 &&
+		len(d.DisappearedInstances) == 0
+}
+
+// NewSnapshot builds the snapshot of a collect result. Instances of regions
+// that were not collected this time, because they failed or had no client,
+// are carried over from prev, so a flaky region does not show up as every
+// instance disappearing and coming back.
+func NewSnapshot(result *CollectResult, prev *Snapshot) *Snapshot {
+	snap := &Snapshot{
+		ScanID:    common.GenerateID(),
+		Provider:  ProviderAWS,
+		Regions:   result.Regions,
+		Instances: []SnapshotInstance{},
+		PublicIPs: []SnapshotIP{},
+		Exposures: []Exposure{},
+		CreatedAt: common.GetTimestamp(),
+	}
+	for _, eas := range result.AttackSurfaces {
+		snap.Instances = append(snap.Instances, SnapshotInstance{InstanceID: eas.InstanceID, Region: eas.Region})
+		for _, ip := range eas.PublicIPs {
+			snap.PublicIPs = append(snap.PublicIPs, SnapshotIP{InstanceID: eas.InstanceID, IP: ip})
+		}
+		snap.Exposures = append(snap.Exposures, eas.Exposures...)
+	}
+
+	if prev != nil {
+		collected := map[string]bool{}
+		for _, region := range result.Regions {
+			collected[region] = true
+		}
+		carried := map[string]bool{}
+		for _, inst := range prev.Instances {
+			if !collected[inst.Region] {
+				snap.Instances = append(snap.Instances, inst)
+				carried[inst.InstanceID] = true
+			}
+		}
+		for _, ip := range prev.PublicIPs {
+			if carried[ip.InstanceID] {
+				snap.PublicIPs = append(snap.PublicIPs, ip)
+			}
+		}
+		for _, e := range prev.Exposures {
+			if carried[e.InstanceID] {
+				snap.Exposures = append(snap.Exposures, e)
+			}
+		}
+	}
+	return snap
+}
+
+// DiffSnapshots computes what changed from prev to cur. prev may be nil for
+// the very first scan, in which case everything is new.
+func DiffSnapshots(prev, cur *Snapshot) *Drift {
+	drift := &Drift{
+		ScanID:                 cur.ScanID,
+		NewExposures:           []Exposure{},
+		ClosedExposures:        []Exposure{},
+		NewPublicIPs:           []SnapshotIP{},
+		RemovedPublicIPs:       []SnapshotIP{},
+		DisappearedInstances:   []SnapshotInstance{},
+		UnwhitelistedExposures: []Exposure{},
+		CreatedAt:              common.GetTimestamp(),
+	}
+	if prev == nil {
+		prev = &Snapshot{}
+	} else {
+		drift.PreviousScanID = prev.ScanID
+	}
+
+	prevExposures := map[string]bool{}
+	for _, e := range prev.Exposures {
+		prevExposures[e.Key()] = true
+	}
+	curExposures := map[string]bool{}
+	for _, e := range cur.Exposures {
+		curExposures[e.Key()] = true
+		if !prevExposures[e.Key()] {
+			drift.NewExposures = append(drift.NewExposures, e)
+		}
+	}
+	for _, e := range prev.Exposures {
+		if !curExposures[e.Key()] {
+			drift.ClosedExposures = append(drift.ClosedExposures, e)
+		}
+	}
+
+	prevIPs := map[SnapshotIP]bool{}
+	for _, ip := range prev.PublicIPs {
+		prevIPs[ip] = true
+	}
+	curIPs := map[SnapshotIP]bool{}
+	for _, ip := range cur.PublicIPs {
+		curIPs[ip] = true
+		if !prevIPs[ip] {
+			drift.NewPublicIPs = append(drift.NewPublicIPs, ip)
+		}
+	}
+	for _, ip := range prev.PublicIPs {
+		if !curIPs[ip] {
+			drift.RemovedPublicIPs = append(drift.RemovedPublicIPs, ip)
+		}
+	}
+
+	curInstances := map[string]bool{}
+	for _, inst := range cur.Instances {
+		curInstances[inst.InstanceID] = true
+	}
+	for _, inst := range prev.Instances {
+		if !curInstances[inst.InstanceID] {
+			drift.DisappearedInstances = append(drift.DisappearedInstances, inst)
+		}
+	}
+
+	sort.Slice(drift.NewExposures, func(i, j int) bool { return exposureLess(drift.NewExposures[i], drift.NewExposures[j]) })
+	sort.Slice(drift.ClosedExposures, func(i, j int) bool { return exposureLess(drift.ClosedExposures[i], drift.ClosedExposures[j]) })
+	return drift
+}
+
+// LatestSnapshot returns the most recent snapshot, or nil if there is none.
+func LatestSnapshot(ctx context.Context, repo xdb.XIDRepo) (*Snapshot, error) {
+	docs, _, err := repo.List(ctx, xdb.Query{
+		Path:     PathAttackSurfaceSnapshot,
+		SortBy:   "createdAt",
+		PageSize: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	var snap Snapshot
+	if err := xdb.DecodePayload(docs[0], &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// RecordScan stores the snapshot of result, diffs it against the previous
+// one and notifies about new exposures that no whitelist entry covers.
+func RecordScan(ctx context.Context, repo xdb.XIDRepo, result *CollectResult) (*Drift, error) {
+	prev, err := LatestSnapshot(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load previous snapshot: %v", err)
+	}
+
+	snap := NewSnapshot(result, prev)
+	drift := DiffSnapshots(prev, snap)
+
+	matcher, err := whitelist.LoadOpenPortMatcher(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load whitelist: %v", err)
+	}
+	for _, e := range drift.NewExposures {
+		if covered, _ := whitelistedBy(matcher, e); !covered {
+			drift.UnwhitelistedExposures = append(drift.UnwhitelistedExposures, e)
+		}
+	}
+
+	if err := saveCard(ctx, repo, snap.ScanID, "attack_surface_scan_id", PathAttackSurfaceSnapshot, snap); err != nil {
+		return nil, fmt.Errorf("failed to store snapshot: %v", err)
+	}
+	if err := saveCard(ctx, repo, snap.ScanID, "attack_surface_scan_id", PathAttackSurfaceDrift, drift); err != nil {
+		return nil, fmt.Errorf("failed to store drift: %v", err)
+	}
+
+	// 实例已不存在，下线它的攻击面卡片
+	for _, inst := range drift.DisappearedInstances {
+		xid := protocols.GenerateXid(inst.InstanceID)
+		if err := repo.DeleteSoft(ctx, xid, PathAWSAttackSurface, common.GetTimestamp()); err != nil {
+			logx.Errorf("failed to retire attack surface of %s: %v", inst.InstanceID, err)
+		}
+	}
+
+	// 首次扫描没有基线，不通知
+	if prev != nil && len(drift.UnwhitelistedExposures) > 0 {
+		notify.Send(ctx, "[xidp] new attack surface exposure", formatExposures(drift.UnwhitelistedExposures))
+	}
+
+	return drift, nil
+}
+
+// GetDrift returns the drift recorded by scanID, or the latest one when
+// scanID is empty.
+func GetDrift(ctx context.Context, repo xdb.XIDRepo, scanID string) (*Drift, error) {
+	var doc *protocols.XID[any]
+	if scanID != "" {
+		found, err := repo.FindByXid(ctx, protocols.GenerateXid(scanID), PathAttackSurfaceDrift)
+		if err != nil {
+			return nil, err
+		}
+		doc = found
+	} else {
+		docs, _, err := repo.List(ctx, xdb.Query{Path: PathAttackSurfaceDrift, SortBy: "createdAt", PageSize: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(docs) == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+		doc = docs[0]
+	}
+	var drift Drift
+	if err := xdb.DecodePayload(doc, &drift); err != nil {
+		return nil, err
+	}
+	return &drift, nil
+}
+
+func formatExposures(exposures []Exposure) string {
+	var b strings.Builder
+	for _, e := range exposures {
+		fmt.Fprintf(&b, "%s %s %s/%d-%d from %s (rules: %s)\n",
+			e.InstanceID, e.IP, e.Protocol, e.FromPort, e.ToPort,
+			strings.Join(e.Cidrs, ","), strings.Join(e.RuleIDs, ","))
+	}
+	return b.String()
+}
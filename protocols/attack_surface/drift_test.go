@@ -0,0 +1,74 @@
+package attack_surface
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+// TestRecordScanUncollectedRegion scans a region, then scans again without
+// it, once failing and once without a client at all. Its instances must not
+// disappear either way.
+func TestRecordScanUncollectedRegion(t *testing.T) {
+	surface := func(id, region, ip string) *AWSAttackSurface {
+		return &AWSAttackSurface{InstanceID: id, Region: region, PublicIPs: []string{ip}, Exposures: []Exposure{
+			{InstanceID: id, IP: ip, Protocol: ProtocolTCP, FromPort: 22, ToPort: 22, Cidrs: []string{"0.0.0.0/0"}},
+		}}
+	}
+	us1 := surface("i-us1", "us-east-1", "198.51.100.1")
+	us2 := surface("i-us2", "us-east-1", "198.51.100.2")
+	ap := surface("i-ap", "ap-south-1", "198.51.100.3")
+
+	tests := []struct {
+		name   string
+		errors map[string]string
+	}{
+		{"region failed", map[string]string{"ap-south-1": "UnauthorizedOperation"}},
+		{"region without a client", map[string]string{}},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		repo := xdbtest.New()
+		if _, err := RecordScan(ctx, repo, &CollectResult{
+			Regions:        []string{"ap-south-1", "us-east-1"},
+			AttackSurfaces: []*AWSAttackSurface{us1, us2, ap},
+			Errors:         map[string]string{},
+		}); err != nil {
+			t.Fatalf("%s: first scan: %v", tt.name, err)
+		}
+
+		// 第二次扫描 i-us2 已下线，ap-south-1 没有结果
+		drift, err := RecordScan(ctx, repo, &CollectResult{
+			Regions:        []string{"us-east-1"},
+			AttackSurfaces: []*AWSAttackSurface{us1},
+			Errors:         tt.errors,
+		})
+		if err != nil {
+			t.Fatalf("%s: second scan: %v", tt.name, err)
+		}
+		if want := []SnapshotInstance{{InstanceID: "i-us2", Region: "us-east-1"}}; !reflect.DeepEqual(drift.DisappearedInstances, want) {
+			t.Errorf("%s: disappeared = %v, want %v", tt.name, drift.DisappearedInstances, want)
+		}
+		if len(drift.ClosedExposures) != 1 || drift.ClosedExposures[0].InstanceID != "i-us2" {
+			t.Errorf("%s: closed exposures = %v, want only i-us2", tt.name, drift.ClosedExposures)
+		}
+		if len(drift.RemovedPublicIPs) != 1 || drift.RemovedPublicIPs[0].InstanceID != "i-us2" {
+			t.Errorf("%s: removed IPs = %v, want only i-us2", tt.name, drift.RemovedPublicIPs)
+		}
+
+		// 区域恢复后实例不算新出现
+		drift, err = RecordScan(ctx, repo, &CollectResult{
+			Regions:        []string{"ap-south-1", "us-east-1"},
+			AttackSurfaces: []*AWSAttackSurface{us1, ap},
+			Errors:         map[string]string{},
+		})
+		if err != nil {
+			t.Fatalf("%s: third scan: %v", tt.name, err)
+		}
+		if !drift.Empty() {
+			t.Errorf("%s: drift after the region came back = %+v, want none", tt.name, drift)
+		}
+	}
+}
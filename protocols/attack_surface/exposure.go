@@ -0,0 +1,301 @@
+package attack_surface
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+const (
+	ExposureIprange   = "0.0.0.0/0"
+	ExposureIpv6range = "::/0"
+)
+
+const (
+	ProtocolTCP    = "tcp"
+	ProtocolUDP    = "udp"
+	ProtocolICMP   = "icmp"
+	ProtocolICMPv6 = "icmpv6"
+)
+
+// Exposure is a single (ip, port range, protocol) reachable from the internet,
+// together with the source CIDRs and the rules that open it.
+type Exposure struct {
+	InstanceID string   `json:"instanceId" bson:"instanceId"`
+	IP         string   `json:"ip" bson:"ip"`
+	Protocol   string   `json:"protocol" bson:"protocol"`
+	FromPort   int      `json:"fromPort" bson:"fromPort"`
+	ToPort     int      `json:"toPort" bson:"toPort"`
+	Cidrs      []string `json:"cidrs" bson:"cidrs"`
+	RuleIDs    []string `json:"ruleIds" bson:"ruleIds"`
+	// 以下字段在采集后评估，不参与比较
+	FirstSeen   int64 `json:"firstSeen,omitempty" bson:"firstSeen,omitempty"`
+	Whitelisted bool  `json:"whitelisted" bson:"whitelisted"`
+	// 放行该暴露面的白名单条目
+	WhitelistIDs []string `json:"whitelistIds,omitempty" bson:"whitelistIds,omitempty"`
+	Risk         *Risk    `json:"risk,omitempty" bson:"risk,omitempty"`
+	AlertedAt    int64    `json:"alertedAt,omitempty" bson:"alertedAt,omitempty"`
+}
+
+// Key identifies the exposure independently of which rules open it.
+func (e Exposure) Key() string {
+	return fmt.Sprintf("%s|%s|%s|%d-%d", e.InstanceID, e.IP, e.Protocol, e.FromPort, e.ToPort)
+}
+
+// Covers reports whether port falls into the exposed range.
+func (e Exposure) Covers(port int) bool {
+	return e.FromPort <= port && port <= e.ToPort
+}
+
+// GroupReference is an ingress rule that admits traffic from the members of
+// another security group rather than from a CIDR.
+type GroupReference struct {
+	InstanceID        string   `json:"instanceId" bson:"instanceId"`
+	GroupID           string   `json:"groupId" bson:"groupId"`
+	ReferencedGroupID string   `json:"referencedGroupId" bson:"referencedGroupId"`
+	RuleID            string   `json:"ruleId" bson:"ruleId"`
+	Protocol          string   `json:"protocol" bson:"protocol"`
+	FromPort          int      `json:"fromPort" bson:"fromPort"`
+	ToPort            int      `json:"toPort" bson:"toPort"`
+	PeerInstanceIDs   []string `json:"peerInstanceIds" bson:"peerInstanceIds"`
+	// 对端实例本身暴露在公网，可作为跳板
+	PeerExposed bool `json:"peerExposed" bson:"peerExposed"`
+}
+
+// PrefixListReference is an ingress rule that admits traffic from a managed
+// prefix list. The entries of the list are not resolved, so whether the rule
+// exposes the instance to the internet is unknown.
+type PrefixListReference struct {
+	InstanceID   string `json:"instanceId" bson:"instanceId"`
+	GroupID      string `json:"groupId" bson:"groupId"`
+	PrefixListID string `json:"prefixListId" bson:"prefixListId"`
+	RuleID       string `json:"ruleId" bson:"ruleId"`
+	Protocol     string `json:"protocol" bson:"protocol"`
+	FromPort     int    `json:"fromPort" bson:"fromPort"`
+	ToPort       int    `json:"toPort" bson:"toPort"`
+}
+
+type Analysis struct {
+	Exposures  []Exposure       `json:"exposures"`
+	References []GroupReference `json:"references"`
+	// 来源为前缀列表、无法判断是否暴露在公网的规则
+	PrefixLists []PrefixListReference `json:"prefixLists"`
+}
+
+// ByInstance groups the exposures by instance ID.
+func (a *Analysis) ByInstance() map[string][]Exposure {
+	out := map[string][]Exposure{}
+	for _, e := range a.Exposures {
+		out[e.InstanceID] = append(out[e.InstanceID], e)
+	}
+	return out
+}
+
+// AnalyzeExposure computes the effective internet exposure of the instances
+// from the ingress rules of their security groups.
+func AnalyzeExposure(instances []*AWSInstance, groups []*AWSSecurityGroup) *Analysis {
+	groupIndex := make(map[string]*AWSSecurityGroup, len(groups))
+	members := map[string][]string{} // groupID -> instanceIDs
+	for _, sg := range groups {
+		groupIndex[sg.GroupID] = sg
+	}
+	for _, instance := range instances {
+		for _, groupID := range instance.SecurityGroupIDs {
+			members[groupID] = append(members[groupID], instance.InstanceID)
+		}
+	}
+
+	merged := map[string]*Exposure{}
+	var references []GroupReference
+	prefixLists := []PrefixListReference{}
+
+	for _, instance := range instances {
+		for _, groupID := range instance.SecurityGroupIDs {
+			sg, ok := groupIndex[groupID]
+			if !ok {
+				continue
+			}
+			for _, rule := range sg.Rules {
+				if rule.IsEgress {
+					continue
+				}
+				if rule.ReferencedGroupID != "" {
+					references = append(references, newGroupReference(instance, rule, members[rule.ReferencedGroupID]))
+					continue
+				}
+				if rule.PrefixListID != "" {
+					prefixLists = append(prefixLists, newPrefixListReference(instance, rule))
+					continue
+				}
+				if rule.CidrIPv4 != "" && IsInternetCidr(rule.CidrIPv4) {
+					for _, ip := range instance.PublicIPs {
+						addExposures(merged, instance.InstanceID, ip, rule.CidrIPv4, rule, false)
+					}
+				}
+				if rule.CidrIPv6 != "" && IsInternetCidr(rule.CidrIPv6) {
+					for _, ip := range instance.IPv6Addresses {
+						addExposures(merged, instance.InstanceID, ip, rule.CidrIPv6, rule, true)
+					}
+				}
+			}
+		}
+	}
+
+	analysis := &Analysis{Exposures: []Exposure{}, References: []GroupReference{}, PrefixLists: prefixLists}
+	exposed := map[string]bool{}
+	for _, e := range merged {
+		sort.Strings(e.Cidrs)
+		sort.Strings(e.RuleIDs)
+		analysis.Exposures = append(analysis.Exposures, *e)
+		exposed[e.InstanceID] = true
+	}
+	sort.Slice(analysis.Exposures, func(i, j int) bool {
+		return exposureLess(analysis.Exposures[i], analysis.Exposures[j])
+	})
+
+	for _, ref := range references {
+		for _, peer := range ref.PeerInstanceIDs {
+			if exposed[peer] {
+				ref.PeerExposed = true
+				break
+			}
+		}
+		analysis.References = append(analysis.References, ref)
+	}
+
+	return analysis
+}
+
+func newGroupReference(instance *AWSInstance, rule SecurityGroupRule, members []string) GroupReference {
+	peers := []string{}
+	for _, id := range members {
+		if id != instance.InstanceID {
+			peers = append(peers, id)
+		}
+	}
+	sort.Strings(peers)
+	protocol := NormalizeProtocol(rule.Protocol)
+	from, to := normalizePorts(protocol, rule.FromPort, rule.ToPort)
+	return GroupReference{
+		InstanceID:        instance.InstanceID,
+		GroupID:           rule.GroupID,
+		ReferencedGroupID: rule.ReferencedGroupID,
+		RuleID:            rule.RuleID,
+		Protocol:          protocol,
+		FromPort:          from,
+		ToPort:            to,
+		PeerInstanceIDs:   peers,
+	}
+}
+
+func newPrefixListReference(instance *AWSInstance, rule SecurityGroupRule) PrefixListReference {
+	protocol := NormalizeProtocol(rule.Protocol)
+	from, to := normalizePorts(protocol, rule.FromPort, rule.ToPort)
+	return PrefixListReference{
+		InstanceID:   instance.InstanceID,
+		GroupID:      rule.GroupID,
+		PrefixListID: rule.PrefixListID,
+		RuleID:       rule.RuleID,
+		Protocol:     protocol,
+		FromPort:     from,
+		ToPort:       to,
+	}
+}
+
+func addExposures(merged map[string]*Exposure, instanceID, ip, cidr string, rule SecurityGroupRule, ipv6 bool) {
+	for _, protocol := range ExpandProtocol(rule.Protocol, ipv6) {
+		from, to := rule.FromPort, rule.ToPort
+		if NormalizeProtocol(rule.Protocol) == "-1" {
+			// 所有流量：规则上的端口无意义，展开为全端口
+			from, to = -1, -1
+		}
+		from, to = normalizePorts(protocol, from, to)
+		e := Exposure{
+			InstanceID: instanceID,
+			IP:         ip,
+			Protocol:   protocol,
+			FromPort:   from,
+			ToPort:     to,
+		}
+		key := e.Key()
+		existing, ok := merged[key]
+		if !ok {
+			existing = &e
+			merged[key] = existing
+		}
+		existing.Cidrs = appendUnique(existing.Cidrs, cidr)
+		existing.RuleIDs = appendUnique(existing.RuleIDs, rule.RuleID)
+	}
+}
+
+// NormalizeProtocol maps the IANA protocol numbers AWS may return to names.
+func NormalizeProtocol(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "-1", "all":
+		return "-1"
+	case "6", ProtocolTCP:
+		return ProtocolTCP
+	case "17", ProtocolUDP:
+		return ProtocolUDP
+	case "1", ProtocolICMP:
+		return ProtocolICMP
+	case "58", ProtocolICMPv6:
+		return ProtocolICMPv6
+	}
+	return strings.ToLower(protocol)
+}
+
+// ExpandProtocol expands "-1" (all traffic) into the concrete protocols that
+// apply to the address family.
+func ExpandProtocol(protocol string, ipv6 bool) []string {
+	p := NormalizeProtocol(protocol)
+	if p != "-1" {
+		return []string{p}
+	}
+	if ipv6 {
+		return []string{ProtocolTCP, ProtocolUDP, ProtocolICMPv6}
+	}
+	return []string{ProtocolTCP, ProtocolUDP, ProtocolICMP}
+}
+
+// tcp/udp 的 -1 表示全部端口；icmp 的端口字段是 type/code，保持原值
+func normalizePorts(protocol string, from, to int) (int, int) {
+	if protocol != ProtocolTCP && protocol != ProtocolUDP {
+		return from, to
+	}
+	if from == -1 || to == -1 {
+		return 0, 65535
+	}
+	if from > to {
+		from, to = to, from
+	}
+	return from, to
+}
+
+// IsInternetCidr reports whether the CIDR admits every source, i.e. it is
+// 0.0.0.0/0 or ::/0. Narrower public ranges usually belong to offices or
+// partners and are not counted as internet exposure.
+func IsInternetCidr(cidr string) bool {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return false
+	}
+	return prefix.Bits() == 0
+}
+
+func exposureLess(a, b Exposure) bool {
+	if a.InstanceID != b.InstanceID {
+		return a.InstanceID < b.InstanceID
+	}
+	if a.IP != b.IP {
+		return a.IP < b.IP
+	}
+	if a.Protocol != b.Protocol {
+		return a.Protocol < b.Protocol
+	}
+	if a.FromPort != b.FromPort {
+		return a.FromPort < b.FromPort
+	}
+	return a.ToPort < b.ToPort
+}
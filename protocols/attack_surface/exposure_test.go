@@ -0,0 +1,149 @@
+package attack_surface
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsInternetCidr(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want bool
+	}{
+		{"0.0.0.0/0", true},
+		{"::/0", true},
+		{"10.0.0.0/8", false},
+		{"172.16.5.0/24", false},
+		{"192.168.1.10/32", false},
+		{"fc00::/7", false},
+		{"203.0.113.0/24", false},
+		{"8.8.8.8/32", false},
+		{"2001:db8::/32", false},
+		{"not-a-cidr", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsInternetCidr(tt.cidr); got != tt.want {
+			t.Errorf("IsInternetCidr(%q) = %v, want %v", tt.cidr, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeExposure(t *testing.T) {
+	web := &AWSInstance{
+		InstanceID:       "i-web",
+		PublicIPs:        []string{"198.51.100.1"},
+		IPv6Addresses:    []string{"2001:db8::1"},
+		SecurityGroupIDs: []string{"sg-web"},
+	}
+	db := &AWSInstance{
+		InstanceID:       "i-db",
+		SecurityGroupIDs: []string{"sg-db"},
+	}
+	rule := func(r SecurityGroupRule) *AWSSecurityGroup {
+		r.GroupID = "sg-web"
+		return &AWSSecurityGroup{GroupID: "sg-web", Rules: []SecurityGroupRule{r}}
+	}
+	dbGroup := &AWSSecurityGroup{GroupID: "sg-db"}
+
+	tests := []struct {
+		name        string
+		group       *AWSSecurityGroup
+		exposures   []Exposure
+		references  []GroupReference
+		prefixLists []PrefixListReference
+	}{
+		{
+			name:  "ipv4 any source",
+			group: rule(SecurityGroupRule{RuleID: "r1", Protocol: "tcp", FromPort: 22, ToPort: 22, CidrIPv4: "0.0.0.0/0"}),
+			exposures: []Exposure{
+				{InstanceID: "i-web", IP: "198.51.100.1", Protocol: "tcp", FromPort: 22, ToPort: 22, Cidrs: []string{"0.0.0.0/0"}, RuleIDs: []string{"r1"}},
+			},
+		},
+		{
+			name:  "ipv6 any source",
+			group: rule(SecurityGroupRule{RuleID: "r1", Protocol: "6", FromPort: 443, ToPort: 443, CidrIPv6: "::/0"}),
+			exposures: []Exposure{
+				{InstanceID: "i-web", IP: "2001:db8::1", Protocol: "tcp", FromPort: 443, ToPort: 443, Cidrs: []string{"::/0"}, RuleIDs: []string{"r1"}},
+			},
+		},
+		{
+			name:  "all traffic",
+			group: rule(SecurityGroupRule{RuleID: "r1", Protocol: "-1", FromPort: 0, ToPort: 0, CidrIPv4: "0.0.0.0/0"}),
+			exposures: []Exposure{
+				{InstanceID: "i-web", IP: "198.51.100.1", Protocol: "icmp", FromPort: -1, ToPort: -1, Cidrs: []string{"0.0.0.0/0"}, RuleIDs: []string{"r1"}},
+				{InstanceID: "i-web", IP: "198.51.100.1", Protocol: "tcp", FromPort: 0, ToPort: 65535, Cidrs: []string{"0.0.0.0/0"}, RuleIDs: []string{"r1"}},
+				{InstanceID: "i-web", IP: "198.51.100.1", Protocol: "udp", FromPort: 0, ToPort: 65535, Cidrs: []string{"0.0.0.0/0"}, RuleIDs: []string{"r1"}},
+			},
+		},
+		{
+			name:  "port range",
+			group: rule(SecurityGroupRule{RuleID: "r1", Protocol: "udp", FromPort: 9000, ToPort: 8000, CidrIPv4: "0.0.0.0/0"}),
+			exposures: []Exposure{
+				{InstanceID: "i-web", IP: "198.51.100.1", Protocol: "udp", FromPort: 8000, ToPort: 9000, Cidrs: []string{"0.0.0.0/0"}, RuleIDs: []string{"r1"}},
+			},
+		},
+		{
+			name:  "private cidr",
+			group: rule(SecurityGroupRule{RuleID: "r1", Protocol: "tcp", FromPort: 22, ToPort: 22, CidrIPv4: "10.0.0.0/8"}),
+		},
+		{
+			name:  "narrow public cidr",
+			group: rule(SecurityGroupRule{RuleID: "r1", Protocol: "tcp", FromPort: 22, ToPort: 22, CidrIPv4: "203.0.113.0/24"}),
+		},
+		{
+			name:  "egress",
+			group: rule(SecurityGroupRule{RuleID: "r1", IsEgress: true, Protocol: "-1", CidrIPv4: "0.0.0.0/0"}),
+		},
+		{
+			name:  "security group reference",
+			group: rule(SecurityGroupRule{RuleID: "r1", Protocol: "tcp", FromPort: 5432, ToPort: 5432, ReferencedGroupID: "sg-db"}),
+			references: []GroupReference{
+				{InstanceID: "i-web", GroupID: "sg-web", ReferencedGroupID: "sg-db", RuleID: "r1", Protocol: "tcp", FromPort: 5432, ToPort: 5432, PeerInstanceIDs: []string{"i-db"}},
+			},
+		},
+		{
+			name:  "prefix list",
+			group: rule(SecurityGroupRule{RuleID: "r1", Protocol: "tcp", FromPort: 80, ToPort: 80, PrefixListID: "pl-1"}),
+			prefixLists: []PrefixListReference{
+				{InstanceID: "i-web", GroupID: "sg-web", PrefixListID: "pl-1", RuleID: "r1", Protocol: "tcp", FromPort: 80, ToPort: 80},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AnalyzeExposure([]*AWSInstance{web, db}, []*AWSSecurityGroup{tt.group, dbGroup})
+			if tt.exposures == nil {
+				tt.exposures = []Exposure{}
+			}
+			if tt.references == nil {
+				tt.references = []GroupReference{}
+			}
+			if tt.prefixLists == nil {
+				tt.prefixLists = []PrefixListReference{}
+			}
+			if !reflect.DeepEqual(got.Exposures, tt.exposures) {
+				t.Errorf("exposures = %+v, want %+v", got.Exposures, tt.exposures)
+			}
+			if !reflect.DeepEqual(got.References, tt.references) {
+				t.Errorf("references = %+v, want %+v", got.References, tt.references)
+			}
+			if !reflect.DeepEqual(got.PrefixLists, tt.prefixLists) {
+				t.Errorf("prefix lists = %+v, want %+v", got.PrefixLists, tt.prefixLists)
+			}
+		})
+	}
+}
+
+func TestAnalyzeExposurePeerExposed(t *testing.T) {
+	bastion := &AWSInstance{InstanceID: "i-bastion", PublicIPs: []string{"198.51.100.2"}, SecurityGroupIDs: []string{"sg-bastion"}}
+	app := &AWSInstance{InstanceID: "i-app", SecurityGroupIDs: []string{"sg-app"}}
+	groups := []*AWSSecurityGroup{
+		{GroupID: "sg-bastion", Rules: []SecurityGroupRule{{RuleID: "r1", GroupID: "sg-bastion", Protocol: "tcp", FromPort: 22, ToPort: 22, CidrIPv4: "0.0.0.0/0"}}},
+		{GroupID: "sg-app", Rules: []SecurityGroupRule{{RuleID: "r2", GroupID: "sg-app", Protocol: "tcp", FromPort: 22, ToPort: 22, ReferencedGroupID: "sg-bastion"}}},
+	}
+	got := AnalyzeExposure([]*AWSInstance{bastion, app}, groups)
+	if len(got.References) != 1 || !got.References[0].PeerExposed {
+		t.Fatalf("references = %+v, want one reference with an exposed peer", got.References)
+	}
+}
@@ -0,0 +1,236 @@
+package attack_surface
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/protocols/whitelist"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+var ErrInvalidFilter = errors.New("invalid attack surface filter")
+
+// ListFilter narrows the stored attack surface. Port, Protocol and Cidr
+// must all hold for the same exposure.
+type ListFilter struct {
+	Provider  string
+	AccountID string
+	Region    string
+	Tags      map[string]string
+	Port      int // 0 表示不过滤
+	Protocol  string
+	Cidr      string
+	// "risk" 按风险分从高到低，默认按实例ID
+	Sort     string
+	PageSize int
+	Cursor   string
+}
+
+type Entry struct {
+	Xid          string            `json:"xid"`
+	Provider     string            `json:"provider"`
+	InstanceID   string            `json:"instanceId"`
+	InstanceName string            `json:"instanceName"`
+	AccountID    string            `json:"accountId"`
+	Region       string            `json:"region"`
+	Tags         map[string]string `json:"tags"`
+	PublicIPs    []string          `json:"publicIps"`
+	Exposures    []Exposure        `json:"exposures"`
+	// 所有暴露面都被白名单覆盖
+	Whitelisted bool    `json:"whitelisted"`
+	RiskScore   float64 `json:"riskScore"`
+	RiskLevel   string  `json:"riskLevel"`
+	CollectedAt int64   `json:"collectedAt"`
+}
+
+func (f ListFilter) query() (xdb.Query, error) {
+	where := map[string]any{}
+	if f.Provider != "" {
+		where["payload.provider"] = f.Provider
+	}
+	if f.AccountID != "" {
+		where["payload.accountId"] = f.AccountID
+	}
+	if f.Region != "" {
+		where["payload.region"] = f.Region
+	}
+	for k, v := range f.Tags {
+		// 标签键拼进字段路径，不能含有路径分隔符或操作符
+		if k == "" || strings.ContainsAny(k, ".$") {
+			return xdb.Query{}, fmt.Errorf("%w: tag key %q must not be empty or contain '.' or '$'", ErrInvalidFilter, k)
+		}
+		where["payload.tags."+k] = v
+	}
+
+	match := map[string]any{}
+	if f.Port > 0 {
+		match["fromPort"] = map[string]any{"$lte": f.Port}
+		match["toPort"] = map[string]any{"$gte": f.Port}
+	}
+	if f.Protocol != "" {
+		match["protocol"] = NormalizeProtocol(f.Protocol)
+	}
+	if f.Cidr != "" {
+		match["cidrs"] = f.Cidr
+	}
+	if len(match) > 0 {
+		where["payload.exposures"] = map[string]any{"$elemMatch": match}
+	}
+
+	q := xdb.Query{
+		Path:     PathAWSAttackSurface,
+		Where:    where,
+		SortBy:   "name",
+		SortAsc:  true,
+		PageSize: f.PageSize,
+	}
+	if f.Sort == "risk" {
+		q.SortBy = "payload.riskScore"
+		q.SortAsc = false
+	}
+	if f.Cursor != "" {
+		q.AfterCursor = &f.Cursor
+	}
+	return q, nil
+}
+
+// ListAttackSurfaces returns one page of stored attack surface entries and
+// the cursor of the next page.
+func ListAttackSurfaces(ctx context.Context, repo xdb.XIDRepo, filter ListFilter) ([]Entry, string, error) {
+	q, err := filter.query()
+	if err != nil {
+		return nil, "", err
+	}
+	docs, next, err := repo.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	matcher, err := whitelist.LoadOpenPortMatcher(ctx, repo)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load whitelist: %v", err)
+	}
+
+	entries := make([]Entry, 0, len(docs))
+	for _, doc := range docs {
+		var eas AWSAttackSurface
+		if err := xdb.DecodePayload(doc, &eas); err != nil {
+			return nil, "", fmt.Errorf("failed to decode attack surface %s: %v", doc.Xid, err)
+		}
+		entries = append(entries, *newEntry(doc.Xid, &eas, matcher))
+	}
+	return entries, next, nil
+}
+
+// newEntry checks the exposures of eas against matcher, which must hold the
+// whitelist entries of the instance.
+func newEntry(xid string, eas *AWSAttackSurface, matcher *whitelist.Matcher) *Entry {
+	entry := &Entry{
+		Xid:          xid,
+		Provider:     eas.Provider,
+		InstanceID:   eas.InstanceID,
+		InstanceName: eas.InstanceName,
+		AccountID:    eas.AccountID,
+		Region:       eas.Region,
+		Tags:         eas.Tags,
+		PublicIPs:    eas.PublicIPs,
+		Exposures:    make([]Exposure, 0, len(eas.Exposures)),
+		Whitelisted:  len(eas.Exposures) > 0,
+		RiskScore:    eas.RiskScore,
+		RiskLevel:    eas.RiskLevel,
+		CollectedAt:  eas.CollectedAt,
+	}
+	// 白名单可能在扫描之后变化，这里按当前白名单重新判断
+	for _, e := range eas.Exposures {
+		e.Whitelisted, e.WhitelistIDs = whitelistedBy(matcher, e)
+		if !e.Whitelisted {
+			entry.Whitelisted = false
+		}
+		entry.Exposures = append(entry.Exposures, e)
+	}
+	return entry
+}
+
+// whitelistedBy reports whether the whitelist entries loaded into matcher
+// allow e, and which entries did.
+func whitelistedBy(matcher *whitelist.Matcher, e Exposure) (bool, []string) {
+	match := matcher.MatchOpenPort(whitelist.OpenPortSubject{
+		InstanceID: e.InstanceID,
+		Protocol:   e.Protocol,
+		FromPort:   e.FromPort,
+		ToPort:     e.ToPort,
+		Cidrs:      e.Cidrs,
+	})
+	if !match.Allowed {
+		return false, nil
+	}
+	return true, match.EntryIDs
+}
+
+// Detail is the attack surface of one instance with the source cards it was
+// derived from.
+type Detail struct {
+	Entry          *Entry                `json:"entry"`
+	Instance       *AWSInstance          `json:"instance"`
+	Rules          []Rule                `json:"rules"`
+	References     []GroupReference      `json:"references"`
+	PrefixLists    []PrefixListReference `json:"prefixLists"`
+	SecurityGroups []*AWSSecurityGroup   `json:"securityGroups"`
+	Cards          []*protocols.XID[any] `json:"cards"`
+}
+
+// GetAttackSurfaceDetail loads the attack surface card of xid together with
+// its instance and security group cards.
+func GetAttackSurfaceDetail(ctx context.Context, repo xdb.XIDRepo, xid string) (*Detail, error) {
+	easCard, err := repo.FindByXid(ctx, xid, PathAWSAttackSurface)
+	if err != nil {
+		return nil, err
+	}
+	var eas AWSAttackSurface
+	if err := xdb.DecodePayload(easCard, &eas); err != nil {
+		return nil, err
+	}
+	matcher, err := whitelist.LoadMatcher(ctx, repo, eas.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load whitelist for %s: %v", eas.InstanceID, err)
+	}
+	entry := newEntry(xid, &eas, matcher)
+
+	detail := &Detail{
+		Entry:          entry,
+		Rules:          eas.Rules,
+		References:     eas.References,
+		PrefixLists:    eas.PrefixLists,
+		SecurityGroups: []*AWSSecurityGroup{},
+		Cards:          []*protocols.XID[any]{easCard},
+	}
+
+	instanceCard, err := repo.FindByXid(ctx, xid, PathAWSInstance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load instance card: %v", err)
+	}
+	detail.Cards = append(detail.Cards, instanceCard)
+	var instance AWSInstance
+	if err := xdb.DecodePayload(instanceCard, &instance); err != nil {
+		return nil, err
+	}
+	detail.Instance = &instance
+
+	for _, groupID := range instance.SecurityGroupIDs {
+		sgCard, err := repo.FindByXid(ctx, protocols.GenerateXid(groupID), PathAWSSecGroup)
+		if err != nil {
+			// 安全组可能已被删除，跳过
+			continue
+		}
+		var sg AWSSecurityGroup
+		if err := xdb.DecodePayload(sgCard, &sg); err != nil {
+			return nil, err
+		}
+		detail.SecurityGroups = append(detail.SecurityGroups, &sg)
+		detail.Cards = append(detail.Cards, sgCard)
+	}
+
+	return detail, nil
+}
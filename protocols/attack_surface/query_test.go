@@ -0,0 +1,67 @@
+package attack_surface
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/xid-protocol/xidp/protocols/whitelist"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+func TestListFilterTagKeys(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"env", true},
+		{"team-name", true},
+		{"aws:cloudformation:stack-name", true},
+		{"", false},
+		{"a.b", false},
+		{"$where", false},
+		{"env$", false},
+	}
+	for _, tt := range tests {
+		_, err := ListFilter{Tags: map[string]string{tt.key: "x"}}.query()
+		if tt.valid && err != nil {
+			t.Errorf("tag key %q: %v", tt.key, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("tag key %q got %v, want ErrInvalidFilter", tt.key, err)
+		}
+	}
+}
+
+// TestListAttackSurfaces checks the listed entries against the whitelist as
+// it is at query time.
+func TestListAttackSurfaces(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	c := NewAWSCollector(repo, map[string]ec2iface.EC2API{
+		"us-east-1": &fakeEC2{instanceID: "i-us", publicIP: "198.51.100.1", port: 22},
+		"eu-west-1": &fakeEC2{instanceID: "i-eu", publicIP: "198.51.100.2", port: 443},
+	})
+	if _, err := c.Collect(ctx); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	approveOpenPort(t, repo, whitelist.AWSOpenPort{InstanceID: "i-us", Cidr: "0.0.0.0/0", Protocol: "tcp", FromPort: 22, ToPort: 22})
+
+	entries, _, err := ListAttackSurfaces(ctx, repo, ListFilter{PageSize: 10})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	for _, e := range entries {
+		if want := e.InstanceID == "i-us"; e.Whitelisted != want {
+			t.Errorf("%s whitelisted = %v, want %v", e.InstanceID, e.Whitelisted, want)
+		}
+	}
+
+	if _, _, err := ListAttackSurfaces(ctx, repo, ListFilter{Tags: map[string]string{"Name.x": "i-us"}}); !errors.Is(err, ErrInvalidFilter) {
+		t.Fatalf("list with tag key Name.x got %v, want ErrInvalidFilter", err)
+	}
+}
@@ -0,0 +1,227 @@
+package attack_surface
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	RiskLevelLow      = "low"
+	RiskLevelMedium   = "medium"
+	RiskLevelHigh     = "high"
+	RiskLevelCritical = "critical"
+)
+
+type RiskFactor struct {
+	Name   string  `json:"name" bson:"name"`
+	Score  float64 `json:"score" bson:"score"`
+	Detail string  `json:"detail,omitempty" bson:"detail,omitempty"`
+}
+
+type Risk struct {
+	Score   float64      `json:"score" bson:"score"`
+	Level   string       `json:"level" bson:"level"`
+	Factors []RiskFactor `json:"factors" bson:"factors"`
+}
+
+type PortRisk struct {
+	Service string  `json:"service" mapstructure:"service"`
+	Score   float64 `json:"score" mapstructure:"score"`
+}
+
+// RiskConfig weighs the factors of an exposure. Scores are summed, scaled by
+// WhitelistedMultiplier when a whitelist entry covers the exposure, and
+// capped at 100.
+type RiskConfig struct {
+	// port -> criticality, ranges are scored by the most critical port inside
+	Ports            map[string]PortRisk `mapstructure:"ports"`
+	DefaultPortScore float64             `mapstructure:"default_port_score"`
+	// range wider than WidePortRange ports, e.g. all traffic
+	WidePortRange int     `mapstructure:"wide_port_range"`
+	WidePortScore float64 `mapstructure:"wide_port_score"`
+	// prefix length -> score, the narrowest entry not longer than the CIDR wins
+	CidrScores map[string]float64 `mapstructure:"cidr_scores"`
+	// "key=value" of an instance tag -> score
+	TagScores             map[string]float64 `mapstructure:"tag_scores"`
+	WhitelistedMultiplier float64            `mapstructure:"whitelisted_multiplier"`
+	AgeScorePerDay        float64            `mapstructure:"age_score_per_day"`
+	AgeMaxScore           float64            `mapstructure:"age_max_score"`
+	AlertThreshold        float64            `mapstructure:"alert_threshold"`
+}
+
+func DefaultRiskConfig() *RiskConfig {
+	return &RiskConfig{
+		Ports: map[string]PortRisk{
+			"22":    {Service: "ssh", Score: 35},
+			"23":    {Service: "telnet", Score: 40},
+			"3389":  {Service: "rdp", Score: 40},
+			"5900":  {Service: "vnc", Score: 35},
+			"445":   {Service: "smb", Score: 40},
+			"3306":  {Service: "mysql", Score: 40},
+			"5432":  {Service: "postgresql", Score: 40},
+			"1433":  {Service: "mssql", Score: 40},
+			"1521":  {Service: "oracle", Score: 40},
+			"27017": {Service: "mongodb", Score: 45},
+			"6379":  {Service: "redis", Score: 45},
+			"9200":  {Service: "elasticsearch", Score: 45},
+			"11211": {Service: "memcached", Score: 40},
+			"2375":  {Service: "docker", Score: 50},
+			"2379":  {Service: "etcd", Score: 50},
+			"6443":  {Service: "kubernetes-api", Score: 40},
+			"10250": {Service: "kubelet", Score: 45},
+			"5601":  {Service: "kibana", Score: 30},
+			"8080":  {Service: "admin-panel", Score: 20},
+			"8443":  {Service: "admin-panel", Score: 20},
+			"9090":  {Service: "admin-panel", Score: 20},
+			"80":    {Service: "http", Score: 5},
+			"443":   {Service: "https", Score: 5},
+		},
+		DefaultPortScore: 10,
+		WidePortRange:    1000,
+		WidePortScore:    50,
+		CidrScores: map[string]float64{
+			"0":  30,
+			"8":  20,
+			"16": 10,
+			"24": 5,
+		},
+		TagScores: map[string]float64{
+			"environment=prod":       15,
+			"environment=production": 15,
+		},
+		WhitelistedMultiplier: 0.2,
+		AgeScorePerDay:        0.5,
+		AgeMaxScore:           15,
+		AlertThreshold:        80,
+	}
+}
+
+// LoadRiskConfig reads RiskScoring from the config file on top of the defaults.
+func LoadRiskConfig() *RiskConfig {
+	cfg := DefaultRiskConfig()
+	if viper.IsSet("RiskScoring") {
+		if err := viper.UnmarshalKey("RiskScoring", cfg); err != nil {
+			return DefaultRiskConfig()
+		}
+	}
+	return cfg
+}
+
+// Score computes the risk of an exposure on an instance with tags, evaluated
+// at now (unix millis).
+func (cfg *RiskConfig) Score(e Exposure, tags map[string]string, now int64) *Risk {
+	risk := &Risk{Factors: []RiskFactor{}}
+	add := func(name string, score float64, detail string) {
+		if score == 0 {
+			return
+		}
+		risk.Factors = append(risk.Factors, RiskFactor{Name: name, Score: score, Detail: detail})
+		risk.Score += score
+	}
+
+	add(cfg.portFactor(e))
+
+	cidrScore, cidr := 0.0, ""
+	for _, c := range e.Cidrs {
+		if s := cfg.cidrScore(c); s > cidrScore {
+			cidrScore, cidr = s, c
+		}
+	}
+	add("cidr_breadth", cidrScore, cidr)
+
+	tagKeys := make([]string, 0, len(tags))
+	for k := range tags {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+	for _, k := range tagKeys {
+		tag := strings.ToLower(k + "=" + tags[k])
+		add("instance_tag", cfg.TagScores[tag], tag)
+	}
+
+	if e.FirstSeen > 0 && now > e.FirstSeen {
+		days := float64(now-e.FirstSeen) / float64(24*60*60*1000)
+		age := math.Min(days*cfg.AgeScorePerDay, cfg.AgeMaxScore)
+		add("exposure_age", math.Round(age*10)/10, fmt.Sprintf("%.1f days", days))
+	}
+
+	if e.Whitelisted && cfg.WhitelistedMultiplier < 1 {
+		reduction := -risk.Score * (1 - cfg.WhitelistedMultiplier)
+		add("whitelisted", math.Round(reduction*10)/10, "covered by whitelist")
+	}
+
+	risk.Score = math.Max(0, math.Min(100, math.Round(risk.Score*10)/10))
+	risk.Level = riskLevel(risk.Score)
+	return risk
+}
+
+func (cfg *RiskConfig) portFactor(e Exposure) (string, float64, string) {
+	if e.Protocol != ProtocolTCP && e.Protocol != ProtocolUDP {
+		return "port", cfg.DefaultPortScore, e.Protocol
+	}
+	if cfg.WidePortRange > 0 && e.ToPort-e.FromPort+1 > cfg.WidePortRange {
+		return "port", cfg.WidePortScore, fmt.Sprintf("%s/%d-%d", e.Protocol, e.FromPort, e.ToPort)
+	}
+
+	ports := make([]int, 0, len(cfg.Ports))
+	for port := range cfg.Ports {
+		if p, err := strconv.Atoi(port); err == nil && e.Covers(p) {
+			ports = append(ports, p)
+		}
+	}
+	sort.Ints(ports)
+
+	// 范围内有已知服务时取最高分，否则按默认分
+	best, service := cfg.DefaultPortScore, ""
+	for _, p := range ports {
+		pr := cfg.Ports[strconv.Itoa(p)]
+		if pr.Score > best || service == "" {
+			best, service = pr.Score, fmt.Sprintf("%s/%d (%s)", e.Protocol, p, pr.Service)
+		}
+	}
+	if service == "" {
+		service = fmt.Sprintf("%s/%d-%d", e.Protocol, e.FromPort, e.ToPort)
+	}
+	return "port", best, service
+}
+
+func (cfg *RiskConfig) cidrScore(cidr string) float64 {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return 0
+	}
+	bits := prefix.Bits()
+	if prefix.Addr().Is6() {
+		// IPv6 前缀按 IPv4 的比例折算，::/0 等同 0.0.0.0/0
+		bits = bits / 4
+	}
+	best, bestBits := 0.0, -1
+	for k, score := range cfg.CidrScores {
+		b, err := strconv.Atoi(k)
+		if err != nil || b > bits {
+			continue
+		}
+		if b > bestBits {
+			best, bestBits = score, b
+		}
+	}
+	return best
+}
+
+func riskLevel(score float64) string {
+	switch {
+	case score >= 80:
+		return RiskLevelCritical
+	case score >= 60:
+		return RiskLevelHigh
+	case score >= 30:
+		return RiskLevelMedium
+	}
+	return RiskLevelLow
+}
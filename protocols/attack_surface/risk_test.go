@@ -0,0 +1,205 @@
+package attack_surface
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xid-protocol/xidp/protocols/whitelist"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+func TestRiskScore(t *testing.T) {
+	const day = int64(24 * 60 * 60 * 1000)
+	now := 1000 * day
+	tcp := func(from, to int, cidrs ...string) Exposure {
+		return Exposure{Protocol: ProtocolTCP, FromPort: from, ToPort: to, Cidrs: cidrs}
+	}
+	aged := func(e Exposure, days int64) Exposure {
+		e.FirstSeen = now - days*day
+		return e
+	}
+	whitelisted := func(e Exposure) Exposure {
+		e.Whitelisted = true
+		return e
+	}
+	prod := map[string]string{"Environment": "Prod"}
+
+	tests := []struct {
+		name     string
+		exposure Exposure
+		tags     map[string]string
+		score    float64
+		level    string
+	}{
+		// 端口类别
+		{"ssh", tcp(22, 22, "0.0.0.0/0"), nil, 65, RiskLevelHigh},
+		{"http", tcp(80, 80, "0.0.0.0/0"), nil, 35, RiskLevelMedium},
+		{"unknown port", tcp(12345, 12345, "0.0.0.0/0"), nil, 40, RiskLevelMedium},
+		{"range takes the most critical port", tcp(6000, 6500, "0.0.0.0/0"), nil, 75, RiskLevelHigh},
+		{"range with a low and a high port", tcp(440, 450, "0.0.0.0/0"), nil, 70, RiskLevelHigh},
+		{"wide range", tcp(0, 65535, "0.0.0.0/0"), nil, 80, RiskLevelCritical},
+		{"icmp", Exposure{Protocol: ProtocolICMP, FromPort: -1, ToPort: -1, Cidrs: []string{"0.0.0.0/0"}}, nil, 40, RiskLevelMedium},
+		// 来源 CIDR 的范围
+		{"cidr /4", tcp(22, 22, "16.0.0.0/4"), nil, 65, RiskLevelHigh},
+		{"cidr /8", tcp(22, 22, "10.0.0.0/8"), nil, 55, RiskLevelMedium},
+		{"cidr /16", tcp(22, 22, "172.16.0.0/16"), nil, 45, RiskLevelMedium},
+		{"cidr /32", tcp(22, 22, "198.51.100.7/32"), nil, 40, RiskLevelMedium},
+		{"ipv6 any", tcp(22, 22, "::/0"), nil, 65, RiskLevelHigh},
+		{"ipv6 /32", tcp(22, 22, "2001:db8::/32"), nil, 55, RiskLevelMedium},
+		{"broadest cidr wins", tcp(22, 22, "198.51.100.0/24", "0.0.0.0/0"), nil, 65, RiskLevelHigh},
+		{"invalid cidr", tcp(22, 22, "anywhere"), nil, 35, RiskLevelMedium},
+		// 实例标签与暴露时长
+		{"prod tag", tcp(22, 22, "0.0.0.0/0"), prod, 80, RiskLevelCritical},
+		{"unscored tag", tcp(22, 22, "0.0.0.0/0"), map[string]string{"team": "web"}, 65, RiskLevelHigh},
+		{"ten days", aged(tcp(22, 22, "0.0.0.0/0"), 10), nil, 70, RiskLevelHigh},
+		{"age capped", aged(tcp(22, 22, "0.0.0.0/0"), 100), nil, 80, RiskLevelCritical},
+		// 白名单与上限
+		{"whitelisted", whitelisted(tcp(22, 22, "0.0.0.0/0")), nil, 13, RiskLevelLow},
+		{"whitelisted critical", whitelisted(aged(tcp(0, 65535, "0.0.0.0/0"), 100)), prod, 22, RiskLevelLow},
+		{"capped at 100", aged(tcp(0, 65535, "0.0.0.0/0"), 100), prod, 100, RiskLevelCritical},
+	}
+	cfg := DefaultRiskConfig()
+	for _, tt := range tests {
+		risk := cfg.Score(tt.exposure, tt.tags, now)
+		if risk.Score != tt.score || risk.Level != tt.level {
+			t.Errorf("%s: score %v (%s), want %v (%s); factors %+v", tt.name, risk.Score, risk.Level, tt.score, tt.level, risk.Factors)
+		}
+	}
+}
+
+func TestRiskLevel(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0, RiskLevelLow},
+		{29.9, RiskLevelLow},
+		{30, RiskLevelMedium},
+		{59.9, RiskLevelMedium},
+		{60, RiskLevelHigh},
+		{79.9, RiskLevelHigh},
+		{80, RiskLevelCritical},
+		{100, RiskLevelCritical},
+	}
+	for _, tt := range tests {
+		if got := riskLevel(tt.score); got != tt.want {
+			t.Errorf("riskLevel(%v) = %s, want %s", tt.score, got, tt.want)
+		}
+	}
+}
+
+// TestAssess scores the exposures derived from the security groups: only
+// instances with a public IP are exposed, and a whitelist entry lowers the
+// score of the exposure it covers.
+func TestAssess(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	approveOpenPort(t, repo, whitelist.AWSOpenPort{InstanceID: "i-web", Cidr: "0.0.0.0/0", Protocol: "tcp", FromPort: 22, ToPort: 22})
+	matcher, err := whitelist.LoadOpenPortMatcher(ctx, repo)
+	if err != nil {
+		t.Fatalf("load matcher: %v", err)
+	}
+
+	groups := map[string]*AWSSecurityGroup{"sg-1": {GroupID: "sg-1", Rules: []SecurityGroupRule{
+		{RuleID: "r1", GroupID: "sg-1", Protocol: "tcp", FromPort: 22, ToPort: 22, CidrIPv4: "0.0.0.0/0"},
+		{RuleID: "r2", GroupID: "sg-1", Protocol: "tcp", FromPort: 3306, ToPort: 3306, CidrIPv4: "0.0.0.0/0"},
+		{RuleID: "r3", GroupID: "sg-1", Protocol: "tcp", FromPort: 6379, ToPort: 6379, CidrIPv4: "10.0.0.0/8"},
+	}}}
+	c := &AWSCollector{repo: repo, risk: DefaultRiskConfig()}
+
+	tests := []struct {
+		name     string
+		instance *AWSInstance
+		scores   map[int]float64 // 端口 -> 风险分
+		top      float64
+		level    string
+	}{
+		{
+			name:     "public ip",
+			instance: &AWSInstance{InstanceID: "i-web", PublicIPs: []string{"198.51.100.1"}, SecurityGroupIDs: []string{"sg-1"}},
+			scores:   map[int]float64{22: 13, 3306: 70},
+			top:      70,
+			level:    RiskLevelHigh,
+		},
+		{
+			name:     "public ip, not whitelisted",
+			instance: &AWSInstance{InstanceID: "i-app", PublicIPs: []string{"198.51.100.2"}, SecurityGroupIDs: []string{"sg-1"}},
+			scores:   map[int]float64{22: 65, 3306: 70},
+			top:      70,
+			level:    RiskLevelHigh,
+		},
+		{
+			name:     "private only",
+			instance: &AWSInstance{InstanceID: "i-db", PrivateIPs: []string{"10.0.0.5"}, SecurityGroupIDs: []string{"sg-1"}},
+			scores:   map[int]float64{},
+			top:      0,
+			level:    RiskLevelLow,
+		},
+	}
+	for _, tt := range tests {
+		eas := NewAWSAttackSurface(tt.instance, groups)
+		eas.Exposures = AnalyzeExposure([]*AWSInstance{tt.instance}, []*AWSSecurityGroup{groups["sg-1"]}).Exposures
+		if err := c.assess(ctx, eas, matcher, eas.CollectedAt); err != nil {
+			t.Fatalf("%s: assess: %v", tt.name, err)
+		}
+		if len(eas.Exposures) != len(tt.scores) {
+			t.Fatalf("%s: exposures = %+v, want %d", tt.name, eas.Exposures, len(tt.scores))
+		}
+		for _, e := range eas.Exposures {
+			if e.Risk.Score != tt.scores[e.FromPort] {
+				t.Errorf("%s: port %d score = %v, want %v", tt.name, e.FromPort, e.Risk.Score, tt.scores[e.FromPort])
+			}
+		}
+		if eas.RiskScore != tt.top || eas.RiskLevel != tt.level {
+			t.Errorf("%s: risk = %v (%s), want %v (%s)", tt.name, eas.RiskScore, eas.RiskLevel, tt.top, tt.level)
+		}
+	}
+}
+
+// TestAlerts alerts once when an exposure reaches the threshold and re-arms
+// when it falls below.
+func TestAlerts(t *testing.T) {
+	exposure := func(port int, score float64, alertedAt int64, whitelisted bool) Exposure {
+		return Exposure{
+			InstanceID: "i-web", Protocol: ProtocolTCP, FromPort: port, ToPort: port,
+			Risk: &Risk{Score: score}, AlertedAt: alertedAt, Whitelisted: whitelisted,
+		}
+	}
+	tests := []struct {
+		name      string
+		exposure  Exposure
+		alert     bool
+		alertedAt int64
+	}{
+		{"below threshold", exposure(1, 79.9, 0, false), false, 0},
+		{"below threshold re-arms", exposure(2, 79.9, 5, false), false, 0},
+		{"at threshold", exposure(3, 80, 0, false), true, 10},
+		{"above threshold", exposure(4, 95, 0, false), true, 10},
+		{"already alerted", exposure(5, 95, 5, false), false, 5},
+		{"whitelisted", exposure(6, 95, 0, true), false, 0},
+	}
+	eas := &AWSAttackSurface{InstanceID: "i-web"}
+	for _, tt := range tests {
+		eas.Exposures = append(eas.Exposures, tt.exposure)
+	}
+	c := &AWSCollector{risk: DefaultRiskConfig()}
+	alerted := map[int]bool{}
+	for _, e := range c.alerts(eas, 10) {
+		alerted[e.FromPort] = true
+	}
+	for i, tt := range tests {
+		if alerted[tt.exposure.FromPort] != tt.alert {
+			t.Errorf("%s: alert = %v, want %v", tt.name, !tt.alert, tt.alert)
+		}
+		if got := eas.Exposures[i].AlertedAt; got != tt.alertedAt {
+			t.Errorf("%s: alertedAt = %d, want %d", tt.name, got, tt.alertedAt)
+		}
+	}
+
+	// 阈值为 0 时不告警
+	c.risk.AlertThreshold = 0
+	eas.Exposures = []Exposure{exposure(1, 100, 0, false)}
+	if got := c.alerts(eas, 10); len(got) != 0 {
+		t.Errorf("alerts with threshold 0 = %+v, want none", got)
+	}
+}
@@ -0,0 +1,65 @@
+package attack_surface
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/xidp/protocols/task"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+const (
+	TaskTypeScan   = "attack_surface_scan"
+	ScanScheduleID = "attack-surface-nightly-scan"
+
+	defaultScanCron    = "0 2 * * *"
+	defaultScanTimeout = 3600
+)
+
+// RegisterScanTask runs attack surface scans as tasks and schedules the
+// nightly scan. AttackSurface.scan_cron overrides the time, "off" disables
+// the schedule while scans can still be started as tasks.
+func RegisterScanTask(ctx context.Context, repo xdb.XIDRepo) error {
+	task.RegisterExecutor(TaskTypeScan, func(ctx context.Context, t *task.Task) (string, error) {
+		result, err := ScanAWS(ctx, repo)
+		if err != nil {
+			return "", err
+		}
+		summary, _ := json.Marshal(map[string]any{
+			"regions":        result.Regions,
+			"instances":      len(result.Instances),
+			"securityGroups": len(result.SecurityGroups),
+			"attackSurfaces": len(result.AttackSurfaces),
+			"exposures":      result.Exposures,
+			"errors":         result.Errors,
+		})
+		return string(summary), nil
+	})
+
+	cron := viper.GetString("AttackSurface.scan_cron")
+	if cron == "off" {
+		return nil
+	}
+	if cron == "" {
+		cron = defaultScanCron
+	}
+	timeout := viper.GetInt64("AttackSurface.scan_timeout")
+	if timeout <= 0 {
+		timeout = defaultScanTimeout
+	}
+	_, err := task.EnsureSchedule(ctx, repo, task.Schedule{
+		ScheduleID:     ScanScheduleID,
+		Name:           "nightly attack surface scan",
+		CronExpression: cron,
+		Timezone:       viper.GetString("AttackSurface.scan_timezone"),
+		Task: task.CreateRequest{
+			Name:       "attack surface scan",
+			TaskType:   TaskTypeScan,
+			Timeout:    timeout,
+			RetryCount: 2,
+			RetryDelay: 600,
+		},
+	})
+	return err
+}
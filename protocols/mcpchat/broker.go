@@ -0,0 +1,319 @@
+package mcpchat
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+
+	"github.com/colin-404/logx"
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+// 未配置 Chat.subscriber_buffer 时每个订阅者最多缓存的事件数
+const defaultSubscriberBuffer = 256
+
+var ErrSlowConsumer = errors.New("subscriber could not keep up and was disconnected")
+
+// OverflowPolicy decides what happens when a subscriber's buffer is full.
+type OverflowPolicy string
+
+const (
+	// 丢弃最早的未读事件，订阅者从事件 ID 的跳跃看出丢失
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// 断开慢订阅者，Next 返回 ErrSlowConsumer
+	OverflowDisconnect OverflowPolicy = "disconnect"
+	// 暂停实时推送，订阅者读完缓存后从存储补读，追上后恢复实时推送
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// brokerMetrics is published at /debug/vars.
+var brokerMetrics = expvar.NewMap("mcpchat_broker")
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case OverflowDropOldest, OverflowDisconnect, OverflowSpill:
+		return p, nil
+	case "":
+		return defaultOverflowPolicy(), nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// defaultOverflowPolicy 读取 Chat.overflow_policy，有存储时默认 spill
+func defaultOverflowPolicy() OverflowPolicy {
+	switch p := OverflowPolicy(viper.GetString("Chat.overflow_policy")); p {
+	case OverflowDropOldest, OverflowDisconnect, OverflowSpill:
+		return p
+	}
+	return OverflowSpill
+}
+
+// Broker fans the events of each thread out to any number of subscribers.
+// Publish never blocks; a subscriber that falls behind is handled by its
+// overflow policy so it cannot stall the agent or other subscribers.
+type Broker struct {
+	repo    xdb.XIDRepo
+	bufSize int
+	mu      sync.RWMutex
+	subs    map[string]map[*Subscriber]struct{}
+}
+
+func NewBroker(repo xdb.XIDRepo, bufSize int) *Broker {
+	if bufSize <= 0 {
+		bufSize = viper.GetInt("Chat.subscriber_buffer")
+	}
+	if bufSize <= 0 {
+		bufSize = defaultSubscriberBuffer
+	}
+	return &Broker{repo: repo, bufSize: bufSize, subs: make(map[string]map[*Subscriber]struct{})}
+}
+
+// Subscribe follows the live events of a thread.
+func (b *Broker) Subscribe(threadID string, policy OverflowPolicy) *Subscriber {
+	return b.subscribe(threadID, policy, 0, false)
+}
+
+// SubscribeFrom replays the stored events after the given event ID before
+// following the live ones. Without a store it behaves like Subscribe.
+func (b *Broker) SubscribeFrom(threadID string, policy OverflowPolicy, after int64) *Subscriber {
+	return b.subscribe(threadID, policy, after, b.repo != nil)
+}
+
+func (b *Broker) subscribe(threadID string, policy OverflowPolicy, after int64, replay bool) *Subscriber {
+	// spill 需要从存储补读
+	if policy == OverflowSpill && b.repo == nil {
+		policy = OverflowDropOldest
+	}
+	s := &Subscriber{
+		b:        b,
+		threadID: threadID,
+		policy:   policy,
+		ch:       make(chan ChatEvent, b.bufSize),
+		done:     make(chan struct{}),
+		lastID:   after,
+		spilled:  replay,
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[threadID] == nil {
+		b.subs[threadID] = make(map[*Subscriber]struct{})
+	}
+	b.subs[threadID][s] = struct{}{}
+	return s
+}
+
+func (b *Broker) unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs[s.threadID], s)
+	if len(b.subs[s.threadID]) == 0 {
+		delete(b.subs, s.threadID)
+	}
+}
+
+// Publish delivers ev to every subscriber of its thread.
+func (b *Broker) Publish(ev ChatEvent) {
+	brokerMetrics.Add("published", 1)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs[ev.ThreadID] {
+		s.offer(ev)
+	}
+}
+
+// Subscribers returns the number of subscribers following a thread.
+func (b *Broker) Subscribers(threadID string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs[threadID])
+}
+
+// Subscriber is one consumer of a thread's events, read with Next.
+type Subscriber struct {
+	b        *Broker
+	threadID string
+	policy   OverflowPolicy
+	ch       chan ChatEvent
+	done     chan struct{}
+
+	mu      sync.Mutex
+	spilled bool
+	err     error
+	dropped int64
+
+	// 只由读取方访问
+	lastID  int64
+	pending []ChatEvent
+}
+
+func (s *Subscriber) offer(ev ChatEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.spilled {
+		return
+	}
+	select {
+	case s.ch <- ev:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case OverflowDisconnect:
+		s.closeLocked(ErrSlowConsumer)
+		brokerMetrics.Add("disconnected", 1)
+		logx.Warnf("disconnect slow subscriber of thread %s", s.threadID)
+	case OverflowSpill:
+		s.spilled = true
+		brokerMetrics.Add("spilled", 1)
+	default:
+		select {
+		case <-s.ch:
+		default:
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+		s.dropped++
+		brokerMetrics.Add("dropped", 1)
+	}
+}
+
+// Next returns the next event, waiting until one is published, the
+// subscriber is closed or ctx is done.
+func (s *Subscriber) Next(ctx context.Context) (ChatEvent, error) {
+	for {
+		if len(s.pending) > 0 {
+			ev := s.pending[0]
+			s.pending = s.pending[1:]
+			if ev.ID <= s.lastID {
+				continue
+			}
+			s.lastID = ev.ID
+			return ev, nil
+		}
+
+		s.mu.Lock()
+		err, spilled := s.err, s.spilled
+		s.mu.Unlock()
+		if err != nil {
+			return ChatEvent{}, err
+		}
+
+		select {
+		case ev := <-s.ch:
+			if ev, ok := s.accept(ev); ok {
+				return ev, nil
+			}
+			continue
+		default:
+		}
+		if spilled {
+			if err := s.catchUp(ctx); err != nil {
+				return ChatEvent{}, err
+			}
+			continue
+		}
+
+		select {
+		case ev := <-s.ch:
+			if ev, ok := s.accept(ev); ok {
+				return ev, nil
+			}
+		case <-s.done:
+		case <-ctx.Done():
+			return ChatEvent{}, ctx.Err()
+		}
+	}
+}
+
+// accept 跳过补读时已经返回过的事件
+func (s *Subscriber) accept(ev ChatEvent) (ChatEvent, bool) {
+	if ev.ID != 0 {
+		if ev.ID <= s.lastID {
+			return ev, false
+		}
+		s.lastID = ev.ID
+	}
+	return ev, true
+}
+
+// catchUp reads one page of missed events from the store. Live delivery is
+// resumed before reading so nothing published meanwhile is lost; when the
+// page is full there is more to read, so live delivery is paused again and
+// the events it queued are discarded to be read from the store in order.
+func (s *Subscriber) catchUp(ctx context.Context) error {
+	const page = 500
+	s.mu.Lock()
+	s.spilled = false
+	s.mu.Unlock()
+
+	events, err := ListEvents(ctx, s.b.repo, s.threadID, s.lastID, page)
+	if err != nil {
+		return fmt.Errorf("failed to read missed events: %v", err)
+	}
+	if len(events) == page {
+		s.mu.Lock()
+		s.spilled = true
+		for len(s.ch) > 0 {
+			<-s.ch
+		}
+		s.mu.Unlock()
+	}
+	s.pending = events
+	return nil
+}
+
+// Events streams the subscriber's events until a final event, an error or
+// ctx is done. The subscriber is closed afterwards.
+func (s *Subscriber) Events(ctx context.Context) <-chan ChatEvent {
+	out := make(chan ChatEvent)
+	go func() {
+		defer close(out)
+		defer s.Close()
+		for {
+			ev, err := s.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logx.Warnf("subscriber of thread %s stopped: %v", s.threadID, err)
+				}
+				return
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+			if ev.Final() {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Dropped returns how many events the drop-oldest policy discarded.
+func (s *Subscriber) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *Subscriber) Close() {
+	s.b.unsubscribe(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked(context.Canceled)
+}
+
+func (s *Subscriber) closeLocked(err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+}
@@ -0,0 +1,222 @@
+package mcpchat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xid-protocol/xidp/internal/eventlog"
+	"github.com/xid-protocol/xidp/xdb"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+const (
+	testThread = "thread-1"
+	testBuffer = 8
+)
+
+// publisher stores events like SendToThread when it has a repo and
+// numbers them itself otherwise.
+type publisher struct {
+	b    *Broker
+	repo xdb.XIDRepo
+	seq  int64
+}
+
+func (p *publisher) publish(t *testing.T, content string) ChatEvent {
+	t.Helper()
+	ev := ChatEvent{ThreadID: testThread, Type: EventAnswer, Content: content}
+	if p.repo != nil {
+		e, err := eventlog.Append(context.Background(), p.repo, EventStream(testThread), ev.Type, ev)
+		if err != nil {
+			t.Fatalf("append event: %v", err)
+		}
+		ev.ID = e.ID
+	} else {
+		p.seq++
+		ev.ID = p.seq
+	}
+	p.b.Publish(ev)
+	return ev
+}
+
+func next(t *testing.T, s *Subscriber) (ChatEvent, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.Next(ctx)
+}
+
+// TestBrokerOverflow publishes more events than a buffer holds to a fast
+// subscriber, reading each event as it is published, and a slow one reading
+// only afterwards.
+func TestBrokerOverflow(t *testing.T) {
+	const n = 100
+	tests := []struct {
+		policy OverflowPolicy
+		// IDs the slow subscriber reads, nil when it is disconnected
+		slow    []int64
+		dropped int64
+	}{
+		{policy: OverflowDropOldest, slow: idRange(n-testBuffer+1, n), dropped: n - testBuffer},
+		{policy: OverflowDisconnect},
+		{policy: OverflowSpill, slow: idRange(1, n)},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			var repo xdb.XIDRepo
+			if tt.policy == OverflowSpill {
+				repo = xdbtest.New()
+			}
+			b := NewBroker(repo, testBuffer)
+			p := &publisher{b: b, repo: repo}
+			fast := b.Subscribe(testThread, tt.policy)
+			slow := b.Subscribe(testThread, tt.policy)
+			defer fast.Close()
+			defer slow.Close()
+
+			for i := 1; i <= n; i++ {
+				want := p.publish(t, "chunk")
+				got, err := next(t, fast)
+				if err != nil {
+					t.Fatalf("fast subscriber: %v", err)
+				}
+				if got.ID != want.ID {
+					t.Fatalf("fast subscriber got event %d, want %d", got.ID, want.ID)
+				}
+			}
+			if fast.Dropped() != 0 {
+				t.Errorf("fast subscriber dropped %d events", fast.Dropped())
+			}
+
+			if tt.slow == nil {
+				if _, err := next(t, slow); !errors.Is(err, ErrSlowConsumer) {
+					t.Fatalf("slow subscriber got %v, want ErrSlowConsumer", err)
+				}
+				// 断开后不再收到事件，其它订阅者不受影响
+				p.publish(t, "after")
+				if _, err := next(t, fast); err != nil {
+					t.Fatalf("fast subscriber after disconnect: %v", err)
+				}
+				return
+			}
+			for _, id := range tt.slow {
+				got, err := next(t, slow)
+				if err != nil {
+					t.Fatalf("slow subscriber: %v", err)
+				}
+				if got.ID != id {
+					t.Fatalf("slow subscriber got event %d, want %d", got.ID, id)
+				}
+			}
+			if slow.Dropped() != tt.dropped {
+				t.Errorf("slow subscriber dropped %d events, want %d", slow.Dropped(), tt.dropped)
+			}
+			// 追上后恢复实时推送
+			want := p.publish(t, "live")
+			got, err := next(t, slow)
+			if err != nil || got.ID != want.ID {
+				t.Fatalf("slow subscriber got %d, %v after catching up, want %d", got.ID, err, want.ID)
+			}
+		})
+	}
+}
+
+// TestBrokerConcurrent publishes while subscribers of every policy read at
+// their own pace. Run with -race.
+func TestBrokerConcurrent(t *testing.T) {
+	const n = 500
+	repo := xdbtest.New()
+	b := NewBroker(repo, testBuffer)
+	p := &publisher{b: b, repo: repo}
+
+	type result struct {
+		policy OverflowPolicy
+		ids    []int64
+		err    error
+	}
+	policies := []OverflowPolicy{OverflowDropOldest, OverflowDisconnect, OverflowSpill, OverflowSpill}
+	results := make([]result, len(policies))
+	subs := make([]*Subscriber, len(policies))
+	for i, policy := range policies {
+		subs[i] = b.Subscribe(testThread, policy)
+	}
+
+	var wg sync.WaitGroup
+	for i, s := range subs {
+		wg.Add(1)
+		go func(i int, s *Subscriber) {
+			defer wg.Done()
+			defer s.Close()
+			r := result{policy: policies[i]}
+			for {
+				ev, err := next(t, s)
+				if err != nil {
+					r.err = err
+					break
+				}
+				r.ids = append(r.ids, ev.ID)
+				if i%2 == 1 {
+					// 慢订阅者
+					time.Sleep(50 * time.Microsecond)
+				}
+				if ev.ID == n {
+					break
+				}
+			}
+			results[i] = r
+		}(i, s)
+	}
+	for i := 0; i < n; i++ {
+		p.publish(t, "chunk")
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if r.err != nil {
+			if r.policy == OverflowDisconnect && errors.Is(r.err, ErrSlowConsumer) {
+				continue
+			}
+			t.Errorf("%s subscriber: %v", r.policy, r.err)
+			continue
+		}
+		for j := 1; j < len(r.ids); j++ {
+			if r.ids[j] <= r.ids[j-1] {
+				t.Fatalf("%s subscriber got event %d after %d", r.policy, r.ids[j], r.ids[j-1])
+			}
+		}
+		if r.policy == OverflowSpill && len(r.ids) != n {
+			t.Errorf("spill subscriber got %d events, want %d", len(r.ids), n)
+		}
+	}
+	if got := b.Subscribers(testThread); got != 0 {
+		t.Errorf("%d subscribers left after close", got)
+	}
+}
+
+func TestSubscribeFromReplays(t *testing.T) {
+	repo := xdbtest.New()
+	b := NewBroker(repo, testBuffer)
+	p := &publisher{b: b, repo: repo}
+	for i := 0; i < 20; i++ {
+		p.publish(t, "chunk")
+	}
+	s := b.SubscribeFrom(testThread, OverflowSpill, 5)
+	defer s.Close()
+	for id := int64(6); id <= 20; id++ {
+		got, err := next(t, s)
+		if err != nil || got.ID != id {
+			t.Fatalf("got %d, %v, want %d", got.ID, err, id)
+		}
+	}
+}
+
+func idRange(from, to int64) []int64 {
+	out := make([]int64, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		out = append(out, i)
+	}
+	return out
+}
@@ -1,5 +1,11 @@
 package mcpchat
 
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
 // user http request body
 type ChatRequest struct {
 	ProjectName string `json:"projectName"`
@@ -9,3 +15,71 @@ type ChatRequest struct {
 	Content     string `json:"content"`
 	Type        string `json:"type"`
 }
+
+const (
+	EventProcessing  = "processing"
+	EventAgentStart  = "agent_start"
+	EventReasoning   = "reasoning"
+	EventToolRunning = "tool_running"
+	EventToolResult  = "tool_result"
+	EventAnswer      = "answer"
+	EventImage       = "image"
+	EventEnd         = "end"
+	EventCancelled   = "cancelled"
+
+	// 工具调用等待审批，content 为 ToolApprovalRequest
+	EventToolApproval = "tool_approval"
+)
+
+// Backend -> Frontend 输出消息
+type ChatEvent struct {
+	ID       int64  `json:"id" bson:"-"` // event log ID within the thread
+	ThreadID string `json:"threadID" bson:"threadID"`
+	Agent    string `json:"agent,omitempty" bson:"agent,omitempty"`
+	MsgID    string `json:"msgID,omitempty" bson:"msgID,omitempty"` // 用于标识消息的唯一ID
+	Content  string `json:"content,omitempty" bson:"content,omitempty"`
+	Type     string `json:"type" bson:"type"`
+}
+
+// Final reports whether no more events follow in this round.
+func (e ChatEvent) Final() bool {
+	return e.Type == EventEnd || e.Type == EventCancelled
+}
+
+// Handler 处理一轮用户输入，通过 tm 的 Send* 方法推送输出，ctx 在取消时结束
+type Handler func(ctx context.Context, tm *ThreadManager, threadID string, req ChatRequest) error
+
+var ErrNoHandler = errors.New("no chat agent is configured")
+
+var (
+	handlerMu sync.RWMutex
+	handler   Handler
+)
+
+// SetHandler 设置处理对话的 agent
+func SetHandler(h Handler) {
+	handlerMu.Lock()
+	defer handlerMu.Unlock()
+	handler = h
+}
+
+// Run 执行一轮对话并在结束时发送 end 事件
+func (tm *ThreadManager) Run(ctx context.Context, threadID string, req ChatRequest) {
+	handlerMu.RLock()
+	h := handler
+	handlerMu.RUnlock()
+	tm.RunWith(ctx, threadID, req, h)
+}
+
+// RunWith 用 h 执行一轮对话，结束时发送 end 事件并返回 h 的错误
+func (tm *ThreadManager) RunWith(ctx context.Context, threadID string, req ChatRequest, h Handler) error {
+	tm.SendStart(threadID)
+	var err error
+	if h == nil {
+		err = ErrNoHandler
+	} else {
+		err = h(ctx, tm, threadID, req)
+	}
+	tm.finishThread(threadID, err)
+	return err
+}
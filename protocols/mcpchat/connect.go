@@ -1,318 +1,441 @@
 package mcpchat
 
-// import (
-// 	"context"
-// 	"sync"
-// 	"time"
-
-// 	"github.com/google/uuid"
-// )
-
-// type Connection struct {
-// 	Ctx        context.Context    `json:"-"`
-// 	CancelFuc  context.CancelFunc `json:"-"`
-// 	EventChan  chan *ChatEvent    `json:"-"`
-// 	AgentID    string             `json:"agent_id"`
-// 	SourceAddr string             `json:"addr"`
-// 	CreateAt   int64              `json:"create_at"`
-
-// 	agentDetailLock  sync.RWMutex
-// 	agentDetail      map[string]interface{} `json:"agent_detail"`
-// 	pluginDetailLock sync.RWMutex
-// 	pluginDetail     map[string]map[string]interface{} `json:"plugin_detail"`
-// }
-
-// var (
-// 	connManager *ConnManager
-// 	once        sync.Once
-// )
-
-// // Backend -> Frontend 输出消息
-// type ChatEvent struct {
-// 	ThreadID string `json:"threadID"`
-// 	Agent    string `json:"agent,omitempty"`
-// 	MsgID    string `json:"msgID,omitempty"` // 用于标识消息的唯一ID
-// 	Content  string `json:"content,omitempty"`
-// 	Error    error  `json:"error,omitempty"`
-// 	Type     string `json:"type"`
-// }
-
-// // ThreadManager 管理每个线程的独立通道
-// type ThreadManager struct {
-// 	channels    map[string]chan ChatEvent     // threadID -> 响应通道
-// 	ctxMap      map[string]context.Context    // threadID -> 上下文
-// 	cancelMap   map[string]context.CancelFunc // threadID -> 取消函数
-// 	status      map[string]string             // threadID -> 状态 (running/cancelled/completed)
-// 	mu          sync.RWMutex
-// 	ChatRequest map[string]ChatRequest
-// }
-
-// // var threadManager = &ThreadManager{
-// // 	channels:    make(map[string]chan SSEEvent),
-// // 	connections: make(map[string]*gin.Context),
-// // 	contexts:    make(map[string]context.CancelFunc),
-// // 	status:      make(map[string]string),
-// // }
-
-// func newThreadManager() *ThreadManager {
-// 	return &ThreadManager{
-// 		channels:    make(map[string]chan ChatEvent),
-// 		cancelMap:   make(map[string]context.CancelFunc),
-// 		ctxMap:      make(map[string]context.Context),
-// 		status:      make(map[string]string),
-// 		ChatRequest: make(map[string]ChatRequest),
-// 	}
-// }
-
-// func ThreadMan() *ThreadManager {
-// 	once.Do(func() {
-// 		threadManager = newThreadManager()
-// 	})
-// 	return threadManager
-// }
-
-// // StartNewThread 开始新的thread
-// func (tm *ThreadManager) CreateThread(chatRequest ChatRequest) (ctx context.Context, ch chan ChatEvent, threadID string) {
-// 	ctx = context.Background()
-// 	threadID = uuid.NewString()
-
-// 	tm.mu.Lock()
-// 	defer tm.mu.Unlock()
-
-// 	// 创建响应通道
-// 	ch = make(chan ChatEvent, 50)
-// 	ctx, cancel := context.WithCancel(ctx)
-// 	// 注册thread
-// 	tm.channels[threadID] = ch
-// 	tm.ctxMap[threadID] = ctx
-// 	tm.cancelMap[threadID] = cancel
-// 	tm.status[threadID] = "running"
-// 	tm.ChatRequest[threadID] = chatRequest
-
-// 	return ctx, ch, threadID
-// }
-
-// // CancelThread 取消指定thread
-// func (tm *ThreadManager) CancelThread(threadID string) bool {
-// 	tm.mu.RLock()
-// 	cancel, ok := tm.cancelMap[threadID]
-// 	tm.mu.RUnlock()
-// 	if !ok {
-// 		return false // 没找到这个 thread
-// 	}
-
-// 	// 触发 ctx.Done()，让后台 goroutine 立刻退出
-// 	cancel()
-
-// 	// （可选）推送一条取消事件，前端立即显示
-// 	tm.SendToThread(threadID, ChatEvent{
-// 		ThreadID: threadID,
-// 		Agent:    "system",
-// 		Content:  "用户已取消",
-// 		Type:     "cancelled",
-// 	})
-// 	return true
-// }
-
-// // CompleteThread 标记thread完成
-// func (tm *ThreadManager) CompleteThread(threadID string) {
-// 	tm.mu.Lock()
-// 	defer tm.mu.Unlock()
-
-// 	if tm.status[threadID] == "running" {
-// 		tm.status[threadID] = "completed"
-// 	}
-// }
-
-// // CleanupThread 清理thread资源
-// func (tm *ThreadManager) CleanupThread(threadID string) {
-// 	tm.mu.Lock()
-// 	defer tm.mu.Unlock()
-
-// 	if cancel, ok := tm.cancelMap[threadID]; ok {
-// 		cancel()
-// 		delete(tm.cancelMap, threadID)
-// 	}
-
-// 	if ch, ok := tm.channels[threadID]; ok {
-// 		close(ch)
-// 		delete(tm.channels, threadID)
-// 	}
-
-// 	delete(tm.ctxMap, threadID)
-// 	delete(tm.ChatRequest, threadID)
-// 	delete(tm.status, threadID)
-// }
-
-// // SendToThread 发送消息到指定thread（检查是否已取消）
-// func (tm *ThreadManager) SendToThread(threadID string, event ChatEvent) bool {
-// 	tm.mu.RLock()
-// 	ch, exists := tm.channels[threadID]
-// 	status := tm.status[threadID]
-// 	tm.mu.RUnlock()
-
-// 	if !exists || status == "cancelled" {
-// 		return false
-// 	}
-
-// 	select {
-// 	case ch <- event:
-// 		return true
-// 	case <-time.After(1 * time.Second):
-// 		return false
-// 	}
-// }
-
-// // GetThreadStatus 获取thread状态
-// func (tm *ThreadManager) GetThreadStatus(threadID string) string {
-// 	tm.mu.RLock()
-// 	defer tm.mu.RUnlock()
-// 	return tm.status[threadID]
-// }
-
-// var (
-// 	threadManager *ThreadManager
-// 	once          sync.Once
-// )
-
-// // Backend -> Frontend 输出消息
-// type ChatEvent struct {
-// 	ThreadID string `json:"threadID"`
-// 	Agent    string `json:"agent,omitempty"`
-// 	MsgID    string `json:"msgID,omitempty"` // 用于标识消息的唯一ID
-// 	Content  string `json:"content,omitempty"`
-// 	Type     string `json:"type"`
-// }
-
-// // ThreadManager 管理每个线程的独立通道
-// type ThreadManager struct {
-// 	channels    map[string]chan ChatEvent     // threadID -> 响应通道
-// 	ctxMap      map[string]context.Context    // threadID -> 上下文
-// 	cancelMap   map[string]context.CancelFunc // threadID -> 取消函数
-// 	status      map[string]string             // threadID -> 状态 (running/cancelled/completed)
-// 	mu          sync.RWMutex
-// 	ChatRequest map[string]ChatRequest
-// }
-
-// // var threadManager = &ThreadManager{
-// // 	channels:    make(map[string]chan SSEEvent),
-// // 	connections: make(map[string]*gin.Context),
-// // 	contexts:    make(map[string]context.CancelFunc),
-// // 	status:      make(map[string]string),
-// // }
-
-// func newThreadManager() *ThreadManager {
-// 	return &ThreadManager{
-// 		channels:    make(map[string]chan ChatEvent),
-// 		cancelMap:   make(map[string]context.CancelFunc),
-// 		ctxMap:      make(map[string]context.Context),
-// 		status:      make(map[string]string),
-// 		ChatRequest: make(map[string]ChatRequest),
-// 	}
-// }
-
-// func ThreadMan() *ThreadManager {
-// 	once.Do(func() {
-// 		threadManager = newThreadManager()
-// 	})
-// 	return threadManager
-// }
-
-// // StartNewThread 开始新的thread
-// func (tm *ThreadManager) CreateThread(chatRequest ChatRequest) (ctx context.Context, ch chan ChatEvent, threadID string) {
-// 	ctx = context.Background()
-// 	threadID = uuid.NewString()
-
-// 	tm.mu.Lock()
-// 	defer tm.mu.Unlock()
-
-// 	// 创建响应通道
-// 	ch = make(chan ChatEvent, 50)
-// 	ctx, cancel := context.WithCancel(ctx)
-// 	// 注册thread
-// 	tm.channels[threadID] = ch
-// 	tm.ctxMap[threadID] = ctx
-// 	tm.cancelMap[threadID] = cancel
-// 	tm.status[threadID] = "running"
-// 	tm.ChatRequest[threadID] = chatRequest
-
-// 	return ctx, ch, threadID
-// }
-
-// // CancelThread 取消指定thread
-// func (tm *ThreadManager) CancelThread(threadID string) bool {
-// 	tm.mu.RLock()
-// 	cancel, ok := tm.cancelMap[threadID]
-// 	tm.mu.RUnlock()
-// 	if !ok {
-// 		return false // 没找到这个 thread
-// 	}
-
-// 	// 触发 ctx.Done()，让后台 goroutine 立刻退出
-// 	cancel()
-
-// 	// （可选）推送一条取消事件，前端立即显示
-// 	tm.SendToThread(threadID, ChatEvent{
-// 		ThreadID: threadID,
-// 		Agent:    "system",
-// 		Content:  "用户已取消",
-// 		Type:     "cancelled",
-// 	})
-// 	return true
-// }
-
-// // CompleteThread 标记thread完成
-// func (tm *ThreadManager) CompleteThread(threadID string) {
-// 	tm.mu.Lock()
-// 	defer tm.mu.Unlock()
-
-// 	if tm.status[threadID] == "running" {
-// 		tm.status[threadID] = "completed"
-// 	}
-// }
-
-// // CleanupThread 清理thread资源
-// func (tm *ThreadManager) CleanupThread(threadID string) {
-// 	tm.mu.Lock()
-// 	defer tm.mu.Unlock()
-
-// 	if cancel, ok := tm.cancelMap[threadID]; ok {
-// 		cancel()
-// 		delete(tm.cancelMap, threadID)
-// 	}
-
-// 	if ch, ok := tm.channels[threadID]; ok {
-// 		close(ch)
-// 		delete(tm.channels, threadID)
-// 	}
-
-// 	delete(tm.ctxMap, threadID)
-// 	delete(tm.ChatRequest, threadID)
-// 	delete(tm.status, threadID)
-// }
-
-// // SendToThread 发送消息到指定thread（检查是否已取消）
-// func (tm *ThreadManager) SendToThread(threadID string, event ChatEvent) bool {
-// 	tm.mu.RLock()
-// 	ch, exists := tm.channels[threadID]
-// 	status := tm.status[threadID]
-// 	tm.mu.RUnlock()
-
-// 	if !exists || status == "cancelled" {
-// 		return false
-// 	}
-
-// 	select {
-// 	case ch <- event:
-// 		return true
-// 	case <-time.After(1 * time.Second):
-// 		return false
-// 	}
-// }
-
-// // GetThreadStatus 获取thread状态
-// func (tm *ThreadManager) GetThreadStatus(threadID string) string {
-// 	tm.mu.RLock()
-// 	defer tm.mu.RUnlock()
-// 	return tm.status[threadID]
-// }
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/google/uuid"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/eventlog"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+var (
+	ErrThreadNotFound = errors.New("thread not found")
+	ErrThreadRunning  = errors.New("thread is still running")
+)
+
+// 已结束的 thread 空闲超过该时间后从内存中清理，之后继续对话会从存储中恢复
+var threadIdleTTL = time.Hour
+
+// 运行中的 thread 每隔该时间刷新一次 updatedAt，证明所在副本仍在执行，
+// 与是否有事件无关，须明显小于 threadStaleAfter
+var threadTouchInterval = 30 * time.Second
+
+type thread struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	status    mcptask.Status
+	taskID    string
+	seq       int64
+	answer    strings.Builder
+	requests  []ChatRequest
+	inputs    chan ChatRequest
+	approvals map[string]chan ToolDecision
+	lastUsed  time.Time
+}
+
+// ThreadManager 管理每个线程的运行状态，事件经 broker 分发给所有订阅者。
+// 配置了 repo 时 thread、消息和事件都会持久化，重启或换副本后可以继续对话和重放事件。
+type ThreadManager struct {
+	repo    xdb.XIDRepo
+	broker  *Broker
+	mu      sync.RWMutex
+	threads map[string]*thread
+}
+
+var (
+	threadManager *ThreadManager
+	once          sync.Once
+)
+
+func newThreadManager(repo xdb.XIDRepo) *ThreadManager {
+	return &ThreadManager{repo: repo, broker: NewBroker(repo, 0), threads: make(map[string]*thread)}
+}
+
+func ThreadMan() *ThreadManager {
+	once.Do(func() {
+		threadManager = newThreadManager(xdb.Default())
+	})
+	return threadManager
+}
+
+func (tm *ThreadManager) Repo() xdb.XIDRepo {
+	return tm.repo
+}
+
+// Broker 用于额外订阅 thread 的事件，例如审计日志
+func (tm *ThreadManager) Broker() *Broker {
+	return tm.broker
+}
+
+// StartThread 开始新的 thread，chatRequest.ThreadID 不为空时继续已有的 thread。
+// 返回的通道接收本轮的全部事件，直到 end 或 cancelled，ctx 结束时关闭。
+func (tm *ThreadManager) StartThread(ctx context.Context, chatRequest ChatRequest) (runCtx context.Context, ch <-chan ChatEvent, threadID string, err error) {
+	tm.mu.Lock()
+	tm.sweep()
+	threadID = chatRequest.ThreadID
+	t, ok := tm.threads[threadID]
+	tm.mu.Unlock()
+	if ok && t.status == mcptask.StatusRunning {
+		return nil, nil, "", ErrThreadRunning
+	}
+
+	taskID := chatRequest.TaskID
+	switch {
+	case threadID == "":
+		threadID = uuid.NewString()
+		if tm.repo != nil {
+			now := common.GetTimestamp()
+			err = saveThread(ctx, tm.repo, &ThreadRecord{
+				Thread: mcptask.Thread{
+					ThreadID:   threadID,
+					ThreadName: threadName(chatRequest.Content),
+					Status:     mcptask.StatusRunning,
+					Steps:      []mcptask.Step{},
+				},
+				TaskID:      taskID,
+				ProjectName: chatRequest.ProjectName,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			})
+		}
+	case tm.repo != nil:
+		taskID, err = tm.claim(ctx, threadID)
+	case !ok:
+		err = ErrThreadNotFound
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	chatRequest.ThreadID = threadID
+	chatRequest.TaskID = taskID
+	if tm.repo != nil {
+		if err := saveMessage(ctx, tm.repo, &Message{
+			MessageID: chatRequest.MessageID,
+			ThreadID:  threadID,
+			TaskID:    taskID,
+			Role:      RoleUser,
+			Content:   chatRequest.Content,
+			Type:      chatRequest.Type,
+		}); err != nil {
+			logx.Errorf("save message of thread %s: %v", threadID, err)
+		}
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	t, ok = tm.threads[threadID]
+	if !ok {
+		t = &thread{}
+		tm.threads[threadID] = t
+	} else if t.status == mcptask.StatusRunning {
+		// 没有 repo 时同一 thread 的并发请求在这里拦截
+		return nil, nil, "", ErrThreadRunning
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	t.inputs = make(chan ChatRequest, maxPendingInputs)
+	t.approvals = make(map[string]chan ToolDecision)
+	t.status = mcptask.StatusRunning
+	t.taskID = taskID
+	t.requests = append(t.requests, chatRequest)
+	t.lastUsed = time.Now()
+	if tm.repo != nil {
+		go tm.watchThread(t.ctx, threadID, cancelPollInterval, threadTouchInterval)
+	}
+	// 在 Run 发出第一个事件之前订阅
+	sub := tm.broker.Subscribe(threadID, defaultOverflowPolicy())
+	return t.ctx, sub.Events(ctx), threadID, nil
+}
+
+// claim 抢占存储中的 thread，返回其所属任务
+func (tm *ThreadManager) claim(ctx context.Context, threadID string) (string, error) {
+	rec, err := GetThread(ctx, tm.repo, threadID)
+	if err != nil {
+		return "", err
+	}
+	claimed, err := claimThread(ctx, tm.repo, threadID)
+	if err != nil {
+		return "", err
+	}
+	if !claimed {
+		return "", ErrThreadRunning
+	}
+	return rec.TaskID, nil
+}
+
+func threadName(content string) string {
+	r := []rune(strings.TrimSpace(content))
+	if len(r) > 50 {
+		return string(r[:50]) + "..."
+	}
+	return string(r)
+}
+
+// CancelThread 取消指定thread。thread 在其他副本运行时把取消请求写入存储，
+// 由该副本读取后取消；返回 false 表示没有运行中的 thread。
+func (tm *ThreadManager) CancelThread(ctx context.Context, threadID string) (bool, error) {
+	if tm.cancelLocal(threadID) {
+		return true, nil
+	}
+	if tm.repo == nil {
+		return false, nil
+	}
+	return requestCancel(ctx, tm.repo, threadID)
+}
+
+// cancelLocal 取消在本副本运行的 thread
+func (tm *ThreadManager) cancelLocal(threadID string) bool {
+	tm.mu.Lock()
+	t, ok := tm.threads[threadID]
+	if !ok || t.status != mcptask.StatusRunning {
+		tm.mu.Unlock()
+		return false
+	}
+	// 触发 ctx.Done()，让后台 goroutine 立刻退出
+	t.cancel()
+	tm.mu.Unlock()
+
+	tm.SendToThread(threadID, ChatEvent{
+		Agent:   "system",
+		Content: "用户已取消",
+		Type:    EventCancelled,
+	})
+	tm.closeRound(threadID, t, mcptask.StatusCancelled)
+	return true
+}
+
+// 运行中的 thread 按该间隔读取其他副本写入的取消请求
+var cancelPollInterval = time.Second
+
+// watchThread 在本轮结束前轮询存储中的取消请求，并定时刷新 updatedAt，
+// 等待审批或长时间的工具调用时 thread 也不会被视为失联
+func (tm *ThreadManager) watchThread(ctx context.Context, threadID string, pollInterval, touchInterval time.Duration) {
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	touch := time.NewTicker(touchInterval)
+	defer touch.Stop()
+	for {
+		select {
+		case <-poll.C:
+			rec, err := GetThread(ctx, tm.repo, threadID)
+			if err != nil {
+				if ctx.Err() == nil {
+					logx.Errorf("read cancel request of thread %s: %v", threadID, err)
+				}
+				continue
+			}
+			if rec.CancelRequested {
+				tm.cancelLocal(threadID)
+				return
+			}
+		case <-touch.C:
+			if err := updateThread(ctx, tm.repo, threadID, map[string]any{}); err != nil && ctx.Err() == nil {
+				logx.Errorf("%v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finishThread 标记本轮结束，取消的 thread 已经发送过 cancelled 事件
+func (tm *ThreadManager) finishThread(threadID string, err error) {
+	tm.mu.RLock()
+	t, ok := tm.threads[threadID]
+	cancelled := ok && t.ctx.Err() != nil
+	tm.mu.RUnlock()
+	if !ok || cancelled {
+		return
+	}
+
+	status := mcptask.StatusCompleted
+	if err != nil {
+		status = mcptask.StatusFailed
+		tm.SendError(threadID, err)
+	} else {
+		tm.SendEnd(threadID)
+	}
+	tm.closeRound(threadID, t, status)
+}
+
+// closeRound 结束本轮并保存 agent 的回答
+func (tm *ThreadManager) closeRound(threadID string, t *thread, status mcptask.Status) {
+	tm.mu.Lock()
+	t.cancel()
+	if t.status != mcptask.StatusRunning {
+		tm.mu.Unlock()
+		return
+	}
+	t.status = status
+	t.lastUsed = time.Now()
+	answer := t.answer.String()
+	t.answer.Reset()
+	taskID := t.taskID
+	tm.mu.Unlock()
+
+	if tm.repo == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if answer != "" {
+		if err := saveMessage(ctx, tm.repo, &Message{
+			ThreadID: threadID,
+			TaskID:   taskID,
+			Role:     RoleAssistant,
+			Content:  answer,
+			Type:     EventAnswer,
+		}); err != nil {
+			logx.Errorf("save answer of thread %s: %v", threadID, err)
+		}
+	}
+	if err := updateThread(ctx, tm.repo, threadID, map[string]any{"payload.status": status}); err != nil {
+		logx.Errorf("%v", err)
+	}
+}
+
+// CleanupThread 清理thread资源
+func (tm *ThreadManager) CleanupThread(threadID string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if t, ok := tm.threads[threadID]; ok {
+		t.cancel()
+		delete(tm.threads, threadID)
+	}
+}
+
+func (tm *ThreadManager) sweep() {
+	for id, t := range tm.threads {
+		if t.status != mcptask.StatusRunning && time.Since(t.lastUsed) > threadIdleTTL {
+			delete(tm.threads, id)
+		}
+	}
+}
+
+// SendToThread 持久化事件后发送到指定thread（检查是否已取消）
+func (tm *ThreadManager) SendToThread(threadID string, event ChatEvent) bool {
+	tm.mu.RLock()
+	t, exists := tm.threads[threadID]
+	running := exists && t.status == mcptask.StatusRunning
+	// 取消后只允许 cancelled 事件
+	if running && t.ctx.Err() != nil && event.Type != EventCancelled {
+		running = false
+	}
+	tm.mu.RUnlock()
+	if !running {
+		return false
+	}
+
+	event.ThreadID = threadID
+	if tm.repo != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		e, err := eventlog.Append(ctx, tm.repo, EventStream(threadID), event.Type, event)
+		cancel()
+		if err != nil {
+			logx.Errorf("persist event of thread %s: %v", threadID, err)
+		} else {
+			event.ID = e.ID
+		}
+	}
+
+	tm.mu.Lock()
+	if tm.repo == nil {
+		t.seq++
+		event.ID = t.seq
+	}
+	if event.Type == EventAnswer {
+		t.answer.WriteString(event.Content)
+	}
+	tm.mu.Unlock()
+
+	tm.broker.Publish(event)
+	return true
+}
+
+// Follow 重连 thread：先重放 after 之后的事件，thread 仍在运行时继续推送直到 end 或 cancelled。
+// 在本副本运行的 thread 直接订阅 broker，其他副本的 thread 轮询存储。
+func (tm *ThreadManager) Follow(ctx context.Context, threadID string, after int64, policy OverflowPolicy) (<-chan ChatEvent, error) {
+	tm.mu.RLock()
+	t, ok := tm.threads[threadID]
+	local := ok && t.status == mcptask.StatusRunning
+	tm.mu.RUnlock()
+	if local {
+		return tm.broker.SubscribeFrom(threadID, policy, after).Events(ctx), nil
+	}
+	if tm.repo == nil {
+		return nil, ErrThreadNotFound
+	}
+	rec, err := GetThread(ctx, tm.repo, threadID)
+	if err != nil {
+		return nil, err
+	}
+	live := rec.Status == mcptask.StatusRunning &&
+		common.GetTimestamp()-rec.UpdatedAt < threadStaleAfter.Milliseconds()
+
+	ch := make(chan ChatEvent)
+	go func() {
+		defer close(ch)
+		if !live {
+			for {
+				events, err := ListEvents(ctx, tm.repo, threadID, after, 500)
+				if err != nil {
+					logx.Errorf("replay thread %s: %v", threadID, err)
+					return
+				}
+				for _, e := range events {
+					select {
+					case ch <- e:
+						after = e.ID
+					case <-ctx.Done():
+						return
+					}
+				}
+				if len(events) < 500 {
+					return
+				}
+			}
+		}
+		for e := range eventlog.Subscribe(ctx, tm.repo, EventStream(threadID), after) {
+			ce, err := chatEvent(e)
+			if err != nil {
+				logx.Errorf("%v", err)
+				continue
+			}
+			select {
+			case ch <- ce:
+			case <-ctx.Done():
+				return
+			}
+			if ce.Final() {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// GetThreadStatus 获取thread状态
+func (tm *ThreadManager) GetThreadStatus(threadID string) mcptask.Status {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if t, ok := tm.threads[threadID]; ok {
+		return t.status
+	}
+	return ""
+}
+
+// History 返回 thread 在本副本收到的用户输入，完整历史见 ListMessages
+func (tm *ThreadManager) History(threadID string) []ChatRequest {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if t, ok := tm.threads[threadID]; ok {
+		return append([]ChatRequest(nil), t.requests...)
+	}
+	return nil
+}
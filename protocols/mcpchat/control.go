@@ -0,0 +1,194 @@
+package mcpchat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/notify"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+)
+
+// 运行中的一轮对话最多排队的追加输入
+const maxPendingInputs = 16
+
+var (
+	ErrNotRunning        = errors.New("thread is not running on this replica")
+	ErrInputQueueFull    = errors.New("too many pending inputs")
+	ErrNoPendingApproval = errors.New("no tool call is waiting for approval")
+)
+
+// ToolDecision is a user's answer to a tool call waiting for approval.
+type ToolDecision struct {
+	Approved  bool   `json:"approved" bson:"approved"`
+	Actor     string `json:"actor,omitempty" bson:"actor,omitempty"`
+	Comment   string `json:"comment,omitempty" bson:"comment,omitempty"`
+	DecidedAt int64  `json:"decidedAt" bson:"decidedAt"`
+}
+
+// PushInput 把用户在运行中追加的输入交给当前这一轮，由 agent 通过 Inputs 读取
+func (tm *ThreadManager) PushInput(ctx context.Context, req ChatRequest) error {
+	tm.mu.RLock()
+	t, ok := tm.threads[req.ThreadID]
+	running := ok && t.status == mcptask.StatusRunning && t.ctx.Err() == nil
+	var inputs chan ChatRequest
+	if running {
+		inputs = t.inputs
+		req.TaskID = t.taskID
+	}
+	tm.mu.RUnlock()
+	if !running {
+		return ErrNotRunning
+	}
+
+	select {
+	case inputs <- req:
+	default:
+		return ErrInputQueueFull
+	}
+	tm.mu.Lock()
+	t.requests = append(t.requests, req)
+	tm.mu.Unlock()
+	if tm.repo != nil {
+		if err := saveMessage(ctx, tm.repo, &Message{
+			MessageID: req.MessageID,
+			ThreadID:  req.ThreadID,
+			TaskID:    req.TaskID,
+			Role:      RoleUser,
+			Content:   req.Content,
+			Type:      req.Type,
+		}); err != nil {
+			logx.Errorf("save message of thread %s: %v", req.ThreadID, err)
+		}
+	}
+	return nil
+}
+
+// Inputs 返回当前这一轮收到的追加输入，thread 不在本副本运行时返回 nil
+func (tm *ThreadManager) Inputs(threadID string) <-chan ChatRequest {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if t, ok := tm.threads[threadID]; ok && t.status == mcptask.StatusRunning {
+		return t.inputs
+	}
+	return nil
+}
+
+// ToolApprovalRequest describes a tool call waiting for approval.
+type ToolApprovalRequest struct {
+	StepID    string         `json:"stepID"`
+	Tool      string         `json:"tool"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+}
+
+// WaitToolApproval blocks the agent until the user approves or rejects the
+// tool call of stepID, or ctx is done.
+func (tm *ThreadManager) WaitToolApproval(ctx context.Context, threadID, stepID string) (ToolDecision, error) {
+	return tm.waitApproval(ctx, threadID, stepID, nil)
+}
+
+// RequestToolApproval announces the tool call to the thread's subscribers
+// and the notifiers, then waits for the decision like WaitToolApproval.
+func (tm *ThreadManager) RequestToolApproval(ctx context.Context, threadID, agent string, req ToolApprovalRequest) (ToolDecision, error) {
+	return tm.waitApproval(ctx, threadID, req.StepID, func() {
+		content, _ := json.Marshal(req)
+		tm.SendToolApproval(threadID, agent, string(content))
+		args, _ := json.Marshal(req.Arguments)
+		notify.Send(ctx, "[xidp] tool approval requested", fmt.Sprintf(
+			"agent %s waits for approval to call %s\narguments: %s\nthread: %s\nstep: %s\napprove: POST /api/v1/chat/threads/%s/approve/%s",
+			agent, req.Tool, args, threadID, req.StepID, threadID, req.StepID))
+	})
+}
+
+// 其他副本收到的审批结果写入存储，等待中的副本按该间隔读取
+var approvalPollInterval = time.Second
+
+// waitApproval 先登记等待再调用 announce，避免审批结果早于登记到达
+func (tm *ThreadManager) waitApproval(ctx context.Context, threadID, stepID string, announce func()) (ToolDecision, error) {
+	ch := make(chan ToolDecision, 1)
+	tm.mu.Lock()
+	t, ok := tm.threads[threadID]
+	if !ok || t.status != mcptask.StatusRunning {
+		tm.mu.Unlock()
+		return ToolDecision{}, ErrNotRunning
+	}
+	t.approvals[stepID] = ch
+	tm.mu.Unlock()
+
+	defer func() {
+		tm.mu.Lock()
+		delete(t.approvals, stepID)
+		tm.mu.Unlock()
+	}()
+	var poll <-chan time.Time
+	if tm.repo != nil {
+		if err := saveApproval(ctx, tm.repo, threadID, stepID); err != nil {
+			return ToolDecision{}, err
+		}
+		defer func() {
+			if err := expireApproval(context.WithoutCancel(ctx), tm.repo, threadID, stepID); err != nil {
+				logx.Errorf("expire approval %s of thread %s: %v", stepID, threadID, err)
+			}
+		}()
+		ticker := time.NewTicker(approvalPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	if announce != nil {
+		announce()
+	}
+	for {
+		select {
+		case d := <-ch:
+			return d, nil
+		case <-poll:
+			a, err := getApproval(ctx, tm.repo, threadID, stepID)
+			if err != nil {
+				logx.Errorf("read approval %s of thread %s: %v", stepID, threadID, err)
+				continue
+			}
+			if a.Status == approvalDecided && a.Decision != nil {
+				return *a.Decision, nil
+			}
+		case <-ctx.Done():
+			return ToolDecision{}, ctx.Err()
+		}
+	}
+}
+
+// DecideTool 把审批结果交给等待中的工具调用。有存储时结果先写入存储，
+// thread 在其他副本运行时由该副本读取；同一工具调用只有第一个结果有效。
+func (tm *ThreadManager) DecideTool(ctx context.Context, threadID, stepID string, d ToolDecision) error {
+	if d.DecidedAt == 0 {
+		d.DecidedAt = common.GetTimestamp()
+	}
+	if tm.repo != nil {
+		ok, err := decideApproval(ctx, tm.repo, threadID, stepID, d)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNoPendingApproval
+		}
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	var ch chan ToolDecision
+	if t, ok := tm.threads[threadID]; ok {
+		ch = t.approvals[stepID]
+		delete(t.approvals, stepID)
+	}
+	if ch == nil {
+		if tm.repo == nil {
+			return ErrNoPendingApproval
+		}
+		return nil
+	}
+	ch <- d
+	return nil
+}
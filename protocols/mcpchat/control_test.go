@@ -0,0 +1,169 @@
+package mcpchat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+// TestDecideToolOnOtherReplica decides a tool call on a replica other than
+// the one running the thread.
+func TestDecideToolOnOtherReplica(t *testing.T) {
+	approvalPollInterval = 10 * time.Millisecond
+	repo := xdbtest.New()
+	owner, other := newThreadManager(repo), newThreadManager(repo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runCtx, _, threadID, err := owner.StartThread(ctx, ChatRequest{Content: "scan the web servers"})
+	if err != nil {
+		t.Fatalf("start thread: %v", err)
+	}
+
+	type result struct {
+		d   ToolDecision
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		d, err := owner.RequestToolApproval(runCtx, threadID, "agent", ToolApprovalRequest{StepID: "step-1", Tool: "nmap"})
+		done <- result{d, err}
+	}()
+
+	decision := ToolDecision{Approved: true, Actor: "alice"}
+	for {
+		err := other.DecideTool(ctx, threadID, "step-1", decision)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrNoPendingApproval) {
+			t.Fatalf("decide: %v", err)
+		}
+		// 审批请求尚未写入存储
+		time.Sleep(5 * time.Millisecond)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("wait for approval: %v", r.err)
+	}
+	if !r.d.Approved || r.d.Actor != "alice" {
+		t.Fatalf("decision = %+v, want approved by alice", r.d)
+	}
+	if err := other.DecideTool(ctx, threadID, "step-1", decision); !errors.Is(err, ErrNoPendingApproval) {
+		t.Fatalf("second decision got %v, want ErrNoPendingApproval", err)
+	}
+}
+
+func TestDecideToolExpired(t *testing.T) {
+	repo := xdbtest.New()
+	tm := newThreadManager(repo)
+	ctx := context.Background()
+	runCtx, _, threadID, err := tm.StartThread(ctx, ChatRequest{Content: "hi"})
+	if err != nil {
+		t.Fatalf("start thread: %v", err)
+	}
+	waitCtx, cancel := context.WithCancel(runCtx)
+	done := make(chan error, 1)
+	go func() {
+		_, err := tm.WaitToolApproval(waitCtx, threadID, "step-1")
+		done <- err
+	}()
+	for {
+		if a, err := getApproval(ctx, repo, threadID, "step-1"); err == nil && a.Status == approvalPending {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait got %v, want context.Canceled", err)
+	}
+	if err := tm.DecideTool(ctx, threadID, "step-1", ToolDecision{Approved: true, Actor: "alice"}); !errors.Is(err, ErrNoPendingApproval) {
+		t.Fatalf("decision after the wait ended got %v, want ErrNoPendingApproval", err)
+	}
+}
+
+// TestCancelThreadOnOtherReplica cancels a thread from a replica other than
+// the one running it.
+func TestCancelThreadOnOtherReplica(t *testing.T) {
+	cancelPollInterval = 10 * time.Millisecond
+	repo := xdbtest.New()
+	owner, other := newThreadManager(repo), newThreadManager(repo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runCtx, events, threadID, err := owner.StartThread(ctx, ChatRequest{Content: "scan the web servers"})
+	if err != nil {
+		t.Fatalf("start thread: %v", err)
+	}
+	ok, err := other.CancelThread(ctx, threadID)
+	if err != nil || !ok {
+		t.Fatalf("cancel = %v, %v, want true", ok, err)
+	}
+	select {
+	case <-runCtx.Done():
+	case <-ctx.Done():
+		t.Fatal("owner did not cancel the thread")
+	}
+	for e := range events {
+		if e.Type == EventCancelled {
+			break
+		}
+	}
+	rec, err := GetThread(ctx, repo, threadID)
+	if err != nil {
+		t.Fatalf("get thread: %v", err)
+	}
+	if rec.Status != mcptask.StatusCancelled {
+		t.Fatalf("status = %s, want %s", rec.Status, mcptask.StatusCancelled)
+	}
+	if ok, err := other.CancelThread(ctx, threadID); err != nil || ok {
+		t.Fatalf("second cancel = %v, %v, want false", ok, err)
+	}
+
+	// 下一轮不受上一轮的取消请求影响
+	runCtx, _, _, err = other.StartThread(ctx, ChatRequest{ThreadID: threadID, Content: "again"})
+	if err != nil {
+		t.Fatalf("continue thread: %v", err)
+	}
+	time.Sleep(5 * cancelPollInterval)
+	if runCtx.Err() != nil {
+		t.Fatal("new round was cancelled")
+	}
+}
+
+// TestCancelIdleThreadOnOtherReplica cancels a round that sent no event for
+// longer than the stale window, e.g. while it waits on an approval.
+func TestCancelIdleThreadOnOtherReplica(t *testing.T) {
+	cancelPollInterval, threadTouchInterval = 10*time.Millisecond, 20*time.Millisecond
+	staleAfter := threadStaleAfter
+	threadStaleAfter = 100 * time.Millisecond
+	defer func() { threadStaleAfter = staleAfter }()
+	repo := xdbtest.New()
+	owner, other := newThreadManager(repo), newThreadManager(repo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runCtx, _, threadID, err := owner.StartThread(ctx, ChatRequest{Content: "scan the web servers"})
+	if err != nil {
+		t.Fatalf("start thread: %v", err)
+	}
+	time.Sleep(3 * threadStaleAfter)
+
+	if _, _, _, err := other.StartThread(ctx, ChatRequest{ThreadID: threadID, Content: "again"}); !errors.Is(err, ErrThreadRunning) {
+		t.Fatalf("claim of an idle round got %v, want ErrThreadRunning", err)
+	}
+	ok, err := other.CancelThread(ctx, threadID)
+	if err != nil || !ok {
+		t.Fatalf("cancel = %v, %v, want true", ok, err)
+	}
+	select {
+	case <-runCtx.Done():
+	case <-ctx.Done():
+		t.Fatal("owner did not cancel the thread")
+	}
+}
@@ -0,0 +1,343 @@
+package mcpchat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/eventlog"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/protocols/mcptask"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	PathThread   = "/protocols/mcpchat/thread"
+	PathMessage  = "/protocols/mcpchat/message"
+	PathApproval = "/protocols/mcpchat/approval"
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// 运行中的 thread 超过该时间没有事件，视为所在副本已退出，可以被其他副本接管
+var threadStaleAfter = 2 * time.Minute
+
+// ThreadRecord is the stored mcptask.Thread behind a chat thread.
+// path /protocols/mcpchat/thread
+type ThreadRecord struct {
+	mcptask.Thread `bson:",inline"`
+	TaskID         string `json:"taskID,omitempty" bson:"taskID,omitempty"`
+	ProjectName    string `json:"projectName,omitempty" bson:"projectName,omitempty"`
+	// 其他副本请求取消本轮，由运行 thread 的副本读取后取消
+	CancelRequested bool  `json:"cancelRequested,omitempty" bson:"cancelRequested,omitempty"`
+	CreatedAt       int64 `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       int64 `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Message is one user input or the agent's answer to it.
+// path /protocols/mcpchat/message
+type Message struct {
+	MessageID string `json:"messageID" bson:"messageID"`
+	ThreadID  string `json:"threadID" bson:"threadID"`
+	TaskID    string `json:"taskID,omitempty" bson:"taskID,omitempty"`
+	Role      string `json:"role" bson:"role"`
+	Content   string `json:"content" bson:"content"`
+	Type      string `json:"type,omitempty" bson:"type,omitempty"`
+	CreatedAt int64  `json:"createdAt" bson:"createdAt"`
+}
+
+// EventStream is the event log stream holding the ChatEvents of a thread.
+func EventStream(threadID string) string {
+	return "chat:" + threadID
+}
+
+func saveThread(ctx context.Context, repo xdb.XIDRepo, t *ThreadRecord) error {
+	info := protocols.NewInfo(t.ThreadID, "thread_id")
+	meta := protocols.NewMetadata(protocols.OperationCreate, PathThread, "application/json")
+	card := protocols.NewXID[any](&info, &meta, t)
+	if err := repo.Upsert(ctx, card.Xid, PathThread, card); err != nil {
+		return fmt.Errorf("failed to store thread: %v", err)
+	}
+	return nil
+}
+
+func GetThread(ctx context.Context, repo xdb.XIDRepo, threadID string) (*ThreadRecord, error) {
+	doc, err := repo.FindByXid(ctx, protocols.GenerateXid(threadID), PathThread)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrThreadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t ThreadRecord
+	if err := xdb.DecodePayload(doc, &t); err != nil {
+		return nil, fmt.Errorf("failed to decode thread %s: %v", threadID, err)
+	}
+	return &t, nil
+}
+
+// ListThreads returns one page of threads, most recently active first. An
+// empty taskID lists threads of all tasks.
+func ListThreads(ctx context.Context, repo xdb.XIDRepo, taskID string, pageSize int, cursor string) ([]*ThreadRecord, string, error) {
+	q := xdb.Query{Path: PathThread, SortBy: "payload.updatedAt", PageSize: pageSize}
+	if taskID != "" {
+		q.Where = map[string]any{"payload.taskID": taskID}
+	}
+	if cursor != "" {
+		q.AfterCursor = &cursor
+	}
+	docs, next, err := repo.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	out := make([]*ThreadRecord, 0, len(docs))
+	for _, doc := range docs {
+		var t ThreadRecord
+		if err := xdb.DecodePayload(doc, &t); err != nil {
+			return nil, "", fmt.Errorf("failed to decode thread %s: %v", doc.Xid, err)
+		}
+		out = append(out, &t)
+	}
+	return out, next, nil
+}
+
+// claimThread marks a stored thread running for another round. It fails
+// while the thread runs elsewhere, unless that run went stale.
+func claimThread(ctx context.Context, repo xdb.XIDRepo, threadID string) (bool, error) {
+	now := common.GetTimestamp()
+	return repo.UpdateFieldsIf(ctx, protocols.GenerateXid(threadID), PathThread, map[string]any{
+		"$or": []map[string]any{
+			{"payload.status": map[string]any{"$ne": mcptask.StatusRunning}},
+			{"payload.updatedAt": map[string]any{"$lt": now - threadStaleAfter.Milliseconds()}},
+		},
+	}, map[string]any{
+		"payload.status":          mcptask.StatusRunning,
+		"payload.cancelRequested": false,
+		"payload.updatedAt":       now,
+	})
+}
+
+// requestCancel flags the current round of a thread running on another
+// replica and reports whether the thread was running there. The replica
+// refreshes updatedAt while the round runs, however long it waits, so only
+// rounds of a replica that is gone count as stale.
+func requestCancel(ctx context.Context, repo xdb.XIDRepo, threadID string) (bool, error) {
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(threadID), PathThread, map[string]any{
+		"payload.status":    mcptask.StatusRunning,
+		"payload.updatedAt": map[string]any{"$gte": common.GetTimestamp() - threadStaleAfter.Milliseconds()},
+	}, map[string]any{
+		"payload.cancelRequested": true,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to request cancel of thread %s: %v", threadID, err)
+	}
+	return ok, nil
+}
+
+func updateThread(ctx context.Context, repo xdb.XIDRepo, threadID string, fields map[string]any) error {
+	fields["payload.updatedAt"] = common.GetTimestamp()
+	if err := repo.UpdateFields(ctx, protocols.GenerateXid(threadID), PathThread, fields); err != nil {
+		return fmt.Errorf("failed to update thread %s: %v", threadID, err)
+	}
+	return nil
+}
+
+func saveMessage(ctx context.Context, repo xdb.XIDRepo, m *Message) error {
+	if m.MessageID == "" {
+		m.MessageID = common.GenerateID()
+	}
+	if m.CreatedAt == 0 {
+		m.CreatedAt = common.GetTimestamp()
+	}
+	info := protocols.NewInfo(m.ThreadID+"/"+m.MessageID, "message_id")
+	meta := protocols.NewMetadata(protocols.OperationCreate, PathMessage, "application/json")
+	if err := repo.Insert(ctx, protocols.NewXID[any](&info, &meta, m)); err != nil {
+		return fmt.Errorf("failed to store message: %v", err)
+	}
+	return nil
+}
+
+// RecordStep stores a step of the thread, replacing the step with the same
+// stepID or appending it, and publishes the step to the thread's step event
+// stream.
+func RecordStep(ctx context.Context, repo xdb.XIDRepo, threadID string, step mcptask.Step) error {
+	step.ThreadID = threadID
+	xid := protocols.GenerateXid(threadID)
+	// 以 steps 的下标和长度做 CAS，并发写入时重读后重试
+	for attempt := 0; ; attempt++ {
+		t, err := GetThread(ctx, repo, threadID)
+		if err != nil {
+			return err
+		}
+		var cond, fields map[string]any
+		i := slices.IndexFunc(t.Steps, func(s mcptask.Step) bool { return s.StepID == step.StepID })
+		switch {
+		case i >= 0:
+			key := fmt.Sprintf("payload.steps.%d", i)
+			cond = map[string]any{key + ".stepID": step.StepID}
+			fields = map[string]any{key: step}
+		case len(t.Steps) == 0:
+			cond = map[string]any{"$or": []map[string]any{
+				{"payload.steps": nil},
+				{"payload.steps": map[string]any{"$size": 0}},
+			}}
+			fields = map[string]any{"payload.steps": []mcptask.Step{step}}
+		default:
+			cond = map[string]any{"payload.steps": map[string]any{"$size": len(t.Steps)}}
+			fields = map[string]any{fmt.Sprintf("payload.steps.%d", len(t.Steps)): step}
+		}
+		fields["payload.updatedAt"] = common.GetTimestamp()
+		ok, err := repo.UpdateFieldsIf(ctx, xid, PathThread, cond, fields)
+		if err != nil {
+			return fmt.Errorf("failed to record step %s: %v", step.StepID, err)
+		}
+		if ok {
+			break
+		}
+		if attempt >= 5 {
+			return fmt.Errorf("failed to record step %s: thread %s keeps changing", step.StepID, threadID)
+		}
+	}
+
+	_, err := mcptask.PublishStepEvent(ctx, repo, threadID, mcptask.StepEvent{
+		StepID:     step.StepID,
+		StepName:   step.StepName,
+		WorkerID:   step.WorkerID,
+		WorkerName: step.WorkerName,
+		DataType:   "step",
+		Data: map[string]any{
+			"status": step.Status,
+			"params": step.Params,
+			"result": step.Result,
+			"error":  step.Error,
+		},
+	})
+	return err
+}
+
+// ListMessages returns the whole conversation of a thread, oldest first.
+func ListMessages(ctx context.Context, repo xdb.XIDRepo, threadID string) ([]*Message, error) {
+	out := []*Message{}
+	var cursor *string
+	for {
+		docs, next, err := repo.List(ctx, xdb.Query{
+			Path:        PathMessage,
+			Where:       map[string]any{"payload.threadID": threadID},
+			SortBy:      "payload.createdAt",
+			SortAsc:     true,
+			PageSize:    500,
+			AfterCursor: cursor,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var m Message
+			if err := xdb.DecodePayload(doc, &m); err != nil {
+				return nil, fmt.Errorf("failed to decode message %s: %v", doc.Xid, err)
+			}
+			out = append(out, &m)
+		}
+		if next == "" {
+			return out, nil
+		}
+		cursor = &next
+	}
+}
+
+// ListEvents returns up to limit stored events of a thread after the given
+// event ID.
+func ListEvents(ctx context.Context, repo xdb.XIDRepo, threadID string, after int64, limit int) ([]ChatEvent, error) {
+	events, err := eventlog.Since(ctx, repo, EventStream(threadID), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ChatEvent, 0, len(events))
+	for _, e := range events {
+		ce, err := chatEvent(e)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ce)
+	}
+	return out, nil
+}
+
+func chatEvent(e *eventlog.Event) (ChatEvent, error) {
+	var ce ChatEvent
+	if err := e.Decode(&ce); err != nil {
+		return ce, fmt.Errorf("failed to decode chat event %s#%d: %v", e.Stream, e.ID, err)
+	}
+	ce.ID = e.ID
+	return ce, nil
+}
+
+const (
+	approvalPending = "pending"
+	approvalDecided = "decided"
+	// 等待结束前没有结果，之后的审批无效
+	approvalExpired = "expired"
+)
+
+// ApprovalRecord is a tool call waiting for a decision. Decisions go through
+// the card, so a replica other than the one running the thread can take them.
+// path /protocols/mcpchat/approval
+type ApprovalRecord struct {
+	ThreadID  string        `json:"threadID" bson:"threadID"`
+	StepID    string        `json:"stepID" bson:"stepID"`
+	Status    string        `json:"status" bson:"status"`
+	Decision  *ToolDecision `json:"decision,omitempty" bson:"decision,omitempty"`
+	CreatedAt int64         `json:"createdAt" bson:"createdAt"`
+}
+
+func approvalID(threadID, stepID string) string {
+	return threadID + "/" + stepID
+}
+
+func saveApproval(ctx context.Context, repo xdb.XIDRepo, threadID, stepID string) error {
+	a := &ApprovalRecord{ThreadID: threadID, StepID: stepID, Status: approvalPending, CreatedAt: common.GetTimestamp()}
+	info := protocols.NewInfo(approvalID(threadID, stepID), "approval_id")
+	meta := protocols.NewMetadata(protocols.OperationCreate, PathApproval, "application/json")
+	card := protocols.NewXID[any](&info, &meta, a)
+	if err := repo.Upsert(ctx, card.Xid, PathApproval, card); err != nil {
+		return fmt.Errorf("failed to store approval: %v", err)
+	}
+	return nil
+}
+
+func getApproval(ctx context.Context, repo xdb.XIDRepo, threadID, stepID string) (*ApprovalRecord, error) {
+	doc, err := repo.FindByXid(ctx, protocols.GenerateXid(approvalID(threadID, stepID)), PathApproval)
+	if err != nil {
+		return nil, err
+	}
+	var a ApprovalRecord
+	if err := xdb.DecodePayload(doc, &a); err != nil {
+		return nil, fmt.Errorf("failed to decode approval %s: %v", approvalID(threadID, stepID), err)
+	}
+	return &a, nil
+}
+
+// decideApproval stores d on a pending approval and reports whether one was
+// pending.
+func decideApproval(ctx context.Context, repo xdb.XIDRepo, threadID, stepID string, d ToolDecision) (bool, error) {
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(approvalID(threadID, stepID)), PathApproval,
+		map[string]any{"payload.status": approvalPending},
+		map[string]any{"payload.status": approvalDecided, "payload.decision": d})
+	if err != nil {
+		return false, fmt.Errorf("failed to store decision: %v", err)
+	}
+	return ok, nil
+}
+
+func expireApproval(ctx context.Context, repo xdb.XIDRepo, threadID, stepID string) error {
+	_, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(approvalID(threadID, stepID)), PathApproval,
+		map[string]any{"payload.status": approvalPending},
+		map[string]any{"payload.status": approvalExpired})
+	return err
+}
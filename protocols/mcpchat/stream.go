@@ -0,0 +1,71 @@
+package mcpchat
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/llm"
+)
+
+// 流式输出攒够一定长度或时间再发送，避免每个 token 写一条事件
+const (
+	streamFlushSize     = 256
+	streamFlushInterval = 150 * time.Millisecond
+)
+
+// Stream forwards the streamed output of one model call to a thread:
+// reasoning chunks become reasoning events and text chunks answer events,
+// all under one msgID. Tool calls are left to the caller, which sends
+// tool_running when it actually runs them.
+type Stream struct {
+	tm       *ThreadManager
+	threadID string
+	agent    string
+	msgID    string
+
+	kind      string
+	buf       strings.Builder
+	flushedAt time.Time
+}
+
+func (tm *ThreadManager) NewStream(threadID, agent string) *Stream {
+	return &Stream{tm: tm, threadID: threadID, agent: agent, msgID: common.GenerateID(), flushedAt: time.Now()}
+}
+
+// OnChunk is passed to llm.Provider.Stream.
+func (s *Stream) OnChunk(c llm.Chunk) error {
+	var kind string
+	switch c.Type {
+	case llm.ChunkReasoning:
+		kind = EventReasoning
+	case llm.ChunkText:
+		kind = EventAnswer
+	default:
+		return nil
+	}
+	if kind != s.kind {
+		s.Flush()
+		s.kind = kind
+	}
+	s.buf.WriteString(c.Text)
+	if s.buf.Len() >= streamFlushSize || time.Since(s.flushedAt) >= streamFlushInterval {
+		s.Flush()
+	}
+	return nil
+}
+
+// Flush sends the buffered output. Call it when the model call returns.
+func (s *Stream) Flush() {
+	s.flushedAt = time.Now()
+	if s.buf.Len() == 0 {
+		return
+	}
+	s.tm.SendToThread(s.threadID, ChatEvent{
+		Agent:   s.agent,
+		MsgID:   s.msgID,
+		Content: s.buf.String(),
+		Type:    s.kind,
+	})
+	s.buf.Reset()
+}
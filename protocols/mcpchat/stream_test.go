@@ -0,0 +1,63 @@
+package mcpchat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xid-protocol/xidp/internal/llm"
+)
+
+// TestStream streams a mock model call into a thread: reasoning chunks
+// become reasoning events and text chunks answer events of one message.
+func TestStream(t *testing.T) {
+	tm := newThreadManager(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, events, threadID, err := tm.StartThread(ctx, ChatRequest{Content: "which ports are open"})
+	if err != nil {
+		t.Fatalf("start thread: %v", err)
+	}
+
+	mock := llm.NewMock(llm.Response{Message: llm.Message{Content: "port 22 is open to the internet"}})
+	mock.Reasoning = "look at the security groups first"
+	s := tm.NewStream(threadID, "agent")
+	if _, err := mock.Stream(ctx, &llm.Request{Messages: []llm.Message{{Role: llm.RoleUser, Content: "which ports are open"}}}, s.OnChunk); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	s.Flush()
+	tm.SendEnd(threadID)
+
+	// 按缓冲刷新，同类事件可能被拆成多条
+	var kinds []string
+	content := map[string]*strings.Builder{}
+	msgIDs := map[string]bool{}
+	for e := range events {
+		if e.Final() {
+			break
+		}
+		if e.Type != EventReasoning && e.Type != EventAnswer {
+			continue
+		}
+		if n := len(kinds); n == 0 || kinds[n-1] != e.Type {
+			kinds = append(kinds, e.Type)
+			content[e.Type] = &strings.Builder{}
+		}
+		content[e.Type].WriteString(e.Content)
+		msgIDs[e.MsgID] = true
+	}
+
+	if want := []string{EventReasoning, EventAnswer}; strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Fatalf("event types = %v, want %v", kinds, want)
+	}
+	if got := content[EventReasoning].String(); got != "look at the security groups first" {
+		t.Errorf("reasoning = %q", got)
+	}
+	if got := content[EventAnswer].String(); got != "port 22 is open to the internet" {
+		t.Errorf("answer = %q", got)
+	}
+	if len(msgIDs) != 1 || msgIDs[""] {
+		t.Errorf("events carry message IDs %v, want one", msgIDs)
+	}
+}
@@ -11,6 +11,8 @@ const (
 	StatusFailed    Status = "failed"
 	StatusCancelled Status = "cancelled"
 	StatusTimeout   Status = "timeout"
+	// 工具调用等待人工审批
+	StatusWaiting Status = "waiting"
 )
 
 type StepEvent struct {
@@ -33,6 +35,18 @@ type Step struct {
 	Status     Status         `json:"status" bson:"status"`
 	Result     map[string]any `json:"result" bson:"result"`
 	Error      string         `json:"error" bson:"error"`
+	// decision on a tool call, set for tool steps of agents
+	Approval *Approval `json:"approval,omitempty" bson:"approval,omitempty"`
+}
+
+// Approval records how a tool call passed the agent's approval policy.
+// Actor is empty when the policy decided without an approver.
+type Approval struct {
+	Policy    string `json:"policy" bson:"policy"`
+	Approved  bool   `json:"approved" bson:"approved"`
+	Actor     string `json:"actor,omitempty" bson:"actor,omitempty"`
+	Comment   string `json:"comment,omitempty" bson:"comment,omitempty"`
+	DecidedAt int64  `json:"decidedAt" bson:"decidedAt"`
 }
 
 // One thread per page, each thread contains multiple steps
@@ -1 +1,29 @@
 package mcptask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xid-protocol/xidp/internal/eventlog"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+var ErrInvalidEvent = errors.New("invalid step event")
+
+// ThreadEventStream is the event log stream holding the step events of a thread.
+func ThreadEventStream(threadID string) string {
+	return "thread:" + threadID
+}
+
+// PublishStepEvent appends a step event emitted by a worker to the thread's stream.
+func PublishStepEvent(ctx context.Context, repo xdb.XIDRepo, threadID string, ev StepEvent) (*eventlog.Event, error) {
+	if threadID == "" || ev.StepID == "" || ev.DataType == "" {
+		return nil, fmt.Errorf("%w: threadID, stepID and dataType are required", ErrInvalidEvent)
+	}
+	if ev.ThreadID != "" && ev.ThreadID != threadID {
+		return nil, fmt.Errorf("%w: event belongs to thread %s", ErrInvalidEvent, ev.ThreadID)
+	}
+	ev.ThreadID = threadID
+	return eventlog.Append(ctx, repo, ThreadEventStream(threadID), ev.DataType, ev)
+}
@@ -1,5 +1,17 @@
 package securityevent
 
+import "errors"
+
+const PathAttackEvent = "/protocols/security_event/attack"
+
+var (
+	ErrAttackEventExists   = errors.New("attack event already exists")
+	ErrAttackEventNotFound = errors.New("attack event not found")
+	ErrInvalidAttackEvent  = errors.New("invalid attack event")
+	// 攻击已结束，状态不能再改变
+	ErrAttackFinished = errors.New("attack already finished")
+)
+
 type AttackGraph struct {
 	TaskID      string `json:"event_id" bson:"eventID"`
 	ThreadID    string `json:"thread_id" bson:"threadID"`
@@ -21,10 +33,53 @@ const (
 	AttackStatusSucceeded AttackStatus = "succeeded"
 )
 
+// Valid reports whether s is a known status.
+func (s AttackStatus) Valid() bool {
+	switch s {
+	case AttackStatusAttacking, AttackStatusFailed, AttackStatusCancelled, AttackStatusTimeout, AttackStatusSucceeded:
+		return true
+	}
+	return false
+}
+
+// Finished reports whether the attack reached a final status.
+func (s AttackStatus) Finished() bool {
+	return s.Valid() && s != AttackStatusAttacking
+}
+
+// AttackEvent is one step of an attack chain against an asset. ParentID
+// links it to the step it was reached from, an event without parent is an
+// entry point.
+// path /protocols/security_event/attack
 type AttackEvent struct {
 	AttackID   string       `json:"attack_id" bson:"attackID"`
 	AttackName string       `json:"attack_name" bson:"attackName"`
 	ParentID   string       `json:"parent_id" bson:"parentID"`
 	Targets    string       `json:"targets" bson:"targets"`
 	Status     AttackStatus `json:"status" bson:"status"`
+	// 被攻击的资产及其 xid，按 asset_xid 关联资产的其他卡片
+	Asset     string `json:"asset" bson:"asset"`
+	AssetXid  string `json:"asset_xid" bson:"assetXid"`
+	TaskID    string `json:"task_id,omitempty" bson:"taskID,omitempty"`
+	ThreadID  string `json:"thread_id,omitempty" bson:"threadID,omitempty"`
+	Technique string `json:"technique,omitempty" bson:"technique,omitempty"` // e.g. a MITRE ATT&CK ID
+	Detail    string `json:"detail,omitempty" bson:"detail,omitempty"`
+	CreatedAt int64  `json:"created_at" bson:"createdAt"`
+	UpdatedAt int64  `json:"updated_at" bson:"updatedAt"`
+}
+
+// Edge links a parent attack event to the event reached from it.
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph holds the attack events of a task or thread with their links.
+type Graph struct {
+	TaskID   string         `json:"task_id,omitempty"`
+	ThreadID string         `json:"thread_id,omitempty"`
+	Events   []*AttackEvent `json:"events"`
+	Edges    []Edge         `json:"edges"`
+	// entry points, events whose parent is not part of the graph
+	Roots []string `json:"roots"`
 }
@@ -0,0 +1,233 @@
+package securityevent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Format string
+
+const (
+	FormatDOT     Format = "dot"
+	FormatMermaid Format = "mermaid"
+	FormatSTIX    Format = "stix"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format, use dot, mermaid or stix")
+
+// STIX 对象 ID 由事件和资产按 UUIDv5 生成，重复导出得到相同的 ID
+var stixNamespace = uuid.MustParse("6f1c7f3e-3c55-4e8e-9a55-2b8a1c3e9d10")
+
+// ContentType is the media type of an export in format f.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatDOT:
+		return "text/vnd.graphviz; charset=utf-8"
+	case FormatSTIX:
+		return "application/stix+json;version=2.1"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Export writes g to w in format f.
+func Export(w io.Writer, g *Graph, f Format) error {
+	switch f {
+	case FormatDOT:
+		return ExportDOT(w, g)
+	case FormatMermaid:
+		return ExportMermaid(w, g)
+	case FormatSTIX:
+		return ExportSTIX(w, g)
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+}
+
+// 节点按攻击状态着色
+var statusColors = map[AttackStatus]string{
+	AttackStatusAttacking: "#f0ad4e",
+	AttackStatusSucceeded: "#d9534f",
+	AttackStatusFailed:    "#5cb85c",
+	AttackStatusCancelled: "#999999",
+	AttackStatusTimeout:   "#999999",
+}
+
+func eventLabel(e *AttackEvent) []string {
+	lines := []string{e.AttackName}
+	if e.Technique != "" {
+		lines[0] += " (" + e.Technique + ")"
+	}
+	return append(lines, e.Asset, string(e.Status))
+}
+
+// ExportDOT writes g as a Graphviz digraph, one node per event.
+func ExportDOT(w io.Writer, g *Graph) error {
+	var b strings.Builder
+	b.WriteString("digraph attack_graph {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	for _, e := range g.Events {
+		label := make([]string, 0, 3)
+		for _, l := range eventLabel(e) {
+			label = append(label, dotEscape(l))
+		}
+		fmt.Fprintf(&b, "  \"%s\" [label=\"%s\", fillcolor=\"%s\"];\n",
+			dotEscape(e.AttackID), strings.Join(label, "\\n"), statusColor(e.Status))
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  \"%s\" -> \"%s\";\n", dotEscape(edge.From), dotEscape(edge.To))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "").Replace(s)
+}
+
+// ExportMermaid writes g as a Mermaid flowchart. Event IDs are replaced by
+// n<index> since Mermaid node IDs allow few characters.
+func ExportMermaid(w io.Writer, g *Graph) error {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	ids := make(map[string]string, len(g.Events))
+	used := map[AttackStatus]bool{}
+	for i, e := range g.Events {
+		id := fmt.Sprintf("n%d", i)
+		ids[e.AttackID] = id
+		label := make([]string, 0, 3)
+		for _, l := range eventLabel(e) {
+			label = append(label, mermaidEscape(l))
+		}
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", id, strings.Join(label, "<br/>"))
+		if e.Status.Valid() {
+			fmt.Fprintf(&b, "  class %s %s\n", id, e.Status)
+			used[e.Status] = true
+		}
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %s --> %s\n", ids[edge.From], ids[edge.To])
+	}
+	statuses := make([]string, 0, len(used))
+	for s := range used {
+		statuses = append(statuses, string(s))
+	}
+	sort.Strings(statuses)
+	for _, s := range statuses {
+		fmt.Fprintf(&b, "  classDef %s fill:%s\n", s, statusColor(AttackStatus(s)))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", " ", "\r", "").Replace(s)
+}
+
+func statusColor(s AttackStatus) string {
+	if c, ok := statusColors[s]; ok {
+		return c
+	}
+	return "#ffffff"
+}
+
+type stixObject map[string]any
+
+// ExportSTIX writes g as a STIX 2.1 bundle: an attack-pattern per event, an
+// infrastructure per attacked asset, "targets" relationships from events to
+// their asset and "related-to" relationships from parent to child events.
+func ExportSTIX(w io.Writer, g *Graph) error {
+	objects := []stixObject{}
+	patternID := func(e *AttackEvent) string { return stixID("attack-pattern", "event:"+e.AttackID) }
+	infraID := func(e *AttackEvent) string { return stixID("infrastructure", "asset:"+e.AssetXid) }
+	byID := make(map[string]*AttackEvent, len(g.Events))
+	// 资产的创建时间取最早的事件
+	var assets []*AttackEvent
+	firstSeen := map[string]int64{}
+
+	for _, e := range g.Events {
+		byID[e.AttackID] = e
+		p := stixObject{
+			"type":             "attack-pattern",
+			"spec_version":     "2.1",
+			"id":               patternID(e),
+			"created":          stixTime(e.CreatedAt),
+			"modified":         stixTime(max(e.UpdatedAt, e.CreatedAt)),
+			"name":             e.AttackName,
+			"x_xidp_attack_id": e.AttackID,
+			"x_xidp_status":    e.Status,
+		}
+		if e.Detail != "" {
+			p["description"] = e.Detail
+		}
+		if e.Technique != "" {
+			p["external_references"] = []stixObject{{"source_name": "mitre-attack", "external_id": e.Technique}}
+		}
+		objects = append(objects, p)
+
+		if t, ok := firstSeen[e.AssetXid]; !ok {
+			assets = append(assets, e)
+			firstSeen[e.AssetXid] = e.CreatedAt
+		} else if e.CreatedAt < t {
+			firstSeen[e.AssetXid] = e.CreatedAt
+		}
+	}
+	for _, e := range assets {
+		created := stixTime(firstSeen[e.AssetXid])
+		objects = append(objects, stixObject{
+			"type":         "infrastructure",
+			"spec_version": "2.1",
+			"id":           infraID(e),
+			"created":      created,
+			"modified":     created,
+			"name":         e.Asset,
+			"x_xidp_xid":   e.AssetXid,
+		})
+	}
+
+	for _, e := range g.Events {
+		objects = append(objects, stixRelationship(patternID(e), "targets", infraID(e), e.CreatedAt))
+	}
+	for _, edge := range g.Edges {
+		from, to := byID[edge.From], byID[edge.To]
+		objects = append(objects, stixRelationship(patternID(from), "related-to", patternID(to), to.CreatedAt))
+	}
+
+	bundle := stixObject{
+		"type":    "bundle",
+		"id":      stixID("bundle", "graph:"+g.TaskID+"/"+g.ThreadID),
+		"objects": objects,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(bundle)
+}
+
+func stixRelationship(source, kind, target string, at int64) stixObject {
+	return stixObject{
+		"type":              "relationship",
+		"spec_version":      "2.1",
+		"id":                stixID("relationship", source+"|"+kind+"|"+target),
+		"created":           stixTime(at),
+		"modified":          stixTime(at),
+		"relationship_type": kind,
+		"source_ref":        source,
+		"target_ref":        target,
+	}
+}
+
+func stixID(kind, key string) string {
+	return kind + "--" + uuid.NewSHA1(stixNamespace, []byte(key)).String()
+}
+
+func stixTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02T15:04:05.000Z")
+}
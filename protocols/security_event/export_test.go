@@ -0,0 +1,75 @@
+package securityevent
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// exportGraph is a fixed graph: an entry point with two children, one of
+// which reaches a second asset.
+func exportGraph() *Graph {
+	g := &Graph{
+		TaskID: "task-1",
+		Events: []*AttackEvent{
+			{AttackID: "a1", AttackName: "Exposed SSH", Status: AttackStatusSucceeded, Asset: "i-web", AssetXid: "xid-web",
+				TaskID: "task-1", Technique: "T1133", Detail: "port 22 open to 0.0.0.0/0", CreatedAt: 1700000000000, UpdatedAt: 1700000060000},
+			{AttackID: "a2", AttackName: "Credential \"reuse\"", ParentID: "a1", Status: AttackStatusAttacking, Asset: "i-web", AssetXid: "xid-web",
+				TaskID: "task-1", CreatedAt: 1700000120000, UpdatedAt: 1700000120000},
+			{AttackID: "a3", AttackName: "Lateral <movement>", ParentID: "a1", Status: AttackStatusFailed, Asset: "i-db", AssetXid: "xid-db",
+				TaskID: "task-1", Technique: "T1021", CreatedAt: 1700000180000, UpdatedAt: 1700000240000},
+		},
+	}
+	g.link()
+	return g
+}
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		format Format
+		golden string
+	}{
+		{FormatDOT, "graph.dot.golden"},
+		{FormatMermaid, "graph.mmd.golden"},
+		{FormatSTIX, "graph.stix.json.golden"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Export(&buf, exportGraph(), tt.format); err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			path := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%s export differs from %s:\n%s", tt.format, path, buf.String())
+			}
+			// 重复导出结果不变
+			var again bytes.Buffer
+			if err := Export(&again, exportGraph(), tt.format); err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+				t.Errorf("%s export is not deterministic", tt.format)
+			}
+		})
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if err := Export(&bytes.Buffer{}, exportGraph(), "svg"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
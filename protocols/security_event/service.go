@@ -0,0 +1,298 @@
+package securityevent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 路径搜索最多返回的条数
+const maxPaths = 100
+
+type AddRequest struct {
+	AttackID   string       `json:"attack_id"` // generated when empty
+	AttackName string       `json:"attack_name"`
+	ParentID   string       `json:"parent_id"`
+	Targets    string       `json:"targets"`
+	Status     AttackStatus `json:"status"` // attacking when empty
+	Asset      string       `json:"asset"`
+	TaskID     string       `json:"task_id"`
+	ThreadID   string       `json:"thread_id"`
+	Technique  string       `json:"technique"`
+	Detail     string       `json:"detail"`
+}
+
+// GraphFilter selects the events of a graph, at least one field is required.
+type GraphFilter struct {
+	TaskID   string
+	ThreadID string
+}
+
+// AddEvent stores an attack event. An event with a parent belongs to the
+// parent's task and thread unless it names its own.
+func AddEvent(ctx context.Context, repo xdb.XIDRepo, req AddRequest) (*AttackEvent, error) {
+	if req.AttackID == "" {
+		req.AttackID = common.GenerateID()
+	}
+	if req.Status == "" {
+		req.Status = AttackStatusAttacking
+	}
+	switch {
+	case req.AttackName == "":
+		return nil, fmt.Errorf("%w: attack_name is required", ErrInvalidAttackEvent)
+	case req.Asset == "":
+		return nil, fmt.Errorf("%w: asset is required", ErrInvalidAttackEvent)
+	case !req.Status.Valid():
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidAttackEvent, req.Status)
+	case req.ParentID == req.AttackID:
+		return nil, fmt.Errorf("%w: an event cannot be its own parent", ErrInvalidAttackEvent)
+	}
+	if req.ParentID != "" {
+		parent, err := GetEvent(ctx, repo, req.ParentID)
+		if errors.Is(err, ErrAttackEventNotFound) {
+			return nil, fmt.Errorf("%w: parent %s not found", ErrInvalidAttackEvent, req.ParentID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if req.TaskID == "" {
+			req.TaskID = parent.TaskID
+		}
+		if req.ThreadID == "" {
+			req.ThreadID = parent.ThreadID
+		}
+	}
+	if req.TaskID == "" && req.ThreadID == "" {
+		return nil, fmt.Errorf("%w: task_id or thread_id is required", ErrInvalidAttackEvent)
+	}
+
+	xid := protocols.GenerateXid(req.AttackID)
+	exists, err := repo.Exists(ctx, xid, PathAttackEvent)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrAttackEventExists
+	}
+	now := common.GetTimestamp()
+	e := &AttackEvent{
+		AttackID:   req.AttackID,
+		AttackName: req.AttackName,
+		ParentID:   req.ParentID,
+		Targets:    req.Targets,
+		Status:     req.Status,
+		Asset:      req.Asset,
+		AssetXid:   protocols.GenerateXid(req.Asset),
+		TaskID:     req.TaskID,
+		ThreadID:   req.ThreadID,
+		Technique:  req.Technique,
+		Detail:     req.Detail,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	info := protocols.NewInfo(e.AttackID, "attack_id")
+	meta := protocols.NewMetadata(protocols.OperationCreate, PathAttackEvent, "application/json")
+	card := protocols.NewXID[any](&info, &meta, e)
+	if err := repo.Insert(ctx, card); err != nil {
+		return nil, fmt.Errorf("failed to store attack event: %v", err)
+	}
+	return e, nil
+}
+
+// GetEvent returns the attack event with id.
+func GetEvent(ctx context.Context, repo xdb.XIDRepo, id string) (*AttackEvent, error) {
+	doc, err := repo.FindByXid(ctx, protocols.GenerateXid(id), PathAttackEvent)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrAttackEventNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e AttackEvent
+	if err := xdb.DecodePayload(doc, &e); err != nil {
+		return nil, fmt.Errorf("failed to decode attack event %s: %v", id, err)
+	}
+	return &e, nil
+}
+
+// UpdateStatus moves an ongoing attack to status, detail replaces the
+// event's detail when not empty. Finished attacks cannot change.
+func UpdateStatus(ctx context.Context, repo xdb.XIDRepo, id string, status AttackStatus, detail string) (*AttackEvent, error) {
+	if !status.Valid() {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidAttackEvent, status)
+	}
+	e, err := GetEvent(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Status.Finished() {
+		return nil, fmt.Errorf("%w: %s is %s", ErrAttackFinished, id, e.Status)
+	}
+	e.Status, e.UpdatedAt = status, common.GetTimestamp()
+	fields := map[string]any{"payload.status": e.Status, "payload.updatedAt": e.UpdatedAt}
+	if detail != "" {
+		e.Detail = detail
+		fields["payload.detail"] = detail
+	}
+	// 并发更新时只有一个能结束攻击
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(id), PathAttackEvent,
+		map[string]any{"payload.status": AttackStatusAttacking}, fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update attack event: %v", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAttackFinished, id)
+	}
+	return e, nil
+}
+
+// ListAssetEvents returns one page of the attack events against the asset
+// with assetXid, newest first, and the cursor of the next page.
+func ListAssetEvents(ctx context.Context, repo xdb.XIDRepo, assetXid string, pageSize int, cursor string) ([]*AttackEvent, string, error) {
+	q := xdb.Query{
+		Path:     PathAttackEvent,
+		Where:    map[string]any{"payload.assetXid": assetXid},
+		SortBy:   "payload.createdAt",
+		PageSize: pageSize,
+	}
+	if cursor != "" {
+		q.AfterCursor = &cursor
+	}
+	docs, next, err := repo.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	events, err := decodeEvents(docs)
+	if err != nil {
+		return nil, "", err
+	}
+	return events, next, nil
+}
+
+// GetGraph loads every attack event of a task or thread, oldest first, and
+// links them by parent.
+func GetGraph(ctx context.Context, repo xdb.XIDRepo, filter GraphFilter) (*Graph, error) {
+	where := map[string]any{}
+	if filter.TaskID != "" {
+		where["payload.taskID"] = filter.TaskID
+	}
+	if filter.ThreadID != "" {
+		where["payload.threadID"] = filter.ThreadID
+	}
+	if len(where) == 0 {
+		return nil, fmt.Errorf("%w: task_id or thread_id is required", ErrInvalidAttackEvent)
+	}
+
+	g := &Graph{TaskID: filter.TaskID, ThreadID: filter.ThreadID, Events: []*AttackEvent{}}
+	var cursor *string
+	for {
+		docs, next, err := repo.List(ctx, xdb.Query{
+			Path:        PathAttackEvent,
+			Where:       where,
+			SortBy:      "payload.createdAt",
+			SortAsc:     true,
+			PageSize:    500,
+			AfterCursor: cursor,
+		})
+		if err != nil {
+			return nil, err
+		}
+		events, err := decodeEvents(docs)
+		if err != nil {
+			return nil, err
+		}
+		g.Events = append(g.Events, events...)
+		if next == "" {
+			break
+		}
+		cursor = &next
+	}
+	g.link()
+	return g, nil
+}
+
+func (g *Graph) link() {
+	ids := make(map[string]bool, len(g.Events))
+	for _, e := range g.Events {
+		ids[e.AttackID] = true
+	}
+	g.Edges, g.Roots = []Edge{}, []string{}
+	for _, e := range g.Events {
+		if e.ParentID != "" && ids[e.ParentID] {
+			g.Edges = append(g.Edges, Edge{From: e.ParentID, To: e.AttackID})
+		} else {
+			g.Roots = append(g.Roots, e.AttackID)
+		}
+	}
+}
+
+// Paths returns the chains of events leading from entry, an event ID or an
+// asset, to events against target, an asset or asset xid. At most maxPaths
+// chains are returned.
+func (g *Graph) Paths(entry, target string) [][]*AttackEvent {
+	children := map[string][]*AttackEvent{}
+	for _, e := range g.Events {
+		if e.ParentID != "" {
+			children[e.ParentID] = append(children[e.ParentID], e)
+		}
+	}
+	out := [][]*AttackEvent{}
+	onPath := map[string]bool{}
+	var walk func(e *AttackEvent, path []*AttackEvent)
+	walk = func(e *AttackEvent, path []*AttackEvent) {
+		// ParentID 只能指向已存在的事件，这里仍防止数据异常导致的环
+		if len(out) >= maxPaths || onPath[e.AttackID] {
+			return
+		}
+		path = append(path, e)
+		if e.Asset == target || e.AssetXid == target {
+			out = append(out, append([]*AttackEvent{}, path...))
+		}
+		onPath[e.AttackID] = true
+		for _, c := range children[e.AttackID] {
+			walk(c, path)
+		}
+		onPath[e.AttackID] = false
+	}
+	byID := make(map[string]*AttackEvent, len(g.Events))
+	for _, e := range g.Events {
+		byID[e.AttackID] = e
+	}
+	isEntry := func(e *AttackEvent) bool { return e.AttackID == entry || e.Asset == entry }
+	for _, e := range g.Events {
+		if isEntry(e) && !entryAbove(e, byID, isEntry) {
+			walk(e, nil)
+		}
+	}
+	return out
+}
+
+// entryAbove reports whether an ancestor of e is an entry too, the paths
+// from e are then part of the paths from that ancestor.
+func entryAbove(e *AttackEvent, byID map[string]*AttackEvent, isEntry func(*AttackEvent) bool) bool {
+	seen := map[string]bool{e.AttackID: true}
+	for p := byID[e.ParentID]; p != nil && !seen[p.AttackID]; p = byID[p.ParentID] {
+		if isEntry(p) {
+			return true
+		}
+		seen[p.AttackID] = true
+	}
+	return false
+}
+
+func decodeEvents(docs []*protocols.XID[any]) ([]*AttackEvent, error) {
+	events := make([]*AttackEvent, 0, len(docs))
+	for _, doc := range docs {
+		var e AttackEvent
+		if err := xdb.DecodePayload(doc, &e); err != nil {
+			return nil, fmt.Errorf("failed to decode attack event %s: %v", doc.Xid, err)
+		}
+		events = append(events, &e)
+	}
+	return events, nil
+}
@@ -0,0 +1,172 @@
+package securityevent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+// pathGraph has two chains from the web server to the database, one of
+// which continues to the vault, and an unrelated chain from the mail server.
+func pathGraph() *Graph {
+	event := func(id, parent, asset string) *AttackEvent {
+		return &AttackEvent{AttackID: id, ParentID: parent, Asset: asset, AssetXid: protocols.GenerateXid(asset)}
+	}
+	g := &Graph{Events: []*AttackEvent{
+		event("ssh", "", "i-web"),
+		event("creds", "ssh", "i-web"),
+		event("sqli", "creds", "i-db"),
+		event("dump", "sqli", "i-vault"),
+		event("pivot", "ssh", "i-app"),
+		event("lateral", "pivot", "i-db"),
+		event("phish", "", "i-mail"),
+		event("relay", "phish", "i-db"),
+	}}
+	g.link()
+	return g
+}
+
+func TestPaths(t *testing.T) {
+	tests := []struct {
+		name          string
+		entry, target string
+		want          [][]string
+	}{
+		{"from an event", "ssh", "i-db", [][]string{{"ssh", "creds", "sqli"}, {"ssh", "pivot", "lateral"}}},
+		{"from an asset", "i-web", "i-db", [][]string{{"ssh", "creds", "sqli"}, {"ssh", "pivot", "lateral"}}},
+		{"from a later event", "creds", "i-db", [][]string{{"creds", "sqli"}}},
+		{"to an asset xid", "ssh", protocols.GenerateXid("i-vault"), [][]string{{"ssh", "creds", "sqli", "dump"}}},
+		{"entry is the target", "ssh", "i-web", [][]string{{"ssh"}, {"ssh", "creds"}}},
+		{"other chain", "i-mail", "i-db", [][]string{{"phish", "relay"}}},
+		{"unreachable target", "pivot", "i-vault", [][]string{}},
+		{"unknown entry", "i-nowhere", "i-db", [][]string{}},
+	}
+	g := pathGraph()
+	for _, tt := range tests {
+		if got := pathIDs(g.Paths(tt.entry, tt.target)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: paths %s -> %s = %v, want %v", tt.name, tt.entry, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestPathsLimits(t *testing.T) {
+	// 数据异常形成的环不会导致死循环
+	cycle := &Graph{Events: []*AttackEvent{
+		{AttackID: "a", ParentID: "b", Asset: "i-web"},
+		{AttackID: "b", ParentID: "a", Asset: "i-db"},
+	}}
+	if got := pathIDs(cycle.Paths("a", "i-db")); !reflect.DeepEqual(got, [][]string{{"a", "b"}}) {
+		t.Errorf("paths in a cycle = %v, want [[a b]]", got)
+	}
+
+	fan := &Graph{Events: []*AttackEvent{{AttackID: "root", Asset: "i-web"}}}
+	for i := 0; i < maxPaths+50; i++ {
+		fan.Events = append(fan.Events, &AttackEvent{AttackID: fmt.Sprint(i), ParentID: "root", Asset: "i-db"})
+	}
+	if got := fan.Paths("root", "i-db"); len(got) != maxPaths {
+		t.Errorf("got %d paths, want at most %d", len(got), maxPaths)
+	}
+}
+
+// TestGraph stores a chain of events and loads it back by task and thread.
+func TestGraph(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	add := func(req AddRequest) *AttackEvent {
+		t.Helper()
+		e, err := AddEvent(ctx, repo, req)
+		if err != nil {
+			t.Fatalf("add %s: %v", req.AttackID, err)
+		}
+		return e
+	}
+	add(AddRequest{AttackID: "ssh", AttackName: "Exposed SSH", Asset: "i-web", TaskID: "task-1", ThreadID: "thread-1"})
+	creds := add(AddRequest{AttackID: "creds", AttackName: "Credential reuse", ParentID: "ssh", Asset: "i-web"})
+	add(AddRequest{AttackID: "sqli", AttackName: "SQL injection", ParentID: "creds", Asset: "i-db"})
+	// 另一个任务中的事件可以接在这条链上，不属于本任务的图，但仍继承线程
+	add(AddRequest{AttackID: "other", AttackName: "Follow-up", ParentID: "sqli", Asset: "i-vault", TaskID: "task-2"})
+
+	if creds.TaskID != "task-1" || creds.ThreadID != "thread-1" || creds.AssetXid != protocols.GenerateXid("i-web") {
+		t.Errorf("child event = %+v, want the task and thread of its parent", creds)
+	}
+	for _, req := range []AddRequest{
+		{AttackID: "x", AttackName: "x", Asset: "i-web"},
+		{AttackID: "x", AttackName: "x", Asset: "i-web", TaskID: "task-1", ParentID: "missing"},
+		{AttackID: "x", AttackName: "x", Asset: "i-web", TaskID: "task-1", ParentID: "x"},
+		{AttackID: "x", AttackName: "x", Asset: "i-web", TaskID: "task-1", Status: "won"},
+	} {
+		if _, err := AddEvent(ctx, repo, req); !errors.Is(err, ErrInvalidAttackEvent) {
+			t.Errorf("add %+v got %v, want ErrInvalidAttackEvent", req, err)
+		}
+	}
+
+	tests := []struct {
+		filter GraphFilter
+		events []string
+		edges  []Edge
+		roots  []string
+	}{
+		{GraphFilter{TaskID: "task-1"}, []string{"ssh", "creds", "sqli"}, []Edge{{"ssh", "creds"}, {"creds", "sqli"}}, []string{"ssh"}},
+		{GraphFilter{ThreadID: "thread-1"}, []string{"ssh", "creds", "sqli", "other"}, []Edge{{"ssh", "creds"}, {"creds", "sqli"}, {"sqli", "other"}}, []string{"ssh"}},
+		{GraphFilter{TaskID: "task-1", ThreadID: "thread-1"}, []string{"ssh", "creds", "sqli"}, []Edge{{"ssh", "creds"}, {"creds", "sqli"}}, []string{"ssh"}},
+		{GraphFilter{TaskID: "task-2"}, []string{"other"}, []Edge{}, []string{"other"}},
+	}
+	for _, tt := range tests {
+		g, err := GetGraph(ctx, repo, tt.filter)
+		if err != nil {
+			t.Fatalf("graph %+v: %v", tt.filter, err)
+		}
+		var ids []string
+		for _, e := range g.Events {
+			ids = append(ids, e.AttackID)
+		}
+		if !reflect.DeepEqual(ids, tt.events) || !reflect.DeepEqual(g.Edges, tt.edges) || !reflect.DeepEqual(g.Roots, tt.roots) {
+			t.Errorf("graph %+v = events %v, edges %v, roots %v", tt.filter, ids, g.Edges, g.Roots)
+		}
+	}
+	if _, err := GetGraph(ctx, repo, GraphFilter{}); !errors.Is(err, ErrInvalidAttackEvent) {
+		t.Errorf("graph without a filter got %v, want ErrInvalidAttackEvent", err)
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	if _, err := AddEvent(ctx, repo, AddRequest{AttackID: "ssh", AttackName: "Exposed SSH", Asset: "i-web", TaskID: "task-1"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := UpdateStatus(ctx, repo, "ssh", "won", ""); !errors.Is(err, ErrInvalidAttackEvent) {
+		t.Errorf("unknown status got %v, want ErrInvalidAttackEvent", err)
+	}
+	if _, err := UpdateStatus(ctx, repo, "missing", AttackStatusFailed, ""); !errors.Is(err, ErrAttackEventNotFound) {
+		t.Errorf("missing event got %v, want ErrAttackEventNotFound", err)
+	}
+	e, err := UpdateStatus(ctx, repo, "ssh", AttackStatusSucceeded, "shell as root")
+	if err != nil || e.Status != AttackStatusSucceeded {
+		t.Fatalf("update = %+v, %v", e, err)
+	}
+	if _, err := UpdateStatus(ctx, repo, "ssh", AttackStatusFailed, ""); !errors.Is(err, ErrAttackFinished) {
+		t.Errorf("update of a finished attack got %v, want ErrAttackFinished", err)
+	}
+	got, _ := GetEvent(ctx, repo, "ssh")
+	if got.Status != AttackStatusSucceeded || got.Detail != "shell as root" {
+		t.Errorf("stored event = %+v", got)
+	}
+}
+
+func pathIDs(paths [][]*AttackEvent) [][]string {
+	out := [][]string{}
+	for _, p := range paths {
+		var ids []string
+		for _, e := range p {
+			ids = append(ids, e.AttackID)
+		}
+		out = append(out, ids)
+	}
+	return out
+}
@@ -0,0 +1,381 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/colin-404/logx"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type depState int
+
+const (
+	depWait    depState = iota // 上游未结束
+	depReady                   // 可以开始
+	depBlocked                 // 必需的依赖条件已无法满足
+)
+
+func (c DependencyCondition) valid() bool {
+	switch c {
+	case ConditionSuccess, ConditionFailed, ConditionCompleted:
+		return true
+	}
+	return false
+}
+
+func stepFinished(s StepStatus) bool {
+	return s != StepStatusPending && s != StepStatusRunning
+}
+
+func stepConditionMet(c DependencyCondition, s StepStatus) bool {
+	switch c {
+	case ConditionSuccess:
+		return s == StepStatusCompleted
+	case ConditionFailed:
+		return s == StepStatusFailed || s == StepStatusTimeout || s == StepStatusCancelled
+	case ConditionCompleted:
+		// 被跳过的步骤没有执行过
+		return stepFinished(s) && s != StepStatusSkipped
+	}
+	return false
+}
+
+func taskConditionMet(c DependencyCondition, s TaskStatus) bool {
+	switch c {
+	case ConditionSuccess:
+		return s == TaskStatusCompleted
+	case ConditionFailed:
+		return s == TaskStatusFailed || s == TaskStatusTimeout || s == TaskStatusCancelled
+	case ConditionCompleted:
+		return s.Finished()
+	}
+	return false
+}
+
+// validateSteps assigns defaults to step dependencies and checks that they
+// refer to steps of the same task and form no cycle.
+func validateSteps(steps []TaskStep) error {
+	index := map[string]int{}
+	for i, s := range steps {
+		if _, ok := index[s.StepID]; ok {
+			return fmt.Errorf("%w: duplicate step %s", ErrInvalidTask, s.StepID)
+		}
+		index[s.StepID] = i
+	}
+	for i := range steps {
+		for j := range steps[i].DependsOn {
+			dep := &steps[i].DependsOn[j]
+			if dep.Condition == "" {
+				dep.Condition = ConditionSuccess
+			}
+			if !dep.Condition.valid() {
+				return fmt.Errorf("%w: step %s: unknown condition %q", ErrInvalidTask, steps[i].StepID, dep.Condition)
+			}
+			if _, ok := index[dep.StepID]; !ok {
+				return fmt.Errorf("%w: step %s depends on unknown step %s", ErrInvalidTask, steps[i].StepID, dep.StepID)
+			}
+		}
+	}
+
+	// 深度优先，遇到灰色节点即成环
+	const (
+		white = iota
+		grey
+		black
+	)
+	color := make([]int, len(steps))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		color[i] = grey
+		path = append(path, steps[i].StepID)
+		for _, dep := range steps[i].DependsOn {
+			j := index[dep.StepID]
+			switch color[j] {
+			case grey:
+				return fmt.Errorf("%w: dependency cycle %s -> %s", ErrInvalidTask, strings.Join(path, " -> "), dep.StepID)
+			case white:
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		color[i] = black
+		return nil
+	}
+	for i := range steps {
+		if color[i] == white {
+			if err := visit(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateTaskDependencies checks that upstream tasks exist. A new task
+// can only depend on tasks created before it, so no cycle is possible.
+func validateTaskDependencies(ctx context.Context, repo xdb.XIDRepo, deps []TaskDependency) error {
+	for i := range deps {
+		if deps[i].Condition == "" {
+			deps[i].Condition = ConditionSuccess
+		}
+		if !deps[i].Condition.valid() {
+			return fmt.Errorf("%w: unknown condition %q", ErrInvalidTask, deps[i].Condition)
+		}
+		if _, err := Get(ctx, repo, deps[i].TaskID); err != nil {
+			if errors.Is(err, ErrTaskNotFound) {
+				return fmt.Errorf("%w: unknown upstream task %s", ErrInvalidTask, deps[i].TaskID)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// stepState tells whether step i can start.
+func (t *Task) stepState(i int) depState {
+	state := depReady
+	for _, dep := range t.Steps[i].DependsOn {
+		j := t.stepIndex(dep.StepID)
+		if j < 0 {
+			continue
+		}
+		up := t.Steps[j].Status
+		if !stepFinished(up) {
+			state = depWait
+			continue
+		}
+		if dep.Required && !stepConditionMet(dep.Condition, up) {
+			return depBlocked
+		}
+	}
+	return state
+}
+
+// stepParams merges the upstream results named by the dependencies of
+// step i into its own params.
+func (t *Task) stepParams(i int) map[string]any {
+	params := map[string]any{}
+	for k, v := range t.Steps[i].Params {
+		params[k] = v
+	}
+	for _, dep := range t.Steps[i].DependsOn {
+		j := t.stepIndex(dep.StepID)
+		if j < 0 {
+			continue
+		}
+		for name, key := range dep.Params {
+			if v, ok := lookup(t.Steps[j].Result, key); ok {
+				params[name] = v
+			}
+		}
+	}
+	return params
+}
+
+// lookup resolves a dotted key inside nested result maps. Nested documents
+// read back from the store may be bson.M or bson.D.
+func lookup(m map[string]any, key string) (any, bool) {
+	var cur any = m
+	for _, part := range strings.Split(key, ".") {
+		var (
+			next any
+			ok   bool
+		)
+		switch v := cur.(type) {
+		case map[string]any:
+			next, ok = v[part]
+		case bson.M:
+			next, ok = v[part]
+		case bson.D:
+			for _, e := range v {
+				if e.Key == part {
+					next, ok = e.Value, true
+					break
+				}
+			}
+		}
+		if !ok {
+			return nil, false
+		}
+		cur = next
+	}
+	return cur, true
+}
+
+// skipBlocked marks pending steps whose required dependencies can no longer
+// be met as skipped, until no more steps are affected. It reports whether
+// any step was skipped.
+func skipBlocked(ctx context.Context, repo xdb.XIDRepo, t *Task) (bool, error) {
+	skipped := false
+	for changed := true; changed; {
+		changed = false
+		for i := range t.Steps {
+			if t.Steps[i].Status != StepStatusPending || t.stepState(i) != depBlocked {
+				continue
+			}
+			now := common.GetTimestamp()
+			prefix := fmt.Sprintf("payload.steps.%d.", i)
+			ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(t.TaskID), Path, map[string]any{
+				prefix + "stepId": t.Steps[i].StepID,
+				prefix + "status": StepStatusPending,
+			}, map[string]any{
+				prefix + "status":     StepStatusSkipped,
+				prefix + "error":      "dependency condition not met",
+				prefix + "finishedAt": now,
+				"payload.updatedAt":   now,
+			})
+			if err != nil {
+				return skipped, fmt.Errorf("failed to skip step: %v", err)
+			}
+			if ok {
+				t.Steps[i].Status = StepStatusSkipped
+				skipped, changed = true, true
+			}
+		}
+	}
+	return skipped, nil
+}
+
+// dependenciesReady reports whether a pending task may start. A task whose
+// required upstream condition can no longer be met is cancelled.
+func dependenciesReady(ctx context.Context, repo xdb.XIDRepo, t *Task, actor string) (bool, error) {
+	state := depReady
+	for _, dep := range t.Dependencies {
+		up, err := Get(ctx, repo, dep.TaskID)
+		if errors.Is(err, ErrTaskNotFound) {
+			// 上游已删除，按未满足处理
+			if dep.Required {
+				state = depBlocked
+				break
+			}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !up.Status.Finished() {
+			state = depWait
+			continue
+		}
+		if dep.Required && !taskConditionMet(dep.Condition, up.Status) {
+			state = depBlocked
+			break
+		}
+	}
+
+	switch state {
+	case depReady:
+		return true, nil
+	case depBlocked:
+		_, err := Cancel(ctx, repo, t.TaskID, actor, "dependency condition not met")
+		if err != nil && !errors.Is(err, ErrTaskConflict) && !errors.Is(err, ErrInvalidTransition) {
+			logx.Errorf("failed to cancel task %s: %v", t.TaskID, err)
+		}
+	}
+	return false, nil
+}
+
+type DAGNode struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"` // task / step
+	TaskID string `json:"taskId"`
+	StepID string `json:"stepId,omitempty"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type DAGEdge struct {
+	From      string              `json:"from"`
+	To        string              `json:"to"`
+	Condition DependencyCondition `json:"condition"`
+	Required  bool                `json:"required"`
+}
+
+// DAG is a task with its steps, upstream and downstream tasks and the
+// dependencies between them, with live statuses.
+type DAG struct {
+	TaskID string    `json:"taskId"`
+	Nodes  []DAGNode `json:"nodes"`
+	Edges  []DAGEdge `json:"edges"`
+}
+
+func taskNodeID(taskID string) string {
+	return "task:" + taskID
+}
+
+func stepNodeID(taskID, stepID string) string {
+	return "step:" + taskID + "/" + stepID
+}
+
+// GetDAG builds the dependency graph around task id.
+func GetDAG(ctx context.Context, repo xdb.XIDRepo, id string) (*DAG, error) {
+	t, err := Get(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+	dag := &DAG{TaskID: id, Nodes: []DAGNode{}, Edges: []DAGEdge{}}
+	addTask := func(t *Task) {
+		dag.Nodes = append(dag.Nodes, DAGNode{ID: taskNodeID(t.TaskID), Kind: "task", TaskID: t.TaskID, Name: t.Name, Status: string(t.Status)})
+	}
+	addTask(t)
+
+	for _, s := range t.Steps {
+		name := s.StepName
+		if name == "" {
+			name = s.StepID
+		}
+		dag.Nodes = append(dag.Nodes, DAGNode{
+			ID: stepNodeID(t.TaskID, s.StepID), Kind: "step", TaskID: t.TaskID, StepID: s.StepID,
+			Name: name, Status: string(s.Status),
+		})
+		for _, dep := range s.DependsOn {
+			dag.Edges = append(dag.Edges, DAGEdge{
+				From: stepNodeID(t.TaskID, dep.StepID), To: stepNodeID(t.TaskID, s.StepID),
+				Condition: dep.Condition, Required: dep.Required,
+			})
+		}
+	}
+
+	for _, dep := range t.Dependencies {
+		up, err := Get(ctx, repo, dep.TaskID)
+		if err != nil {
+			if errors.Is(err, ErrTaskNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		addTask(up)
+		dag.Edges = append(dag.Edges, DAGEdge{From: taskNodeID(up.TaskID), To: taskNodeID(t.TaskID), Condition: dep.Condition, Required: dep.Required})
+	}
+
+	docs, _, err := repo.List(ctx, xdb.Query{
+		Path:     Path,
+		Where:    map[string]any{"payload.dependencies.taskId": id},
+		PageSize: 500,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		var down Task
+		if err := xdb.DecodePayload(doc, &down); err != nil {
+			return nil, fmt.Errorf("failed to decode task %s: %v", doc.Xid, err)
+		}
+		addTask(&down)
+		for _, dep := range down.Dependencies {
+			if dep.TaskID == id {
+				dag.Edges = append(dag.Edges, DAGEdge{From: taskNodeID(id), To: taskNodeID(down.TaskID), Condition: dep.Condition, Required: dep.Required})
+			}
+		}
+	}
+	return dag, nil
+}
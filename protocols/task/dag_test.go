@@ -0,0 +1,158 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+func TestValidateSteps(t *testing.T) {
+	on := func(id string) []StepDependency { return []StepDependency{{StepID: id}} }
+	tests := []struct {
+		name  string
+		steps []TaskStep
+		valid bool
+	}{
+		{"no dependencies", []TaskStep{{StepID: "a"}, {StepID: "b"}}, true},
+		{"chain", []TaskStep{{StepID: "a"}, {StepID: "b", DependsOn: on("a")}, {StepID: "c", DependsOn: on("b")}}, true},
+		{"diamond", []TaskStep{
+			{StepID: "a"},
+			{StepID: "b", DependsOn: on("a")},
+			{StepID: "c", DependsOn: on("a")},
+			{StepID: "d", DependsOn: []StepDependency{{StepID: "b"}, {StepID: "c", Condition: ConditionCompleted}}},
+		}, true},
+		{"unknown step", []TaskStep{{StepID: "a", DependsOn: on("x")}}, false},
+		{"duplicate step", []TaskStep{{StepID: "a"}, {StepID: "a"}}, false},
+		{"unknown condition", []TaskStep{{StepID: "a"}, {StepID: "b", DependsOn: []StepDependency{{StepID: "a", Condition: "sometimes"}}}}, false},
+		{"self cycle", []TaskStep{{StepID: "a", DependsOn: on("a")}}, false},
+		{"cycle", []TaskStep{{StepID: "a", DependsOn: on("c")}, {StepID: "b", DependsOn: on("a")}, {StepID: "c", DependsOn: on("b")}}, false},
+		{"cycle behind a valid step", []TaskStep{{StepID: "a"}, {StepID: "b", DependsOn: on("c")}, {StepID: "c", DependsOn: on("b")}}, false},
+	}
+	for _, tt := range tests {
+		err := validateSteps(tt.steps)
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidTask) {
+			t.Errorf("%s: got %v, want ErrInvalidTask", tt.name, err)
+		}
+	}
+
+	steps := []TaskStep{{StepID: "a"}, {StepID: "b", DependsOn: on("a")}}
+	if err := validateSteps(steps); err != nil || steps[1].DependsOn[0].Condition != ConditionSuccess {
+		t.Errorf("default condition = %q, %v, want success", steps[1].DependsOn[0].Condition, err)
+	}
+}
+
+// TestSkipBlocked fails a step and checks which dependent steps are skipped,
+// transitively.
+func TestSkipBlocked(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	task := createTask(t, repo,
+		TaskStep{StepID: "scan"},
+		TaskStep{StepID: "exploit", DependsOn: []StepDependency{{StepID: "scan", Required: true}}},
+		TaskStep{StepID: "report", DependsOn: []StepDependency{{StepID: "exploit", Required: true}}},
+		TaskStep{StepID: "alert", DependsOn: []StepDependency{{StepID: "scan", Condition: ConditionFailed, Required: true}}},
+		TaskStep{StepID: "cleanup", DependsOn: []StepDependency{{StepID: "exploit", Condition: ConditionCompleted}}},
+	)
+	setStepStatus(t, repo, task.TaskID, 0, StepStatusFailed)
+
+	task, _ = Get(ctx, repo, task.TaskID)
+	skipped, err := skipBlocked(ctx, repo, task)
+	if err != nil || !skipped {
+		t.Fatalf("skipBlocked = %v, %v, want skipped steps", skipped, err)
+	}
+	got, _ := Get(ctx, repo, task.TaskID)
+	want := map[string]StepStatus{
+		"scan":    StepStatusFailed,
+		"exploit": StepStatusSkipped,
+		"report":  StepStatusSkipped,
+		"alert":   StepStatusPending,
+		"cleanup": StepStatusPending,
+	}
+	for i, s := range got.Steps {
+		if s.Status != want[s.StepID] {
+			t.Errorf("step %s = %s, want %s", s.StepID, s.Status, want[s.StepID])
+		}
+		if state := got.stepState(i); s.StepID == "cleanup" && state != depReady {
+			t.Errorf("cleanup state = %v, want ready after its optional upstream was skipped", state)
+		}
+	}
+	if skipped, err := skipBlocked(ctx, repo, got); err != nil || skipped {
+		t.Errorf("second skipBlocked = %v, %v, want nothing left to skip", skipped, err)
+	}
+}
+
+func TestDependenciesReady(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		upstream  []TaskStatus // 上游依次经过的状态
+		condition DependencyCondition
+		required  bool
+		ready     bool
+		cancelled bool
+	}{
+		{"upstream pending", nil, ConditionSuccess, true, false, false},
+		{"upstream running", []TaskStatus{TaskStatusRunning}, ConditionSuccess, true, false, false},
+		{"success met", []TaskStatus{TaskStatusRunning, TaskStatusCompleted}, ConditionSuccess, true, true, false},
+		{"success not met", []TaskStatus{TaskStatusRunning, TaskStatusFailed}, ConditionSuccess, true, false, true},
+		{"success not met, optional", []TaskStatus{TaskStatusRunning, TaskStatusFailed}, ConditionSuccess, false, true, false},
+		{"failed met", []TaskStatus{TaskStatusTimeout}, ConditionFailed, true, true, false},
+		{"failed not met", []TaskStatus{TaskStatusRunning, TaskStatusCompleted}, ConditionFailed, true, false, true},
+		{"completed", []TaskStatus{TaskStatusCancelled}, ConditionCompleted, true, true, false},
+	}
+	for _, tt := range tests {
+		repo := xdbtest.New()
+		up := createTask(t, repo)
+		for _, s := range tt.upstream {
+			if _, err := Transition(ctx, repo, up.TaskID, s, "alice", "", nil); err != nil {
+				t.Fatalf("%s: move upstream to %s: %v", tt.name, s, err)
+			}
+		}
+		down, err := Create(ctx, repo, CreateRequest{
+			TaskType:     "scan",
+			Dependencies: []TaskDependency{{TaskID: up.TaskID, Condition: tt.condition, Required: tt.required}},
+		})
+		if err != nil {
+			t.Fatalf("%s: create: %v", tt.name, err)
+		}
+
+		ready, err := dependenciesReady(ctx, repo, down, "scheduler")
+		if err != nil || ready != tt.ready {
+			t.Errorf("%s: ready = %v, %v, want %v", tt.name, ready, err, tt.ready)
+		}
+		got, _ := Get(ctx, repo, down.TaskID)
+		if cancelled := got.Status == TaskStatusCancelled; cancelled != tt.cancelled {
+			t.Errorf("%s: status = %s, want cancelled %v", tt.name, got.Status, tt.cancelled)
+		}
+	}
+
+	// 必需的上游被删除后按不满足处理
+	repo := xdbtest.New()
+	up := createTask(t, repo)
+	down, err := Create(ctx, repo, CreateRequest{TaskType: "scan", Dependencies: []TaskDependency{{TaskID: up.TaskID, Required: true}}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := repo.DeleteHard(ctx, protocols.GenerateXid(up.TaskID), Path); err != nil {
+		t.Fatal(err)
+	}
+	if ready, err := dependenciesReady(ctx, repo, down, "scheduler"); err != nil || ready {
+		t.Errorf("deleted upstream: ready = %v, %v, want false", ready, err)
+	}
+}
+
+func setStepStatus(t *testing.T, repo xdb.XIDRepo, taskID string, i int, status StepStatus) {
+	t.Helper()
+	field := fmt.Sprintf("payload.steps.%d.status", i)
+	if err := repo.UpdateFields(context.Background(), protocols.GenerateXid(taskID), Path, map[string]any{field: status}); err != nil {
+		t.Fatalf("set step status: %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/colin-404/logx"
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+const (
+	// 未配置 Task.visibility_timeout 时的默认租约时长（秒）
+	defaultVisibilityTimeout = 300
+	// 未配置 Task.max_attempts 时，步骤最多分发的次数
+	defaultMaxAttempts = 3
+)
+
+var (
+	ErrNoStep       = errors.New("no step available")
+	ErrLeaseExpired = errors.New("lease is no longer held")
+)
+
+// Lease is a step handed to a worker. The worker owns it until ExpiresAt and
+// must complete or extend it before then, otherwise it is dispatched again.
+type Lease struct {
+	LeaseID   string   `json:"leaseId"`
+	TaskID    string   `json:"taskId"`
+	TaskType  string   `json:"taskType"`
+	Step      TaskStep `json:"step"`
+	ExpiresAt int64    `json:"expiresAt"`
+}
+
+type CompleteRequest struct {
+	TaskID   string         `json:"taskId"`
+	StepID   string         `json:"stepId"`
+	LeaseID  string         `json:"leaseId"`
+	WorkerID string         `json:"workerId"`
+	Result   map[string]any `json:"result"`
+	// 非空表示步骤失败
+	Error string `json:"error"`
+}
+
+func visibilityTimeout(seconds int64) int64 {
+	if seconds <= 0 {
+		seconds = viper.GetInt64("Task.visibility_timeout")
+	}
+	if seconds <= 0 {
+		seconds = defaultVisibilityTimeout
+	}
+	return seconds * 1000
+}
+
+func maxAttempts() int {
+	if n := viper.GetInt("Task.max_attempts"); n > 0 {
+		return n
+	}
+	return defaultMaxAttempts
+}
+
+// leasable reports whether the step can be handed out at now: pending, or
+// running under a lease that has expired.
+func (s *TaskStep) leasable(now int64) bool {
+	return s.Status == StepStatusPending || s.Status == StepStatusRunning && s.LeaseExpiresAt < now
+}
+
+// LeaseStep hands the oldest available step matching the worker's
+// capabilities to the worker for timeoutSeconds (0 means the configured
+// default). Steps whose lease expired are dispatched again; one that has run
+// out of attempts times out instead. Every claim is a conditional update, so
+// replicas sharing the store never hand the same step out twice.
+func LeaseStep(ctx context.Context, repo xdb.XIDRepo, workerID string, timeoutSeconds int64) (*Lease, error) {
+	w, err := GetWorker(ctx, repo, workerID)
+	if err != nil {
+		return nil, err
+	}
+	now := common.GetTimestamp()
+	if !w.Online(now) {
+		return nil, ErrWorkerOffline
+	}
+
+	docs, _, err := repo.List(ctx, xdb.Query{
+		Path: Path,
+		Where: map[string]any{
+			"payload.status": map[string]any{"$in": []TaskStatus{TaskStatusPending, TaskStatusRunning}},
+			"payload.steps": map[string]any{"$elemMatch": map[string]any{
+				"capability": map[string]any{"$in": w.Capabilities},
+				"$or": []map[string]any{
+					{"status": StepStatusPending},
+					{"status": StepStatusRunning, "leaseExpiresAt": map[string]any{"$lt": now}},
+				},
+			}},
+		},
+		SortBy:   "createdAt",
+		SortAsc:  true,
+		PageSize: 20,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range docs {
+		var t Task
+		if err := xdb.DecodePayload(doc, &t); err != nil {
+			return nil, fmt.Errorf("failed to decode task %s: %v", doc.Xid, err)
+		}
+		if t.Status == TaskStatusPending {
+			ready, err := dependenciesReady(ctx, repo, &t, "dispatcher")
+			if err != nil {
+				return nil, err
+			}
+			if !ready {
+				continue
+			}
+		}
+		for i := range t.Steps {
+			step := &t.Steps[i]
+			if !w.can(step.Capability) || !step.leasable(now) {
+				continue
+			}
+			switch t.stepState(i) {
+			case depWait:
+				continue
+			case depBlocked:
+				if err := finishTask(ctx, repo, t.TaskID, "dispatcher"); err != nil {
+					logx.Errorf("failed to skip steps of task %s: %v", t.TaskID, err)
+				}
+				continue
+			}
+			if step.Status == StepStatusRunning && step.Attempts >= maxAttempts() {
+				if err := expireStep(ctx, repo, &t, i, now); err != nil {
+					logx.Errorf("failed to time out step %s of task %s: %v", step.StepID, t.TaskID, err)
+				}
+				continue
+			}
+			lease, err := claimStep(ctx, repo, &t, i, w, timeoutSeconds, now)
+			if err != nil {
+				return nil, err
+			}
+			if lease != nil {
+				return lease, nil
+			}
+		}
+	}
+	return nil, ErrNoStep
+}
+
+// claimStep leases step i of t to w. It returns nil without error when
+// another worker claimed the step first.
+func claimStep(ctx context.Context, repo xdb.XIDRepo, t *Task, i int, w *Worker, timeoutSeconds, now int64) (*Lease, error) {
+	step := t.Steps[i]
+	prefix := fmt.Sprintf("payload.steps.%d.", i)
+	cond := map[string]any{
+		"payload.status":    map[string]any{"$in": []TaskStatus{TaskStatusPending, TaskStatusRunning}},
+		prefix + "stepId":   step.StepID,
+		prefix + "status":   step.Status,
+		prefix + "attempts": step.Attempts,
+	}
+
+	step.Status = StepStatusRunning
+	step.WorkerID, step.WorkerName = w.WorkerID, w.WorkerName
+	step.LeaseID = common.GenerateID()
+	step.LeaseExpiresAt = now + visibilityTimeout(timeoutSeconds)
+	step.Attempts++
+	step.StartedAt = now
+	step.Params = t.stepParams(i)
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(t.TaskID), Path, cond, map[string]any{
+		prefix + "status":         step.Status,
+		prefix + "workerId":       step.WorkerID,
+		prefix + "workerName":     step.WorkerName,
+		prefix + "leaseId":        step.LeaseID,
+		prefix + "leaseExpiresAt": step.LeaseExpiresAt,
+		prefix + "attempts":       step.Attempts,
+		prefix + "startedAt":      step.StartedAt,
+		prefix + "params":         step.Params,
+		"payload.updatedAt":       now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease step: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	if t.Status == TaskStatusPending {
+		_, err := Transition(ctx, repo, t.TaskID, TaskStatusRunning, w.WorkerID, "step "+step.StepID+" leased", nil)
+		// 其他副本可能已经把任务置为 running
+		if err != nil && !errors.Is(err, ErrTaskConflict) && !errors.Is(err, ErrInvalidTransition) {
+			return nil, err
+		}
+	}
+	return &Lease{
+		LeaseID:   step.LeaseID,
+		TaskID:    t.TaskID,
+		TaskType:  t.TaskType,
+		Step:      step,
+		ExpiresAt: step.LeaseExpiresAt,
+	}, nil
+}
+
+// expireStep times out step i of t, which has used up its attempts, and
+// finishes the task if nothing else is left to run.
+func expireStep(ctx context.Context, repo xdb.XIDRepo, t *Task, i int, now int64) error {
+	step := t.Steps[i]
+	prefix := fmt.Sprintf("payload.steps.%d.", i)
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(t.TaskID), Path, map[string]any{
+		prefix + "leaseId": step.LeaseID,
+		prefix + "status":  StepStatusRunning,
+	}, map[string]any{
+		prefix + "status":     StepStatusTimeout,
+		prefix + "error":      fmt.Sprintf("lease expired after %d attempts", step.Attempts),
+		prefix + "finishedAt": now,
+		"payload.updatedAt":   now,
+	})
+	if err != nil || !ok {
+		return err
+	}
+	return finishTask(ctx, repo, t.TaskID, "dispatcher")
+}
+
+// ExtendLease keeps a step leased to workerID for another timeoutSeconds.
+func ExtendLease(ctx context.Context, repo xdb.XIDRepo, taskID, stepID, leaseID, workerID string, timeoutSeconds int64) (int64, error) {
+	t, err := Get(ctx, repo, taskID)
+	if err != nil {
+		return 0, err
+	}
+	i := t.stepIndex(stepID)
+	if i < 0 {
+		return 0, fmt.Errorf("%w: step %s", ErrTaskNotFound, stepID)
+	}
+	now := common.GetTimestamp()
+	expiresAt := now + visibilityTimeout(timeoutSeconds)
+	prefix := fmt.Sprintf("payload.steps.%d.", i)
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(taskID), Path, map[string]any{
+		prefix + "leaseId":  leaseID,
+		prefix + "workerId": workerID,
+		prefix + "status":   StepStatusRunning,
+	}, map[string]any{
+		prefix + "leaseExpiresAt": expiresAt,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to extend lease: %v", err)
+	}
+	if !ok {
+		return 0, ErrLeaseExpired
+	}
+	return expiresAt, nil
+}
+
+// CompleteStep writes the result of a step leased to req.WorkerID. A step
+// whose lease has been handed to another worker meanwhile is rejected with
+// ErrLeaseExpired.
+// The task completes once every step has completed, and fails as soon as
+// every step has finished with at least one failure.
+func CompleteStep(ctx context.Context, repo xdb.XIDRepo, req CompleteRequest) (*Task, error) {
+	t, err := Get(ctx, repo, req.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	i := t.stepIndex(req.StepID)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: step %s", ErrTaskNotFound, req.StepID)
+	}
+
+	now := common.GetTimestamp()
+	status := StepStatusCompleted
+	if req.Error != "" {
+		status = StepStatusFailed
+	}
+	if req.Result == nil {
+		req.Result = map[string]any{}
+	}
+	prefix := fmt.Sprintf("payload.steps.%d.", i)
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(req.TaskID), Path, map[string]any{
+		prefix + "leaseId":  req.LeaseID,
+		prefix + "workerId": req.WorkerID,
+		prefix + "status":   StepStatusRunning,
+	}, map[string]any{
+		prefix + "status":     status,
+		prefix + "result":     req.Result,
+		prefix + "error":      req.Error,
+		prefix + "finishedAt": now,
+		"payload.updatedAt":   now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete step: %v", err)
+	}
+	if !ok {
+		return nil, ErrLeaseExpired
+	}
+
+	if err := finishTask(ctx, repo, req.TaskID, req.WorkerID); err != nil {
+		return nil, err
+	}
+	return Get(ctx, repo, req.TaskID)
+}
+
+// finishTask skips steps whose dependencies can no longer be met, then
+// moves a running task to completed or failed once none of its steps can
+// run any more. Skipped steps do not fail the task.
+func finishTask(ctx context.Context, repo xdb.XIDRepo, taskID, actor string) error {
+	t, err := Get(ctx, repo, taskID)
+	if err != nil {
+		return err
+	}
+	if t.Status != TaskStatusRunning {
+		return nil
+	}
+	skipped, err := skipBlocked(ctx, repo, t)
+	if err != nil {
+		return err
+	}
+	if skipped {
+		// 重新读取最新的步骤状态
+		if t, err = Get(ctx, repo, taskID); err != nil {
+			return err
+		}
+	}
+	var failed *TaskStep
+	for i := range t.Steps {
+		switch t.Steps[i].Status {
+		case StepStatusPending, StepStatusRunning:
+			return nil
+		case StepStatusFailed, StepStatusTimeout, StepStatusCancelled:
+			if failed == nil {
+				failed = &t.Steps[i]
+			}
+		}
+	}
+
+	to, fields := TaskStatusCompleted, map[string]any{}
+	if failed != nil {
+		to = TaskStatusFailed
+		fields["payload.error"] = fmt.Sprintf("step %s %s: %s", failed.StepID, failed.Status, failed.Error)
+	}
+	_, err = apply(ctx, repo, t, to, actor, "", fields)
+	if errors.Is(err, ErrTaskConflict) {
+		return nil
+	}
+	return err
+}
+
+func (t *Task) stepIndex(stepID string) int {
+	for i := range t.Steps {
+		if t.Steps[i].StepID == stepID {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,172 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+func TestLeaseStep(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	task := createTask(t, repo, TaskStep{StepID: "a"}, TaskStep{StepID: "b", Capability: "exploit"})
+	registerWorker(t, repo, "w1", "scan")
+	registerWorker(t, repo, "w2", "scan")
+
+	lease, err := LeaseStep(ctx, repo, "w1", 0)
+	if err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	if lease.TaskID != task.TaskID || lease.Step.StepID != "a" || lease.Step.WorkerID != "w1" || lease.Step.Attempts != 1 {
+		t.Fatalf("lease = %+v, want step a for w1", lease)
+	}
+	got, _ := Get(ctx, repo, task.TaskID)
+	if got.Status != TaskStatusRunning {
+		t.Errorf("task status = %s, want running", got.Status)
+	}
+
+	// 另一个 worker 拿不到已租出的步骤，也拿不到能力不符的步骤
+	if _, err := LeaseStep(ctx, repo, "w2", 0); !errors.Is(err, ErrNoStep) {
+		t.Fatalf("second lease got %v, want ErrNoStep", err)
+	}
+	if _, err := LeaseStep(ctx, repo, "missing", 0); !errors.Is(err, ErrWorkerNotFound) {
+		t.Fatalf("lease by unknown worker got %v, want ErrWorkerNotFound", err)
+	}
+	if err := repo.UpdateFields(ctx, protocols.GenerateXid("w2"), PathWorker, map[string]any{"payload.lastHeartbeat": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LeaseStep(ctx, repo, "w2", 0); !errors.Is(err, ErrWorkerOffline) {
+		t.Fatalf("lease by offline worker got %v, want ErrWorkerOffline", err)
+	}
+}
+
+// TestLeaseExpiry hands a step whose lease expired to another worker and
+// times it out once it has used up its attempts.
+func TestLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	task := createTask(t, repo, TaskStep{StepID: "a"})
+	registerWorker(t, repo, "w1", "scan")
+	registerWorker(t, repo, "w2", "scan")
+
+	first, err := LeaseStep(ctx, repo, "w1", 0)
+	if err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	var lease *Lease
+	for attempt := 2; attempt <= defaultMaxAttempts; attempt++ {
+		expireLease(t, repo, task.TaskID)
+		if lease, err = LeaseStep(ctx, repo, "w2", 0); err != nil {
+			t.Fatalf("lease attempt %d: %v", attempt, err)
+		}
+		if lease.LeaseID == first.LeaseID || lease.Step.WorkerID != "w2" || lease.Step.Attempts != attempt {
+			t.Fatalf("lease attempt %d = %+v", attempt, lease)
+		}
+	}
+
+	// 过期的租约不能再续约或完成
+	if _, err := ExtendLease(ctx, repo, task.TaskID, "a", first.LeaseID, "w1", 0); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("extend of a reassigned lease got %v, want ErrLeaseExpired", err)
+	}
+	if _, err := CompleteStep(ctx, repo, CompleteRequest{TaskID: task.TaskID, StepID: "a", LeaseID: first.LeaseID, WorkerID: "w1"}); !errors.Is(err, ErrLeaseExpired) {
+		t.Fatalf("complete of a reassigned lease got %v, want ErrLeaseExpired", err)
+	}
+
+	expireLease(t, repo, task.TaskID)
+	if _, err := LeaseStep(ctx, repo, "w1", 0); !errors.Is(err, ErrNoStep) {
+		t.Fatalf("lease after the last attempt got %v, want ErrNoStep", err)
+	}
+	got, _ := Get(ctx, repo, task.TaskID)
+	if got.Steps[0].Status != StepStatusTimeout || got.Status != TaskStatusFailed {
+		t.Fatalf("step %s, task %s, want step timeout and task failed", got.Steps[0].Status, got.Status)
+	}
+}
+
+func TestCompleteStep(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	task := createTask(t, repo, TaskStep{StepID: "a"}, TaskStep{StepID: "b"})
+	registerWorker(t, repo, "w1", "scan")
+	a, err := LeaseStep(ctx, repo, "w1", 0)
+	if err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	b, err := LeaseStep(ctx, repo, "w1", 0)
+	if err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		req     CompleteRequest
+		wantErr error
+		status  TaskStatus
+	}{
+		{"wrong lease", CompleteRequest{StepID: "a", LeaseID: b.LeaseID, WorkerID: "w1"}, ErrLeaseExpired, ""},
+		{"wrong worker", CompleteRequest{StepID: "a", LeaseID: a.LeaseID, WorkerID: "w2"}, ErrLeaseExpired, ""},
+		{"unknown step", CompleteRequest{StepID: "c", LeaseID: a.LeaseID, WorkerID: "w1"}, ErrTaskNotFound, ""},
+		{"first step", CompleteRequest{StepID: "a", LeaseID: a.LeaseID, WorkerID: "w1", Result: map[string]any{"open": 22}}, nil, TaskStatusRunning},
+		{"twice", CompleteRequest{StepID: "a", LeaseID: a.LeaseID, WorkerID: "w1"}, ErrLeaseExpired, ""},
+		{"failed step", CompleteRequest{StepID: "b", LeaseID: b.LeaseID, WorkerID: "w1", Error: "host unreachable"}, nil, TaskStatusFailed},
+	}
+	for _, tt := range tests {
+		tt.req.TaskID = task.TaskID
+		got, err := CompleteStep(ctx, repo, tt.req)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && got.Status != tt.status {
+			t.Fatalf("%s: task status = %s, want %s", tt.name, got.Status, tt.status)
+		}
+	}
+	got, _ := Get(ctx, repo, task.TaskID)
+	if got.Steps[0].Status != StepStatusCompleted || got.Steps[0].Result["open"] == nil {
+		t.Errorf("step a = %+v, want completed with its result", got.Steps[0])
+	}
+}
+
+func TestWorkerPrincipal(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	registerWorker(t, repo, "w1", "scan")
+
+	if _, err := CheckWorker(ctx, repo, "w1", "worker"); err != nil {
+		t.Fatalf("check by the registering principal: %v", err)
+	}
+	if _, err := CheckWorker(ctx, repo, "w1", "intruder"); !errors.Is(err, ErrWorkerForbidden) {
+		t.Fatalf("check by another principal got %v, want ErrWorkerForbidden", err)
+	}
+	if _, err := RegisterWorker(ctx, repo, Worker{WorkerID: "w1", Capabilities: []string{"exploit"}, Principal: "intruder"}); !errors.Is(err, ErrWorkerForbidden) {
+		t.Fatalf("register by another principal got %v, want ErrWorkerForbidden", err)
+	}
+	w, err := GetWorker(ctx, repo, "w1")
+	if err != nil || w.Capabilities[0] != "scan" {
+		t.Fatalf("worker = %+v, %v, want it unchanged", w, err)
+	}
+}
+
+// expireLease moves the lease of every running step of the task into the
+// past.
+func expireLease(t *testing.T, repo xdb.XIDRepo, taskID string) {
+	t.Helper()
+	ctx := context.Background()
+	task, err := Get(ctx, repo, taskID)
+	if err != nil {
+		t.Fatalf("get task: %v", err)
+	}
+	for i, s := range task.Steps {
+		if s.Status != StepStatusRunning {
+			continue
+		}
+		field := fmt.Sprintf("payload.steps.%d.leaseExpiresAt", i)
+		if err := repo.UpdateFields(ctx, protocols.GenerateXid(taskID), Path, map[string]any{field: common.GetTimestamp() - 1}); err != nil {
+			t.Fatalf("expire lease: %v", err)
+		}
+	}
+}
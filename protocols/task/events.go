@@ -0,0 +1,30 @@
+package task
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xid-protocol/xidp/internal/eventlog"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+// EventStream is the event log stream holding the step events of a task.
+func EventStream(taskID string) string {
+	return "task:" + taskID
+}
+
+// PublishStepEvent appends a step event emitted by a worker to the task's
+// stream. The step must belong to the task.
+func PublishStepEvent(ctx context.Context, repo xdb.XIDRepo, taskID string, ev StepEvent) (*eventlog.Event, error) {
+	if ev.StepID == "" || ev.DataType == "" {
+		return nil, fmt.Errorf("%w: stepId and dataType are required", ErrInvalidTask)
+	}
+	t, err := Get(ctx, repo, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if t.stepIndex(ev.StepID) < 0 {
+		return nil, fmt.Errorf("%w: unknown step %s", ErrInvalidTask, ev.StepID)
+	}
+	return eventlog.Append(ctx, repo, EventStream(taskID), ev.DataType, ev)
+}
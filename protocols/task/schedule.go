@@ -0,0 +1,224 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/cron"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const PathSchedule = "/protocols/task/schedule"
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule launches a task from Task either on every match of
+// CronExpression or once at StartTime.
+// path /protocols/task/schedule
+type Schedule struct {
+	ScheduleID     string `json:"scheduleId" bson:"scheduleId"`
+	Name           string `json:"name" bson:"name"`
+	CronExpression string `json:"cronExpression,omitempty" bson:"cronExpression,omitempty"`
+	// 一次性任务的开始时间（毫秒）
+	StartTime int64 `json:"startTime,omitempty" bson:"startTime,omitempty"`
+	// IANA 时区，cron 按该时区解释，默认本地时区
+	Timezone   string        `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	Task       CreateRequest `json:"task" bson:"task"`
+	Enabled    bool          `json:"enabled" bson:"enabled"`
+	NextRunAt  int64         `json:"nextRunAt" bson:"nextRunAt"`
+	LastRunAt  int64         `json:"lastRunAt,omitempty" bson:"lastRunAt,omitempty"`
+	LastTaskID string        `json:"lastTaskId,omitempty" bson:"lastTaskId,omitempty"`
+	CreatedBy  string        `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt  int64         `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  int64         `json:"updatedAt" bson:"updatedAt"`
+}
+
+// next returns the run time after now in unix millis, 0 when the schedule
+// will not fire again.
+func (s *Schedule) next(now int64) (int64, error) {
+	if s.CronExpression == "" {
+		if s.StartTime > now {
+			return s.StartTime, nil
+		}
+		return 0, nil
+	}
+	expr, err := cron.Parse(s.CronExpression)
+	if err != nil {
+		return 0, err
+	}
+	loc := time.Local
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return 0, err
+		}
+	}
+	t := expr.Next(time.UnixMilli(now).In(loc))
+	if t.IsZero() {
+		return 0, nil
+	}
+	return t.UnixMilli(), nil
+}
+
+func (s *Schedule) validate(now int64) error {
+	if (s.CronExpression == "") == (s.StartTime == 0) {
+		return fmt.Errorf("%w: exactly one of cronExpression and startTime is required", ErrInvalidTask)
+	}
+	if s.Task.TaskType == "" {
+		return fmt.Errorf("%w: task.taskType is required", ErrInvalidTask)
+	}
+	next, err := s.next(now)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTask, err)
+	}
+	if next == 0 {
+		return fmt.Errorf("%w: schedule never fires", ErrInvalidTask)
+	}
+	s.NextRunAt = next
+	return nil
+}
+
+// CreateSchedule stores an enabled schedule.
+func CreateSchedule(ctx context.Context, repo xdb.XIDRepo, s Schedule) (*Schedule, error) {
+	now := common.GetTimestamp()
+	if err := s.validate(now); err != nil {
+		return nil, err
+	}
+	s.ScheduleID = common.GenerateID()
+	s.Enabled = true
+	s.CreatedAt, s.UpdatedAt = now, now
+	if err := saveSchedule(ctx, repo, &s, protocols.OperationCreate); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// EnsureSchedule creates the schedule with the fixed s.ScheduleID, or
+// updates it when its timing or task changed. Used for schedules defined in
+// code or config, so every replica can call it on startup.
+func EnsureSchedule(ctx context.Context, repo xdb.XIDRepo, s Schedule) (*Schedule, error) {
+	if s.ScheduleID == "" {
+		return nil, fmt.Errorf("%w: scheduleId is required", ErrInvalidTask)
+	}
+	now := common.GetTimestamp()
+	if err := s.validate(now); err != nil {
+		return nil, err
+	}
+	s.Enabled = true
+	s.CreatedAt, s.UpdatedAt = now, now
+
+	prev, err := GetSchedule(ctx, repo, s.ScheduleID)
+	if err != nil && !errors.Is(err, ErrScheduleNotFound) {
+		return nil, err
+	}
+	if prev != nil {
+		if prev.CronExpression == s.CronExpression && prev.StartTime == s.StartTime &&
+			prev.Timezone == s.Timezone && prev.Task.TaskType == s.Task.TaskType && prev.Enabled {
+			return prev, nil
+		}
+		s.CreatedAt, s.LastRunAt, s.LastTaskID = prev.CreatedAt, prev.LastRunAt, prev.LastTaskID
+	}
+	if err := saveSchedule(ctx, repo, &s, protocols.OperationUpdate); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func saveSchedule(ctx context.Context, repo xdb.XIDRepo, s *Schedule, op protocols.OperationType) error {
+	info := protocols.NewInfo(s.ScheduleID, "schedule_id")
+	meta := protocols.NewMetadata(op, PathSchedule, "application/json")
+	card := protocols.NewXID[any](&info, &meta, s)
+	if err := repo.Upsert(ctx, card.Xid, PathSchedule, card); err != nil {
+		return fmt.Errorf("failed to store schedule: %v", err)
+	}
+	return nil
+}
+
+func GetSchedule(ctx context.Context, repo xdb.XIDRepo, id string) (*Schedule, error) {
+	doc, err := repo.FindByXid(ctx, protocols.GenerateXid(id), PathSchedule)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Schedule
+	if err := xdb.DecodePayload(doc, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode schedule %s: %v", id, err)
+	}
+	return &s, nil
+}
+
+// ListSchedules returns one page of schedules and the cursor of the next page.
+func ListSchedules(ctx context.Context, repo xdb.XIDRepo, pageSize int, cursor string) ([]*Schedule, string, error) {
+	q := xdb.Query{Path: PathSchedule, SortBy: "createdAt", PageSize: pageSize}
+	if cursor != "" {
+		q.AfterCursor = &cursor
+	}
+	docs, next, err := repo.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	out := make([]*Schedule, 0, len(docs))
+	for _, doc := range docs {
+		var s Schedule
+		if err := xdb.DecodePayload(doc, &s); err != nil {
+			return nil, "", fmt.Errorf("failed to decode schedule %s: %v", doc.Xid, err)
+		}
+		out = append(out, &s)
+	}
+	return out, next, nil
+}
+
+// DeleteSchedule stops the schedule. Tasks it already launched are kept.
+func DeleteSchedule(ctx context.Context, repo xdb.XIDRepo, id string) error {
+	if _, err := GetSchedule(ctx, repo, id); err != nil {
+		return err
+	}
+	return repo.DeleteSoft(ctx, protocols.GenerateXid(id), PathSchedule, common.GetTimestamp())
+}
+
+// fireSchedule launches the task of a due schedule. The run is claimed by
+// moving NextRunAt conditionally, so a run fires once even if a former
+// leader is still finishing its tick.
+func fireSchedule(ctx context.Context, repo xdb.XIDRepo, s *Schedule, now int64) (*Task, error) {
+	next, err := s.next(now)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(s.ScheduleID), PathSchedule, map[string]any{
+		"payload.nextRunAt": s.NextRunAt,
+	}, map[string]any{
+		"payload.nextRunAt": next,
+		"payload.enabled":   next > 0,
+		"payload.lastRunAt": now,
+		"payload.updatedAt": now,
+	})
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	req := s.Task
+	req.ScheduleID = s.ScheduleID
+	req.Draft = false
+	if req.Name == "" {
+		req.Name = s.Name
+	}
+	if req.CreatedBy == "" {
+		req.CreatedBy = "scheduler"
+	}
+	t, err := Create(ctx, repo, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.UpdateFields(ctx, protocols.GenerateXid(s.ScheduleID), PathSchedule, map[string]any{
+		"payload.lastTaskId": t.TaskID,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %v", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,372 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/colin-404/logx"
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/leader"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+// 未配置 Task.scheduler_interval 时的轮询间隔（秒）
+const defaultSchedulerInterval = 10
+
+// Executor runs a task in process and returns its result. ctx is cancelled
+// when the task times out or is cancelled.
+type Executor func(ctx context.Context, t *Task) (string, error)
+
+var (
+	executorsMu sync.RWMutex
+	executors   = map[string]Executor{}
+)
+
+// RegisterExecutor runs pending tasks of taskType that have no steps in
+// process, on the replica that holds the scheduler lease.
+func RegisterExecutor(taskType string, fn Executor) {
+	executorsMu.Lock()
+	defer executorsMu.Unlock()
+	executors[taskType] = fn
+}
+
+func executorTypes() []string {
+	executorsMu.RLock()
+	defer executorsMu.RUnlock()
+	types := make([]string, 0, len(executors))
+	for t := range executors {
+		types = append(types, t)
+	}
+	return types
+}
+
+func executorFor(taskType string) (Executor, bool) {
+	executorsMu.RLock()
+	defer executorsMu.RUnlock()
+	fn, ok := executors[taskType]
+	return fn, ok
+}
+
+// Scheduler fires due schedules, times out tasks, retries failed ones and
+// runs in-process executors. Only the replica holding the leader lease acts
+// on each tick.
+type Scheduler struct {
+	repo     xdb.XIDRepo
+	elector  *leader.Elector
+	interval time.Duration
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+func NewScheduler(repo xdb.XIDRepo) *Scheduler {
+	interval := time.Duration(viper.GetInt64("Task.scheduler_interval")) * time.Second
+	if interval <= 0 {
+		interval = defaultSchedulerInterval * time.Second
+	}
+	return &Scheduler{
+		repo:     repo,
+		elector:  leader.NewElector(repo, "task-scheduler", 3*interval),
+		interval: interval,
+		running:  map[string]context.CancelFunc{},
+	}
+}
+
+// Run ticks until ctx is done, then gives up the leader lease.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			s.stopAll()
+			s.elector.Release(context.Background())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	ok, err := s.elector.TryAcquire(ctx)
+	if err != nil {
+		logx.Errorf("scheduler: failed to acquire leader lease: %v", err)
+		return
+	}
+	if !ok {
+		// 失去 leader 后停止本地执行的任务，由新 leader 回收
+		s.stopAll()
+		return
+	}
+
+	now := common.GetTimestamp()
+	for _, step := range []struct {
+		name string
+		fn   func(context.Context, int64) error
+	}{
+		{"fire schedules", s.fireSchedules},
+		{"enforce timeouts", s.enforceTimeouts},
+		{"reclaim tasks", s.reclaimTasks},
+		{"retry tasks", s.retryTasks},
+		{"run executors", s.runExecutors},
+	} {
+		if err := step.fn(ctx, now); err != nil {
+			logx.Errorf("scheduler: %s: %v", step.name, err)
+		}
+	}
+}
+
+func (s *Scheduler) fireSchedules(ctx context.Context, now int64) error {
+	docs, _, err := s.repo.List(ctx, xdb.Query{
+		Path: PathSchedule,
+		Where: map[string]any{
+			"payload.enabled":   true,
+			"payload.nextRunAt": map[string]any{"$gt": 0, "$lte": now},
+		},
+		PageSize: 100,
+	})
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		var sch Schedule
+		if err := xdb.DecodePayload(doc, &sch); err != nil {
+			logx.Errorf("scheduler: failed to decode schedule %s: %v", doc.Xid, err)
+			continue
+		}
+		t, err := fireSchedule(ctx, s.repo, &sch, now)
+		if err != nil {
+			logx.Errorf("scheduler: failed to fire schedule %s: %v", sch.ScheduleID, err)
+			continue
+		}
+		if t != nil {
+			logx.Infof("scheduler: schedule %s launched task %s", sch.ScheduleID, t.TaskID)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) enforceTimeouts(ctx context.Context, now int64) error {
+	docs, _, err := s.repo.List(ctx, xdb.Query{
+		Path: Path,
+		Where: map[string]any{
+			"payload.status":  TaskStatusRunning,
+			"payload.timeout": map[string]any{"$gt": 0},
+		},
+		PageSize: 500,
+	})
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		var t Task
+		if err := xdb.DecodePayload(doc, &t); err != nil {
+			continue
+		}
+		if t.StartedAt == 0 || t.StartedAt+t.Timeout*1000 > now {
+			continue
+		}
+		_, err := TimeoutTask(ctx, s.repo, t.TaskID, "scheduler", "timeout exceeded")
+		if err != nil && !errors.Is(err, ErrTaskConflict) && !errors.Is(err, ErrInvalidTransition) {
+			logx.Errorf("scheduler: failed to time out task %s: %v", t.TaskID, err)
+			continue
+		}
+		s.stop(t.TaskID)
+	}
+	return nil
+}
+
+// reclaimTasks fails executor tasks left running by a replica that stopped
+// or lost the leader lease, so that they are retried instead of staying
+// running forever. Only the leader runs executors, so every running executor
+// task it does not run itself is orphaned, unless it was claimed by another
+// owner whose lease is still live.
+func (s *Scheduler) reclaimTasks(ctx context.Context, now int64) error {
+	types := executorTypes()
+	if len(types) == 0 {
+		return nil
+	}
+	docs, _, err := s.repo.List(ctx, xdb.Query{
+		Path: Path,
+		Where: map[string]any{
+			"payload.status":   TaskStatusRunning,
+			"payload.taskType": map[string]any{"$in": types},
+			"payload.steps":    map[string]any{"$size": 0},
+		},
+		PageSize: 500,
+	})
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		var t Task
+		if err := xdb.DecodePayload(doc, &t); err != nil {
+			continue
+		}
+		s.mu.Lock()
+		_, local := s.running[t.TaskID]
+		s.mu.Unlock()
+		if local || t.LeaseExpiresAt > now {
+			continue
+		}
+		_, err := Transition(ctx, s.repo, t.TaskID, TaskStatusFailed, "scheduler", "executor lost",
+			map[string]any{"payload.error": "executor stopped before the task finished"})
+		if err != nil && !errors.Is(err, ErrTaskConflict) && !errors.Is(err, ErrInvalidTransition) {
+			logx.Errorf("scheduler: failed to reclaim task %s: %v", t.TaskID, err)
+			continue
+		}
+		logx.Infof("scheduler: reclaimed task %s left running by another executor", t.TaskID)
+	}
+	return nil
+}
+
+func (s *Scheduler) retryTasks(ctx context.Context, now int64) error {
+	docs, _, err := s.repo.List(ctx, xdb.Query{
+		Path: Path,
+		Where: map[string]any{
+			"payload.status":     map[string]any{"$in": []TaskStatus{TaskStatusFailed, TaskStatusTimeout}},
+			"payload.retryCount": map[string]any{"$gt": 0},
+			// attempt 从 1 开始，重试 retryCount 次
+			"$expr": map[string]any{"$lte": []string{"$payload.attempt", "$payload.retryCount"}},
+		},
+		PageSize: 500,
+	})
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		var t Task
+		if err := xdb.DecodePayload(doc, &t); err != nil || len(t.Transitions) == 0 {
+			continue
+		}
+		if t.Transitions[len(t.Transitions)-1].At+t.RetryDelay*1000 > now {
+			continue
+		}
+		_, err := Retry(ctx, s.repo, t.TaskID, "scheduler", "automatic retry")
+		if err != nil && !errors.Is(err, ErrTaskConflict) && !errors.Is(err, ErrInvalidTransition) {
+			logx.Errorf("scheduler: failed to retry task %s: %v", t.TaskID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) runExecutors(ctx context.Context, now int64) error {
+	// 任务被取消或已结束时停止本地执行
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.running))
+	for id := range s.running {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	for _, id := range ids {
+		if t, err := Get(ctx, s.repo, id); err == nil && t.Status != TaskStatusRunning {
+			s.stop(id)
+		}
+	}
+
+	types := executorTypes()
+	if len(types) == 0 {
+		return nil
+	}
+	docs, _, err := s.repo.List(ctx, xdb.Query{
+		Path: Path,
+		Where: map[string]any{
+			"payload.status":   TaskStatusPending,
+			"payload.taskType": map[string]any{"$in": types},
+			"payload.steps":    map[string]any{"$size": 0},
+		},
+		SortBy:   "createdAt",
+		SortAsc:  true,
+		PageSize: 50,
+	})
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		var t Task
+		if err := xdb.DecodePayload(doc, &t); err != nil {
+			continue
+		}
+		fn, ok := executorFor(t.TaskType)
+		if !ok {
+			continue
+		}
+		if ready, err := dependenciesReady(ctx, s.repo, &t, "scheduler"); err != nil || !ready {
+			continue
+		}
+		started, err := Transition(ctx, s.repo, t.TaskID, TaskStatusRunning, "scheduler", "executor "+s.elector.ID(), map[string]any{
+			"payload.owner":          s.elector.ID(),
+			"payload.leaseExpiresAt": 0,
+		})
+		if err != nil {
+			if !errors.Is(err, ErrTaskConflict) && !errors.Is(err, ErrInvalidTransition) {
+				logx.Errorf("scheduler: failed to start task %s: %v", t.TaskID, err)
+			}
+			continue
+		}
+		s.execute(started, fn)
+	}
+	return nil
+}
+
+func (s *Scheduler) execute(t *Task, fn Executor) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if t.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(t.Timeout)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	s.mu.Lock()
+	s.running[t.TaskID] = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer s.stop(t.TaskID)
+		result, err := fn(ctx, t)
+
+		to, fields := TaskStatusCompleted, map[string]any{"payload.result": result}
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			// 任务已被取消，或本副本不再是 leader，由新 leader 回收
+			return
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			_, err = TimeoutTask(context.Background(), s.repo, t.TaskID, "scheduler", "timeout exceeded")
+		case err != nil:
+			to, fields = TaskStatusFailed, map[string]any{"payload.error": err.Error()}
+			fallthrough
+		default:
+			_, err = Transition(context.Background(), s.repo, t.TaskID, to, "scheduler", "", fields)
+		}
+		// 任务可能已被取消或超时
+		if err != nil && !errors.Is(err, ErrTaskConflict) && !errors.Is(err, ErrInvalidTransition) {
+			logx.Errorf("scheduler: failed to finish task %s: %v", t.TaskID, err)
+		}
+	}()
+}
+
+func (s *Scheduler) stop(taskID string) {
+	s.mu.Lock()
+	cancel, ok := s.running[taskID]
+	delete(s.running, taskID)
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+func (s *Scheduler) stopAll() {
+	s.mu.Lock()
+	running := s.running
+	s.running = map[string]context.CancelFunc{}
+	s.mu.Unlock()
+	for _, cancel := range running {
+		cancel()
+	}
+}
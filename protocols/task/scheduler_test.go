@@ -0,0 +1,61 @@
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+// TestReclaimTasks fails executor tasks nobody runs any more, but keeps
+// those claimed under a live lease.
+func TestReclaimTasks(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	RegisterExecutor("reclaim-test", func(ctx context.Context, t *Task) (string, error) { return "", nil })
+	s := NewScheduler(repo)
+
+	newRunning := func() *Task {
+		task, err := Create(ctx, repo, CreateRequest{TaskType: "reclaim-test"})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		return task
+	}
+	orphan := newRunning()
+	if _, err := Transition(ctx, repo, orphan.TaskID, TaskStatusRunning, "scheduler", "executor gone", nil); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	claimed := newRunning()
+	if _, err := Claim(ctx, repo, claimed.TaskID, "chat:t1", time.Minute, ""); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	expired := newRunning()
+	if _, err := Claim(ctx, repo, expired.TaskID, "chat:t2", -time.Second, ""); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	if err := s.reclaimTasks(ctx, common.GetTimestamp()); err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+	for id, want := range map[string]TaskStatus{
+		orphan.TaskID:  TaskStatusFailed,
+		claimed.TaskID: TaskStatusRunning,
+		expired.TaskID: TaskStatusFailed,
+	} {
+		got, _ := Get(ctx, repo, id)
+		if got.Status != want {
+			t.Errorf("task %s = %s, want %s", id, got.Status, want)
+		}
+	}
+
+	// 续约只对持有者有效
+	if ok, err := RenewClaim(ctx, repo, claimed.TaskID, "chat:t2", time.Minute); err != nil || ok {
+		t.Errorf("renew by another owner = %v, %v, want false", ok, err)
+	}
+	if ok, err := RenewClaim(ctx, repo, claimed.TaskID, "chat:t1", time.Minute); err != nil || !ok {
+		t.Errorf("renew by the owner = %v, %v, want true", ok, err)
+	}
+}
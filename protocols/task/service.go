@@ -0,0 +1,337 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/protocols/aiagent"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const Path = "/protocols/task"
+
+type CreateRequest struct {
+	Name        string     `json:"name" bson:"name,omitempty"`
+	TaskType    string     `json:"taskType" bson:"taskType"`
+	UserInput   string     `json:"userInput" bson:"userInput,omitempty"`
+	Description string     `json:"description" bson:"description,omitempty"`
+	Targets     []string   `json:"targets" bson:"targets,omitempty"`
+	Steps       []TaskStep `json:"steps" bson:"steps,omitempty"`
+	// 上游任务，依赖满足前任务不会开始
+	Dependencies []TaskDependency `json:"dependencies" bson:"dependencies,omitempty"`
+	CreatedBy    string           `json:"createdBy" bson:"createdBy,omitempty"`
+	Timeout      int64            `json:"timeout" bson:"timeout,omitempty"`
+	RetryCount   int              `json:"retryCount" bson:"retryCount,omitempty"`
+	RetryDelay   int64            `json:"retryDelay" bson:"retryDelay,omitempty"`
+	// 由调度器填写
+	ScheduleID string `json:"-" bson:"-"`
+	// 为 true 时停留在 init，稍后再提交
+	Draft bool `json:"draft" bson:"draft,omitempty"`
+	// 执行任务的代理；agentVersion 为 0 时固定为创建时的当前版本
+	Agent        string `json:"agent" bson:"agent,omitempty"`
+	AgentVersion int64  `json:"agentVersion" bson:"agentVersion,omitempty"`
+}
+
+type ListFilter struct {
+	Status    TaskStatus
+	TaskType  string
+	CreatedBy string
+	PageSize  int
+	Cursor    string
+}
+
+// Create stores a new task. Unless req.Draft is set it is submitted right
+// away and left pending.
+func Create(ctx context.Context, repo xdb.XIDRepo, req CreateRequest) (*Task, error) {
+	if strings.TrimSpace(req.TaskType) == "" {
+		return nil, fmt.Errorf("%w: taskType is required", ErrInvalidTask)
+	}
+	if req.Timeout < 0 || req.RetryCount < 0 || req.RetryDelay < 0 {
+		return nil, fmt.Errorf("%w: timeout, retryCount and retryDelay must not be negative", ErrInvalidTask)
+	}
+	now := common.GetTimestamp()
+	t := &Task{
+		TaskID:      common.GenerateID(),
+		Name:        req.Name,
+		TaskType:    req.TaskType,
+		UserInput:   req.UserInput,
+		Description: req.Description,
+		Targets:     req.Targets,
+		History:     []string{},
+		Status:      TaskStatusInit,
+		Steps:       []TaskStep{},
+		Attempt:     1,
+		Timeout:     req.Timeout,
+		RetryCount:  req.RetryCount,
+		RetryDelay:  req.RetryDelay,
+		ScheduleID:  req.ScheduleID,
+		Transitions: []TaskTransition{},
+		CreatedBy:   req.CreatedBy,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if req.Agent != "" {
+		v, err := aiagent.Resolve(ctx, repo, req.Agent, req.AgentVersion)
+		if errors.Is(err, aiagent.ErrAgentNotFound) || errors.Is(err, aiagent.ErrVersionNotFound) {
+			return nil, fmt.Errorf("%w: agent %s: %v", ErrInvalidTask, req.Agent, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.Agent, t.AgentVersion = v.Name, v.Version
+	}
+	if req.UserInput != "" {
+		t.History = append(t.History, req.UserInput)
+	}
+	for _, step := range req.Steps {
+		if step.StepID == "" {
+			step.StepID = common.GenerateID()
+		}
+		if step.Params == nil {
+			step.Params = map[string]any{}
+		}
+		if step.Capability == "" {
+			step.Capability = req.TaskType
+		}
+		step.Status = StepStatusPending
+		t.Steps = append(t.Steps, step)
+	}
+	if err := validateSteps(t.Steps); err != nil {
+		return nil, err
+	}
+	if err := validateTaskDependencies(ctx, repo, req.Dependencies); err != nil {
+		return nil, err
+	}
+	t.Dependencies = req.Dependencies
+	if !req.Draft {
+		t.Transitions = append(t.Transitions, TaskTransition{From: TaskStatusInit, To: TaskStatusPending, Actor: req.CreatedBy, At: now})
+		t.Status = TaskStatusPending
+	}
+
+	info := protocols.NewInfo(t.TaskID, "task_id")
+	meta := protocols.NewMetadata(protocols.OperationCreate, Path, "application/json")
+	card := protocols.NewXID[any](&info, &meta, t)
+	if err := repo.Insert(ctx, card); err != nil {
+		return nil, fmt.Errorf("failed to store task: %v", err)
+	}
+	return t, nil
+}
+
+// Get returns the task with id.
+func Get(ctx context.Context, repo xdb.XIDRepo, id string) (*Task, error) {
+	doc, err := repo.FindByXid(ctx, protocols.GenerateXid(id), Path)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t Task
+	if err := xdb.DecodePayload(doc, &t); err != nil {
+		return nil, fmt.Errorf("failed to decode task %s: %v", id, err)
+	}
+	return &t, nil
+}
+
+// List returns one page of tasks, newest first, and the cursor of the next page.
+func List(ctx context.Context, repo xdb.XIDRepo, filter ListFilter) ([]*Task, string, error) {
+	where := map[string]any{}
+	if filter.Status != "" {
+		where["payload.status"] = filter.Status
+	}
+	if filter.TaskType != "" {
+		where["payload.taskType"] = filter.TaskType
+	}
+	if filter.CreatedBy != "" {
+		where["payload.createdBy"] = filter.CreatedBy
+	}
+	q := xdb.Query{
+		Path:     Path,
+		Where:    where,
+		SortBy:   "createdAt",
+		PageSize: filter.PageSize,
+	}
+	if filter.Cursor != "" {
+		q.AfterCursor = &filter.Cursor
+	}
+	docs, next, err := repo.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	tasks := make([]*Task, 0, len(docs))
+	for _, doc := range docs {
+		var t Task
+		if err := xdb.DecodePayload(doc, &t); err != nil {
+			return nil, "", fmt.Errorf("failed to decode task %s: %v", doc.Xid, err)
+		}
+		tasks = append(tasks, &t)
+	}
+	return tasks, next, nil
+}
+
+// Transition moves the task to status to on behalf of actor. fields are
+// extra payload fields (full paths) stored together with the status, e.g.
+// the result. It fails with ErrInvalidTransition when the state machine does
+// not allow the move, and ErrTaskConflict when another update won the race.
+func Transition(ctx context.Context, repo xdb.XIDRepo, id string, to TaskStatus, actor, reason string, fields map[string]any) (*Task, error) {
+	t, err := Get(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransition(t.Status, to); err != nil {
+		return nil, err
+	}
+	return apply(ctx, repo, t, to, actor, reason, fields)
+}
+
+func apply(ctx context.Context, repo xdb.XIDRepo, t *Task, to TaskStatus, actor, reason string, fields map[string]any) (*Task, error) {
+	return applyIf(ctx, repo, t, to, actor, reason, nil, fields)
+}
+
+// applyIf is apply with extra conditions on the stored task.
+func applyIf(ctx context.Context, repo xdb.XIDRepo, t *Task, to TaskStatus, actor, reason string, cond, fields map[string]any) (*Task, error) {
+	now := common.GetTimestamp()
+	from := t.Status
+	t.Transitions = append(t.Transitions, TaskTransition{From: from, To: to, Actor: actor, Reason: reason, At: now})
+	t.Status, t.UpdatedAt = to, now
+
+	set := map[string]any{
+		"payload.status":      t.Status,
+		"payload.updatedAt":   t.UpdatedAt,
+		"payload.transitions": t.Transitions,
+	}
+	if to == TaskStatusRunning {
+		t.StartedAt = now
+		set["payload.startedAt"] = now
+	}
+	for k, v := range fields {
+		set[k] = v
+	}
+	// 以当前状态和转换次数作为条件，防止多个副本同时修改
+	where := map[string]any{
+		"payload.status":      from,
+		"payload.transitions": map[string]any{"$size": len(t.Transitions) - 1},
+	}
+	for k, v := range cond {
+		where[k] = v
+	}
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(t.TaskID), Path, where, set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update task: %v", err)
+	}
+	if !ok {
+		return nil, ErrTaskConflict
+	}
+	return Get(ctx, repo, t.TaskID)
+}
+
+// Claim moves the task to running for owner, which runs it outside the
+// scheduler and must renew the lease with RenewClaim before it expires.
+func Claim(ctx context.Context, repo xdb.XIDRepo, id, owner string, lease time.Duration, reason string) (*Task, error) {
+	return Transition(ctx, repo, id, TaskStatusRunning, owner, reason, map[string]any{
+		"payload.owner":          owner,
+		"payload.leaseExpiresAt": common.GetTimestamp() + lease.Milliseconds(),
+	})
+}
+
+// RenewClaim renews the lease of owner on a running task and reports
+// whether owner still holds it.
+func RenewClaim(ctx context.Context, repo xdb.XIDRepo, id, owner string, lease time.Duration) (bool, error) {
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(id), Path, map[string]any{
+		"payload.status": TaskStatusRunning,
+		"payload.owner":  owner,
+	}, map[string]any{
+		"payload.leaseExpiresAt": common.GetTimestamp() + lease.Milliseconds(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to renew claim on task %s: %v", id, err)
+	}
+	return ok, nil
+}
+
+// Submit moves a draft task to pending.
+func Submit(ctx context.Context, repo xdb.XIDRepo, id, actor string) (*Task, error) {
+	return Transition(ctx, repo, id, TaskStatusPending, actor, "", nil)
+}
+
+// Cancel stops a task that has not finished yet. Unfinished steps are
+// cancelled with it, so their leases can no longer complete.
+func Cancel(ctx context.Context, repo xdb.XIDRepo, id, actor, reason string) (*Task, error) {
+	return stop(ctx, repo, id, TaskStatusCancelled, StepStatusCancelled, actor, reason)
+}
+
+// TimeoutTask moves a task that ran out of time to timeout, together with
+// its unfinished steps.
+func TimeoutTask(ctx context.Context, repo xdb.XIDRepo, id, actor, reason string) (*Task, error) {
+	return stop(ctx, repo, id, TaskStatusTimeout, StepStatusTimeout, actor, reason)
+}
+
+// stop 遇到并发修改时最多尝试的次数
+const stopAttempts = 5
+
+// stop moves the task to a final status together with its unfinished steps.
+// The steps it rewrites are part of the condition, so a step completed or
+// leased again meanwhile fails the update, which is retried on the fresh
+// task instead of overwriting the step's result.
+func stop(ctx context.Context, repo xdb.XIDRepo, id string, to TaskStatus, stepTo StepStatus, actor, reason string) (*Task, error) {
+	for attempt := 1; ; attempt++ {
+		t, err := Get(ctx, repo, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkTransition(t.Status, to); err != nil {
+			return nil, err
+		}
+		now := common.GetTimestamp()
+		cond := map[string]any{}
+		for i := range t.Steps {
+			if t.Steps[i].Status == StepStatusPending || t.Steps[i].Status == StepStatusRunning {
+				prefix := fmt.Sprintf("payload.steps.%d.", i)
+				cond[prefix+"status"] = t.Steps[i].Status
+				if t.Steps[i].LeaseID != "" {
+					cond[prefix+"leaseId"] = t.Steps[i].LeaseID
+				}
+				t.Steps[i].Status = stepTo
+				t.Steps[i].FinishedAt = now
+			}
+		}
+		stopped, err := applyIf(ctx, repo, t, to, actor, reason, cond, map[string]any{
+			"payload.steps": t.Steps,
+		})
+		if !errors.Is(err, ErrTaskConflict) || attempt == stopAttempts {
+			return stopped, err
+		}
+	}
+}
+
+// Retry puts a failed, cancelled or timed out task back to pending as a new
+// attempt. Steps that did not complete are reset.
+func Retry(ctx context.Context, repo xdb.XIDRepo, id, actor, reason string) (*Task, error) {
+	t, err := Get(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransition(t.Status, TaskStatusPending); err != nil {
+		return nil, err
+	}
+	for i := range t.Steps {
+		if t.Steps[i].Status != StepStatusCompleted {
+			t.Steps[i].Status = StepStatusPending
+			t.Steps[i].Error = ""
+			t.Steps[i].Result = nil
+			t.Steps[i].Attempts = 0
+		}
+	}
+	return apply(ctx, repo, t, TaskStatusPending, actor, reason, map[string]any{
+		"payload.attempt": t.Attempt + 1,
+		"payload.steps":   t.Steps,
+		"payload.result":  "",
+		"payload.error":   "",
+	})
+}
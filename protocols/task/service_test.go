@@ -0,0 +1,184 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/xid-protocol/xidp/xdb"
+	"github.com/xid-protocol/xidp/xdb/xdbtest"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to TaskStatus
+		want     bool
+	}{
+		{TaskStatusInit, TaskStatusPending, true},
+		{TaskStatusInit, TaskStatusCancelled, true},
+		{TaskStatusInit, TaskStatusRunning, false},
+		{TaskStatusPending, TaskStatusRunning, true},
+		{TaskStatusPending, TaskStatusTimeout, true},
+		{TaskStatusPending, TaskStatusCompleted, false},
+		{TaskStatusRunning, TaskStatusCompleted, true},
+		{TaskStatusRunning, TaskStatusFailed, true},
+		{TaskStatusRunning, TaskStatusPending, false},
+		{TaskStatusRunning, TaskStatusInit, false},
+		{TaskStatusCompleted, TaskStatusPending, false},
+		{TaskStatusCompleted, TaskStatusRunning, false},
+		{TaskStatusFailed, TaskStatusPending, true},
+		{TaskStatusFailed, TaskStatusRunning, false},
+		{TaskStatusCancelled, TaskStatusPending, true},
+		{TaskStatusTimeout, TaskStatusPending, true},
+		{TaskStatusTimeout, TaskStatusCompleted, false},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// TestTransition walks a task through the state machine; illegal moves are
+// rejected and leave the task unchanged.
+func TestTransition(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	task, err := Create(ctx, repo, CreateRequest{TaskType: "scan", Draft: true, CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	steps := []struct {
+		to      TaskStatus
+		wantErr error
+	}{
+		{TaskStatusRunning, ErrInvalidTransition},
+		{TaskStatusPending, nil},
+		{TaskStatusCompleted, ErrInvalidTransition},
+		{TaskStatusRunning, nil},
+		{TaskStatusFailed, nil},
+		{TaskStatusCompleted, ErrInvalidTransition},
+		{TaskStatusPending, nil},
+		{TaskStatusRunning, nil},
+		{TaskStatusCompleted, nil},
+		{TaskStatusPending, ErrInvalidTransition},
+		{TaskStatusCancelled, ErrInvalidTransition},
+	}
+	moves := 0
+	for _, s := range steps {
+		before, err := Get(ctx, repo, task.TaskID)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		got, err := Transition(ctx, repo, task.TaskID, s.to, "bob", "", nil)
+		if !errors.Is(err, s.wantErr) {
+			t.Fatalf("%s -> %s: got %v, want %v", before.Status, s.to, err, s.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		moves++
+		if got.Status != s.to || len(got.Transitions) != moves {
+			t.Fatalf("%s -> %s: status %s with %d transitions, want %d", before.Status, s.to, got.Status, len(got.Transitions), moves)
+		}
+		last := got.Transitions[moves-1]
+		if last.From != before.Status || last.To != s.to || last.Actor != "bob" {
+			t.Errorf("transition = %+v, want %s -> %s by bob", last, before.Status, s.to)
+		}
+	}
+}
+
+// TestTransitionConflict applies a transition on a stale copy of a task
+// that another update has moved meanwhile.
+func TestTransitionConflict(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	task, err := Create(ctx, repo, CreateRequest{TaskType: "scan"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := Transition(ctx, repo, task.TaskID, TaskStatusCancelled, "alice", "", nil); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if _, err := apply(ctx, repo, task, TaskStatusRunning, "bob", "", nil); !errors.Is(err, ErrTaskConflict) {
+		t.Fatalf("stale transition got %v, want ErrTaskConflict", err)
+	}
+	// 重试后再次失败，转换次数不同
+	if _, err := Retry(ctx, repo, task.TaskID, "alice", ""); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if _, err := apply(ctx, repo, task, TaskStatusRunning, "bob", "", nil); !errors.Is(err, ErrTaskConflict) {
+		t.Fatalf("transition after a retry got %v, want ErrTaskConflict", err)
+	}
+}
+
+// hookRepo runs before once ahead of the first conditional update.
+type hookRepo struct {
+	xdb.XIDRepo
+	once   sync.Once
+	before func()
+}
+
+func (r *hookRepo) UpdateFieldsIf(ctx context.Context, xid, path string, cond, fields map[string]any) (bool, error) {
+	r.once.Do(r.before)
+	return r.XIDRepo.UpdateFieldsIf(ctx, xid, path, cond, fields)
+}
+
+// TestCancelKeepsCompletedStep completes a step while the task is being
+// cancelled: the step keeps its result, the others are cancelled.
+func TestCancelKeepsCompletedStep(t *testing.T) {
+	ctx := context.Background()
+	repo := xdbtest.New()
+	task := createTask(t, repo, TaskStep{StepID: "a"}, TaskStep{StepID: "b"})
+	registerWorker(t, repo, "w1", "scan")
+	lease, err := LeaseStep(ctx, repo, "w1", 0)
+	if err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+
+	hooked := &hookRepo{XIDRepo: repo, before: func() {
+		if _, err := CompleteStep(ctx, repo, CompleteRequest{
+			TaskID: task.TaskID, StepID: lease.Step.StepID, LeaseID: lease.LeaseID, WorkerID: "w1",
+			Result: map[string]any{"open": 22},
+		}); err != nil {
+			t.Errorf("complete: %v", err)
+		}
+	}}
+	cancelled, err := Cancel(ctx, hooked, task.TaskID, "alice", "")
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if cancelled.Status != TaskStatusCancelled {
+		t.Fatalf("status = %s, want cancelled", cancelled.Status)
+	}
+	for _, s := range cancelled.Steps {
+		want := StepStatusCancelled
+		if s.StepID == lease.Step.StepID {
+			want = StepStatusCompleted
+			if s.Result["open"] == nil {
+				t.Errorf("step %s lost its result: %+v", s.StepID, s)
+			}
+		}
+		if s.Status != want {
+			t.Errorf("step %s status = %s, want %s", s.StepID, s.Status, want)
+		}
+	}
+}
+
+func createTask(t *testing.T, repo xdb.XIDRepo, steps ...TaskStep) *Task {
+	t.Helper()
+	task, err := Create(context.Background(), repo, CreateRequest{TaskType: "scan", Steps: steps, CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	return task
+}
+
+func registerWorker(t *testing.T, repo xdb.XIDRepo, id string, capabilities ...string) {
+	t.Helper()
+	if _, err := RegisterWorker(context.Background(), repo, Worker{WorkerID: id, Capabilities: capabilities, Principal: "worker"}); err != nil {
+		t.Fatalf("register worker: %v", err)
+	}
+}
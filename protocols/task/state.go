@@ -0,0 +1,51 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTaskNotFound      = errors.New("task not found")
+	ErrInvalidTask       = errors.New("invalid task")
+	ErrInvalidTransition = errors.New("invalid task transition")
+	// 并发修改，状态已被其他请求改变
+	ErrTaskConflict = errors.New("task was modified concurrently")
+)
+
+// transitions lists the statuses a task may move to from each status.
+// Finished tasks other than completed may be retried back to pending.
+var transitions = map[TaskStatus][]TaskStatus{
+	TaskStatusInit:      {TaskStatusPending, TaskStatusCancelled},
+	TaskStatusPending:   {TaskStatusRunning, TaskStatusCancelled, TaskStatusTimeout},
+	TaskStatusRunning:   {TaskStatusCompleted, TaskStatusFailed, TaskStatusCancelled, TaskStatusTimeout},
+	TaskStatusFailed:    {TaskStatusPending},
+	TaskStatusCancelled: {TaskStatusPending},
+	TaskStatusTimeout:   {TaskStatusPending},
+}
+
+// CanTransition reports whether a task in status from may move to to.
+func CanTransition(from, to TaskStatus) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+func checkTransition(from, to TaskStatus) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
+
+// Finished reports whether the status is final until the task is retried.
+func (s TaskStatus) Finished() bool {
+	switch s {
+	case TaskStatusCompleted, TaskStatusFailed, TaskStatusCancelled, TaskStatusTimeout:
+		return true
+	}
+	return false
+}
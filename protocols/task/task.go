@@ -23,16 +23,6 @@ const (
 // 	TaskPriorityUrgent TaskPriority = 0 // 紧急优先级
 // )
 
-// // TaskSchedule 任务调度信息
-// type TaskSchedule struct {
-// 	StartTime      *time.Time `json:"startTime,omitempty" bson:"startTime,omitempty"`           // 计划开始时间
-// 	EndTime        *time.Time `json:"endTime,omitempty" bson:"endTime,omitempty"`               // 计划结束时间
-// 	Timeout        int64      `json:"timeout,omitempty" bson:"timeout,omitempty"`               // 超时时间（秒）
-// 	RetryCount     int        `json:"retryCount,omitempty" bson:"retryCount,omitempty"`         // 重试次数
-// 	RetryDelay     int64      `json:"retryDelay,omitempty" bson:"retryDelay,omitempty"`         // 重试延迟（秒）
-// 	CronExpression string     `json:"cronExpression,omitempty" bson:"cronExpression,omitempty"` // Cron表达式（定时任务）
-// }
-
 // // TaskExecution 任务执行信息
 // type TaskExecution struct {
 // 	ExecutionID string      `json:"executionId,omitempty" bson:"executionId,omitempty"` // 执行ID
@@ -73,13 +63,6 @@ const (
 // 	Details any    `json:"details,omitempty" bson:"details,omitempty"` // 错误详情
 // }
 
-// // TaskDependency 任务依赖
-// type TaskDependency struct {
-// 	TaskID    string `json:"taskId" bson:"taskId"`       // 依赖的任务ID
-// 	Condition string `json:"condition" bson:"condition"` // 依赖条件（success/failed/completed）
-// 	Required  bool   `json:"required" bson:"required"`   // 是否必需
-// }
-
 // // Task 任务主体结构
 // type Task struct {
 // 	Name         string           `json:"name" bson:"name"`                                     // 任务名称
@@ -108,17 +91,55 @@ const (
 	StepStatusFailed    StepStatus = "failed"    // 执行失败
 	StepStatusCancelled StepStatus = "cancelled" // 已取消
 	StepStatusTimeout   StepStatus = "timeout"   // 超时
+	StepStatusSkipped   StepStatus = "skipped"   // 依赖条件不满足，跳过
+)
+
+// DependencyCondition 依赖条件
+type DependencyCondition string
+
+const (
+	ConditionSuccess   DependencyCondition = "success"   // 上游成功
+	ConditionFailed    DependencyCondition = "failed"    // 上游失败、超时或取消
+	ConditionCompleted DependencyCondition = "completed" // 上游结束，不论结果
 )
 
+// TaskDependency 任务依赖，任务在依赖满足前停留在 pending
+type TaskDependency struct {
+	TaskID    string              `json:"taskId" bson:"taskId"`       // 依赖的任务ID
+	Condition DependencyCondition `json:"condition" bson:"condition"` // 依赖条件，默认 success
+	// 必需的依赖条件不满足时任务被取消，非必需的只等待上游结束
+	Required bool `json:"required" bson:"required"`
+}
+
+// StepDependency 步骤依赖，Params 把上游 Result 的字段传给本步骤的 Params
+type StepDependency struct {
+	StepID    string              `json:"stepId" bson:"stepId"`
+	Condition DependencyCondition `json:"condition" bson:"condition"`
+	// 必需的依赖条件不满足时步骤被跳过
+	Required bool `json:"required" bson:"required"`
+	// 本步骤参数名 -> 上游 Result 中的字段，支持 a.b.c
+	Params map[string]string `json:"params,omitempty" bson:"params,omitempty"`
+}
+
 type TaskStep struct {
-	StepID     string         `json:"stepId" bson:"stepId"`
-	StepName   string         `json:"stepName,omitempty" bson:"stepName,omitempty"`
-	WorkerID   string         `json:"workerId" bson:"workerId"`
-	WorkerName string         `json:"workerName" bson:"workerName"`
-	Params     map[string]any `json:"params" bson:"params"` //step params
-	Status     StepStatus     `json:"status" bson:"status"`
-	Result     map[string]any `json:"result" bson:"result"`
-	Error      string         `json:"error" bson:"error"`
+	StepID     string           `json:"stepId" bson:"stepId"`
+	StepName   string           `json:"stepName,omitempty" bson:"stepName,omitempty"`
+	WorkerID   string           `json:"workerId" bson:"workerId"`
+	WorkerName string           `json:"workerName" bson:"workerName"`
+	Params     map[string]any   `json:"params" bson:"params"` //step params
+	Status     StepStatus       `json:"status" bson:"status"`
+	Result     map[string]any   `json:"result" bson:"result"`
+	Error      string           `json:"error" bson:"error"`
+	DependsOn  []StepDependency `json:"dependsOn,omitempty" bson:"dependsOn,omitempty"`
+	// 执行该步骤需要的 worker 能力，默认为任务类型
+	Capability string `json:"capability" bson:"capability"`
+	// 当前租约，过期后步骤可被重新分发
+	LeaseID        string `json:"leaseId,omitempty" bson:"leaseId,omitempty"`
+	LeaseExpiresAt int64  `json:"leaseExpiresAt,omitempty" bson:"leaseExpiresAt,omitempty"`
+	// 已分发次数
+	Attempts   int   `json:"attempts" bson:"attempts"`
+	StartedAt  int64 `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	FinishedAt int64 `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
 }
 
 type StepEvent struct {
@@ -130,6 +151,16 @@ type StepEvent struct {
 	Data       map[string]any `json:"data" bson:"data"`
 }
 
+// TaskTransition records one status change of a task.
+type TaskTransition struct {
+	From   TaskStatus `json:"from" bson:"from"`
+	To     TaskStatus `json:"to" bson:"to"`
+	Actor  string     `json:"actor" bson:"actor"`
+	Reason string     `json:"reason,omitempty" bson:"reason,omitempty"`
+	At     int64      `json:"at" bson:"at"`
+}
+
+// path /protocols/task
 type Task struct {
 	TaskID      string     `json:"taskId" bson:"taskId"`
 	Name        string     `json:"name,omitempty" bson:"name,omitempty"`
@@ -142,40 +173,25 @@ type Task struct {
 	Steps       []TaskStep `json:"steps" bson:"steps"`
 	Result      string     `json:"result" bson:"result"`
 	Error       string     `json:"error" bson:"error"`
-	CreatedAt   int64      `json:"createdAt" bson:"createdAt"`
-	UpdatedAt   int64      `json:"updatedAt" bson:"updatedAt"`
+	// 第几次执行，retry 时加一
+	Attempt int `json:"attempt" bson:"attempt"`
+	// 超时时间（秒），从进入 running 开始计算，0 表示不限
+	Timeout int64 `json:"timeout,omitempty" bson:"timeout,omitempty"`
+	// 失败或超时后自动重试的次数和延迟（秒）
+	RetryCount int    `json:"retryCount,omitempty" bson:"retryCount,omitempty"`
+	RetryDelay int64  `json:"retryDelay,omitempty" bson:"retryDelay,omitempty"`
+	ScheduleID string `json:"scheduleId,omitempty" bson:"scheduleId,omitempty"`
+	// 固定的代理版本
+	Agent        string `json:"agent,omitempty" bson:"agent,omitempty"`
+	AgentVersion int64  `json:"agentVersion,omitempty" bson:"agentVersion,omitempty"`
+	// 上游任务
+	Dependencies []TaskDependency `json:"dependencies,omitempty" bson:"dependencies,omitempty"`
+	StartedAt    int64            `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	// 运行中任务的执行者；调度器之外的执行者（如对话）须在租约过期前续约，否则被调度器回收
+	Owner          string           `json:"owner,omitempty" bson:"owner,omitempty"`
+	LeaseExpiresAt int64            `json:"leaseExpiresAt,omitempty" bson:"leaseExpiresAt,omitempty"`
+	Transitions    []TaskTransition `json:"transitions" bson:"transitions"`
+	CreatedBy      string           `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt      int64            `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      int64            `json:"updatedAt" bson:"updatedAt"`
 }
-
-// NewTask
-// func CreateTask(task *Task) (*protocols.XID, error) {
-// 	task.CreatedAt = common.GetTimestamp()
-// 	task.UpdatedAt = common.GetTimestamp()
-// 	xid := protocols.GenerateXid(task.Name)
-// 	//check if XID already exists
-// 	xidRepository := xdb.NewXidInfoRepository()
-// 	XID, err := xidRepository.FindOneByXidAndPath(context.Background(), xid, "/protocols/task")
-// 	//if not nil, return error
-// 	if XID != nil {
-// 		return nil, errors.New("xid already exists")
-// 	}
-// 	if err != nil {
-// 		logx.Errorf("CreateTask Error: %v", err)
-// 		return nil, err
-// 	}
-
-// 	info := protocols.NewInfo(task.Name, "taskName")
-// 	info.Extra = map[string]any{
-// 		"description": task.Description,
-// 	}
-// 	metadata := protocols.NewMetadata(protocols.OperationInit, "/protocols/task", "application/json")
-
-// 	//create task
-// 	payload := &Task{
-// 		TaskType: task.TaskType,
-// 		Status:   TaskStatusInit,
-// 	}
-
-// 	XID = protocols.NewXID(&info, &metadata, payload)
-
-// 	return XID, nil
-// }
@@ -0,0 +1,157 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const PathWorker = "/protocols/task/worker"
+
+// 未配置 Task.worker_ttl 时，超过该秒数没有心跳的 worker 视为离线
+const defaultWorkerTTL = 60
+
+var (
+	ErrWorkerNotFound = errors.New("worker not found")
+	ErrWorkerOffline  = errors.New("worker is offline")
+	// 调用方不是注册该 worker 的调用方
+	ErrWorkerForbidden = errors.New("worker belongs to another principal")
+)
+
+// path /protocols/task/worker
+type Worker struct {
+	WorkerID      string   `json:"workerId" bson:"workerId"`
+	WorkerName    string   `json:"workerName" bson:"workerName"`
+	Capabilities  []string `json:"capabilities" bson:"capabilities"`
+	RegisteredAt  int64    `json:"registeredAt" bson:"registeredAt"`
+	LastHeartbeat int64    `json:"lastHeartbeat" bson:"lastHeartbeat"`
+	// 注册该 worker 的调用方，之后只有它可以以该 worker 领取和完成步骤
+	Principal string `json:"principal,omitempty" bson:"principal,omitempty"`
+}
+
+// Online reports whether the worker sent a heartbeat within the TTL.
+func (w *Worker) Online(now int64) bool {
+	return now-w.LastHeartbeat <= workerTTL()*1000
+}
+
+func (w *Worker) can(capability string) bool {
+	for _, c := range w.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func workerTTL() int64 {
+	if ttl := viper.GetInt64("Task.worker_ttl"); ttl > 0 {
+		return ttl
+	}
+	return defaultWorkerTTL
+}
+
+// RegisterWorker stores the worker, or refreshes its name and capabilities
+// when it registers again. A new ID is assigned when WorkerID is empty.
+// A worker registered by another principal is rejected with
+// ErrWorkerForbidden.
+func RegisterWorker(ctx context.Context, repo xdb.XIDRepo, w Worker) (*Worker, error) {
+	if len(w.Capabilities) == 0 {
+		return nil, fmt.Errorf("%w: capabilities are required", ErrInvalidTask)
+	}
+	w.WorkerID = strings.TrimSpace(w.WorkerID)
+	if w.WorkerID == "" {
+		w.WorkerID = common.GenerateID()
+	}
+	now := common.GetTimestamp()
+	w.RegisteredAt, w.LastHeartbeat = now, now
+	if prev, err := GetWorker(ctx, repo, w.WorkerID); err == nil {
+		if prev.Principal != "" && prev.Principal != w.Principal {
+			return nil, ErrWorkerForbidden
+		}
+		w.RegisteredAt = prev.RegisteredAt
+	}
+
+	info := protocols.NewInfo(w.WorkerID, "worker_id")
+	meta := protocols.NewMetadata(protocols.OperationUpdate, PathWorker, "application/json")
+	card := protocols.NewXID[any](&info, &meta, &w)
+	if err := repo.Upsert(ctx, card.Xid, PathWorker, card); err != nil {
+		return nil, fmt.Errorf("failed to store worker: %v", err)
+	}
+	return &w, nil
+}
+
+// Heartbeat marks the worker alive.
+func Heartbeat(ctx context.Context, repo xdb.XIDRepo, workerID string) error {
+	ok, err := repo.UpdateFieldsIf(ctx, protocols.GenerateXid(workerID), PathWorker, nil, map[string]any{
+		"payload.lastHeartbeat": common.GetTimestamp(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update worker: %v", err)
+	}
+	if !ok {
+		return ErrWorkerNotFound
+	}
+	return nil
+}
+
+// CheckWorker returns the worker if it was registered by principal.
+func CheckWorker(ctx context.Context, repo xdb.XIDRepo, workerID, principal string) (*Worker, error) {
+	w, err := GetWorker(ctx, repo, workerID)
+	if err != nil {
+		return nil, err
+	}
+	if w.Principal != principal {
+		return nil, ErrWorkerForbidden
+	}
+	return w, nil
+}
+
+func GetWorker(ctx context.Context, repo xdb.XIDRepo, workerID string) (*Worker, error) {
+	doc, err := repo.FindByXid(ctx, protocols.GenerateXid(workerID), PathWorker)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrWorkerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w Worker
+	if err := xdb.DecodePayload(doc, &w); err != nil {
+		return nil, fmt.Errorf("failed to decode worker %s: %v", workerID, err)
+	}
+	return &w, nil
+}
+
+// ListWorkers returns the registered workers, only those online when
+// onlineOnly is set.
+func ListWorkers(ctx context.Context, repo xdb.XIDRepo, onlineOnly bool) ([]*Worker, error) {
+	where := map[string]any{}
+	if onlineOnly {
+		where["payload.lastHeartbeat"] = map[string]any{"$gte": common.GetTimestamp() - workerTTL()*1000}
+	}
+	var workers []*Worker
+	var cursor *string
+	for {
+		docs, next, err := repo.List(ctx, xdb.Query{Path: PathWorker, Where: where, PageSize: 500, AfterCursor: cursor})
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var w Worker
+			if err := xdb.DecodePayload(doc, &w); err != nil {
+				return nil, fmt.Errorf("failed to decode worker %s: %v", doc.Xid, err)
+			}
+			workers = append(workers, &w)
+		}
+		if next == "" {
+			return workers, nil
+		}
+		cursor = &next
+	}
+}
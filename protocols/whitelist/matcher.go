@@ -0,0 +1,117 @@
+package whitelist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/xdb"
+)
+
+// Matcher answers whether a subject is allowed by the entries it was built
+// from. Only approved entries not expired at build time are considered.
+type Matcher struct {
+	kind    Kind
+	entries []*Whitelist
+}
+
+func NewMatcher(kind Kind, entries []*Whitelist, now int64) *Matcher {
+	m := &Matcher{kind: kind}
+	for _, w := range entries {
+		if w.Type == kind.Name() && w.EffectiveStatus(now) == StatusApproved {
+			m.entries = append(m.entries, w)
+		}
+	}
+	return m
+}
+
+func (m *Matcher) Match(subject Value) Match {
+	if m == nil {
+		return Match{EntryIDs: []string{}}
+	}
+	return m.kind.Match(m.entries, subject)
+}
+
+func (m *Matcher) MatchOpenPort(s OpenPortSubject) Match {
+	return m.Match(&s)
+}
+
+// Load builds a matcher for the kind name from the approved entries that may
+// apply to subject, or from all approved entries when subject is nil.
+func Load(ctx context.Context, repo xdb.XIDRepo, name string, subject Value) (*Matcher, error) {
+	kind, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidWhitelist, name)
+	}
+	now := common.GetTimestamp()
+	where := map[string]any{
+		"payload.type":      name,
+		"payload.status":    StatusApproved,
+		"payload.expiresAt": map[string]any{"$gt": now},
+	}
+	if scoper, ok := kind.(Scoper); ok && subject != nil {
+		for k, v := range scoper.Scope(subject) {
+			where[k] = v
+		}
+	}
+
+	var entries []*Whitelist
+	var cursor *string
+	for {
+		docs, next, err := repo.List(ctx, xdb.Query{
+			Path:        Path,
+			PageSize:    500,
+			Where:       where,
+			AfterCursor: cursor,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			w, err := decodeWhitelist(doc)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, w)
+		}
+		if next == "" {
+			break
+		}
+		cursor = &next
+	}
+	return NewMatcher(kind, entries, now), nil
+}
+
+// LoadMatcher builds an open port matcher for instanceID. Open port entries
+// are always bound to one instance.
+func LoadMatcher(ctx context.Context, repo xdb.XIDRepo, instanceID string) (*Matcher, error) {
+	return Load(ctx, repo, TypeAWSOpenPort, &OpenPortSubject{InstanceID: instanceID})
+}
+
+// LoadOpenPortMatcher builds an open port matcher from all approved entries,
+// to check the exposures of many instances with one query.
+func LoadOpenPortMatcher(ctx context.Context, repo xdb.XIDRepo) (*Matcher, error) {
+	return Load(ctx, repo, TypeAWSOpenPort, nil)
+}
+
+// Check decodes a subject of the kind name and matches it against the
+// approved entries of that kind.
+func Check(ctx context.Context, repo xdb.XIDRepo, name string, raw json.RawMessage) (Match, error) {
+	kind, ok := Lookup(name)
+	if !ok {
+		return Match{}, fmt.Errorf("%w: unsupported type %q", ErrInvalidWhitelist, name)
+	}
+	subject := kind.NewSubject()
+	if err := json.Unmarshal(raw, subject); err != nil {
+		return Match{}, fmt.Errorf("%w: subject: %v", ErrInvalidWhitelist, err)
+	}
+	if err := subject.Normalize(); err != nil {
+		return Match{}, err
+	}
+	m, err := Load(ctx, repo, name, subject)
+	if err != nil {
+		return Match{}, err
+	}
+	return m.Match(subject), nil
+}
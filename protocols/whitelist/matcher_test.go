@@ -0,0 +1,120 @@
+package whitelist
+
+import (
+	"reflect"
+	"testing"
+)
+
+const now = int64(1_000_000)
+
+func entry(id string, v Value) *Whitelist {
+	return &Whitelist{ID: id, Type: kindOf(v), Value: v, Status: StatusApproved, ExpiresAt: now + 1}
+}
+
+func kindOf(v Value) string {
+	for _, name := range Types() {
+		kind, _ := Lookup(name)
+		if reflect.TypeOf(kind.NewValue()) == reflect.TypeOf(v) {
+			return name
+		}
+	}
+	return ""
+}
+
+func TestMatchOpenPort(t *testing.T) {
+	port := func(id, cidr, protocol string, from, to int) *Whitelist {
+		p := &AWSOpenPort{InstanceID: "i-web", Cidr: cidr, Protocol: protocol, FromPort: from, ToPort: to}
+		if err := p.Normalize(); err != nil {
+			t.Fatalf("normalize %s: %v", id, err)
+		}
+		return entry(id, p)
+	}
+	entries := []*Whitelist{
+		port("ssh", "0.0.0.0/0", "tcp", 20, 25),
+		port("app", "0.0.0.0/0", "tcp", 24, 30),
+		port("high-a", "0.0.0.0/0", "tcp", 40, 50),
+		port("high-b", "0.0.0.0/0", "tcp", 52, 60),
+		port("intranet", "10.0.0.0/8", "tcp", 80, 80),
+		port("office", "198.51.100.0/24", "all", 0, 0),
+		port("v6", "2001:db8::/32", "tcp", 443, 443),
+	}
+	m := NewMatcher(openPortKind{}, entries, now)
+
+	subject := func(protocol string, from, to int, cidrs ...string) OpenPortSubject {
+		return OpenPortSubject{InstanceID: "i-web", Protocol: protocol, FromPort: from, ToPort: to, Cidrs: cidrs}
+	}
+	tests := []struct {
+		name    string
+		subject OpenPortSubject
+		allowed []string // 放行的条目，nil 表示不放行
+	}{
+		{"single entry", subject("tcp", 22, 22, "0.0.0.0/0"), []string{"ssh"}},
+		{"range inside an entry", subject("tcp", 21, 24, "0.0.0.0/0"), []string{"ssh"}},
+		{"range across overlapping entries", subject("tcp", 20, 30, "0.0.0.0/0"), []string{"app", "ssh"}},
+		{"range beyond the entries", subject("tcp", 20, 31, "0.0.0.0/0"), nil},
+		{"range starting before the entries", subject("tcp", 19, 22, "0.0.0.0/0"), nil},
+		{"gap between entries", subject("tcp", 40, 60, "0.0.0.0/0"), nil},
+		{"one side of a gap", subject("tcp", 52, 60, "0.0.0.0/0"), []string{"high-b"}},
+		{"other protocol", subject("udp", 22, 22, "0.0.0.0/0"), nil},
+		{"other instance", OpenPortSubject{InstanceID: "i-db", Protocol: "tcp", FromPort: 22, ToPort: 22, Cidrs: []string{"0.0.0.0/0"}}, nil},
+		{"narrower cidr", subject("tcp", 80, 80, "10.1.0.0/16"), []string{"intranet"}},
+		{"same cidr", subject("tcp", 80, 80, "10.0.0.0/8"), []string{"intranet"}},
+		{"wider cidr", subject("tcp", 80, 80, "0.0.0.0/0"), nil},
+		{"disjoint cidr", subject("tcp", 80, 80, "192.168.0.0/16"), nil},
+		{"every cidr must be covered", subject("tcp", 80, 80, "10.0.0.0/8", "0.0.0.0/0"), nil},
+		{"cidrs covered by one entry", subject("tcp", 22, 22, "10.0.0.0/8", "0.0.0.0/0"), []string{"ssh"}},
+		{"all protocols", subject("udp", 53, 53, "198.51.100.7/32"), []string{"office"}},
+		{"all protocols, icmp", subject("icmp", -1, -1, "198.51.100.0/24"), []string{"office"}},
+		{"ipv6", subject("tcp", 443, 443, "2001:db8:1::/48"), []string{"v6"}},
+		{"ipv6 any", subject("tcp", 443, 443, "::/0"), nil},
+		{"no cidrs", subject("tcp", 22, 22), nil},
+	}
+	for _, tt := range tests {
+		got := m.MatchOpenPort(tt.subject)
+		if got.Allowed != (tt.allowed != nil) {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got.Allowed, tt.allowed != nil)
+			continue
+		}
+		if tt.allowed != nil && !reflect.DeepEqual(got.EntryIDs, tt.allowed) {
+			t.Errorf("%s: entries = %v, want %v", tt.name, got.EntryIDs, tt.allowed)
+		}
+	}
+}
+
+// TestMatcherExpiry builds a matcher from entries in every state: only
+// approved entries not yet expired allow anything.
+func TestMatcherExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  Status
+		expires int64
+		allowed bool
+	}{
+		{"approved", StatusApproved, now + 1, true},
+		{"approved, expires now", StatusApproved, now, false},
+		{"approved, expired", StatusApproved, now - 1, false},
+		{"pending", StatusPending, now + 1, false},
+		{"rejected", StatusRejected, now + 1, false},
+		{"revoked", StatusRevoked, now + 1, false},
+	}
+	for _, tt := range tests {
+		w := entry("e", &IPCidr{Cidr: "10.0.0.0/8"})
+		w.Status, w.ExpiresAt = tt.status, tt.expires
+		kind, _ := Lookup(TypeIPCidr)
+		m := NewMatcher(kind, []*Whitelist{w}, now)
+		if got := m.Match(&IPCidr{Cidr: "10.0.0.1/32"}); got.Allowed != tt.allowed {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got.Allowed, tt.allowed)
+		}
+	}
+
+	// 其他类型的条目不参与匹配
+	kind, _ := Lookup(TypeDomain)
+	m := NewMatcher(kind, []*Whitelist{entry("e", &IPCidr{Cidr: "10.0.0.0/8"})}, now)
+	if got := m.Match(&Domain{Domain: "example.com"}); got.Allowed {
+		t.Errorf("domain matched an ipCidr entry: %+v", got)
+	}
+	var none *Matcher
+	if got := none.Match(&IPCidr{Cidr: "10.0.0.1/32"}); got.Allowed {
+		t.Errorf("nil matcher allowed %+v", got)
+	}
+}
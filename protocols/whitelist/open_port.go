@@ -0,0 +1,149 @@
+package whitelist
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+const TypeAWSOpenPort = "awsOpenPort"
+
+type AWSOpenPort struct {
+	InstanceID string `json:"instanceId" bson:"instanceId"`
+	Cidr       string `json:"cidr" bson:"cidr"`
+	FromPort   int    `json:"fromPort" bson:"fromPort"`
+	ToPort     int    `json:"toPort" bson:"toPort"`
+	Protocol   string `json:"protocol" bson:"protocol"`
+}
+
+// OpenPortSubject is an exposure to be checked against the whitelist.
+type OpenPortSubject struct {
+	InstanceID string   `json:"instanceId"`
+	Protocol   string   `json:"protocol"`
+	FromPort   int      `json:"fromPort"`
+	ToPort     int      `json:"toPort"`
+	Cidrs      []string `json:"cidrs"`
+}
+
+// Normalize validates the open port entry and brings it into canonical form.
+func (p *AWSOpenPort) Normalize() error {
+	if p.InstanceID == "" {
+		return fmt.Errorf("%w: instanceId is required", ErrInvalidWhitelist)
+	}
+	prefix, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return fmt.Errorf("%w: cidr %q: %v", ErrInvalidWhitelist, p.Cidr, err)
+	}
+	p.Cidr = prefix.Masked().String()
+
+	p.Protocol = strings.ToLower(p.Protocol)
+	switch p.Protocol {
+	case "-1", "all":
+		// 所有流量，icmp 的 type -1 也在范围内
+		p.Protocol, p.FromPort, p.ToPort = "-1", -1, 65535
+	case "tcp", "udp", "icmp", "icmpv6":
+	default:
+		return fmt.Errorf("%w: unsupported protocol %q", ErrInvalidWhitelist, p.Protocol)
+	}
+	if p.Protocol == "tcp" || p.Protocol == "udp" {
+		if p.FromPort < 0 || p.ToPort > 65535 || p.FromPort > p.ToPort {
+			return fmt.Errorf("%w: invalid port range %d-%d", ErrInvalidWhitelist, p.FromPort, p.ToPort)
+		}
+	}
+	return nil
+}
+
+func (s *OpenPortSubject) Normalize() error {
+	if s.InstanceID == "" || len(s.Cidrs) == 0 {
+		return fmt.Errorf("%w: instanceId and cidrs are required", ErrInvalidWhitelist)
+	}
+	s.Protocol = strings.ToLower(s.Protocol)
+	return nil
+}
+
+func (p AWSOpenPort) appliesTo(instanceID, protocol string) bool {
+	return p.InstanceID == instanceID && (p.Protocol == "-1" || p.Protocol == protocol)
+}
+
+// containsCidr reports whether cidr lies entirely inside the entry's CIDR.
+func (p AWSOpenPort) containsCidr(cidr string) bool {
+	allowed, err := netip.ParsePrefix(p.Cidr)
+	if err != nil {
+		return false
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return false
+	}
+	return prefix.Bits() >= allowed.Bits() && allowed.Contains(prefix.Addr())
+}
+
+type openPortKind struct{}
+
+func (openPortKind) Name() string      { return TypeAWSOpenPort }
+func (openPortKind) NewValue() Value   { return &AWSOpenPort{} }
+func (openPortKind) NewSubject() Value { return &OpenPortSubject{} }
+
+func (openPortKind) Scope(subject Value) map[string]any {
+	s := subject.(*OpenPortSubject)
+	return map[string]any{
+		"payload.value.instanceId": s.InstanceID,
+	}
+}
+
+// Match allows the subject when, for every source CIDR, the port ranges of
+// the entries whose CIDR contains it together cover the whole exposed range.
+// Several overlapping entries may share the work.
+func (openPortKind) Match(entries []*Whitelist, subject Value) Match {
+	s := subject.(*OpenPortSubject)
+	match := Match{EntryIDs: []string{}}
+	if len(s.Cidrs) == 0 {
+		return match
+	}
+
+	used := map[string]bool{}
+	for _, cidr := range s.Cidrs {
+		type span struct {
+			from, to int
+			id       string
+		}
+		var spans []span
+		for _, w := range entries {
+			p, ok := w.Value.(*AWSOpenPort)
+			if !ok || !p.appliesTo(s.InstanceID, s.Protocol) || !p.containsCidr(cidr) {
+				continue
+			}
+			if p.ToPort < s.FromPort || p.FromPort > s.ToPort {
+				continue
+			}
+			spans = append(spans, span{p.FromPort, p.ToPort, w.ID})
+		}
+		sort.Slice(spans, func(i, j int) bool { return spans[i].from < spans[j].from })
+
+		// 从 FromPort 开始向右扫描，中间出现空洞即不放行
+		next := s.FromPort
+		for _, sp := range spans {
+			if sp.from > next {
+				break
+			}
+			if sp.to >= next {
+				next = sp.to + 1
+				used[sp.id] = true
+			}
+			if next > s.ToPort {
+				break
+			}
+		}
+		if next <= s.ToPort {
+			return match
+		}
+	}
+
+	match.Allowed = true
+	for id := range used {
+		match.EntryIDs = append(match.EntryIDs, id)
+	}
+	sort.Strings(match.EntryIDs)
+	return match
+}
@@ -0,0 +1,118 @@
+package whitelist
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Value is the typed value of a whitelist entry. Normalize validates it and
+// brings it into canonical form, so equal entries hash the same.
+type Value interface {
+	Normalize() error
+}
+
+// Kind implements one whitelist type. Register it to make the type
+// available to Create, decoding and Check.
+type Kind interface {
+	Name() string
+	// NewValue returns a pointer to an empty value to decode into.
+	NewValue() Value
+	// NewSubject returns a pointer to an empty subject to decode a check
+	// request into.
+	NewSubject() Value
+	// Match decides subject against approved, unexpired entries of the kind.
+	Match(entries []*Whitelist, subject Value) Match
+}
+
+// Scoper is implemented by kinds that can narrow the entries loaded for a
+// subject, e.g. to one instance. Keys are full field paths of the card.
+type Scoper interface {
+	Scope(subject Value) map[string]any
+}
+
+type Match struct {
+	Allowed  bool     `json:"allowed"`
+	EntryIDs []string `json:"entryIds"`
+}
+
+var (
+	kindsMu sync.RWMutex
+	kinds   = map[string]Kind{}
+)
+
+// Register makes a whitelist kind available under its name, replacing any
+// kind registered under the same name.
+func Register(k Kind) {
+	kindsMu.Lock()
+	defer kindsMu.Unlock()
+	kinds[k.Name()] = k
+}
+
+func Lookup(name string) (Kind, bool) {
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+	k, ok := kinds[name]
+	return k, ok
+}
+
+// Types returns the names of the registered kinds.
+func Types() []string {
+	kindsMu.RLock()
+	defer kindsMu.RUnlock()
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(openPortKind{})
+	Register(ipCidrKind{})
+	Register(domainKind{})
+	Register(userAccountKind{})
+	Register(processHashKind{})
+	Register(agentToolKind{})
+}
+
+// decodeValue parses raw into the value of kind name and normalizes it.
+func decodeValue(name string, raw json.RawMessage) (Value, error) {
+	k, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidWhitelist, name)
+	}
+	v := k.NewValue()
+	if err := json.Unmarshal(raw, v); err != nil {
+		return nil, fmt.Errorf("%w: value: %v", ErrInvalidWhitelist, err)
+	}
+	if err := v.Normalize(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// canonicalHash hashes the type and normalized value, used to dedupe.
+func canonicalHash(name string, v Value) string {
+	raw, _ := json.Marshal(struct {
+		Type  string `json:"type"`
+		Value Value  `json:"value"`
+	}{name, v})
+	return fmt.Sprintf("%x", sha256.Sum256(raw))
+}
+
+// matchAny allows the subject when any single entry matches it.
+func matchAny(entries []*Whitelist, match func(Value) bool) Match {
+	m := Match{EntryIDs: []string{}}
+	for _, w := range entries {
+		if v, ok := w.Value.(Value); ok && match(v) {
+			m.Allowed = true
+			m.EntryIDs = append(m.EntryIDs, w.ID)
+		}
+	}
+	sort.Strings(m.EntryIDs)
+	return m
+}
@@ -0,0 +1,331 @@
+package whitelist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/xid-protocol/common"
+	"github.com/xid-protocol/xidp/internal/notify"
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 未配置 Whitelist.max_days 时白名单最长有效期
+const defaultMaxDays = 365
+
+type CreateRequest struct {
+	Type          string          `json:"type"`
+	Value         json.RawMessage `json:"value"`
+	Owner         string          `json:"owner"`
+	Justification string          `json:"justification"`
+	ExpiresAt     int64           `json:"expiresAt"`
+	// the authenticated caller, never taken from the request body
+	CreatedBy string `json:"-"`
+	Approver  string `json:"approver"`
+}
+
+type ListFilter struct {
+	Type     string
+	Owner    string
+	Status   Status
+	Approver string
+	// 仅 awsOpenPort
+	InstanceID string
+	PageSize   int
+	Cursor     string
+}
+
+// Create validates req, stores it as a pending whitelist card and asks the
+// approver for a decision. An entry with the same canonical value that is
+// pending or approved yields ErrWhitelistExists together with the existing
+// entry; a rejected, revoked or expired one is replaced by the new request,
+// keeping its audit trail.
+func Create(ctx context.Context, repo xdb.XIDRepo, req CreateRequest) (*Whitelist, error) {
+	now := common.GetTimestamp()
+	if strings.TrimSpace(req.CreatedBy) == "" {
+		return nil, fmt.Errorf("%w: createdBy is required", ErrInvalidWhitelist)
+	}
+	if strings.TrimSpace(req.Approver) == "" {
+		return nil, fmt.Errorf("%w: approver is required", ErrInvalidWhitelist)
+	}
+	if req.Approver == req.CreatedBy {
+		return nil, fmt.Errorf("%w: approver must not be the requester", ErrInvalidWhitelist)
+	}
+	if strings.TrimSpace(req.Owner) == "" {
+		return nil, fmt.Errorf("%w: owner is required", ErrInvalidWhitelist)
+	}
+	if strings.TrimSpace(req.Justification) == "" {
+		return nil, fmt.Errorf("%w: justification is required", ErrInvalidWhitelist)
+	}
+	if req.ExpiresAt <= now {
+		return nil, fmt.Errorf("%w: expiresAt must be in the future", ErrInvalidWhitelist)
+	}
+	maxDays := viper.GetInt64("Whitelist.max_days")
+	if maxDays <= 0 {
+		maxDays = defaultMaxDays
+	}
+	if req.ExpiresAt > now+maxDays*24*60*60*1000 {
+		return nil, fmt.Errorf("%w: expiresAt is more than %d days away", ErrInvalidWhitelist, maxDays)
+	}
+
+	value, err := decodeValue(req.Type, req.Value)
+	if err != nil {
+		return nil, err
+	}
+	sum := canonicalHash(req.Type, value)
+
+	xid := protocols.GenerateXid(sum)
+	existing, err := Get(ctx, repo, xid)
+	if err != nil && !errors.Is(err, ErrWhitelistMissing) {
+		return nil, err
+	}
+	if existing != nil {
+		switch existing.EffectiveStatus(now) {
+		case StatusPending, StatusApproved:
+			return existing, ErrWhitelistExists
+		}
+	}
+
+	wl := &Whitelist{
+		ID:            xid,
+		Type:          req.Type,
+		Value:         value,
+		Sha256Value:   sum,
+		Owner:         req.Owner,
+		Justification: req.Justification,
+		ExpiresAt:     req.ExpiresAt,
+		Status:        StatusPending,
+		CreatedBy:     req.CreatedBy,
+		Approver:      req.Approver,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Audit:         []AuditEntry{},
+	}
+	if existing != nil {
+		wl.Audit = append(wl.Audit, existing.Audit...)
+	}
+	wl.Audit = append(wl.Audit, AuditEntry{Action: AuditRequested, Actor: req.CreatedBy, Comment: req.Justification, At: now})
+
+	stored, err := store(ctx, repo, wl, existing)
+	if err != nil {
+		return nil, err
+	}
+	if !stored {
+		// 并发的申请已抢先写入
+		current, err := Get(ctx, repo, xid)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrWhitelistExists
+	}
+
+	notify.Send(ctx, "[xidp] whitelist approval requested", fmt.Sprintf(
+		"%s requests a %s whitelist owned by %s until %s, approver: %s\nvalue: %s\njustification: %s\nid: %s",
+		wl.CreatedBy, wl.Type, wl.Owner, time.UnixMilli(wl.ExpiresAt).UTC().Format(time.RFC3339),
+		wl.Approver, string(req.Value), wl.Justification, wl.ID))
+	return wl, nil
+}
+
+// store writes wl as a new card, or over previous when the entry is
+// requested again. It reports false when a concurrent request got there
+// first: the card already exists, or previous changed since it was read.
+func store(ctx context.Context, repo xdb.XIDRepo, wl *Whitelist, previous *Whitelist) (bool, error) {
+	if previous == nil {
+		info := protocols.NewInfo(wl.Sha256Value, "whitelist_sha256")
+		meta := protocols.NewMetadata(protocols.OperationCreate, Path, "application/json")
+		err := repo.Insert(ctx, protocols.NewXID[any](&info, &meta, wl))
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to store whitelist: %v", err)
+		}
+		return true, nil
+	}
+	ok, err := repo.UpdateFieldsIf(ctx, wl.ID, Path, map[string]any{
+		"payload.status":    previous.Status,
+		"payload.updatedAt": previous.UpdatedAt,
+	}, map[string]any{"payload": wl})
+	if err != nil {
+		return false, fmt.Errorf("failed to store whitelist: %v", err)
+	}
+	return ok, nil
+}
+
+// Get returns the whitelist entry stored under id (the xid of its card).
+func Get(ctx context.Context, repo xdb.XIDRepo, id string) (*Whitelist, error) {
+	doc, err := repo.FindByXid(ctx, id, Path)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrWhitelistMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeWhitelist(doc)
+}
+
+// List returns one page of whitelist entries and the cursor of the next page.
+// Status filters by effective status, so expired entries can be listed apart
+// from active ones.
+func List(ctx context.Context, repo xdb.XIDRepo, filter ListFilter) ([]*Whitelist, string, error) {
+	now := common.GetTimestamp()
+	where := map[string]any{}
+	if filter.Type != "" {
+		where["payload.type"] = filter.Type
+	}
+	if filter.Owner != "" {
+		where["payload.owner"] = filter.Owner
+	}
+	if filter.InstanceID != "" {
+		where["payload.value.instanceId"] = filter.InstanceID
+	}
+	if filter.Approver != "" {
+		where["payload.approver"] = filter.Approver
+	}
+	switch filter.Status {
+	case "":
+	case StatusPending, StatusApproved:
+		where["payload.status"] = filter.Status
+		where["payload.expiresAt"] = map[string]any{"$gt": now}
+	case StatusExpired:
+		where["payload.status"] = map[string]any{"$in": []Status{StatusPending, StatusApproved}}
+		where["payload.expiresAt"] = map[string]any{"$lte": now}
+	default:
+		where["payload.status"] = filter.Status
+	}
+
+	q := xdb.Query{
+		Path:     Path,
+		Where:    where,
+		SortBy:   "createdAt",
+		PageSize: filter.PageSize,
+	}
+	if filter.Cursor != "" {
+		q.AfterCursor = &filter.Cursor
+	}
+	docs, next, err := repo.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	out := make([]*Whitelist, 0, len(docs))
+	for _, doc := range docs {
+		wl, err := decodeWhitelist(doc)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, wl)
+	}
+	return out, next, nil
+}
+
+// Approve puts a pending entry into effect. Only the designated approver,
+// who is never the requester, may approve.
+func Approve(ctx context.Context, repo xdb.XIDRepo, id, actor, comment string) (*Whitelist, error) {
+	return decide(ctx, repo, id, actor, comment, StatusApproved, AuditApproved)
+}
+
+// Reject closes a pending entry without putting it into effect.
+func Reject(ctx context.Context, repo xdb.XIDRepo, id, actor, comment string) (*Whitelist, error) {
+	return decide(ctx, repo, id, actor, comment, StatusRejected, AuditRejected)
+}
+
+func decide(ctx context.Context, repo xdb.XIDRepo, id, actor, comment string, status Status, action string) (*Whitelist, error) {
+	wl, err := Get(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+	now := common.GetTimestamp()
+	switch wl.EffectiveStatus(now) {
+	case StatusPending:
+	case StatusExpired:
+		return nil, ErrWhitelistExpired
+	default:
+		return nil, ErrNotPending
+	}
+	if actor == "" || actor != wl.Approver || actor == wl.CreatedBy {
+		return nil, ErrNotApprover
+	}
+
+	wl.Status, wl.DecidedBy, wl.DecidedAt, wl.UpdatedAt = status, actor, now, now
+	wl.Audit = append(wl.Audit, AuditEntry{Action: action, Actor: actor, Comment: comment, At: now})
+	if err := transition(ctx, repo, wl, StatusPending, map[string]any{
+		"payload.decidedBy": wl.DecidedBy,
+		"payload.decidedAt": wl.DecidedAt,
+	}); err != nil {
+		return nil, err
+	}
+
+	notify.Send(ctx, "[xidp] whitelist "+action, fmt.Sprintf(
+		"%s %s the %s whitelist requested by %s\ncomment: %s\nid: %s",
+		actor, action, wl.Type, wl.CreatedBy, comment, wl.ID))
+	return wl, nil
+}
+
+// Revoke takes a pending or approved entry out of effect. Only its requester
+// or approver may revoke it. The card is kept for audit.
+func Revoke(ctx context.Context, repo xdb.XIDRepo, id, revokedBy, reason string) (*Whitelist, error) {
+	wl, err := Get(ctx, repo, id)
+	if err != nil {
+		return nil, err
+	}
+	if revokedBy == "" || (revokedBy != wl.CreatedBy && revokedBy != wl.Approver) {
+		return nil, ErrNotRevoker
+	}
+	if wl.Status != StatusPending && wl.Status != StatusApproved {
+		return wl, nil
+	}
+	now := common.GetTimestamp()
+	from := wl.Status
+	wl.Status, wl.RevokedBy, wl.RevokedAt, wl.RevokeReason, wl.UpdatedAt = StatusRevoked, revokedBy, now, reason, now
+	wl.Audit = append(wl.Audit, AuditEntry{Action: AuditRevoked, Actor: revokedBy, Comment: reason, At: now})
+	if err := transition(ctx, repo, wl, from, map[string]any{
+		"payload.revokedBy":    wl.RevokedBy,
+		"payload.revokedAt":    wl.RevokedAt,
+		"payload.revokeReason": wl.RevokeReason,
+	}); err != nil {
+		return nil, err
+	}
+	return wl, nil
+}
+
+// transition stores the new status and audit trail of wl, provided the card
+// is still in status from. A concurrent decision yields ErrNotPending.
+func transition(ctx context.Context, repo xdb.XIDRepo, wl *Whitelist, from Status, fields map[string]any) error {
+	fields["payload.status"] = wl.Status
+	fields["payload.updatedAt"] = wl.UpdatedAt
+	fields["payload.audit"] = wl.Audit
+	ok, err := repo.UpdateFieldsIf(ctx, wl.ID, Path, map[string]any{"payload.status": from}, fields)
+	if err != nil {
+		return fmt.Errorf("failed to update whitelist: %v", err)
+	}
+	if !ok {
+		return ErrNotPending
+	}
+	return nil
+}
+
+// decodeWhitelist decodes a card payload, turning Value into its typed form.
+func decodeWhitelist(doc *protocols.XID[any]) (*Whitelist, error) {
+	var wl Whitelist
+	if err := xdb.DecodePayload(doc, &wl); err != nil {
+		return nil, fmt.Errorf("failed to decode whitelist %s: %v", doc.Xid, err)
+	}
+	if wl.ID == "" {
+		wl.ID = doc.Xid
+	}
+	// 未注册的类型保留原始值
+	if kind, ok := Lookup(wl.Type); ok {
+		v := kind.NewValue()
+		if err := xdb.DecodePayload(&protocols.XID[any]{Payload: wl.Value}, v); err != nil {
+			return nil, fmt.Errorf("failed to decode whitelist %s: %v", doc.Xid, err)
+		}
+		wl.Value = v
+	}
+	return &wl, nil
+}
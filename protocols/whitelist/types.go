@@ -0,0 +1,206 @@
+package whitelist
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const (
+	TypeIPCidr      = "ipCidr"
+	TypeDomain      = "domain"
+	TypeUserAccount = "userAccount"
+	TypeProcessHash = "processHash"
+	TypeAgentTool   = "aiAgentToolPermission"
+)
+
+// IPCidr allows an address or network. A bare address is taken as /32 or
+// /128. As a subject it is the address or network being checked.
+type IPCidr struct {
+	Cidr string `json:"cidr" bson:"cidr"`
+}
+
+func (v *IPCidr) Normalize() error {
+	s := strings.TrimSpace(v.Cidr)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		v.Cidr = netip.PrefixFrom(addr, addr.BitLen()).String()
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return fmt.Errorf("%w: cidr %q: %v", ErrInvalidWhitelist, v.Cidr, err)
+	}
+	v.Cidr = prefix.Masked().String()
+	return nil
+}
+
+type ipCidrKind struct{}
+
+func (ipCidrKind) Name() string      { return TypeIPCidr }
+func (ipCidrKind) NewValue() Value   { return &IPCidr{} }
+func (ipCidrKind) NewSubject() Value { return &IPCidr{} }
+
+func (ipCidrKind) Match(entries []*Whitelist, subject Value) Match {
+	s := subject.(*IPCidr)
+	return matchAny(entries, func(v Value) bool {
+		return AWSOpenPort{Cidr: v.(*IPCidr).Cidr}.containsCidr(s.Cidr)
+	})
+}
+
+// Domain allows a host name, and every name below it when
+// IncludeSubdomains is set.
+type Domain struct {
+	Domain            string `json:"domain" bson:"domain"`
+	IncludeSubdomains bool   `json:"includeSubdomains" bson:"includeSubdomains"`
+}
+
+func (v *Domain) Normalize() error {
+	d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(v.Domain)), ".")
+	if d == "" || len(d) > 253 {
+		return fmt.Errorf("%w: invalid domain %q", ErrInvalidWhitelist, v.Domain)
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("%w: invalid domain %q", ErrInvalidWhitelist, v.Domain)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fmt.Errorf("%w: invalid domain %q", ErrInvalidWhitelist, v.Domain)
+			}
+		}
+	}
+	v.Domain = d
+	return nil
+}
+
+type domainKind struct{}
+
+func (domainKind) Name() string      { return TypeDomain }
+func (domainKind) NewValue() Value   { return &Domain{} }
+func (domainKind) NewSubject() Value { return &Domain{} }
+
+func (domainKind) Match(entries []*Whitelist, subject Value) Match {
+	s := subject.(*Domain)
+	return matchAny(entries, func(v Value) bool {
+		d := v.(*Domain)
+		return s.Domain == d.Domain || d.IncludeSubdomains && strings.HasSuffix(s.Domain, "."+d.Domain)
+	})
+}
+
+// UserAccount allows an account of an identity provider, e.g. aws, github
+// or ldap. An empty Provider matches the account on any provider. Both are
+// compared case-insensitively.
+type UserAccount struct {
+	Provider string `json:"provider" bson:"provider"`
+	Account  string `json:"account" bson:"account"`
+}
+
+func (v *UserAccount) Normalize() error {
+	v.Provider = strings.ToLower(strings.TrimSpace(v.Provider))
+	v.Account = strings.ToLower(strings.TrimSpace(v.Account))
+	if v.Account == "" {
+		return fmt.Errorf("%w: account is required", ErrInvalidWhitelist)
+	}
+	return nil
+}
+
+type userAccountKind struct{}
+
+func (userAccountKind) Name() string      { return TypeUserAccount }
+func (userAccountKind) NewValue() Value   { return &UserAccount{} }
+func (userAccountKind) NewSubject() Value { return &UserAccount{} }
+
+func (userAccountKind) Scope(subject Value) map[string]any {
+	return map[string]any{"payload.value.account": subject.(*UserAccount).Account}
+}
+
+func (userAccountKind) Match(entries []*Whitelist, subject Value) Match {
+	s := subject.(*UserAccount)
+	return matchAny(entries, func(v Value) bool {
+		a := v.(*UserAccount)
+		return a.Account == s.Account && (a.Provider == "" || a.Provider == s.Provider)
+	})
+}
+
+// ProcessHash allows an executable by the hash of its binary. Algorithm is
+// derived from the length of Hash when empty.
+type ProcessHash struct {
+	Algorithm string `json:"algorithm" bson:"algorithm"`
+	Hash      string `json:"hash" bson:"hash"`
+}
+
+var hashLengths = map[string]int{"md5": 32, "sha1": 40, "sha256": 64}
+
+func (v *ProcessHash) Normalize() error {
+	v.Hash = strings.ToLower(strings.TrimSpace(v.Hash))
+	v.Algorithm = strings.ToLower(strings.TrimSpace(v.Algorithm))
+	if v.Algorithm == "" {
+		for alg, n := range hashLengths {
+			if len(v.Hash) == n {
+				v.Algorithm = alg
+			}
+		}
+	}
+	n, ok := hashLengths[v.Algorithm]
+	if !ok {
+		return fmt.Errorf("%w: unsupported hash algorithm %q", ErrInvalidWhitelist, v.Algorithm)
+	}
+	if _, err := hex.DecodeString(v.Hash); err != nil || len(v.Hash) != n {
+		return fmt.Errorf("%w: invalid %s hash %q", ErrInvalidWhitelist, v.Algorithm, v.Hash)
+	}
+	return nil
+}
+
+type processHashKind struct{}
+
+func (processHashKind) Name() string      { return TypeProcessHash }
+func (processHashKind) NewValue() Value   { return &ProcessHash{} }
+func (processHashKind) NewSubject() Value { return &ProcessHash{} }
+
+func (processHashKind) Scope(subject Value) map[string]any {
+	return map[string]any{"payload.value.hash": subject.(*ProcessHash).Hash}
+}
+
+func (processHashKind) Match(entries []*Whitelist, subject Value) Match {
+	s := subject.(*ProcessHash)
+	return matchAny(entries, func(v Value) bool {
+		return *v.(*ProcessHash) == *s
+	})
+}
+
+// AgentToolPermission allows an AI agent to call a tool. An empty Server
+// matches the tool on any MCP server, Tool "*" matches every tool.
+type AgentToolPermission struct {
+	AgentID string `json:"agentId" bson:"agentId"`
+	Server  string `json:"server" bson:"server"`
+	Tool    string `json:"tool" bson:"tool"`
+}
+
+func (v *AgentToolPermission) Normalize() error {
+	v.AgentID = strings.TrimSpace(v.AgentID)
+	v.Server = strings.TrimSpace(v.Server)
+	v.Tool = strings.TrimSpace(v.Tool)
+	if v.AgentID == "" || v.Tool == "" {
+		return fmt.Errorf("%w: agentId and tool are required", ErrInvalidWhitelist)
+	}
+	return nil
+}
+
+type agentToolKind struct{}
+
+func (agentToolKind) Name() string      { return TypeAgentTool }
+func (agentToolKind) NewValue() Value   { return &AgentToolPermission{} }
+func (agentToolKind) NewSubject() Value { return &AgentToolPermission{} }
+
+func (agentToolKind) Scope(subject Value) map[string]any {
+	return map[string]any{"payload.value.agentId": subject.(*AgentToolPermission).AgentID}
+}
+
+func (agentToolKind) Match(entries []*Whitelist, subject Value) Match {
+	s := subject.(*AgentToolPermission)
+	return matchAny(entries, func(v Value) bool {
+		p := v.(*AgentToolPermission)
+		return p.AgentID == s.AgentID && (p.Server == "" || p.Server == s.Server) && (p.Tool == "*" || p.Tool == s.Tool)
+	})
+}
@@ -0,0 +1,69 @@
+package whitelist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchTypes(t *testing.T) {
+	sha256 := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		entries map[string]Value // id -> 条目
+		subject Value
+		allowed []string // 放行的条目，nil 表示不放行
+	}{
+		// ipCidr 按包含关系匹配
+		{"address in network", map[string]Value{"net": &IPCidr{Cidr: "10.0.0.0/8"}}, &IPCidr{Cidr: "10.1.2.3"}, []string{"net"}},
+		{"network in network", map[string]Value{"net": &IPCidr{Cidr: "10.0.0.0/8"}}, &IPCidr{Cidr: "10.1.0.0/16"}, []string{"net"}},
+		{"same address", map[string]Value{"host": &IPCidr{Cidr: "198.51.100.7"}}, &IPCidr{Cidr: "198.51.100.7/32"}, []string{"host"}},
+		{"wider network", map[string]Value{"net": &IPCidr{Cidr: "10.0.0.0/8"}}, &IPCidr{Cidr: "10.0.0.0/7"}, nil},
+		{"address outside", map[string]Value{"net": &IPCidr{Cidr: "10.0.0.0/8"}}, &IPCidr{Cidr: "11.0.0.1"}, nil},
+		{"unmasked entry", map[string]Value{"net": &IPCidr{Cidr: "10.9.9.9/8"}}, &IPCidr{Cidr: "10.1.2.3"}, []string{"net"}},
+		{"ipv6", map[string]Value{"v6": &IPCidr{Cidr: "2001:db8::/32"}}, &IPCidr{Cidr: "2001:db8::1"}, []string{"v6"}},
+		{"ipv4 against ipv6", map[string]Value{"v6": &IPCidr{Cidr: "::/0"}}, &IPCidr{Cidr: "10.1.2.3"}, nil},
+		{"several entries", map[string]Value{"a": &IPCidr{Cidr: "10.0.0.0/8"}, "b": &IPCidr{Cidr: "10.1.0.0/16"}, "c": &IPCidr{Cidr: "192.168.0.0/16"}}, &IPCidr{Cidr: "10.1.2.3"}, []string{"a", "b"}},
+		// domain 按后缀匹配，仅在 IncludeSubdomains 时包含子域名
+		{"same domain", map[string]Value{"d": &Domain{Domain: "example.com"}}, &Domain{Domain: "Example.COM."}, []string{"d"}},
+		{"subdomain not included", map[string]Value{"d": &Domain{Domain: "example.com"}}, &Domain{Domain: "api.example.com"}, nil},
+		{"subdomain", map[string]Value{"d": &Domain{Domain: "example.com", IncludeSubdomains: true}}, &Domain{Domain: "api.example.com"}, []string{"d"}},
+		{"nested subdomain", map[string]Value{"d": &Domain{Domain: "example.com", IncludeSubdomains: true}}, &Domain{Domain: "a.b.example.com"}, []string{"d"}},
+		{"suffix without a dot", map[string]Value{"d": &Domain{Domain: "example.com", IncludeSubdomains: true}}, &Domain{Domain: "badexample.com"}, nil},
+		{"parent domain", map[string]Value{"d": &Domain{Domain: "api.example.com", IncludeSubdomains: true}}, &Domain{Domain: "example.com"}, nil},
+		// userAccount
+		{"account on provider", map[string]Value{"u": &UserAccount{Provider: "github", Account: "alice"}}, &UserAccount{Provider: "GitHub", Account: "Alice"}, []string{"u"}},
+		{"account on other provider", map[string]Value{"u": &UserAccount{Provider: "github", Account: "alice"}}, &UserAccount{Provider: "ldap", Account: "alice"}, nil},
+		{"account on any provider", map[string]Value{"u": &UserAccount{Account: "alice"}}, &UserAccount{Provider: "ldap", Account: "alice"}, []string{"u"}},
+		// processHash
+		{"hash", map[string]Value{"h": &ProcessHash{Hash: sha256}}, &ProcessHash{Algorithm: "SHA256", Hash: strings.ToUpper(sha256)}, []string{"h"}},
+		{"other hash", map[string]Value{"h": &ProcessHash{Hash: sha256}}, &ProcessHash{Hash: strings.Repeat("cd", 32)}, nil},
+		// aiAgentToolPermission
+		{"tool", map[string]Value{"p": &AgentToolPermission{AgentID: "a1", Server: "nmap", Tool: "scan"}}, &AgentToolPermission{AgentID: "a1", Server: "nmap", Tool: "scan"}, []string{"p"}},
+		{"tool on any server", map[string]Value{"p": &AgentToolPermission{AgentID: "a1", Tool: "scan"}}, &AgentToolPermission{AgentID: "a1", Server: "nmap", Tool: "scan"}, []string{"p"}},
+		{"every tool", map[string]Value{"p": &AgentToolPermission{AgentID: "a1", Server: "nmap", Tool: "*"}}, &AgentToolPermission{AgentID: "a1", Server: "nmap", Tool: "exploit"}, []string{"p"}},
+		{"tool on other server", map[string]Value{"p": &AgentToolPermission{AgentID: "a1", Server: "nmap", Tool: "scan"}}, &AgentToolPermission{AgentID: "a1", Server: "shell", Tool: "scan"}, nil},
+		{"tool of other agent", map[string]Value{"p": &AgentToolPermission{AgentID: "a1", Tool: "*"}}, &AgentToolPermission{AgentID: "a2", Server: "nmap", Tool: "scan"}, nil},
+	}
+	for _, tt := range tests {
+		var entries []*Whitelist
+		for id, v := range tt.entries {
+			if err := v.Normalize(); err != nil {
+				t.Fatalf("%s: normalize %s: %v", tt.name, id, err)
+			}
+			entries = append(entries, entry(id, v))
+		}
+		if err := tt.subject.Normalize(); err != nil {
+			t.Fatalf("%s: normalize subject: %v", tt.name, err)
+		}
+		kind, _ := Lookup(kindOf(tt.subject))
+		got := NewMatcher(kind, entries, now).Match(tt.subject)
+		if got.Allowed != (tt.allowed != nil) {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got.Allowed, tt.allowed != nil)
+			continue
+		}
+		if tt.allowed != nil && !reflect.DeepEqual(got.EntryIDs, tt.allowed) {
+			t.Errorf("%s: entries = %v, want %v", tt.name, got.EntryIDs, tt.allowed)
+		}
+	}
+}
@@ -1,51 +1,76 @@
 package whitelist
 
-type AWSOpenPort struct {
-	InstanceID string `json:"instanceId"`
-	Cidr       string `json:"cidr"`
-	FromPort   int    `json:"fromPort"`
-	ToPort     int    `json:"toPort"`
-	Protocol   string `json:"protocol"`
+import "errors"
+
+const Path = "/protocols/whitelist"
+
+type Status string
+
+// pending -> approved/rejected, approved -> revoked, pending -> revoked
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	StatusRejected Status = "rejected"
+	StatusRevoked  Status = "revoked"
+	// 不落库，由 ExpiresAt 推导
+	StatusExpired Status = "expired"
+)
+
+const (
+	AuditRequested = "requested"
+	AuditApproved  = "approved"
+	AuditRejected  = "rejected"
+	AuditRevoked   = "revoked"
+)
+
+var (
+	ErrWhitelistExists  = errors.New("whitelist already exists")
+	ErrWhitelistMissing = errors.New("whitelist not found")
+	ErrInvalidWhitelist = errors.New("invalid whitelist")
+	ErrNotPending       = errors.New("whitelist is not pending")
+	ErrNotApprover      = errors.New("not the approver of the whitelist")
+	ErrNotRevoker       = errors.New("only the requester or approver may revoke the whitelist")
+	ErrWhitelistExpired = errors.New("whitelist has expired")
+)
+
+// AuditEntry records one step of the lifecycle of a whitelist entry.
+type AuditEntry struct {
+	Action  string `json:"action" bson:"action"`
+	Actor   string `json:"actor" bson:"actor"`
+	Comment string `json:"comment,omitempty" bson:"comment,omitempty"`
+	At      int64  `json:"at" bson:"at"`
 }
 
+// path /protocols/whitelist
 type Whitelist struct {
-	Type        string      `json:"type"`
-	Value       interface{} `json:"value"`
-	Sha256Value string      `json:"sha256Value"`
+	ID   string `json:"id" bson:"id"` // xid of the card
+	Type string `json:"type" bson:"type"`
+	// typed by the registered Kind of Type once decoded
+	Value         interface{} `json:"value" bson:"value"`
+	Sha256Value   string      `json:"sha256Value" bson:"sha256Value"`
+	Owner         string      `json:"owner" bson:"owner"`
+	Justification string      `json:"justification" bson:"justification"`
+	ExpiresAt     int64       `json:"expiresAt" bson:"expiresAt"`
+	Status        Status      `json:"status" bson:"status"`
+	// requester
+	CreatedBy string `json:"createdBy" bson:"createdBy"`
+	// 指定的审批人，不能是申请人
+	Approver     string       `json:"approver" bson:"approver"`
+	DecidedBy    string       `json:"decidedBy,omitempty" bson:"decidedBy,omitempty"`
+	DecidedAt    int64        `json:"decidedAt,omitempty" bson:"decidedAt,omitempty"`
+	CreatedAt    int64        `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    int64        `json:"updatedAt" bson:"updatedAt"`
+	RevokedBy    string       `json:"revokedBy,omitempty" bson:"revokedBy,omitempty"`
+	RevokedAt    int64        `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	RevokeReason string       `json:"revokeReason,omitempty" bson:"revokeReason,omitempty"`
+	Audit        []AuditEntry `json:"audit" bson:"audit"`
 }
 
-// func NewWhitelist(xid string, whitelistType string, payload Whitelist) (*protocols.XID, error) {
-
-// 	whitelistRepository := xdb.NewXidInfoRepository()
-// 	xidInfoRepository := xdb.NewXidInfoRepository()
-// 	xidInfo, err := xidInfoRepository.FindByName(context.Background(), xid, "/protocols/whitelist")
-// 	if err != nil {
-// 		logx.Errorf("NewWhitelist: %v", err)
-// 		return nil, err
-// 	}
-
-// 	metadata := protocols.NewMetadata("create", "/protocols/whitelist", "application/json")
-
-// 	NewXID := protocols.NewXID(xidInfo.Info, &metadata, payload)
-
-// 	//检测数据库中是否存在相同的whitelist
-// 	xidInfos, err := xidInfoRepository.FindAllByXid(context.Background(), xid)
-// 	if err != nil {
-// 		logx.Errorf("NewWhitelist: %v", err)
-// 		return nil, err
-// 	}
-// 	if len(xidInfos) > 0 {
-// 		//检测是否有重复的
-// 		for _, xidInfo := range xidInfos {
-// 			//对比Sha256Value
-// 			if xidInfo.Payload.(Whitelist).Sha256Value == payload.Sha256Value {
-// 				logx.Errorf("Whitelist already exists: %v", err)
-// 				return xidInfo, errors.New("Whitelist already exists")
-// 			}
-// 		}
-// 	}
-
-// 	whitelistRepository.Insert(context.Background(), NewXID)
-
-// 	return NewXID, nil
-// }
+// EffectiveStatus is Status with expiry applied at now (unix millis). Pending
+// entries expire too, they can no longer be approved.
+func (w *Whitelist) EffectiveStatus(now int64) Status {
+	if (w.Status == StatusApproved || w.Status == StatusPending) && w.ExpiresAt <= now {
+		return StatusExpired
+	}
+	return w.Status
+}
@@ -2,10 +2,16 @@ package xdb
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/xid-protocol/xidp/protocols"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -15,23 +21,171 @@ type mongoXIDRepo struct {
 }
 
 func NewMongoXIDRepo(c *mongo.Collection) XIDRepo {
-	return &mongoXIDRepo{collection: c}
+	// payload 是 any，默认会被解码成 bson.D（JSON 输出为 Key/Value 数组），这里改为 bson.M
+	opts := options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true})
+	return &mongoXIDRepo{collection: c.Database().Collection(c.Name(), opts)}
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type listCursor struct {
+	Value bson.RawValue      `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func sortField(sortBy string) string {
+	switch sortBy {
+	case "", "_id":
+		return "_id"
+	case "createdAt":
+		return "metadata.createdAt"
+	case "name":
+		return "info.id"
+	}
+	return sortBy
 }
 
 func (r *mongoXIDRepo) List(ctx context.Context, q Query) ([]*protocols.XID[any], string, error) {
-	return nil, "", nil
+	conds := bson.A{bson.M{"deletedAt": bson.M{"$exists": false}}}
+	if q.Path != "" {
+		conds = append(conds, bson.M{"metadata.path": q.Path})
+	}
+	if q.NameEquals != nil {
+		conds = append(conds, bson.M{"info.id": *q.NameEquals})
+	}
+	if q.NamePrefix != nil {
+		conds = append(conds, bson.M{"info.id": bson.M{"$regex": "^" + regexp.QuoteMeta(*q.NamePrefix)}})
+	}
+	if len(q.TagsAll) > 0 {
+		conds = append(conds, bson.M{"info.tags": bson.M{"$all": q.TagsAll}})
+	}
+	if q.CreatedAtGTE != nil {
+		conds = append(conds, bson.M{"metadata.createdAt": bson.M{"$gte": q.CreatedAtGTE.UnixMilli()}})
+	}
+	if q.CreatedAtLT != nil {
+		conds = append(conds, bson.M{"metadata.createdAt": bson.M{"$lt": q.CreatedAtLT.UnixMilli()}})
+	}
+	for k, v := range q.AttributesEq {
+		conds = append(conds, bson.M{"payload." + k: v})
+	}
+	if len(q.Where) > 0 {
+		conds = append(conds, bson.M(q.Where))
+	}
+
+	field := sortField(q.SortBy)
+	dir, cmp := -1, "$lt"
+	if q.SortAsc {
+		dir, cmp = 1, "$gt"
+	}
+
+	if q.AfterCursor != nil && *q.AfterCursor != "" {
+		cur, err := decodeCursor(*q.AfterCursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if field == "_id" {
+			conds = append(conds, bson.M{"_id": bson.M{cmp: cur.ID}})
+		} else {
+			conds = append(conds, bson.M{"$or": bson.A{
+				bson.M{field: bson.M{cmp: cur.Value}},
+				bson.M{field: cur.Value, "_id": bson.M{cmp: cur.ID}},
+			}})
+		}
+	}
+
+	pageSize := q.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	sort := bson.D{{Key: field, Value: dir}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: dir})
+	}
+	opts := options.Find().SetSort(sort).SetLimit(int64(pageSize + 1))
+	if len(q.Projection) > 0 {
+		projection := bson.M{"_id": 1, field: 1}
+		for _, p := range q.Projection {
+			projection[p] = 1
+		}
+		opts.SetProjection(projection)
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{"$and": conds}, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*protocols.XID[any]
+	var last bson.Raw
+	for cursor.Next(ctx) {
+		if len(out) == pageSize {
+			// 多取的一条只用来判断是否还有下一页
+			next, err := encodeCursor(last, field)
+			return out, next, err
+		}
+		var doc protocols.XID[any]
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, "", err
+		}
+		out = append(out, &doc)
+		last = append(bson.Raw{}, cursor.Current...)
+	}
+	return out, "", cursor.Err()
 }
 
-// EnsureXIDIndexes creates recommended indexes on the collection.
-// func EnsureXIDIndexes(ctx context.Context, c *mongo.Collection) error {
-// 	models := []mongo.IndexModel{
-// 		{Keys: bson.D{{Key: "xid", Value: 1}, {Key: "metadata.path", Value: 1}}, Options: options.Index().SetUnique(true)},
-// 		{Keys: bson.D{{Key: "idempotencyKey", Value: 1}, {Key: "metadata.path", Value: 1}}, Options: options.Index().SetUnique(true)},
-// 		{Keys: bson.D{{Key: "metadata.createdAt", Value: 1}, {Key: "metadata.path", Value: 1}}},
-// 	}
-// 	_, err := c.Indexes().CreateMany(ctx, models)
-// 	return err
-// }
+func encodeCursor(doc bson.Raw, field string) (string, error) {
+	id, ok := doc.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return "", errors.New("cursor: document has no ObjectID")
+	}
+	cur := listCursor{ID: id}
+	if field != "_id" {
+		cur.Value = doc.Lookup(strings.Split(field, ".")...)
+	}
+	if cur.Value.Type == 0 {
+		cur.Value = bson.RawValue{Type: bson.TypeNull}
+	}
+	raw, err := bson.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+	var cur listCursor
+	if err := bson.Unmarshal(raw, &cur); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return &cur, nil
+}
+
+// EnsureXIDIndexes creates the indexes the repository relies on: one card
+// per xid and path, and one card per idempotency key and path so that
+// concurrent InsertIdempotent calls cannot both insert. Cards without a key
+// are left out of the second index.
+func EnsureXIDIndexes(ctx context.Context, c *mongo.Collection) error {
+	models := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "xid", Value: 1}, {Key: "metadata.path", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "idempotencyKey", Value: 1}, {Key: "metadata.path", Value: 1}}, Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$exists": true}})},
+		{Keys: bson.D{{Key: "metadata.createdAt", Value: 1}, {Key: "metadata.path", Value: 1}}},
+	}
+	_, err := c.Indexes().CreateMany(ctx, models)
+	return err
+}
 
 func (r *mongoXIDRepo) Exists(ctx context.Context, xid, path string) (bool, error) {
 	filter := bson.M{"xid": xid, "metadata.path": path, "deletedAt": bson.M{"$exists": false}}
@@ -68,12 +222,17 @@ func (r *mongoXIDRepo) InsertIdempotent(ctx context.Context, doc *protocols.XID[
 	setOnInsert["idempotencyKey"] = idempotencyKey
 	update := bson.M{"$setOnInsert": setOnInsert}
 	_, err = r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	// 并发插入时唯一索引拒绝后到的一方，卡片已存在即达到目的
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
 	return err
 }
 
 func (r *mongoXIDRepo) Upsert(ctx context.Context, xid, path string, doc any) error {
 	filter := bson.M{"xid": xid, "metadata.path": path}
-	update := bson.M{"$set": doc}
+	// 重新写入的卡片视为恢复，清除软删除标记
+	update := bson.M{"$set": doc, "$unset": bson.M{"deletedAt": ""}}
 	_, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
 	return err
 }
@@ -91,6 +250,36 @@ func (r *mongoXIDRepo) UpdateFields(ctx context.Context, xid, path string, field
 	return err
 }
 
+func (r *mongoXIDRepo) UpdateFieldsIf(ctx context.Context, xid, path string, cond, fields map[string]any) (bool, error) {
+	filter := bson.M{"xid": xid, "metadata.path": path, "deletedAt": bson.M{"$exists": false}}
+	for k, v := range cond {
+		filter[k] = v
+	}
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+func (r *mongoXIDRepo) Incr(ctx context.Context, xid, path, field string, delta int64) (int64, error) {
+	filter := bson.M{"xid": xid, "metadata.path": path}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After).SetProjection(bson.M{field: 1})
+	var out bson.Raw
+	if err := r.collection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{field: delta}}, opts).Decode(&out); err != nil {
+		return 0, err
+	}
+	v, err := out.LookupErr(strings.Split(field, ".")...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.AsInt64OK()
+	if !ok {
+		return 0, fmt.Errorf("field %s is not a number", field)
+	}
+	return n, nil
+}
+
 // modify
 
 func (r *mongoXIDRepo) DeleteSoft(ctx context.Context, xid, path string, deletedAt int64) error {
@@ -5,17 +5,19 @@ import (
 	"time"
 
 	"github.com/xid-protocol/xidp/protocols"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 type Query struct {
 	Path         string
-	NameEquals   *string
-	NamePrefix   *string
+	NameEquals   *string // info.id
+	NamePrefix   *string // info.id
 	TagsAll      []string
 	CreatedAtGTE *time.Time
 	CreatedAtLT  *time.Time
-	AttributesEq map[string]any
-	SortBy       string // "createdAt","name","_id"
+	AttributesEq map[string]any // payload fields, e.g. "instanceId"
+	Where        map[string]any // raw filter expressions on full field paths
+	SortBy       string         // "createdAt","name","_id" or a full field path
 	SortAsc      bool
 	PageSize     int
 	AfterCursor  *string
@@ -31,7 +33,33 @@ type XIDRepo interface {
 	Upsert(ctx context.Context, xid, path string, doc any) error
 	Replace(ctx context.Context, xid, path string, doc *protocols.XID[any]) error
 	UpdateFields(ctx context.Context, xid, path string, fields map[string]any) error
+	// UpdateFieldsIf sets fields only when the document also matches cond
+	// (full field paths) and reports whether it did.
+	UpdateFieldsIf(ctx context.Context, xid, path string, cond, fields map[string]any) (bool, error)
+	// Incr atomically adds delta to a numeric field (full field path),
+	// creating the document if needed, and returns the new value.
+	Incr(ctx context.Context, xid, path, field string, delta int64) (int64, error)
 	DeleteSoft(ctx context.Context, xid, path string, deletedAt int64) error
 	DeleteHard(ctx context.Context, xid, path string) error
 	FindByXid(ctx context.Context, xid, path string) (*protocols.XID[any], error)
 }
+
+var defaultRepo XIDRepo
+
+// SetDefault sets the repository shared by handlers and background jobs.
+func SetDefault(repo XIDRepo) {
+	defaultRepo = repo
+}
+
+func Default() XIDRepo {
+	return defaultRepo
+}
+
+// DecodePayload converts the generic payload of a stored card into out.
+func DecodePayload(doc *protocols.XID[any], out any) error {
+	raw, err := bson.Marshal(bson.M{"payload": doc.Payload})
+	if err != nil {
+		return err
+	}
+	return bson.Raw(raw).Lookup("payload").Unmarshal(out)
+}
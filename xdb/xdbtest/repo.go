@@ -0,0 +1,656 @@
+// Package xdbtest provides an in-memory XIDRepo for tests. It understands
+// the subset of MongoDB filters the protocols use: equality on full field
+// paths, array elements included (steps.0.status), $gt, $gte, $lt, $lte,
+// $ne, $in, $nin, $exists, $all, $regex, $size, $elemMatch, $or and $and.
+// Like the Mongo repository, a card is unique per xid and path.
+package xdbtest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xid-protocol/xidp/protocols"
+	"github.com/xid-protocol/xidp/xdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Repo keeps cards as BSON documents in insertion order.
+type Repo struct {
+	mu   sync.Mutex
+	docs []bson.M
+	seq  int64
+}
+
+var _ xdb.XIDRepo = (*Repo)(nil)
+
+func New() *Repo {
+	return &Repo{}
+}
+
+// Len returns the number of stored cards, soft-deleted ones included.
+func (r *Repo) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.docs)
+}
+
+func (r *Repo) Exists(ctx context.Context, xid, path string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.find(xid, path, false) != nil, nil
+}
+
+func (r *Repo) Insert(ctx context.Context, doc *protocols.XID[any]) error {
+	if doc.Metadata != nil && doc.Metadata.CreatedAt == 0 {
+		doc.Metadata.CreatedAt = time.Now().UnixMilli()
+	}
+	m, err := toM(doc)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.insert(m)
+}
+
+func (r *Repo) InsertIdempotent(ctx context.Context, doc *protocols.XID[any], idempotencyKey string) error {
+	if doc.Metadata != nil && doc.Metadata.CreatedAt == 0 {
+		doc.Metadata.CreatedAt = time.Now().UnixMilli()
+	}
+	m, err := toM(doc)
+	if err != nil {
+		return err
+	}
+	m["idempotencyKey"] = idempotencyKey
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.docs {
+		if lookup(d, "metadata.path") == doc.Metadata.Path && d["idempotencyKey"] == idempotencyKey {
+			return nil
+		}
+	}
+	if err := r.insert(m); !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *Repo) Upsert(ctx context.Context, xid, path string, doc any) error {
+	m, err := toM(doc)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.find(xid, path, true)
+	if d == nil {
+		m["xid"] = xid
+		setPath(m, "metadata.path", path)
+		return r.insert(m)
+	}
+	for k, v := range m {
+		d[k] = v
+	}
+	delete(d, "deletedAt")
+	return nil
+}
+
+func (r *Repo) Replace(ctx context.Context, xid, path string, doc *protocols.XID[any]) error {
+	m, err := toM(doc)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, d := range r.docs {
+		if d["xid"] == xid && lookup(d, "metadata.path") == path {
+			m["_id"] = d["_id"]
+			r.docs[i] = m
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *Repo) UpdateFields(ctx context.Context, xid, path string, fields map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d := r.find(xid, path, true); d != nil {
+		return setFields(d, fields)
+	}
+	return nil
+}
+
+func (r *Repo) UpdateFieldsIf(ctx context.Context, xid, path string, cond, fields map[string]any) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.find(xid, path, false)
+	if d == nil {
+		return false, nil
+	}
+	ok, err := matches(d, cond)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, setFields(d, fields)
+}
+
+func (r *Repo) Incr(ctx context.Context, xid, path, field string, delta int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.find(xid, path, true)
+	if d == nil {
+		d = bson.M{"xid": xid, "metadata": bson.M{"path": path}}
+		if err := r.insert(d); err != nil {
+			return 0, err
+		}
+	}
+	var n int64
+	if v := lookup(d, field); v != nil {
+		f, ok := number(v)
+		if !ok {
+			return 0, fmt.Errorf("field %s is not a number", field)
+		}
+		n = int64(f)
+	}
+	n += delta
+	setPath(d, field, n)
+	return n, nil
+}
+
+func (r *Repo) DeleteSoft(ctx context.Context, xid, path string, deletedAt int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d := r.find(xid, path, true); d != nil {
+		d["deletedAt"] = deletedAt
+	}
+	return nil
+}
+
+func (r *Repo) DeleteHard(ctx context.Context, xid, path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, d := range r.docs {
+		if d["xid"] == xid && lookup(d, "metadata.path") == path {
+			r.docs = append(r.docs[:i], r.docs[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *Repo) FindByXid(ctx context.Context, xid, path string) (*protocols.XID[any], error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.find(xid, path, false)
+	if d == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return toXID(d)
+}
+
+// List filters and sorts like the Mongo repository. The cursor is the
+// offset of the next page, so pages shift when cards are added meanwhile.
+func (r *Repo) List(ctx context.Context, q xdb.Query) ([]*protocols.XID[any], string, error) {
+	filter := bson.M{}
+	conds := []any{bson.M{"deletedAt": bson.M{"$exists": false}}}
+	if q.Path != "" {
+		conds = append(conds, bson.M{"metadata.path": q.Path})
+	}
+	if q.NameEquals != nil {
+		conds = append(conds, bson.M{"info.id": *q.NameEquals})
+	}
+	if q.NamePrefix != nil {
+		conds = append(conds, bson.M{"info.id": bson.M{"$regex": "^" + regexp.QuoteMeta(*q.NamePrefix)}})
+	}
+	if len(q.TagsAll) > 0 {
+		conds = append(conds, bson.M{"info.tags": bson.M{"$all": q.TagsAll}})
+	}
+	if q.CreatedAtGTE != nil {
+		conds = append(conds, bson.M{"metadata.createdAt": bson.M{"$gte": q.CreatedAtGTE.UnixMilli()}})
+	}
+	if q.CreatedAtLT != nil {
+		conds = append(conds, bson.M{"metadata.createdAt": bson.M{"$lt": q.CreatedAtLT.UnixMilli()}})
+	}
+	for k, v := range q.AttributesEq {
+		conds = append(conds, bson.M{"payload." + k: v})
+	}
+	if len(q.Where) > 0 {
+		conds = append(conds, q.Where)
+	}
+	filter["$and"] = conds
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []bson.M
+	for _, d := range r.docs {
+		ok, err := matches(d, filter)
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			found = append(found, d)
+		}
+	}
+	field := sortField(q.SortBy)
+	sort.SliceStable(found, func(i, j int) bool {
+		c := compare(lookup(found[i], field), lookup(found[j], field))
+		if c == 0 {
+			c = compare(found[i]["_id"], found[j]["_id"])
+		}
+		if q.SortAsc {
+			return c < 0
+		}
+		return c > 0
+	})
+
+	offset := 0
+	if q.AfterCursor != nil && *q.AfterCursor != "" {
+		n, err := strconv.Atoi(*q.AfterCursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor: %v", err)
+		}
+		offset = n
+	}
+	pageSize := q.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	next := ""
+	if offset > len(found) {
+		offset = len(found)
+	}
+	found = found[offset:]
+	if len(found) > pageSize {
+		found, next = found[:pageSize], strconv.Itoa(offset+pageSize)
+	}
+	out := make([]*protocols.XID[any], 0, len(found))
+	for _, d := range found {
+		x, err := toXID(d)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, x)
+	}
+	return out, next, nil
+}
+
+func sortField(sortBy string) string {
+	switch sortBy {
+	case "", "_id":
+		return "_id"
+	case "createdAt":
+		return "metadata.createdAt"
+	case "name":
+		return "info.id"
+	}
+	return sortBy
+}
+
+func (r *Repo) find(xid, path string, deleted bool) bson.M {
+	for _, d := range r.docs {
+		if d["xid"] != xid || lookup(d, "metadata.path") != path {
+			continue
+		}
+		if _, ok := d["deletedAt"]; ok && !deleted {
+			return nil
+		}
+		return d
+	}
+	return nil
+}
+
+func (r *Repo) insert(m bson.M) error {
+	path := lookup(m, "metadata.path")
+	for _, d := range r.docs {
+		if d["xid"] == m["xid"] && lookup(d, "metadata.path") == path {
+			return mongo.WriteException{WriteErrors: []mongo.WriteError{
+				{Code: 11000, Message: fmt.Sprintf("duplicate key: xid %v path %v", m["xid"], path)},
+			}}
+		}
+	}
+	r.seq++
+	m["_id"] = r.seq
+	r.docs = append(r.docs, m)
+	return nil
+}
+
+// toM converts v into a document the way the driver would store it.
+func toM(v any) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// toValue converts a field value into its stored form.
+func toValue(v any) (any, error) {
+	m, err := toM(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+	return m["v"], nil
+}
+
+func toXID(d bson.M) (*protocols.XID[any], error) {
+	raw, err := bson.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	dec.DefaultDocumentM()
+	var x protocols.XID[any]
+	if err := dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
+
+func setFields(d bson.M, fields map[string]any) error {
+	for k, v := range fields {
+		val, err := toValue(v)
+		if err != nil {
+			return err
+		}
+		setPath(d, k, val)
+	}
+	return nil
+}
+
+func lookup(d bson.M, path string) any {
+	var cur any = d
+	for _, k := range strings.Split(path, ".") {
+		if list, ok := cur.(bson.A); ok {
+			n, err := strconv.Atoi(k)
+			if err != nil || n < 0 || n >= len(list) {
+				return nil
+			}
+			cur = list[n]
+			continue
+		}
+		m, ok := cur.(bson.M)
+		if !ok {
+			return nil
+		}
+		if cur, ok = m[k]; !ok {
+			return nil
+		}
+	}
+	return cur
+}
+
+func setPath(d bson.M, path string, v any) {
+	keys := strings.Split(path, ".")
+	for i, k := range keys[:len(keys)-1] {
+		// 数组元素，如 steps.0.status
+		if list, ok := d[k].(bson.A); ok {
+			if n, err := strconv.Atoi(keys[i+1]); err == nil && n >= 0 && n < len(list) {
+				if i+2 == len(keys) {
+					list[n] = v
+					return
+				}
+				if elem, ok := list[n].(bson.M); ok {
+					setPath(elem, strings.Join(keys[i+2:], "."), v)
+					return
+				}
+			}
+		}
+		next, ok := d[k].(bson.M)
+		if !ok {
+			next = bson.M{}
+			d[k] = next
+		}
+		d = next
+	}
+	d[keys[len(keys)-1]] = v
+}
+
+func matches(d bson.M, filter map[string]any) (bool, error) {
+	for k, v := range filter {
+		switch k {
+		case "$or", "$and":
+			subs, err := filters(v)
+			if err != nil {
+				return false, err
+			}
+			matched := false
+			for _, f := range subs {
+				ok, err := matches(d, f)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					matched = true
+				} else if k == "$and" {
+					return false, nil
+				}
+			}
+			if k == "$or" && !matched {
+				return false, nil
+			}
+			continue
+		}
+		ok, err := matchField(d, k, v)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// filters converts the operand of $or/$and into a list of filters.
+func filters(v any) ([]map[string]any, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("$or/$and needs a list, got %T", v)
+	}
+	out := make([]map[string]any, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		f, ok := asMap(rv.Index(i).Interface())
+		if !ok {
+			return nil, fmt.Errorf("$or/$and needs filters, got %T", rv.Index(i).Interface())
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func asMap(v any) (map[string]any, bool) {
+	switch m := v.(type) {
+	case map[string]any:
+		return m, true
+	case bson.M:
+		return m, true
+	}
+	return nil, false
+}
+
+func matchField(d bson.M, path string, cond any) (bool, error) {
+	actual := lookup(d, path)
+	ops, ok := asMap(cond)
+	if !ok || len(ops) == 0 || !isOperators(ops) {
+		want, err := toValue(cond)
+		if err != nil {
+			return false, err
+		}
+		return equal(actual, want), nil
+	}
+	for op, arg := range ops {
+		if op == "$elemMatch" {
+			f, isFilter := asMap(arg)
+			if !isFilter {
+				return false, fmt.Errorf("$elemMatch needs a filter, got %T", arg)
+			}
+			ok, err := elemMatch(actual, f)
+			if err != nil || !ok {
+				return false, err
+			}
+			continue
+		}
+		want, err := toValue(arg)
+		if err != nil {
+			return false, err
+		}
+		var ok bool
+		switch op {
+		case "$gt", "$gte", "$lt", "$lte":
+			if actual == nil || want == nil {
+				return false, nil
+			}
+			c := compare(actual, want)
+			ok = op == "$gt" && c > 0 || op == "$gte" && c >= 0 || op == "$lt" && c < 0 || op == "$lte" && c <= 0
+		case "$ne":
+			ok = !equal(actual, want)
+		case "$in", "$nin":
+			list, _ := want.(bson.A)
+			for _, w := range list {
+				if equal(actual, w) {
+					ok = true
+					break
+				}
+			}
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			exists := lookup(d, path) != nil
+			ok = exists == (want == true)
+		case "$all":
+			list, _ := want.(bson.A)
+			ok = true
+			for _, w := range list {
+				if !equal(actual, w) {
+					ok = false
+				}
+			}
+		case "$size":
+			list, isList := actual.(bson.A)
+			n, _ := number(want)
+			ok = isList && float64(len(list)) == n
+		case "$regex":
+			s, isString := actual.(string)
+			re, err := regexp.Compile(fmt.Sprint(want))
+			if err != nil {
+				return false, err
+			}
+			ok = isString && re.MatchString(s)
+		default:
+			return false, fmt.Errorf("operator %s is not supported", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// elemMatch reports whether an element of the array actual matches filter.
+func elemMatch(actual any, filter map[string]any) (bool, error) {
+	list, _ := actual.(bson.A)
+	for _, e := range list {
+		elem, ok := e.(bson.M)
+		if !ok {
+			continue
+		}
+		ok, err := matches(elem, filter)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func isOperators(m map[string]any) bool {
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// equal compares like a Mongo equality match: an array field matches when
+// any of its elements does.
+func equal(actual, want any) bool {
+	if list, ok := actual.(bson.A); ok {
+		if _, wantList := want.(bson.A); !wantList {
+			for _, a := range list {
+				if equal(a, want) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if a, ok := number(actual); ok {
+		w, ok := number(want)
+		return ok && a == w
+	}
+	return reflect.DeepEqual(actual, want)
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// compare orders missing values first, then numbers, then strings.
+func compare(a, b any) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return -1
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}